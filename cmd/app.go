@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+
+	"vibed-traveller/internal/blob"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/middleware"
+	"vibed-traveller/internal/store"
+)
+
+// app holds the configuration and store shared by every command
+type app struct {
+	cfg   *config.Config
+	store *store.Store
+}
+
+// openApp loads configuration from args, opens the store and returns the
+// positional arguments that follow the flags
+func openApp(ctx context.Context, args []string) (*app, []string, error) {
+	cfg, rest, err := config.LoadWithArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := store.Open(ctx, cfg.DatabasePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening store: %w", err)
+	}
+
+	return &app{cfg: cfg, store: st}, rest, nil
+}
+
+// Close releases the app's resources
+func (a *app) Close() {
+	if err := a.store.Close(); err != nil {
+		slog.Error("Failed to close store", "error", err)
+	}
+}
+
+// openBlobs returns the blob store configured by BLOB_STORAGE
+func openBlobs(cfg *config.Config) (blob.Store, error) {
+	if cfg.BlobStorage == "s3" {
+		return blob.NewS3(blob.S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+	}
+	return blob.NewLocal(cfg.BlobDir)
+}
+
+// setupLogger installs the default logger writing JSON to w with redaction
+func setupLogger(cfg *config.Config, w io.Writer, level slog.Leveler) {
+	base := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, AddSource: false})
+	slog.SetDefault(slog.New(middleware.NewWithRedactor(base, newRedactor(cfg))))
+}
+
+// newRedactor builds the log redactor from the defaults plus configured keys and patterns
+func newRedactor(cfg *config.Config) *middleware.Redactor {
+	keys := append(slices.Clone(middleware.DefaultRedactedKeys), cfg.LogRedactKeys...)
+	patterns := slices.Clone(middleware.DefaultRedactedPatterns)
+	for _, pattern := range cfg.LogRedactPatterns {
+		// Patterns were validated at load time
+		patterns = append(patterns, regexp.MustCompile(pattern))
+	}
+	return middleware.NewRedactor(keys, patterns)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"vibed-traveller/internal/airports"
+	"vibed-traveller/internal/cli"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/entryrules"
+	"vibed-traveller/internal/places"
+	"vibed-traveller/internal/routes"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/vault"
+)
+
+// withApp loads configuration, opens the store and runs fn with exactly n positional arguments
+func withApp(args []string, n int, fn func(ctx context.Context, a *app, rest []string) error) error {
+	ctx := context.Background()
+
+	a, rest, err := openApp(ctx, args)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if len(rest) != n {
+		return cli.Usagef("expected %d argument(s), got %d", n, len(rest))
+	}
+
+	// Keep command output clean; only warnings and errors are logged
+	setupLogger(a.cfg, os.Stderr, slog.LevelWarn)
+
+	return fn(ctx, a, rest)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// notFoundError turns store.ErrNotFound into a readable message
+func notFoundError(kind, id string, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%s %q not found", kind, id)
+	}
+	return err
+}
+
+// usersList prints every user as a table
+func usersList(args []string) error {
+	return withApp(args, 0, func(ctx context.Context, a *app, _ []string) error {
+		users, err := a.store.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tUSERNAME\tROLE\tDISABLED\tLAST SEEN")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\n", u.ID, u.Email, u.Username, u.Role, u.Disabled, u.LastSeenAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	})
+}
+
+// usersShow prints one user as JSON
+func usersShow(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		user, err := a.store.GetUser(ctx, rest[0])
+		if err != nil {
+			return notFoundError("user", rest[0], err)
+		}
+		return printJSON(user)
+	})
+}
+
+// usersSetDisabled returns a command that disables or re-enables a user
+func usersSetDisabled(disabled bool) func(args []string) error {
+	return func(args []string) error {
+		return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+			before, err := a.store.GetUser(ctx, rest[0])
+			if err != nil {
+				return notFoundError("user", rest[0], err)
+			}
+			if err := a.store.SetUserDisabled(ctx, rest[0], disabled); err != nil {
+				return notFoundError("user", rest[0], err)
+			}
+			state, action := "enabled", store.AuditUserEnable
+			if disabled {
+				state, action = "disabled", store.AuditUserDisable
+			}
+			if err := a.auditUserChange(ctx, action, before); err != nil {
+				return err
+			}
+			fmt.Printf("user %s %s\n", rest[0], state)
+			return nil
+		})
+	}
+}
+
+// usersSetRole gives a user the user or admin role
+func usersSetRole(args []string) error {
+	return withApp(args, 2, func(ctx context.Context, a *app, rest []string) error {
+		id, role := rest[0], rest[1]
+		if !slices.Contains(store.UserRoles, role) {
+			return cli.Usagef("role must be one of %s", strings.Join(store.UserRoles, ", "))
+		}
+		before, err := a.store.GetUser(ctx, id)
+		if err != nil {
+			return notFoundError("user", id, err)
+		}
+		if err := a.store.SetUserRole(ctx, id, role); err != nil {
+			return notFoundError("user", id, err)
+		}
+		if err := a.auditUserChange(ctx, store.AuditRoleChange, before); err != nil {
+			return err
+		}
+		fmt.Printf("user %s now has role %s\n", id, role)
+		return nil
+	})
+}
+
+// auditUserChange records a change made to a user from the command line,
+// diffing the user as it was before against the stored record
+func (a *app) auditUserChange(ctx context.Context, action string, before *store.User) error {
+	after, err := a.store.GetUser(ctx, before.ID)
+	if err != nil {
+		return err
+	}
+	changes, err := store.AuditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	return a.store.RecordAudit(ctx, &store.AuditEvent{
+		Action:     action,
+		ActorID:    store.CLIActor,
+		TargetType: "user",
+		TargetID:   before.ID,
+		Changes:    changes,
+	})
+}
+
+// tripsExport prints a trip and its related records as JSON
+func tripsExport(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		export, err := a.store.ExportTrip(ctx, rest[0])
+		if err != nil {
+			return notFoundError("trip", rest[0], err)
+		}
+		return printJSON(export)
+	})
+}
+
+// placesImport loads a GeoNames file into the place database
+func placesImport(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		stats, err := places.Import(ctx, a.store, rest[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %s file %s: %d countries, %d regions, %d places (%d other features skipped)\n",
+			stats.Kind, rest[0], stats.Countries, stats.Regions, stats.Places, stats.Skipped)
+		return nil
+	})
+}
+
+// airportsImport loads an OurAirports CSV file into the airport table
+func airportsImport(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		stats, err := airports.Import(ctx, a.store, rest[0])
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", rest[0], err)
+		}
+		fmt.Printf("imported %d airports from %s (%d heliports, closed and other sites skipped)\n", stats.Imported, rest[0], stats.Skipped)
+		return nil
+	})
+}
+
+// airlinesImport replaces the airline table with a file's airlines
+func airlinesImport(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		stats, err := airports.ImportAirlines(ctx, a.store, rest[0])
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", rest[0], err)
+		}
+		fmt.Printf("imported %d airlines from %s (%d without a name or code skipped)\n", stats.Imported, rest[0], stats.Skipped)
+		return nil
+	})
+}
+
+// entryRulesImport imports a CSV file of entry requirement rules
+func entryRulesImport(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stats, err := entryrules.Import(ctx, a.store, f)
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", rest[0], err)
+		}
+		changes, err := store.AuditDiff(nil, stats)
+		if err != nil {
+			return err
+		}
+		if err := a.store.RecordAudit(ctx, &store.AuditEvent{
+			Action:     store.AuditEntryRuleImport,
+			ActorID:    store.CLIActor,
+			TargetType: "entry_rule",
+			Changes:    changes,
+			Detail:     rest[0],
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("imported %d new and %d replaced entry rules from %s\n", stats.Created, stats.Updated, rest[0])
+		return nil
+	})
+}
+
+// documentsRekey encrypts every travel document with the first key of
+// DOCUMENT_KEYS, after which the other keys can be removed
+func documentsRekey(args []string) error {
+	return withApp(args, 0, func(ctx context.Context, a *app, _ []string) error {
+		kr, err := vault.NewKeyring(a.cfg.DocumentKeys)
+		if err != nil {
+			return fmt.Errorf("DOCUMENT_KEYS: %w", err)
+		}
+		n, err := a.store.RekeyDocuments(ctx, kr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("re-encrypted %d documents with the first key\n", n)
+		return nil
+	})
+}
+
+// sessionsRevoke invalidates every token issued to a user so far
+func sessionsRevoke(args []string) error {
+	return withApp(args, 1, func(ctx context.Context, a *app, rest []string) error {
+		before, err := a.store.GetUser(ctx, rest[0])
+		if err != nil {
+			return notFoundError("user", rest[0], err)
+		}
+		if err := a.store.RevokeSessions(ctx, rest[0]); err != nil {
+			return notFoundError("user", rest[0], err)
+		}
+		if err := a.auditUserChange(ctx, store.AuditSessionsRevoke, before); err != nil {
+			return err
+		}
+		fmt.Printf("sessions of user %s revoked\n", rest[0])
+		return nil
+	})
+}
+
+// configCheck loads and validates the configuration, printing every problem found
+func configCheck(args []string) error {
+	_, rest, err := config.LoadWithArgs(args)
+	if err == nil {
+		if len(rest) > 0 {
+			return cli.Usagef("unexpected arguments: %v", rest)
+		}
+		fmt.Println("configuration OK")
+		return nil
+	}
+
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "configuration has %d problem(s):\n", len(verr.Errors))
+	for _, fe := range verr.Errors {
+		fmt.Fprintf(os.Stderr, "  %s\n", fe.Error())
+	}
+	return errors.New("invalid configuration")
+}
+
+// configPrint prints every configuration value with its source
+func configPrint(args []string) error {
+	cfg, rest, err := config.LoadWithArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return cli.Usagef("unexpected arguments: %v", rest)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, setting := range cfg.Settings() {
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", setting.Env, setting.Value, setting.Source)
+	}
+	return tw.Flush()
+}
+
+// routesList prints the HTTP route table built by routes.SetupRoutes and
+// fails if a route is missing from the OpenAPI document
+func routesList(args []string) error {
+	return withApp(args, 0, func(_ context.Context, a *app, _ []string) error {
+		// Only the route table is needed, not the attachments' blob store
+		r, err := routes.SetupRoutes(config.NewManager(a.cfg, args), a.store, nil)
+		if err != nil {
+			return err
+		}
+		doc := routes.APISpec(a.cfg)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "METHOD\tPATH\tDOCUMENTED\tHANDLER")
+		for _, route := range r.Routes() {
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", route.Method, route.Path, doc.Has(route.Method, route.Path), route.Handler)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if missing := routes.UndocumentedRoutes(r, doc); len(missing) > 0 {
+			return fmt.Errorf("%d route(s) missing from the OpenAPI document", len(missing))
+		}
+		return nil
+	})
+}
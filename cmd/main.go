@@ -1,31 +1,63 @@
 package main
 
 import (
-	"log/slog"
 	"os"
-	"vibed-traveller/internal/middleware"
+	"strings"
 
-	"vibed-traveller/internal/config"
-	"vibed-traveller/internal/routes"
+	"vibed-traveller/internal/cli"
 )
 
 func main() {
-	// Load configuration
-	cfg := config.Load()
+	args := os.Args[1:]
 
-	// Logger
-	level := cfg.GetSlogLevel()
-	base := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level, AddSource: false})
-	logger := slog.New(middleware.New(base))
-	slog.SetDefault(logger)
+	// With no command (or only flags), start the server
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"serve"}, args...)
+	}
 
-	// Setup routes with configuration
-	r := routes.SetupRoutes(cfg)
+	os.Exit(cli.Execute(rootCommand(), args, os.Stderr))
+}
 
-	// Start server
-	slog.Info("Starting server", "port", cfg.GetPort())
-	if err := r.Run(":" + cfg.GetPort()); err != nil {
-		slog.Error("Server failed to start", "error", err)
-		os.Exit(1)
+// rootCommand builds the command tree. Every command accepts the configuration
+// flags (e.g. --config, --database-path) before its positional arguments.
+func rootCommand() *cli.Command {
+	return &cli.Command{
+		Name: "vibed-traveller",
+		Commands: []*cli.Command{
+			{Name: "serve", Summary: "Start the HTTP server (default)", Run: serve},
+			{Name: "users", Summary: "Manage users", Commands: []*cli.Command{
+				{Name: "list", Summary: "List users", Run: usersList},
+				{Name: "show", Args: "<user-id>", Summary: "Show a user as JSON", Run: usersShow},
+				{Name: "disable", Args: "<user-id>", Summary: "Refuse all requests from a user", Run: usersSetDisabled(true)},
+				{Name: "enable", Args: "<user-id>", Summary: "Re-enable a disabled user", Run: usersSetDisabled(false)},
+				{Name: "set-role", Args: "<user-id> <user|admin>", Summary: "Change a user's role", Run: usersSetRole},
+			}},
+			{Name: "trips", Summary: "Manage trips", Commands: []*cli.Command{
+				{Name: "export", Args: "<trip-id>", Summary: "Export a trip as JSON", Run: tripsExport},
+			}},
+			{Name: "places", Summary: "Manage the offline place database", Commands: []*cli.Command{
+				{Name: "import", Args: "<file>", Summary: "Import a GeoNames dump, countryInfo.txt or admin1CodesASCII.txt (.txt or .zip)", Run: placesImport},
+			}},
+			{Name: "airports", Summary: "Manage the airport reference database", Commands: []*cli.Command{
+				{Name: "import", Args: "<airports.csv>", Summary: "Import or update airports from an OurAirports CSV file", Run: airportsImport},
+			}},
+			{Name: "airlines", Summary: "Manage the airline reference database", Commands: []*cli.Command{
+				{Name: "import", Args: "<file>", Summary: "Replace the airlines with an OpenFlights airlines.dat or CSV file", Run: airlinesImport},
+			}},
+			{Name: "entry-rules", Summary: "Manage the entry requirement rules", Commands: []*cli.Command{
+				{Name: "import", Args: "<file.csv>", Summary: "Import or replace entry requirement rules from a CSV file", Run: entryRulesImport},
+			}},
+			{Name: "documents", Summary: "Manage the travel document vault", Commands: []*cli.Command{
+				{Name: "rekey", Summary: "Re-encrypt every document with the first key of DOCUMENT_KEYS", Run: documentsRekey},
+			}},
+			{Name: "sessions", Summary: "Manage sessions", Commands: []*cli.Command{
+				{Name: "revoke", Args: "<user-id>", Summary: "Invalidate every token issued to a user so far", Run: sessionsRevoke},
+			}},
+			{Name: "config", Summary: "Inspect configuration", Commands: []*cli.Command{
+				{Name: "check", Summary: "Validate configuration, exiting non-zero on problems", Run: configCheck},
+				{Name: "print", Summary: "Print every value with its source (secrets redacted)", Run: configPrint},
+			}},
+			{Name: "routes", Summary: "List the HTTP route table", Run: routesList},
+		},
 	}
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/routes"
+	"vibed-traveller/internal/server"
+	"vibed-traveller/internal/webhooks"
+)
+
+// attachmentSweepInterval is how often the files no attachment uses any more are deleted
+const attachmentSweepInterval = 10 * time.Minute
+
+// serve runs the HTTP server until SIGINT/SIGTERM
+func serve(args []string) error {
+	// Shut down gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration and open the store
+	a, rest, err := openApp(ctx, args)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	cfg := a.cfg
+
+	configs := config.NewManager(cfg, args)
+
+	// Logger, with the level following configuration reloads
+	setupLogger(cfg, os.Stdout, configs.LevelVar())
+
+	// Attachments are kept in the configured blob store
+	blobs, err := openBlobs(cfg)
+	if err != nil {
+		return fmt.Errorf("opening blob storage: %w", err)
+	}
+	files := attachments.New(a.store, blobs)
+
+	// Setup routes with configuration
+	r, err := routes.SetupRoutes(configs, a.store, files)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:         ":" + cfg.GetPort(),
+		Handler:      r,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+
+	// Deliver queued webhook events in the background
+	go webhooks.NewWorker(a.store, configs).Run(ctx)
+
+	// Delete the files of deleted trips and items in the background
+	go files.Run(ctx, attachmentSweepInterval)
+
+	// Reload configuration on SIGHUP and file changes
+	if err := configs.Watch(ctx); err != nil {
+		slog.Warn("Configuration reload disabled", "error", err)
+	}
+
+	// Start server
+	errCh := make(chan error, 2)
+	var redirectSrv *http.Server
+	if cfg.TLSEnabled() {
+		certs, err := server.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		if err := certs.Watch(ctx); err != nil {
+			slog.Warn("TLS certificate reload disabled", "error", err)
+		}
+		srv.TLSConfig = certs.TLSConfig()
+
+		if cfg.HTTPRedirectPort != "" {
+			redirectSrv = &http.Server{
+				Addr:        ":" + cfg.HTTPRedirectPort,
+				Handler:     server.RedirectHandler(cfg.GetPort()),
+				ReadTimeout: cfg.ReadTimeout,
+			}
+			slog.Info("Starting HTTP redirect listener", "port", cfg.HTTPRedirectPort)
+			go func() {
+				errCh <- redirectSrv.ListenAndServe()
+			}()
+		}
+
+		slog.Info("Starting server", "port", cfg.GetPort(), "tls", true)
+		go func() {
+			errCh <- srv.ListenAndServeTLS("", "")
+		}()
+	} else {
+		slog.Info("Starting server", "port", cfg.GetPort(), "tls", false)
+		go func() {
+			errCh <- srv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server failed to start: %w", err)
+		}
+	case <-ctx.Done():
+		slog.Info("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Server shutdown failed", "error", err)
+		}
+		if redirectSrv != nil {
+			if err := redirectSrv.Shutdown(shutdownCtx); err != nil {
+				slog.Error("Redirect listener shutdown failed", "error", err)
+			}
+		}
+	}
+
+	return nil
+}
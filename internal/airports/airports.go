@@ -0,0 +1,251 @@
+package airports
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/places"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+)
+
+// ImportStats counts the records stored by an import
+type ImportStats struct {
+	Imported int
+	Skipped  int
+}
+
+// Import loads an OurAirports airports.csv file. Columns are found by their
+// header names; an optional time_zone column is used when present, otherwise
+// the time zone is looked up from the coordinates. Airports that are not
+// large, medium or small airports (heliports, closed, …) are skipped.
+func Import(ctx context.Context, st *store.Store, path string) (*ImportStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	cols := columnIndex(header)
+	for _, required := range []string{"ident", "type", "name", "latitude_deg", "longitude_deg"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	imp, err := st.BeginAirportImport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ImportStats{}
+	if err := importAirports(r, cols, imp, stats); err != nil {
+		_ = imp.Rollback()
+		return nil, err
+	}
+	if err := imp.Commit(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// importAirports reads airport rows
+func importAirports(r *csv.Reader, cols map[string]int, imp *store.AirportImport, stats *ImportStats) error {
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if !slices.Contains(store.AirportTypes, field("type")) {
+			stats.Skipped++
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		lat, errLat := strconv.ParseFloat(field("latitude_deg"), 64)
+		lon, errLon := strconv.ParseFloat(field("longitude_deg"), 64)
+		if errLat != nil || errLon != nil || !(geo.Point{Lat: lat, Lon: lon}).Valid() {
+			return fmt.Errorf("line %d: invalid coordinates", line)
+		}
+
+		a := &store.Airport{
+			Ident:            strings.ToUpper(field("ident")),
+			IATA:             strings.ToUpper(field("iata_code")),
+			ICAO:             strings.ToUpper(field("icao_code")),
+			Type:             field("type"),
+			Name:             field("name"),
+			City:             field("municipality"),
+			CountryCode:      strings.ToUpper(field("iso_country")),
+			RegionCode:       field("iso_region"),
+			Latitude:         lat,
+			Longitude:        lon,
+			TimeZone:         field("time_zone"),
+			ScheduledService: field("scheduled_service") == "yes",
+		}
+		// Older files have no icao_code column; gps_code holds the ICAO code there
+		if a.ICAO == "" && len(field("gps_code")) == 4 {
+			a.ICAO = strings.ToUpper(field("gps_code"))
+		}
+		if elevation, err := strconv.ParseInt(field("elevation_ft"), 10, 64); err == nil {
+			a.ElevationFT = &elevation
+		}
+		if a.TimeZone == "" {
+			a.TimeZone = timezone.Lookup(geo.Point{Lat: lat, Lon: lon})
+		}
+
+		search := places.Normalize(strings.Join([]string{a.Name, a.City, field("keywords")}, " "))
+		if err := imp.AddAirport(a, search); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		stats.Imported++
+	}
+}
+
+// ImportAirlines replaces the airline table with an OpenFlights airlines.dat
+// file (id, name, alias, IATA, ICAO, callsign, country, active, without a
+// header) or a CSV file with a header naming iata, icao, name, callsign,
+// country and active columns
+func ImportAirlines(ctx context.Context, st *store.Store, path string) (*ImportStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenFlights layout unless the first row is a header
+	cols := map[string]int{"name": 1, "iata": 3, "icao": 4, "callsign": 5, "country": 6, "active": 7}
+	if len(records) > 0 {
+		if header := columnIndex(records[0]); hasColumn(header, "name") && (hasColumn(header, "iata") || hasColumn(header, "icao")) {
+			cols, records = header, records[1:]
+		}
+	}
+
+	stats := &ImportStats{}
+	airlines := make([]*store.Airline, 0, len(records))
+	for _, record := range records {
+		field := func(name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(record) || record[i] == `\N` {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		a := &store.Airline{
+			IATA:     strings.ToUpper(field("iata")),
+			ICAO:     strings.ToUpper(field("icao")),
+			Name:     field("name"),
+			Callsign: field("callsign"),
+			Country:  field("country"),
+			Active:   !strings.EqualFold(field("active"), "n"),
+		}
+		// OpenFlights uses "-" and similar placeholders for missing codes
+		if !validCode(a.IATA, 2) {
+			a.IATA = ""
+		}
+		if !validCode(a.ICAO, 3) {
+			a.ICAO = ""
+		}
+		if a.Name == "" || a.Code() == "" {
+			stats.Skipped++
+			continue
+		}
+		airlines = append(airlines, a)
+	}
+	if err := st.ReplaceAirlines(ctx, airlines); err != nil {
+		return nil, err
+	}
+	stats.Imported = len(airlines)
+	return stats, nil
+}
+
+// NewFlight builds the flight details of an item between two airports
+func NewFlight(departure, arrival *store.Airport, airline *store.Airline, number string) *store.Flight {
+	distance := geo.DistanceKM(
+		geo.Point{Lat: departure.Latitude, Lon: departure.Longitude},
+		geo.Point{Lat: arrival.Latitude, Lon: arrival.Longitude},
+	)
+	return &store.Flight{
+		Airline:    airline,
+		Number:     number,
+		Departure:  departure,
+		Arrival:    arrival,
+		DistanceKM: math.Round(distance*10) / 10,
+	}
+}
+
+// SetLocalTimes records when a flight departs and, if arrives is not nil,
+// arrives in the time zones of its airports
+func SetLocalTimes(f *store.Flight, departs time.Time, arrives *time.Time) {
+	f.DepartsLocal, f.ArrivesLocal = localTime(departs, f.Departure.TimeZone), ""
+	if arrives != nil {
+		f.ArrivesLocal = localTime(*arrives, f.Arrival.TimeZone)
+	}
+}
+
+// localTime formats t in the named zone, or returns "" if the zone is unknown
+func localTime(t time.Time, zone string) string {
+	local, err := timezone.In(t, zone)
+	if err != nil {
+		return ""
+	}
+	return local.Time.Format(time.RFC3339)
+}
+
+// columnIndex maps lowercase header names to their positions
+func columnIndex(header []string) map[string]int {
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	return cols
+}
+
+// hasColumn reports whether a header names a column
+func hasColumn(cols map[string]int, name string) bool {
+	_, ok := cols[name]
+	return ok
+}
+
+// validCode reports whether code is an n-character alphanumeric code
+func validCode(code string, n int) bool {
+	if len(code) != n {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,340 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"vibed-traveller/internal/blob"
+	"vibed-traveller/internal/photos"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+)
+
+// ErrUnsupportedType is returned for files whose detected media type is not accepted
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// ErrEmpty is returned for empty files
+var ErrEmpty = errors.New("the file is empty")
+
+// ErrNoThumbnail is returned for attachments without thumbnails
+var ErrNoThumbnail = errors.New("no thumbnail")
+
+// photoTypes are the media types of the images read as photos
+var photoTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// sweepBatchSize caps the unused blobs deleted per sweep
+const sweepBatchSize = 100
+
+// maxFilenameLength caps the length of stored filenames, in characters
+const maxFilenameLength = 255
+
+// Service stores the content of attachments as blobs named after its
+// SHA-256, so that a file attached several times is stored once. It
+// serializes the uploads and deletions of a given content, and assumes it
+// is the only one writing to the blob store.
+type Service struct {
+	store *store.Store
+	blobs blob.Store
+
+	// locks serialize storing and deleting content, by the first byte of its hash
+	locks [256]sync.Mutex
+}
+
+// New returns a service keeping the content of attachments in blobs
+func New(st *store.Store, blobs blob.Store) *Service {
+	return &Service{store: st, blobs: blobs}
+}
+
+// blobKey is the key of content in the blob store
+func blobKey(hash string) string {
+	return "sha256/" + hash[:2] + "/" + hash
+}
+
+// thumbnailKey is the key of a thumbnail of content in the blob store
+func thumbnailKey(hash, size string) string {
+	return "thumbnails/" + hash[:2] + "/" + hash + "-" + size + ".jpg"
+}
+
+// lock returns the lock of content
+func (s *Service) lock(hash string) *sync.Mutex {
+	b, _ := hex.DecodeString(hash[:2])
+	return &s.locks[b[0]]
+}
+
+// Add reads the content of a new attachment, whose trip, item and filename
+// are set, and stores it unless the same content already is. The media type
+// is detected from the content and must be one of allowed; the file is
+// refused as soon as it is known not to be. Photos are read and their
+// thumbnails stored along with them.
+func (s *Service) Add(ctx context.Context, a *store.Attachment, r io.Reader, allowed []string) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if n == 0 {
+		return ErrEmpty
+	}
+	head = head[:n]
+	a.ContentType = http.DetectContentType(head)
+	if !Allowed(a.ContentType, allowed) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, a.ContentType)
+	}
+
+	// Spool the file to disk to hash it before storing it
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return fmt.Errorf("buffering attachment: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		return err
+	}
+	a.Size = size
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	a.Filename = CleanFilename(a.Filename)
+
+	mu := s.lock(a.SHA256)
+	mu.Lock()
+	defer mu.Unlock()
+
+	stored, err := s.store.BlobStored(ctx, a.SHA256)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		if slices.Contains(photoTypes, a.ContentType) {
+			if a.Photo, err = s.readPhoto(ctx, tmp, a); err != nil {
+				s.deleteBlob(ctx, a.SHA256)
+				return err
+			}
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("buffering attachment: %w", err)
+		}
+		if err := s.blobs.Put(ctx, blobKey(a.SHA256), tmp, size, a.ContentType); err != nil {
+			s.deleteBlob(ctx, a.SHA256)
+			return err
+		}
+	}
+	if err := s.store.CreateAttachment(ctx, a); err != nil {
+		if !stored {
+			s.deleteBlob(ctx, a.SHA256)
+		}
+		return err
+	}
+	if stored {
+		// Read when the content was first stored
+		if saved, err := s.store.GetAttachment(ctx, a.TripID, a.ID); err == nil {
+			a.Photo = saved.Photo
+		}
+	}
+	return nil
+}
+
+// readPhoto reads the size of an image, and when and where it was taken from
+// its EXIF metadata, and stores its thumbnails. It returns nil for images
+// that can't be decoded.
+func (s *Service) readPhoto(ctx context.Context, f *os.File, a *store.Attachment) (*store.Photo, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("buffering attachment: %w", err)
+	}
+	meta, err := photos.ReadEXIF(f, a.ContentType)
+	if err != nil {
+		if !errors.Is(err, photos.ErrNoEXIF) {
+			slog.DebugContext(ctx, "Ignoring unreadable EXIF metadata", slog.String("sha256", a.SHA256), slog.Any("error", err))
+		}
+		meta = &photos.EXIF{}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("buffering attachment: %w", err)
+	}
+	width, height, err := photos.Size(f, meta.Orientation)
+	if err != nil {
+		return nil, nil
+	}
+
+	p := &store.Photo{Width: width, Height: height}
+	var loc *time.Location
+	if meta.Location != nil {
+		p.Latitude, p.Longitude = &meta.Location.Lat, &meta.Location.Lon
+		loc, _ = timezone.Load(timezone.Lookup(*meta.Location))
+	}
+	if t, ok := meta.Instant(); ok {
+		p.TakenAt = &t
+		if loc != nil {
+			p.LocalTime = t.In(loc).Format(store.LocalTimeLayout)
+		}
+	}
+	if dt := meta.DateTime; p.LocalTime == "" && !dt.IsZero() {
+		p.LocalTime = dt.Format(store.LocalTimeLayout)
+		if p.TakenAt == nil && loc != nil {
+			t := timezone.Resolve(dt.Year(), dt.Month(), dt.Day(), dt.Hour(), dt.Minute(), dt.Second(), loc).UTC()
+			p.TakenAt = &t
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("buffering attachment: %w", err)
+	}
+	img, err := photos.Decode(f)
+	if err != nil {
+		slog.InfoContext(ctx, "Not making thumbnails of photo", slog.String("sha256", a.SHA256), slog.Any("error", err))
+		return p, nil
+	}
+	for name, size := range photos.ThumbnailSizes {
+		thumbnail, err := photos.Thumbnail(img, size, meta.Orientation)
+		if err != nil {
+			return nil, fmt.Errorf("making thumbnail: %w", err)
+		}
+		if err := s.blobs.Put(ctx, thumbnailKey(a.SHA256, name), bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+			return nil, err
+		}
+	}
+	p.Thumbnails = true
+	return p, nil
+}
+
+// Open returns a reader of the content of an attachment
+func (s *Service) Open(ctx context.Context, a *store.Attachment) (io.ReadCloser, error) {
+	return s.blobs.Open(ctx, blobKey(a.SHA256))
+}
+
+// OpenThumbnail returns a reader of a thumbnail of a photo, in one of
+// photos.ThumbnailSizes
+func (s *Service) OpenThumbnail(ctx context.Context, a *store.Attachment, size string) (io.ReadCloser, error) {
+	if a.Photo == nil || !a.Photo.Thumbnails {
+		return nil, ErrNoThumbnail
+	}
+	return s.blobs.Open(ctx, thumbnailKey(a.SHA256, size))
+}
+
+// Delete deletes an attachment, and its content unless another attachment shares it
+func (s *Service) Delete(ctx context.Context, a *store.Attachment) error {
+	mu := s.lock(a.SHA256)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := s.store.DeleteAttachment(ctx, a.ID); err != nil {
+		return err
+	}
+	_, err := s.release(ctx, a.SHA256)
+	return err
+}
+
+// release deletes content no attachment uses any more, reporting whether it
+// did; the caller holds its lock
+func (s *Service) release(ctx context.Context, hash string) (bool, error) {
+	released, err := s.store.ReleaseBlob(ctx, hash)
+	if err != nil {
+		return false, err
+	}
+	if released {
+		s.deleteBlob(ctx, hash)
+	}
+	return released, nil
+}
+
+// deleteBlob deletes content and its thumbnails from the blob store. A
+// failure only leaves an unreferenced object behind, replaced if the same
+// content is added again.
+func (s *Service) deleteBlob(ctx context.Context, hash string) {
+	keys := []string{blobKey(hash)}
+	for name := range photos.ThumbnailSizes {
+		keys = append(keys, thumbnailKey(hash, name))
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			slog.WarnContext(ctx, "Failed to delete attachment content", slog.String("key", key), slog.Any("error", err))
+		}
+	}
+}
+
+// Sweep deletes content left unused by deleted trips and items, whose
+// attachments are deleted with them, and returns how many blobs it deleted
+func (s *Service) Sweep(ctx context.Context) (int, error) {
+	hashes, err := s.store.UnusedBlobs(ctx, sweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, hash := range hashes {
+		mu := s.lock(hash)
+		mu.Lock()
+		released, err := s.release(ctx, hash)
+		mu.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+		if released {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Run sweeps unused content every interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Sweep(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to sweep attachment content", slog.Any("error", err))
+		} else if n > 0 {
+			slog.InfoContext(ctx, "Deleted unused attachment content", slog.Int("blobs", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Allowed reports whether a media type, ignoring its parameters, is one of allowed
+func Allowed(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(strings.TrimSpace(a), mediaType) })
+}
+
+// CleanFilename keeps the last element of an uploaded file's name, without
+// control characters and at most 255 characters long
+func CleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, "")))
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
@@ -0,0 +1,50 @@
+package attachments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of download links
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+// What download links give access to: the file itself or its thumbnails. A
+// link signed for one doesn't work for the other.
+const (
+	LinkFile      = "file"
+	LinkThumbnail = "thumbnail"
+)
+
+// linkSignature signs the download link to a variant of an attachment
+// expiring at the given Unix time
+func linkSignature(key []byte, id, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("attachment/" + id + "/" + variant + "/" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignLink returns the query of a link downloading a variant of an
+// attachment, LinkFile or LinkThumbnail, until expires
+func SignLink(key []byte, id, variant string, expires time.Time) url.Values {
+	return url.Values{
+		ExpiresParam:   {strconv.FormatInt(expires.Unix(), 10)},
+		SignatureParam: {linkSignature(key, id, variant, expires.Unix())},
+	}
+}
+
+// VerifyLink reports whether a download link query was signed with key for
+// the variant of the attachment and has not expired at now
+func VerifyLink(key []byte, id, variant string, query url.Values, now time.Time) bool {
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get(SignatureParam)), []byte(linkSignature(key, id, variant, expires)))
+}
@@ -0,0 +1,67 @@
+package attachments
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyLink(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(15 * time.Minute)
+	signed := SignLink(key, "att-1", LinkFile, expires)
+
+	with := func(param, value string) url.Values {
+		q := url.Values{}
+		for k, v := range signed {
+			q[k] = append([]string(nil), v...)
+		}
+		if value == "" {
+			q.Del(param)
+		} else {
+			q.Set(param, value)
+		}
+		return q
+	}
+	// tamper flips the last character of a signature
+	tamper := func(s string) string {
+		last := s[len(s)-1]
+		if last == 'A' {
+			return s[:len(s)-1] + "B"
+		}
+		return s[:len(s)-1] + "A"
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		id      string
+		variant string
+		query   url.Values
+		now     time.Time
+		want    bool
+	}{
+		{"valid", key, "att-1", LinkFile, signed, now, true},
+		{"valid until the last second", key, "att-1", LinkFile, signed, expires.Add(-time.Second), true},
+		{"expired at expiry", key, "att-1", LinkFile, signed, expires, false},
+		{"expired after expiry", key, "att-1", LinkFile, signed, expires.Add(time.Hour), false},
+		{"other attachment", key, "att-2", LinkFile, signed, now, false},
+		{"other key", []byte("another key, just as long as one"), "att-1", LinkFile, signed, now, false},
+		{"tampered signature", key, "att-1", LinkFile, with(SignatureParam, tamper(signed.Get(SignatureParam))), now, false},
+		{"signature of another attachment", key, "att-1", LinkFile, with(SignatureParam, SignLink(key, "att-2", LinkFile, expires).Get(SignatureParam)), now, false},
+		{"extended expiry", key, "att-1", LinkFile, with(ExpiresParam, strconv.FormatInt(expires.Add(time.Hour).Unix(), 10)), expires, false},
+		{"thumbnail link used for the file", key, "att-1", LinkFile, SignLink(key, "att-1", LinkThumbnail, expires), now, false},
+		{"file link used for a thumbnail", key, "att-1", LinkThumbnail, signed, now, false},
+		{"thumbnail link", key, "att-1", LinkThumbnail, SignLink(key, "att-1", LinkThumbnail, expires), now, true},
+		{"missing signature", key, "att-1", LinkFile, with(SignatureParam, ""), now, false},
+		{"missing expiry", key, "att-1", LinkFile, with(ExpiresParam, ""), now, false},
+		{"malformed expiry", key, "att-1", LinkFile, with(ExpiresParam, "soon"), now, false},
+	}
+	for _, tt := range tests {
+		if got := VerifyLink(tt.key, tt.id, tt.variant, tt.query, tt.now); got != tt.want {
+			t.Errorf("%s: VerifyLink = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
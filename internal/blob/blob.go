@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ErrNotFound is returned when no object is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are empty, absolute or contain . or .. elements
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps objects under slash-separated keys
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any object stored there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Open returns a reader of the object stored under key, or ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under key; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey rejects keys that could escape the store's directory or prefix
+func checkKey(key string) error {
+	if !fs.ValidPath(key) || key == "." || strings.Contains(key, `\`) {
+		return ErrInvalidKey
+	}
+	return nil
+}
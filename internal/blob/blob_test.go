@@ -0,0 +1,169 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Settings of the S3-compatible service the S3 store is tested against, e.g.
+// MinIO started with
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//
+// The bucket must exist. The test is skipped unless the endpoint is set.
+const (
+	testS3EndpointEnv = "BLOB_TEST_S3_ENDPOINT"
+	testS3BucketEnv   = "BLOB_TEST_S3_BUCKET"
+	testS3KeyEnv      = "BLOB_TEST_S3_ACCESS_KEY_ID"
+	testS3SecretEnv   = "BLOB_TEST_S3_SECRET_ACCESS_KEY"
+	testS3RegionEnv   = "BLOB_TEST_S3_REGION"
+)
+
+func TestLocal(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s, "")
+}
+
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv(testS3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("set %s to test against an S3-compatible service such as MinIO", testS3EndpointEnv)
+	}
+	s, err := NewS3(S3Options{
+		Endpoint:        endpoint,
+		Region:          os.Getenv(testS3RegionEnv),
+		Bucket:          envOr(testS3BucketEnv, "vibed-traveller-test"),
+		AccessKeyID:     envOr(testS3KeyEnv, "minioadmin"),
+		SecretAccessKey: envOr(testS3SecretEnv, "minioadmin"),
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Runs share the bucket, so each keeps to its own prefix
+	testStore(t, s, "test-"+strconv.FormatInt(time.Now().UnixNano(), 36)+"/")
+}
+
+// envOr returns the environment variable or fallback if it is empty
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// testStore checks that s behaves as the Store interface documents, using
+// keys under prefix
+func testStore(t *testing.T, s Store, prefix string) {
+	ctx := context.Background()
+
+	put := func(t *testing.T, key string, data []byte) {
+		t.Helper()
+		if err := s.Put(ctx, prefix+key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	read := func(t *testing.T, key string) ([]byte, error) {
+		t.Helper()
+		r, err := s.Open(ctx, prefix+key)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	expect := func(t *testing.T, key string, want []byte) {
+		t.Helper()
+		got, err := read(t, key)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", key, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Open(%s) read %d bytes, want %d", key, len(got), len(want))
+		}
+	}
+	expectMissing := func(t *testing.T, key string) {
+		t.Helper()
+		if _, err := read(t, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Open(%s) = %v, want ErrNotFound", key, err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, key := range []string{"round-trip", "empty", "replaced", "nested/dir/file.bin", "deleted", "short", "name with spaces+symbols&é"} {
+			_ = s.Delete(ctx, prefix+key)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data := make([]byte, 3<<20)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		put(t, "round-trip", data)
+		expect(t, "round-trip", data)
+	})
+
+	t.Run("empty object", func(t *testing.T) {
+		put(t, "empty", nil)
+		expect(t, "empty", []byte{})
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		put(t, "replaced", []byte("first version, longer than the second"))
+		put(t, "replaced", []byte("second"))
+		expect(t, "replaced", []byte("second"))
+	})
+
+	t.Run("nested and unusual keys", func(t *testing.T) {
+		put(t, "nested/dir/file.bin", []byte("nested"))
+		expect(t, "nested/dir/file.bin", []byte("nested"))
+		put(t, "name with spaces+symbols&é", []byte("escaped"))
+		expect(t, "name with spaces+symbols&é", []byte("escaped"))
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		expectMissing(t, "never-stored")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put(t, "deleted", []byte("gone soon"))
+		if err := s.Delete(ctx, prefix+"deleted"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		expectMissing(t, "deleted")
+		if err := s.Delete(ctx, prefix+"deleted"); err != nil {
+			t.Fatalf("deleting a missing object: %v", err)
+		}
+	})
+
+	t.Run("short body", func(t *testing.T) {
+		if err := s.Put(ctx, prefix+"short", strings.NewReader("12345"), 10, ""); err == nil {
+			t.Fatal("Put succeeded with fewer bytes than its size")
+		}
+		expectMissing(t, "short")
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", ".", "/absolute", "../escape", "a/../../b", "a/./b", "a//b", `a\b`, "trailing/"} {
+			if err := s.Put(ctx, key, strings.NewReader("x"), 1, ""); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+			}
+			if _, err := s.Open(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Open(%q) = %v, want ErrInvalidKey", key, err)
+			}
+			if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrUsage indicates a command was invoked with the wrong arguments
+var ErrUsage = errors.New("invalid usage")
+
+// Command is a node in the command tree. Leaf commands have Run; group
+// commands have Commands and dispatch on their first argument.
+type Command struct {
+	// Name is the word that selects this command
+	Name string
+
+	// Args describes the positional arguments, e.g. "<user-id>"
+	Args string
+
+	// Summary is a one-line description shown in help
+	Summary string
+
+	// Run executes a leaf command with the remaining arguments
+	Run func(args []string) error
+
+	// Commands are the subcommands of a group command
+	Commands []*Command
+}
+
+// Usagef returns an ErrUsage with a message
+func Usagef(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrUsage, fmt.Sprintf(format, args...))
+}
+
+// Execute dispatches args to the matching command and returns the process exit code:
+// 0 on success, 1 on failure, 2 on usage errors
+func Execute(root *Command, args []string, stderr io.Writer) int {
+	cmd, path, rest := root, []string{root.Name}, args
+	for len(cmd.Commands) > 0 {
+		if len(rest) == 0 || rest[0] == "help" || rest[0] == "-h" || rest[0] == "--help" {
+			printUsage(stderr, path, cmd)
+			if len(rest) == 0 {
+				return 2
+			}
+			return 0
+		}
+
+		next := cmd.find(rest[0])
+		if next == nil {
+			fmt.Fprintf(stderr, "unknown command %q\n\n", strings.Join(append(path, rest[0]), " "))
+			printUsage(stderr, path, cmd)
+			return 2
+		}
+		cmd, path, rest = next, append(path, next.Name), rest[1:]
+	}
+
+	if err := cmd.Run(rest); err != nil {
+		if errors.Is(err, ErrUsage) {
+			fmt.Fprintf(stderr, "%v\nusage: %s %s\n", err, strings.Join(path, " "), cmd.Args)
+			return 2
+		}
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// find returns the subcommand with the given name
+func (c *Command) find(name string) *Command {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// printUsage lists the subcommands of a group command
+func printUsage(w io.Writer, path []string, cmd *Command) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\ncommands:\n", strings.Join(path, " "))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, sub := range cmd.Commands {
+		usage := sub.Name
+		if len(sub.Commands) > 0 {
+			usage += " <command>"
+		} else if sub.Args != "" {
+			usage += " " + sub.Args
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", usage, sub.Summary)
+	}
+	_ = tw.Flush()
+}
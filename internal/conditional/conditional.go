@@ -0,0 +1,91 @@
+package conditional
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"vibed-traveller/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Conditional request headers
+const (
+	ETagHeader        = "ETag"
+	IfMatchHeader     = "If-Match"
+	IfNoneMatchHeader = "If-None-Match"
+)
+
+// ETag returns the strong entity tag of a resource version
+func ETag(version int64) string {
+	return `"v` + strconv.FormatInt(version, 10) + `"`
+}
+
+// SetETag sets the ETag response header
+func SetETag(c *gin.Context, etag string) {
+	c.Header(ETagHeader, etag)
+}
+
+// NotModified sets the ETag header and, when If-None-Match matches it
+// (weak comparison), responds 304 Not Modified. It reports whether the
+// response is complete.
+func NotModified(c *gin.Context, etag string) bool {
+	SetETag(c, etag)
+
+	header := c.GetHeader(IfNoneMatchHeader)
+	if header == "" {
+		return false
+	}
+	for _, tag := range splitTags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			c.Status(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			c.Abort()
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIfMatch verifies If-Match against the resource's current etag
+// (strong comparison). Without the header it aborts with 428 if required
+// and otherwise lets the request through; on mismatch it aborts with 412.
+// It reports whether the request may proceed.
+func CheckIfMatch(c *gin.Context, etag string, required bool) bool {
+	header := c.GetHeader(IfMatchHeader)
+	if header == "" {
+		if required {
+			problem.Abort(c, problem.New(http.StatusPreconditionRequired, problem.CodePreconditionRequired,
+				"Send If-Match with the ETag of the version being modified"))
+			return false
+		}
+		return true
+	}
+
+	for _, tag := range splitTags(header) {
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	problem.Abort(c, Failed())
+	return false
+}
+
+// Failed is the 412 problem reported when the resource changed since the client read it
+func Failed() *problem.Problem {
+	return problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed,
+		"The resource was modified; fetch it again and retry")
+}
+
+// splitTags splits an If-Match or If-None-Match list
+func splitTags(header string) []string {
+	parts := strings.Split(header, ",")
+	tags := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if tag := strings.TrimSpace(part); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
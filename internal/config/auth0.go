@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,9 +27,11 @@ const (
 	// AuthTokenCookiePath is the path where the auth token cookie is available
 	AuthTokenCookiePath = "/"
 
-	// AuthTokenCookieSecure determines if the cookie should only be sent over HTTPS
-	// Set to false for development, true for production
-	AuthTokenCookieSecure = false
+	// TokenIssuedAtKey is the gin context key holding the validated token's issue time
+	TokenIssuedAtKey = "token_issued_at"
+
+	// AuthFailureKey is the gin context key holding why a presented token was rejected
+	AuthFailureKey = "auth_failure"
 )
 
 // Auth0 path constants
@@ -73,7 +76,8 @@ type Auth0UserInfo struct {
 	EmailVerified bool       `json:"email_verified"`
 }
 
-// SetAuthTokenCookie sets the authentication token cookie with the configured settings
+// SetAuthTokenCookie sets the authentication token cookie with the configured settings.
+// The cookie is marked Secure when the request arrived over HTTPS.
 func SetAuthTokenCookie(c *gin.Context, token string) {
 	c.SetCookie(
 		AuthTokenCookieName,
@@ -81,7 +85,7 @@ func SetAuthTokenCookie(c *gin.Context, token string) {
 		AuthTokenCookieMaxAge,
 		AuthTokenCookiePath,
 		"",
-		AuthTokenCookieSecure,
+		IsSecureRequest(c),
 		false,
 	)
 }
@@ -94,7 +98,7 @@ func ClearAuthTokenCookie(c *gin.Context) {
 		-1, // Delete immediately
 		AuthTokenCookiePath,
 		"",
-		AuthTokenCookieSecure,
+		IsSecureRequest(c),
 		true, // httpOnly
 	)
 }
@@ -159,9 +163,10 @@ func AuthMiddleware(config *Config) gin.HandlerFunc {
 		}
 
 		// Validate JWT expiration
-		_, err = createdValidator.ValidateToken(c.Request.Context(), token)
+		claims, err := createdValidator.ValidateToken(c.Request.Context(), token)
 		if err != nil {
 			slog.ErrorContext(c.Request.Context(), "Invalid token", slog.Any("error", err))
+			c.Set(AuthFailureKey, "invalid token: "+err.Error())
 			// If token is invalid or expired, redirect to login
 			c.Redirect(http.StatusTemporaryRedirect, loginURL)
 			c.Abort()
@@ -172,6 +177,7 @@ func AuthMiddleware(config *Config) gin.HandlerFunc {
 		user, err := ExtractUserFromToken(token, config)
 		if err != nil {
 			slog.ErrorContext(c.Request.Context(), "Failed to extract user info from token", slog.Any("error", err))
+			c.Set(AuthFailureKey, "user info unavailable")
 			c.Redirect(http.StatusTemporaryRedirect, loginURL)
 			c.Abort()
 			return
@@ -179,12 +185,32 @@ func AuthMiddleware(config *Config) gin.HandlerFunc {
 
 		slog.InfoContext(c.Request.Context(), "User authenticated successfully", slog.String("user_id", user.ID))
 
-		// Store user in context
+		// Store user and token issue time in context
 		c.Set("user", user)
+		if validated, ok := claims.(*validator.ValidatedClaims); ok && validated.RegisteredClaims.IssuedAt > 0 {
+			c.Set(TokenIssuedAtKey, time.Unix(validated.RegisteredClaims.IssuedAt, 0))
+		}
 		c.Next()
 	}
 }
 
+// TokenSubject validates a token and returns the user ID it was issued to
+func TokenSubject(ctx context.Context, config *Config, token string) (string, error) {
+	v, err := CreateValidator(config)
+	if err != nil {
+		return "", err
+	}
+	claims, err := v.ValidateToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	validated, ok := claims.(*validator.ValidatedClaims)
+	if !ok {
+		return "", fmt.Errorf("unexpected claims type %T", claims)
+	}
+	return validated.RegisteredClaims.Subject, nil
+}
+
 // CreateValidator creates a JWT validator for token validation
 func CreateValidator(config *Config) (*validator.Validator, error) {
 	// Validate Auth0 configuration first
@@ -384,6 +410,16 @@ func buildCallbackURL(config *Config) string {
 	return fmt.Sprintf("%s/auth/callback", config.APIURL)
 }
 
+// GetTokenIssuedAt returns when the request's token was issued, or the zero time if unknown
+func GetTokenIssuedAt(c *gin.Context) time.Time {
+	if issuedAt, exists := c.Get(TokenIssuedAtKey); exists {
+		if t, ok := issuedAt.(time.Time); ok {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 // GetUserFromContext extracts the authenticated user from Gin context
 func GetUserFromContext(c *gin.Context) *User {
 	if user, exists := c.Get("user"); exists {
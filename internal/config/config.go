@@ -1,98 +1,300 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
-
-	"github.com/joho/godotenv"
+	"strings"
+	"time"
 )
 
 // Config holds the configuration for the application
 type Config struct {
-	Port     string `env:"PORT" default:"8080"`
-	LogLevel string `env:"LOG_LEVEL" default:"info"`
-	BaseURL  string `env:"BASE_URL" default:"http://localhost:3000"`
-	APIURL   string `env:"API_URL" default:"http://localhost:8080"`
+	Port     string `env:"PORT" default:"8080" validate:"required,port"`
+	LogLevel string `env:"LOG_LEVEL" default:"info" validate:"oneof=debug|info|warn|error" reload:"true"`
+	BaseURL  string `env:"BASE_URL" default:"http://localhost:3000" validate:"required,url"`
+	APIURL   string `env:"API_URL" default:"http://localhost:8080" validate:"required,url"`
+
+	// GinMode is the gin mode; error responses include internal details only in debug mode
+	GinMode string `env:"GIN_MODE" default:"release" validate:"oneof=debug|release|test"`
+
+	// Server Configuration
+	ReadTimeout      time.Duration  `env:"READ_TIMEOUT" default:"15s"`
+	WriteTimeout     time.Duration  `env:"WRITE_TIMEOUT" default:"30s"`
+	ShutdownTimeout  time.Duration  `env:"SHUTDOWN_TIMEOUT" default:"10s"`
+	CORSAllowOrigins []string       `env:"CORS_ALLOW_ORIGINS" default:"" reload:"true" validate:"origin"`
+	TimeZone         *time.Location `env:"TIME_ZONE" default:"UTC"`
+
+	// DatabasePath is the SQLite database file
+	DatabasePath string `env:"DATABASE_PATH" default:"data/vibed-traveller.db" validate:"required"`
+
+	// Additional log attribute/query keys and regular expressions to redact
+	LogRedactKeys     []string `env:"LOG_REDACT_KEYS" default:""`
+	LogRedactPatterns []string `env:"LOG_REDACT_PATTERNS" default:"" validate:"regexp"`
+
+	// Rate limiting per client IP; a rate of 0 disables limiting
+	RateLimitRPS   float64 `env:"RATE_LIMIT_RPS" default:"0" reload:"true"`
+	RateLimitBurst int     `env:"RATE_LIMIT_BURST" default:"20" reload:"true"`
+
+	// TLS Configuration; TLS is served in-process when both files are set
+	TLSCertFile      string        `env:"TLS_CERT_FILE" default:""`
+	TLSKeyFile       string        `env:"TLS_KEY_FILE" default:""`
+	HTTPRedirectPort string        `env:"HTTP_REDIRECT_PORT" default:"" validate:"port"`
+	HSTSMaxAge       time.Duration `env:"HSTS_MAX_AGE" default:"0s"`
+	TrustedProxies   []string      `env:"TRUSTED_PROXIES" default:"" validate:"cidr"`
+
+	// IdempotencyKeyTTL is how long responses to POST requests with an Idempotency-Key are kept for replay
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" default:"24h" reload:"true"`
+
+	// RequireIfMatch rejects updates and deletes without If-Match with 428
+	RequireIfMatch bool `env:"REQUIRE_IF_MATCH" default:"true" reload:"true"`
+
+	// Outbound webhooks: per-request timeout, attempts before a delivery is
+	// given up, and the first retry delay, doubled after every failure
+	WebhookTimeout     time.Duration `env:"WEBHOOK_TIMEOUT" default:"10s" reload:"true"`
+	WebhookMaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" default:"8" reload:"true"`
+	WebhookRetryDelay  time.Duration `env:"WEBHOOK_RETRY_DELAY" default:"30s" reload:"true"`
+
+	// WebhookAllowPrivate lets webhooks reach loopback, link-local and
+	// private addresses, for receivers running next to a development server
+	WebhookAllowPrivate bool `env:"WEBHOOK_ALLOW_PRIVATE" default:"false" reload:"true"`
+
+	// TrackUploadMaxMB limits the size of uploaded GPX and KML files
+	TrackUploadMaxMB int `env:"TRACK_UPLOAD_MAX_MB" default:"50" reload:"true"`
+
+	// DocumentKeys are base64-encoded 32-byte keys encrypting travel documents at
+	// rest. The first key encrypts; the others only decrypt, for key rotation.
+	DocumentKeys []string `env:"DOCUMENT_KEYS" default:"" validate:"key" secret:"true" reload:"true"`
+
+	// DocumentScanMaxMB limits the size of uploaded document scans
+	DocumentScanMaxMB int `env:"DOCUMENT_SCAN_MAX_MB" default:"10" reload:"true"`
+
+	// DocumentExpiryWarningMonths is how long documents must stay valid after
+	// a trip ends before /trips/:id/warnings stops warning about them
+	DocumentExpiryWarningMonths int `env:"DOCUMENT_EXPIRY_WARNING_MONTHS" default:"6" reload:"true"`
+
+	// Blob storage of attachments: "local" keeps them in files under BlobDir,
+	// "s3" in a bucket of an S3-compatible service such as AWS S3 or MinIO
+	BlobStorage       string `env:"BLOB_STORAGE" default:"local" validate:"oneof=local|s3"`
+	BlobDir           string `env:"BLOB_DIR" default:"data/blobs"`
+	S3Endpoint        string `env:"S3_ENDPOINT" default:"" validate:"url"`
+	S3Region          string `env:"S3_REGION" default:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET" default:""`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID" default:""`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY" default:"" secret:"true"`
+	S3PathStyle       bool   `env:"S3_PATH_STYLE" default:"true"`
+
+	// AttachmentMaxMB limits the size of files attached to trips and items
+	AttachmentMaxMB int `env:"ATTACHMENT_MAX_MB" default:"25" reload:"true"`
+
+	// AttachmentTypes are the accepted media types of attachments, as detected from their content
+	AttachmentTypes []string `env:"ATTACHMENT_TYPES" default:"application/pdf,image/jpeg,image/png,image/webp,image/gif,text/plain" reload:"true"`
+
+	// AttachmentURLKey is a base64-encoded 32-byte key signing attachment
+	// download links, which are valid for AttachmentURLTTL
+	AttachmentURLKey string        `env:"ATTACHMENT_URL_KEY" default:"" validate:"key" secret:"true" reload:"true"`
+	AttachmentURLTTL time.Duration `env:"ATTACHMENT_URL_TTL" default:"15m" reload:"true"`
+
+	// Features lists the enabled feature flags
+	Features []string `env:"FEATURES" default:"" reload:"true"`
 
 	// Auth0 Configuration
-	Auth0Domain       string `env:"AUTH0_DOMAIN" default:""`
-	Auth0Audience     string `env:"AUTH0_AUDIENCE" default:""`
-	Auth0IssuerURL    string `env:"AUTH0_ISSUER_URL" default:""`
-	Auth0ClientID     string `env:"AUTH0_CLIENT_ID" default:""`
-	Auth0ClientSecret string `env:"AUTH0_CLIENT_SECRET" default:""`
+	Auth0Domain       string `env:"AUTH0_DOMAIN" default:"" validate:"required"`
+	Auth0Audience     string `env:"AUTH0_AUDIENCE" default:"" validate:"required"`
+	Auth0IssuerURL    string `env:"AUTH0_ISSUER_URL" default:"" validate:"required,url"`
+	Auth0ClientID     string `env:"AUTH0_CLIENT_ID" default:"" validate:"required"`
+	Auth0ClientSecret string `env:"AUTH0_CLIENT_SECRET" default:"" validate:"required" secret:"true"`
+
+	// sources records where each field's value came from, keyed by field name
+	sources map[string]string
+
+	// file is the configuration file this config was loaded from, if any
+	file string
 }
 
-// Load loads configuration from environment variables and .env file
-func Load() *Config {
-	// Load .env file if it exists (ignores error if file doesn't exist)
-	err := godotenv.Load()
+// Load loads configuration from, in increasing order of precedence: struct tag
+// defaults, a YAML/TOML config file (--config or CONFIG_FILE), the .env file,
+// process environment (both with *_FILE secret-file indirection), and command-line
+// flags. Every unparsable or invalid value is reported in the returned *ValidationError.
+func Load(args []string) (*Config, error) {
+	config, _, err := LoadWithArgs(args)
+	return config, err
+}
+
+// LoadWithArgs is Load, also returning the positional arguments that follow the flags
+func LoadWithArgs(args []string) (*Config, []string, error) {
+	specs := configFields()
+
+	flags, configFile, rest, err := flagLayer(args, specs)
 	if err != nil {
-		slog.Warn("failed to load .env file")
+		return nil, nil, err
+	}
+	if configFile == "" {
+		configFile = os.Getenv(ConfigFileEnv)
 	}
 
-	config := &Config{}
+	var errs []*FieldError
+	layers := []layer{defaultLayer(specs)}
 
-	// Use reflection to automatically populate config from environment
-	config.loadFromEnv()
+	if configFile != "" {
+		file, fileErrs := fileLayer(configFile, specs)
+		errs = append(errs, fileErrs...)
+		layers = append(layers, file)
+	}
 
-	return config
-}
+	// Load .env file if it exists (ignores error if file doesn't exist)
+	dotenv, dotenvErrs := dotenvLayer(specs)
+	errs = append(errs, dotenvErrs...)
 
-// loadFromEnv uses reflection to automatically populate config fields from environment variables
-func (c *Config) loadFromEnv() {
-	val := reflect.ValueOf(c).Elem()
-	typ := val.Type()
+	env, envErrs := envLayer(specs, os.Getenv, SourceEnv)
+	errs = append(errs, envErrs...)
+	layers = append(layers, dotenv, env, flags)
 
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := typ.Field(i)
+	config := &Config{file: configFile}
 
-		// Get the env tag
-		envKey := fieldType.Tag.Get("env")
-		if envKey == "" {
-			continue
-		}
+	// Use reflection to populate config from the merged sources
+	errs = append(errs, config.apply(specs, resolve(specs, layers...))...)
+
+	// Validate the populated values, skipping fields that already failed to parse
+	errs = append(errs, config.validate(errs)...)
 
-		// Get the default value
-		defaultValue := fieldType.Tag.Get("default")
+	if len(errs) > 0 {
+		return config, rest, &ValidationError{Errors: errs}
+	}
 
-		// Get value from environment
-		envValue := os.Getenv(envKey)
+	return config, rest, nil
+}
 
-		// Use default if environment variable is not set
-		if envValue == "" {
-			envValue = defaultValue
-		}
+// apply uses reflection to set each field from its resolved value, recording its source
+func (c *Config) apply(specs []fieldSpec, values map[string]value) []*FieldError {
+	val := reflect.ValueOf(c).Elem()
+	c.sources = make(map[string]string, len(specs))
+
+	var errs []*FieldError
+	for _, spec := range specs {
+		v := values[spec.env]
+		c.sources[spec.name] = v.source
 
 		// Set the field value based on its type
-		c.setFieldValue(field, envValue)
+		if err := setFieldValue(val.Field(spec.index), v.raw); err != nil {
+			fe := &FieldError{Field: spec.name, Env: spec.env, Value: v.raw, Err: err}
+			if spec.secret {
+				fe.Value = redacted
+			}
+			errs = append(errs, fe)
+		}
 	}
+
+	return errs
 }
 
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	locationType = reflect.TypeOf((*time.Location)(nil))
+	urlType      = reflect.TypeOf((*url.URL)(nil))
+)
+
 // setFieldValue sets the field value based on its type
-func (c *Config) setFieldValue(field reflect.Value, value string) {
+func setFieldValue(field reflect.Value, value string) error {
+	// Types with a dedicated parser must be matched before their underlying kind
+	switch field.Type() {
+	case durationType:
+		if value == "" {
+			field.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a duration", ErrParse, value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	case locationType:
+		if value == "" {
+			field.Set(reflect.Zero(locationType))
+			return nil
+		}
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a time zone", ErrParse, value)
+		}
+		field.Set(reflect.ValueOf(loc))
+		return nil
+	case urlType:
+		if value == "" {
+			field.Set(reflect.Zero(urlType))
+			return nil
+		}
+		u, err := parseAbsoluteURL(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(u))
+		return nil
+	}
+
 	switch field.Kind() {
 	case reflect.String:
 		field.SetString(value)
-	case reflect.Int:
+	case reflect.Int, reflect.Int64:
 		if value == "" {
 			field.SetInt(0)
-		} else {
-			if intVal, err := strconv.Atoi(value); err == nil {
-				field.SetInt(int64(intVal))
-			}
+			return nil
+		}
+		intVal, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an integer", ErrParse, value)
+		}
+		field.SetInt(intVal)
+	case reflect.Float64:
+		if value == "" {
+			field.SetFloat(0)
+			return nil
+		}
+		floatVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a number", ErrParse, value)
 		}
+		field.SetFloat(floatVal)
 	case reflect.Bool:
 		if value == "" {
 			field.SetBool(false)
-		} else {
-			if boolVal, err := strconv.ParseBool(value); err == nil {
-				field.SetBool(boolVal)
+			return nil
+		}
+		boolVal, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a boolean", ErrParse, value)
+		}
+		field.SetBool(boolVal)
+	case reflect.Slice:
+		// Slices are comma-separated lists of their element type
+		parts := splitList(value)
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFieldValue(slice.Index(i), part); err != nil {
+				return err
 			}
 		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("%w: unsupported field type %s", ErrParse, field.Type())
+	}
+
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var parts []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
 	}
+	return parts
 }
 
 // GetPort returns the configured port
@@ -110,6 +312,38 @@ func (c *Config) GetBaseURL() string {
 	return c.BaseURL
 }
 
+// GetCORSAllowOrigins returns the origins allowed to make cross-origin requests,
+// defaulting to the base URL when none are configured
+func (c *Config) GetCORSAllowOrigins() []string {
+	if len(c.CORSAllowOrigins) == 0 {
+		return []string{c.BaseURL}
+	}
+	return c.CORSAllowOrigins
+}
+
+// GetTimeZone returns the configured time zone, defaulting to UTC
+func (c *Config) GetTimeZone() *time.Location {
+	if c.TimeZone == nil {
+		return time.UTC
+	}
+	return c.TimeZone
+}
+
+// FeatureEnabled reports whether the named feature flag is enabled
+func (c *Config) FeatureEnabled(name string) bool {
+	for _, feature := range c.Features {
+		if feature == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
 // GetSlogLevel returns the slog.Level for the configured log level
 func (c *Config) GetSlogLevel() slog.Level {
 	switch c.LogLevel {
@@ -151,42 +385,28 @@ func (c *Config) GetAuth0ClientSecret() string {
 	return c.Auth0ClientSecret
 }
 
-// IsAuth0Configured checks if Auth0 is properly configured
+// IsAuth0Configured checks if Auth0 is properly configured.
+// Missing fields are reported individually by Load.
 func (c *Config) IsAuth0Configured() bool {
-	// Check if all required Auth0 fields are set
-	if c.Auth0Domain == "" {
-		slog.Error("AUTH0_DOMAIN is not set")
-		return false
-	}
-	if c.Auth0Audience == "" {
-		slog.Error("AUTH0_AUDIENCE is not set")
-		return false
-	}
-	if c.Auth0IssuerURL == "" {
-		slog.Error("AUTH0_ISSUER_URL is not set")
-		return false
-	}
-	if c.Auth0ClientID == "" {
-		slog.Error("AUTH0_CLIENT_ID is not set")
-		return false
-	}
-	if c.Auth0ClientSecret == "" {
-		slog.Error("AUTH0_CLIENT_SECRET is not set")
-		return false
-	}
-	return true
+	return c.Auth0Domain != "" &&
+		c.Auth0Audience != "" &&
+		c.Auth0IssuerURL != "" &&
+		c.Auth0ClientID != "" &&
+		c.Auth0ClientSecret != ""
 }
 
-// Debug prints the current configuration values for debugging
+// Debug logs every configuration value together with the source it was loaded
+// from. Values of fields tagged secret:"true" are redacted.
 func (c *Config) Debug() {
-	slog.Info("Current configuration",
-		"port", c.Port,
-		"log_level", c.LogLevel,
-		"base_url", c.BaseURL,
-		"auth0_domain", c.Auth0Domain,
-		"auth0_audience", c.Auth0Audience,
-		"auth0_issuer_url", c.Auth0IssuerURL,
-		"auth0_client_id", c.Auth0ClientID,
-		"auth0_client_secret_set", c.Auth0ClientSecret != "",
-	)
+	settings := c.Settings()
+
+	attrs := make([]any, 0, len(settings))
+	for _, setting := range settings {
+		attrs = append(attrs, slog.Group(strings.ToLower(setting.Env),
+			slog.Any("value", setting.Value),
+			slog.String("source", setting.Source),
+		))
+	}
+
+	slog.Info("Current configuration", attrs...)
 }
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"vibed-traveller/internal/filestamp"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigContextKey is the key used to store the request's config snapshot in gin context
+const ConfigContextKey = "config"
+
+// reloadDebounce coalesces bursts of file events (editors, atomic renames) into one reload
+const reloadDebounce = 500 * time.Millisecond
+
+// Manager holds the current configuration snapshot and reloads the fields
+// tagged reload:"true" on SIGHUP or when the config or .env file changes.
+// Snapshots are immutable; a reload swaps in a new one atomically.
+type Manager struct {
+	current atomic.Pointer[Config]
+	level   slog.LevelVar
+	args    []string
+
+	// mu serializes reloads
+	mu sync.Mutex
+}
+
+// NewManager creates a manager serving cfg, re-reading args on every reload
+func NewManager(cfg *Config, args []string) *Manager {
+	m := &Manager{args: args}
+	m.current.Store(cfg)
+	m.level.Set(cfg.GetSlogLevel())
+	return m
+}
+
+// Current returns the current configuration snapshot
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// LevelVar returns the log level variable kept in sync with LOG_LEVEL
+func (m *Manager) LevelVar() *slog.LevelVar {
+	return &m.level
+}
+
+// Reload loads the configuration again and swaps in the reloadable fields.
+// Changes to other fields are logged as warnings and otherwise ignored
+// until restart. An invalid configuration leaves the current one in place.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	loaded, err := Load(m.args)
+	if err != nil {
+		return fmt.Errorf("reloading configuration: %w", err)
+	}
+
+	old := m.Current()
+	next := *old
+	next.sources = make(map[string]string, len(old.sources))
+	for k, v := range old.sources {
+		next.sources[k] = v
+	}
+
+	oldVal := reflect.ValueOf(old).Elem()
+	loadedVal := reflect.ValueOf(loaded).Elem()
+	nextVal := reflect.ValueOf(&next).Elem()
+
+	var changed []any
+	for _, spec := range configFields() {
+		before := oldVal.Field(spec.index)
+		after := loadedVal.Field(spec.index)
+		if reflect.DeepEqual(before.Interface(), after.Interface()) {
+			continue
+		}
+
+		if !spec.reload {
+			slog.Warn("Configuration change requires restart, ignoring",
+				slog.String("field", strings.ToLower(spec.env)),
+				slog.String("source", loaded.Source(spec.name)),
+			)
+			continue
+		}
+
+		nextVal.Field(spec.index).Set(after)
+		next.sources[spec.name] = loaded.Source(spec.name)
+		changed = append(changed, slog.Group(strings.ToLower(spec.env),
+			slog.Any("from", displayValue(spec, before)),
+			slog.Any("to", displayValue(spec, after)),
+		))
+	}
+
+	if len(changed) == 0 {
+		slog.Info("Configuration reloaded, no changes")
+		return nil
+	}
+
+	m.current.Store(&next)
+	m.level.Set(next.GetSlogLevel())
+	slog.Info("Configuration reloaded", changed...)
+
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the config file or
+// .env file changes, until ctx is cancelled
+func (m *Manager) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %w", err)
+	}
+
+	// Watch parent directories and look at the files again on every event
+	// there, so atomic replacements (e.g. Kubernetes ConfigMaps swapping
+	// their ..data symlink) are seen
+	stamps := filestamp.New(m.Current().file, DotenvFile)
+	watched := map[string]bool{}
+	for _, abs := range stamps.Paths() {
+		watched[abs] = true
+		dir := filepath.Dir(abs)
+		if err := watcher.Add(dir); err != nil {
+			slog.Warn("Cannot watch configuration directory", slog.String("dir", dir), slog.Any("error", err))
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("Received SIGHUP, reloading configuration")
+				m.reloadAndLog()
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				abs, _ := filepath.Abs(event.Name)
+				if stamps.Changed() || watched[abs] {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-debounce:
+				slog.Info("Configuration file changed, reloading configuration")
+				m.reloadAndLog()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Configuration file watcher error", slog.Any("error", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reloadAndLog reloads the configuration, logging failures
+func (m *Manager) reloadAndLog() {
+	if err := m.Reload(); err != nil {
+		slog.Error("Configuration reload failed, keeping current configuration", slog.Any("error", err))
+	}
+}
+
+// Middleware pins the current configuration snapshot in the gin context so a
+// request sees one consistent configuration even if a reload happens mid-flight
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ConfigContextKey, m.Current())
+		c.Next()
+	}
+}
+
+// FromContext returns the configuration snapshot pinned for the request
+func FromContext(c *gin.Context) *Config {
+	if cfg, exists := c.Get(ConfigContextKey); exists {
+		if snapshot, ok := cfg.(*Config); ok {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+// displayValue renders a field value for logs, redacting secrets
+func displayValue(spec fieldSpec, v reflect.Value) any {
+	if spec.secret {
+		if v.IsZero() {
+			return ""
+		}
+		return redacted
+	}
+	if loc, ok := v.Interface().(*time.Location); ok && loc != nil {
+		return loc.String()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration sources, from lowest to highest precedence
+const (
+	SourceDefault    = "default"
+	SourceFile       = "file"
+	SourceDotenv     = "dotenv"
+	SourceEnv        = "env"
+	SourceSecretFile = "secret_file"
+	SourceFlag       = "flag"
+)
+
+const (
+	// ConfigFileEnv is the environment variable naming the configuration file
+	ConfigFileEnv = "CONFIG_FILE"
+
+	// ConfigFileFlag is the command-line flag naming the configuration file
+	ConfigFileFlag = "config"
+
+	// DotenvFile is the optional .env file read from the working directory
+	DotenvFile = ".env"
+
+	// secretFileSuffix marks an environment variable holding the path to a secret file
+	secretFileSuffix = "_FILE"
+
+	// redacted replaces secret values in debug output
+	redacted = "[REDACTED]"
+)
+
+// ErrUnknownKey indicates a configuration file contains a key that maps to no field
+var ErrUnknownKey = errors.New("unknown configuration key")
+
+// value is a raw configuration value and where it came from
+type value struct {
+	raw    string
+	source string
+}
+
+// layer is a single configuration source keyed by env name
+type layer map[string]value
+
+// fieldSpec describes a config field that can be populated from a source
+type fieldSpec struct {
+	index  int
+	name   string
+	env    string
+	def    string
+	secret bool
+	reload bool
+}
+
+// configFields lists the fields of Config that carry an env tag
+func configFields() []fieldSpec {
+	typ := reflect.TypeOf(Config{})
+
+	var specs []fieldSpec
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		envKey := f.Tag.Get("env")
+		if envKey == "" {
+			continue
+		}
+		specs = append(specs, fieldSpec{
+			index:  i,
+			name:   f.Name,
+			env:    envKey,
+			def:    f.Tag.Get("default"),
+			secret: f.Tag.Get("secret") == "true",
+			reload: f.Tag.Get("reload") == "true",
+		})
+	}
+	return specs
+}
+
+// flagName derives the command-line flag name from an env name (LOG_LEVEL -> log-level)
+func flagName(envKey string) string {
+	return strings.ReplaceAll(strings.ToLower(envKey), "_", "-")
+}
+
+// defaultLayer returns the default tag values of every field
+func defaultLayer(specs []fieldSpec) layer {
+	l := make(layer, len(specs))
+	for _, spec := range specs {
+		l[spec.env] = value{raw: spec.def, source: SourceDefault}
+	}
+	return l
+}
+
+// envLayer reads variables through lookup, resolving *_FILE indirection
+// to the contents of the referenced file (e.g. Docker or Kubernetes secrets)
+func envLayer(specs []fieldSpec, lookup func(string) string, source string) (layer, []*FieldError) {
+	l := make(layer)
+	var errs []*FieldError
+	for _, spec := range specs {
+		envValue := lookup(spec.env)
+		path := lookup(spec.env + secretFileSuffix)
+
+		switch {
+		case envValue != "" && path != "":
+			errs = append(errs, &FieldError{
+				Field: spec.name,
+				Env:   spec.env,
+				Err:   fmt.Errorf("%w: both %s and %s%s are set", ErrParse, spec.env, spec.env, secretFileSuffix),
+			})
+		case path != "":
+			content, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, &FieldError{
+					Field: spec.name,
+					Env:   spec.env + secretFileSuffix,
+					Value: path,
+					Err:   fmt.Errorf("%w: reading secret file: %v", ErrParse, err),
+				})
+				continue
+			}
+			l[spec.env] = value{
+				raw:    strings.TrimRight(string(content), "\r\n"),
+				source: SourceSecretFile + ":" + path,
+			}
+		case envValue != "":
+			l[spec.env] = value{raw: envValue, source: source}
+		}
+	}
+	return l, errs
+}
+
+// dotenvLayer reads the .env file without modifying the process environment,
+// so that it can be re-read on reload
+func dotenvLayer(specs []fieldSpec) (layer, []*FieldError) {
+	vars, err := godotenv.Read(DotenvFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, []*FieldError{{Field: "Dotenv", Env: DotenvFile, Err: fmt.Errorf("%w: %v", ErrParse, err)}}
+		}
+		slog.Warn("failed to load .env file")
+		return nil, nil
+	}
+	return envLayer(specs, func(key string) string { return vars[key] }, SourceDotenv)
+}
+
+// fileLayer reads a YAML or TOML configuration file, chosen by extension.
+// Keys are env names in any case; nested tables are joined with underscores,
+// so both `auth0_domain: x` and `auth0: {domain: x}` set AUTH0_DOMAIN.
+func fileLayer(path string, specs []fieldSpec) (layer, []*FieldError) {
+	fileErr := func(err error) []*FieldError {
+		return []*FieldError{{Field: "ConfigFile", Env: ConfigFileEnv, Value: path, Err: fmt.Errorf("%w: %v", ErrParse, err)}}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fileErr(err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		err = fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fileErr(err)
+	}
+
+	known := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		known[spec.env] = true
+	}
+
+	l := make(layer)
+	var errs []*FieldError
+	flatten("", raw, func(key string, v any) {
+		if !known[key] {
+			errs = append(errs, &FieldError{Field: "ConfigFile", Env: key, Value: path, Err: ErrUnknownKey})
+			return
+		}
+		l[key] = value{raw: stringify(v), source: SourceFile + ":" + path}
+	})
+
+	// Report unknown keys in a stable order
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Env < errs[j].Env })
+
+	return l, errs
+}
+
+// flatten walks nested maps, calling fn with upper-cased, underscore-joined keys
+func flatten(prefix string, m map[string]any, fn func(key string, v any)) {
+	for k, v := range m {
+		key := strings.ToUpper(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		if nested, ok := v.(map[string]any); ok {
+			flatten(key, nested, fn)
+			continue
+		}
+		fn(key, v)
+	}
+}
+
+// stringify converts a decoded file value to the string form setFieldValue expects
+func stringify(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			parts = append(parts, stringify(item))
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// flagLayer parses command-line flags; only flags that were explicitly set are returned.
+// The configuration file path, if given with --config, and the positional arguments
+// following the flags are returned separately.
+func flagLayer(args []string, specs []fieldSpec) (layer, string, []string, error) {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String(ConfigFileFlag, "", "path to a YAML or TOML configuration file")
+	values := make(map[string]*string, len(specs))
+	for _, spec := range specs {
+		values[spec.env] = flags.String(flagName(spec.env), "", spec.env)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, "", nil, fmt.Errorf("parsing flags: %w", err)
+	}
+
+	l := make(layer)
+	flags.Visit(func(f *flag.Flag) {
+		for _, spec := range specs {
+			if flagName(spec.env) == f.Name {
+				l[spec.env] = value{raw: *values[spec.env], source: SourceFlag}
+			}
+		}
+	})
+
+	return l, *configFile, flags.Args(), nil
+}
+
+// resolve picks, for each field, the value from the highest-precedence layer that has one
+func resolve(specs []fieldSpec, layers ...layer) map[string]value {
+	resolved := make(map[string]value, len(specs))
+	for _, spec := range specs {
+		for i := len(layers) - 1; i >= 0; i-- {
+			if v, ok := layers[i][spec.env]; ok {
+				resolved[spec.env] = v
+				break
+			}
+		}
+	}
+	return resolved
+}
+
+// Source reports where the value of the named field came from
+// (default, file:<path>, dotenv, env, secret_file:<path> or flag)
+func (c *Config) Source(field string) string {
+	if src, ok := c.sources[field]; ok {
+		return src
+	}
+	return SourceDefault
+}
+
+// Setting is a configuration value with its origin, as reported by Settings
+type Setting struct {
+	Env    string
+	Value  any
+	Source string
+}
+
+// Settings lists every configuration value with its source; secrets are redacted
+func (c *Config) Settings() []Setting {
+	val := reflect.ValueOf(c).Elem()
+
+	specs := configFields()
+	settings := make([]Setting, 0, len(specs))
+	for _, spec := range specs {
+		settings = append(settings, Setting{
+			Env:    spec.env,
+			Value:  displayValue(spec, val.Field(spec.index)),
+			Source: c.Source(spec.name),
+		})
+	}
+	return settings
+}
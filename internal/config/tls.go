@@ -0,0 +1,55 @@
+package config
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForwardedProtoHeader is the header set by reverse proxies to the original request scheme
+const ForwardedProtoHeader = "X-Forwarded-Proto"
+
+// IsSecureRequest reports whether the request arrived over HTTPS, either
+// terminated in-process or by a trusted proxy that set X-Forwarded-Proto
+func IsSecureRequest(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+
+	proto := c.GetHeader(ForwardedProtoHeader)
+	if proto == "" {
+		return false
+	}
+	// Proxy chains may append several values; the first is the client-facing one
+	proto, _, _ = strings.Cut(proto, ",")
+	if !strings.EqualFold(strings.TrimSpace(proto), "https") {
+		return false
+	}
+
+	cfg := FromContext(c)
+	if cfg == nil {
+		return false
+	}
+	return cfg.isTrustedProxy(c.Request.RemoteAddr)
+}
+
+// isTrustedProxy reports whether remoteAddr belongs to one of the configured trusted proxies
+func (c *Config) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		// Values were validated at load time
+		if ipNet, err := ParseCIDR(proxy); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
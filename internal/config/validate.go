@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"vibed-traveller/internal/vault"
+)
+
+// Validation error kinds, usable with errors.Is on a *FieldError
+var (
+	// ErrParse indicates a value could not be converted to the field's type
+	ErrParse = errors.New("invalid value")
+
+	// ErrRequired indicates a required value is missing
+	ErrRequired = errors.New("value is required")
+
+	// ErrInvalidURL indicates a value is not an absolute http(s) URL
+	ErrInvalidURL = errors.New("invalid URL")
+
+	// ErrPortRange indicates a port is outside 1-65535
+	ErrPortRange = errors.New("port out of range")
+
+	// ErrInvalidEnum indicates a value is not one of the allowed options
+	ErrInvalidEnum = errors.New("value not allowed")
+
+	// ErrInvalidCIDR indicates a value is neither an IP address nor a CIDR range
+	ErrInvalidCIDR = errors.New("invalid IP or CIDR")
+
+	// ErrIncomplete indicates a value only makes sense together with another one
+	ErrIncomplete = errors.New("incomplete settings")
+)
+
+// FieldError describes a single invalid configuration value
+type FieldError struct {
+	Field string
+	Env   string
+	Value string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Env, e.Err)
+}
+
+// Unwrap returns the underlying error kind
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError aggregates every problem found while loading configuration
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("invalid configuration (%d problems): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap exposes the individual field errors to errors.Is and errors.As
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		errs = append(errs, fe)
+	}
+	return errs
+}
+
+// Validate checks every field against the rules in its validate tag
+func (c *Config) Validate() error {
+	if errs := c.validate(nil); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validate applies the validate tag rules to each field, skipping fields listed in skip
+func (c *Config) validate(skip []*FieldError) []*FieldError {
+	failed := make(map[string]bool, len(skip))
+	for _, fe := range skip {
+		failed[fe.Field] = true
+	}
+
+	val := reflect.ValueOf(c).Elem()
+	typ := val.Type()
+
+	var errs []*FieldError
+	for i := 0; i < val.NumField(); i++ {
+		fieldType := typ.Field(i)
+		rules := fieldType.Tag.Get("validate")
+		if rules == "" || failed[fieldType.Name] {
+			continue
+		}
+
+		field := val.Field(i)
+		for _, rule := range strings.Split(rules, ",") {
+			if err := checkRule(field, rule); err != nil {
+				fe := &FieldError{
+					Field: fieldType.Name,
+					Env:   fieldType.Tag.Get("env"),
+					Value: fmt.Sprint(field.Interface()),
+					Err:   err,
+				}
+				if fieldType.Tag.Get("secret") == "true" {
+					fe.Value = redacted
+				}
+				errs = append(errs, fe)
+				// Report only the first failing rule per field
+				break
+			}
+		}
+	}
+
+	return append(errs, c.validateCombinations(failed)...)
+}
+
+// validateCombinations checks rules that span several fields
+func (c *Config) validateCombinations(failed map[string]bool) []*FieldError {
+	var errs []*FieldError
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") && !failed["TLSCertFile"] && !failed["TLSKeyFile"] {
+		errs = append(errs, &FieldError{
+			Field: "TLSCertFile",
+			Env:   "TLS_CERT_FILE",
+			Err:   fmt.Errorf("%w: TLS_CERT_FILE and TLS_KEY_FILE must be set together", ErrIncomplete),
+		})
+	}
+
+	if c.HTTPRedirectPort != "" && !c.TLSEnabled() && !failed["HTTPRedirectPort"] {
+		errs = append(errs, &FieldError{
+			Field: "HTTPRedirectPort",
+			Env:   "HTTP_REDIRECT_PORT",
+			Value: c.HTTPRedirectPort,
+			Err:   fmt.Errorf("%w: HTTP_REDIRECT_PORT requires TLS to be enabled", ErrIncomplete),
+		})
+	}
+
+	if c.BlobStorage == "s3" && !failed["BlobStorage"] {
+		for _, setting := range []struct{ field, env, value string }{
+			{"S3Endpoint", "S3_ENDPOINT", c.S3Endpoint},
+			{"S3Bucket", "S3_BUCKET", c.S3Bucket},
+			{"S3AccessKeyID", "S3_ACCESS_KEY_ID", c.S3AccessKeyID},
+			{"S3SecretAccessKey", "S3_SECRET_ACCESS_KEY", c.S3SecretAccessKey},
+		} {
+			if setting.value == "" && !failed[setting.field] {
+				errs = append(errs, &FieldError{
+					Field: setting.field,
+					Env:   setting.env,
+					Err:   fmt.Errorf("%w: BLOB_STORAGE=s3 requires %s", ErrIncomplete, setting.env),
+				})
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkRule validates a field against a single rule
+func checkRule(field reflect.Value, rule string) error {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	if name == "required" {
+		if field.IsZero() || (field.Kind() == reflect.Slice && field.Len() == 0) {
+			return ErrRequired
+		}
+		return nil
+	}
+
+	// The remaining rules apply to each element of a list
+	if field.Kind() == reflect.Slice {
+		for i := 0; i < field.Len(); i++ {
+			if err := checkRule(field.Index(i), rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The remaining rules only apply to non-empty string values
+	if field.Kind() != reflect.String || field.String() == "" {
+		return nil
+	}
+	value := field.String()
+
+	switch name {
+	case "url":
+		_, err := parseAbsoluteURL(value)
+		return err
+	case "port":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a number", ErrParse, value)
+		}
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%w: %d", ErrPortRange, port)
+		}
+	case "cidr":
+		if _, err := ParseCIDR(value); err != nil {
+			return err
+		}
+	case "key":
+		if _, err := vault.ParseKey(value); err != nil {
+			return fmt.Errorf("%w: %v", ErrParse, err)
+		}
+	case "origin":
+		// Cross-origin requests carry credentials, so every origin is listed
+		if value == "*" {
+			return fmt.Errorf("%w: %q cannot be used since credentials are allowed, list the origins instead", ErrInvalidEnum, value)
+		}
+	case "regexp":
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("%w: %v", ErrParse, err)
+		}
+	case "oneof":
+		options := strings.Split(arg, "|")
+		for _, option := range options {
+			if value == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %q must be one of %s", ErrInvalidEnum, value, strings.Join(options, ", "))
+	default:
+		return fmt.Errorf("unknown validation rule %q", name)
+	}
+
+	return nil
+}
+
+// parseAbsoluteURL parses a value that must be an absolute http or https URL
+func parseAbsoluteURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %q must use http or https", ErrInvalidURL, value)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: %q has no host", ErrInvalidURL, value)
+	}
+	return u, nil
+}
+
+// ParseCIDR parses a CIDR range, treating a bare IP address as a single-host range
+func ParseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, value)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, value)
+	}
+	return ipNet, nil
+}
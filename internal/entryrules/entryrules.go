@@ -0,0 +1,239 @@
+package entryrules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// Finding severities, as in trip warnings
+const (
+	// SeverityError means the traveller cannot enter as planned
+	SeverityError = "error"
+	// SeverityWarning means the traveller has something to do before leaving
+	SeverityWarning = "warning"
+)
+
+// Finding codes
+const (
+	FindingRuleMissing      = "entry_rule_missing"
+	FindingPassportMissing  = "passport_missing"
+	FindingVisaRequired     = "visa_required"
+	FindingEVisaRequired    = "e_visa_required"
+	FindingVisaOnArrival    = "visa_on_arrival"
+	FindingStayTooLong      = "stay_exceeds_limit"
+	FindingPassportTooShort = "passport_validity_insufficient"
+)
+
+// Passport is a passport a traveller can enter countries with
+type Passport struct {
+	Nationality string
+	// ExpiresOn is the expiry date (YYYY-MM-DD), or empty if unknown
+	ExpiresOn string
+	// DocumentID is the passport in the document vault, if it comes from there
+	DocumentID string
+}
+
+// Visa is a visa a traveller holds
+type Visa struct {
+	Country    string
+	ExpiresOn  string
+	DocumentID string
+}
+
+// Traveller is someone on a trip with the documents they travel with
+type Traveller struct {
+	Name      string
+	Passports []Passport
+	Visas     []Visa
+}
+
+// Visit is a stay in a country, from the day of entry to the day of departure
+type Visit struct {
+	Country  string
+	EntersOn time.Time
+	LeavesOn time.Time
+}
+
+// Days is the length of the stay, counting the days of entry and departure
+func (v Visit) Days() int {
+	return int(v.LeavesOn.Sub(v.EntersOn).Hours()/24) + 1
+}
+
+// Finding is something a traveller has to act on to enter a country
+type Finding struct {
+	Code     string `json:"code" doc:"Stable machine-readable finding code"`
+	Severity string `json:"severity" doc:"error if the traveller cannot enter as planned, otherwise warning"`
+	Message  string `json:"message"`
+}
+
+// Check is the evaluation of one traveller's entry into one country
+type Check struct {
+	Traveller       string           `json:"traveller"`
+	Nationality     string           `json:"nationality,omitempty" doc:"Passport checked: the most favourable one of travellers holding several"`
+	DocumentID      string           `json:"document_id,omitempty" doc:"The passport in the document vault"`
+	Destination     string           `json:"destination" doc:"ISO 3166-1 alpha-2 code"`
+	DestinationName string           `json:"destination_name"`
+	EntersOn        string           `json:"enters_on"`
+	LeavesOn        string           `json:"leaves_on"`
+	StayDays        int              `json:"stay_days"`
+	Rule            *store.EntryRule `json:"rule,omitempty" doc:"Rule in force on the day of entry; absent if there is none for the passport"`
+	Findings        []Finding        `json:"findings" doc:"Errors first; empty if the traveller has nothing to do"`
+}
+
+// Evaluate checks each traveller against each visit, with the rules in force
+// on the day of entry. Travellers holding a passport of the country visited
+// are not checked; of several other passports, the one leaving the least to
+// do is checked.
+func Evaluate(ctx context.Context, st *store.Store, travellers []Traveller, visits []Visit) ([]*Check, error) {
+	names := map[string]string{}
+	var checks []*Check
+	for _, v := range visits {
+		name, ok := names[v.Country]
+		if !ok {
+			var err error
+			name, err = st.CountryName(ctx, v.Country)
+			if errors.Is(err, store.ErrNotFound) {
+				name = v.Country
+			} else if err != nil {
+				return nil, err
+			}
+			names[v.Country] = name
+		}
+
+		for _, t := range travellers {
+			if slices.ContainsFunc(t.Passports, func(p Passport) bool { return p.Nationality == v.Country }) {
+				continue
+			}
+			check := &Check{
+				Traveller:       t.Name,
+				Destination:     v.Country,
+				DestinationName: name,
+				EntersOn:        v.EntersOn.Format(time.DateOnly),
+				LeavesOn:        v.LeavesOn.Format(time.DateOnly),
+				StayDays:        v.Days(),
+				Findings:        []Finding{},
+			}
+			if len(t.Passports) == 0 {
+				check.Findings = append(check.Findings, Finding{
+					Code:     FindingPassportMissing,
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("%s has no passport on file; add one to check the requirements for entering %s", t.Name, name),
+				})
+				checks = append(checks, check)
+				continue
+			}
+
+			var best *Check
+			for _, p := range t.Passports {
+				candidate := *check
+				if err := candidate.evaluate(ctx, st, t, p, v); err != nil {
+					return nil, err
+				}
+				if best == nil || candidate.effort() < best.effort() {
+					best = &candidate
+				}
+			}
+			checks = append(checks, best)
+		}
+	}
+	return checks, nil
+}
+
+// evaluate checks a passport against the rule in force on the day of entry
+func (c *Check) evaluate(ctx context.Context, st *store.Store, t Traveller, p Passport, v Visit) error {
+	c.Nationality, c.DocumentID = p.Nationality, p.DocumentID
+	rule, err := st.EntryRuleOn(ctx, p.Nationality, v.Country, c.EntersOn)
+	if errors.Is(err, store.ErrNotFound) {
+		c.Findings = []Finding{{
+			Code:     FindingRuleMissing,
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("No entry requirements are known for %s passports in %s; check them with the embassy",
+				p.Nationality, c.DestinationName),
+		}}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.Rule = rule
+
+	var errs, warnings []Finding
+	leaves := c.LeavesOn
+	if rule.PassportValidityMonths > 0 {
+		leaves = v.LeavesOn.AddDate(0, rule.PassportValidityMonths, 0).Format(time.DateOnly)
+	}
+	if p.ExpiresOn != "" && p.ExpiresOn < leaves {
+		message := fmt.Sprintf("%s's %s passport expires on %s, before leaving %s on %s",
+			t.Name, p.Nationality, p.ExpiresOn, c.DestinationName, c.LeavesOn)
+		if rule.PassportValidityMonths > 0 {
+			message = fmt.Sprintf("%s's %s passport expires on %s; %s requires it to be valid until %s, %d months after leaving",
+				t.Name, p.Nationality, p.ExpiresOn, c.DestinationName, leaves, rule.PassportValidityMonths)
+		}
+		errs = append(errs, Finding{Code: FindingPassportTooShort, Severity: SeverityError, Message: message})
+	}
+	if rule.MaxStayDays != nil && c.StayDays > *rule.MaxStayDays {
+		errs = append(errs, Finding{
+			Code:     FindingStayTooLong,
+			Severity: SeverityError,
+			Message: fmt.Sprintf("%s stays %d days in %s, longer than the %d days allowed to %s passports",
+				t.Name, c.StayDays, c.DestinationName, *rule.MaxStayDays, p.Nationality),
+		})
+	}
+
+	switch rule.Requirement {
+	case store.EntryVisaRequired, store.EntryEVisa:
+		if holdsVisa(t, v.Country, c.LeavesOn) {
+			break
+		}
+		finding := Finding{
+			Code:     FindingVisaRequired,
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s needs a visa to enter %s on %s with a %s passport; apply at the embassy or consulate",
+				t.Name, c.DestinationName, c.EntersOn, p.Nationality),
+		}
+		if rule.Requirement == store.EntryEVisa {
+			finding.Code = FindingEVisaRequired
+			finding.Message = fmt.Sprintf("%s needs an e-visa to enter %s on %s with a %s passport; apply online before leaving",
+				t.Name, c.DestinationName, c.EntersOn, p.Nationality)
+		}
+		warnings = append(warnings, finding)
+	case store.EntryVisaOnArrival:
+		warnings = append(warnings, Finding{
+			Code:     FindingVisaOnArrival,
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s gets a visa on arrival in %s; check the fee and what the border asks for",
+				t.Name, c.DestinationName),
+		})
+	}
+	c.Findings = append(append([]Finding{}, errs...), warnings...)
+	return nil
+}
+
+// effort ranks checks by how much they leave to do: errors, then warnings,
+// then how demanding the requirement is
+func (c *Check) effort() int {
+	effort := len(store.EntryRequirements)
+	if c.Rule != nil {
+		effort = slices.Index(store.EntryRequirements, c.Rule.Requirement)
+	}
+	for _, f := range c.Findings {
+		if f.Severity == SeverityError {
+			effort += 1000
+		} else {
+			effort += 10
+		}
+	}
+	return effort
+}
+
+// holdsVisa reports whether a traveller has a visa for a country valid until a day
+func holdsVisa(t Traveller, country, until string) bool {
+	return slices.ContainsFunc(t.Visas, func(visa Visa) bool {
+		return visa.Country == country && (visa.ExpiresOn == "" || visa.ExpiresOn >= until)
+	})
+}
@@ -0,0 +1,181 @@
+package entryrules
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// Columns of rule files; the others are optional
+var requiredColumns = []string{"nationality", "destination", "requirement", "effective_from"}
+
+// countryCode matches ISO 3166-1 alpha-2 codes
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ImportStats counts the rules stored by an import
+type ImportStats struct {
+	Created int `json:"created"`
+	Updated int `json:"updated" doc:"Rules replaced by one of the same nationality, destination and effective_from"`
+}
+
+// FormatError reports an invalid row of a rule file
+type FormatError struct {
+	// Line is the line of the file the error was found on, or 0 for the whole file
+	Line int
+	Err  error
+}
+
+func (e *FormatError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// Import reads a CSV file of rules, with a header row naming its columns:
+// nationality, destination, requirement and effective_from, and optionally
+// max_stay_days, passport_validity_months, effective_until and notes. Lines
+// starting with # are comments. A rule replaces the stored rule of the same
+// nationality, destination and effective_from; the file is imported entirely
+// or not at all.
+func Import(ctx context.Context, st *store.Store, file io.Reader) (*ImportStats, error) {
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &FormatError{Err: errors.New("the file is empty")}
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range requiredColumns {
+		if _, ok := cols[required]; !ok {
+			return nil, &FormatError{Line: 1, Err: fmt.Errorf("missing column %q", required)}
+		}
+	}
+
+	imp, err := st.BeginEntryRuleImport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ImportStats{}
+	if err := importRules(r, cols, imp, stats); err != nil {
+		_ = imp.Rollback()
+		return nil, err
+	}
+	if err := imp.Commit(); err != nil {
+		if errors.Is(err, store.ErrEntryRuleOverlap) {
+			return nil, &FormatError{Err: err}
+		}
+		return nil, err
+	}
+	return stats, nil
+}
+
+// importRules reads rule rows
+func importRules(r *csv.Reader, cols map[string]int, imp *store.EntryRuleImport, stats *ImportStats) error {
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return csvError(err)
+		}
+		line, _ := r.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		rule, err := parseRule(field)
+		if err != nil {
+			return &FormatError{Line: line, Err: err}
+		}
+		created, err := imp.AddRule(rule)
+		if err != nil {
+			return err
+		}
+		if created {
+			stats.Created++
+		} else {
+			stats.Updated++
+		}
+	}
+}
+
+// parseRule reads and validates the fields of a rule row
+func parseRule(field func(string) string) (*store.EntryRule, error) {
+	r := &store.EntryRule{
+		Nationality:    strings.ToUpper(field("nationality")),
+		Destination:    strings.ToUpper(field("destination")),
+		Requirement:    strings.ToLower(field("requirement")),
+		EffectiveFrom:  field("effective_from"),
+		EffectiveUntil: field("effective_until"),
+		Notes:          field("notes"),
+	}
+	if r.Nationality != store.AnyNationality && !countryCode.MatchString(r.Nationality) {
+		return nil, fmt.Errorf("nationality %q is not an ISO 3166-1 alpha-2 code or *", r.Nationality)
+	}
+	if !countryCode.MatchString(r.Destination) {
+		return nil, fmt.Errorf("destination %q is not an ISO 3166-1 alpha-2 code", r.Destination)
+	}
+	if !slices.Contains(store.EntryRequirements, r.Requirement) {
+		return nil, fmt.Errorf("requirement %q is not one of %s", r.Requirement, strings.Join(store.EntryRequirements, ", "))
+	}
+	if _, err := time.Parse(time.DateOnly, r.EffectiveFrom); err != nil {
+		return nil, fmt.Errorf("effective_from %q is not a YYYY-MM-DD date", r.EffectiveFrom)
+	}
+	if r.EffectiveUntil != "" {
+		if _, err := time.Parse(time.DateOnly, r.EffectiveUntil); err != nil {
+			return nil, fmt.Errorf("effective_until %q is not a YYYY-MM-DD date", r.EffectiveUntil)
+		}
+		if r.EffectiveUntil <= r.EffectiveFrom {
+			return nil, errors.New("effective_until must be after effective_from")
+		}
+	}
+	if s := field("max_stay_days"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("max_stay_days %q is not a positive number", s)
+		}
+		r.MaxStayDays = &days
+	}
+	if s := field("passport_validity_months"); s != "" {
+		months, err := strconv.Atoi(s)
+		if err != nil || months < 0 || months > 120 {
+			return nil, fmt.Errorf("passport_validity_months %q is not a number of months", s)
+		}
+		r.PassportValidityMonths = months
+	}
+	return r, nil
+}
+
+// csvError converts CSV syntax errors to FormatError
+func csvError(err error) error {
+	var parse *csv.ParseError
+	if errors.As(err, &parse) {
+		return &FormatError{Line: parse.Line, Err: parse.Err}
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package filestamp
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileStamp is what a watched path resolved to when last looked at
+type fileStamp struct {
+	info    os.FileInfo
+	modTime time.Time
+	size    int64
+}
+
+// Stamps remembers the files behind a set of paths, following symlinks,
+// so that a change is seen even when no file event names the path itself:
+// Kubernetes ConfigMap updates and certbot renewals swap a symlink elsewhere
+// in the directory.
+type Stamps struct {
+	stamps map[string]fileStamp
+}
+
+// New records the current state of files, ignoring empty paths
+func New(files ...string) *Stamps {
+	s := &Stamps{stamps: map[string]fileStamp{}}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(file); err == nil {
+			s.stamps[abs] = stat(abs)
+		}
+	}
+	return s
+}
+
+// Paths returns the absolute paths being tracked
+func (s *Stamps) Paths() []string {
+	paths := make([]string, 0, len(s.stamps))
+	for path := range s.stamps {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Changed looks at the files again and reports whether any of them was
+// replaced, modified, created or removed since the last look
+func (s *Stamps) Changed() bool {
+	changed := false
+	for path, before := range s.stamps {
+		after := stat(path)
+		if !sameStamp(before, after) {
+			s.stamps[path] = after
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stat returns the stamp of the file at path, which has no info if it is missing
+func stat(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info: info, modTime: info.ModTime(), size: info.Size()}
+}
+
+// sameStamp reports whether two stamps describe the same unchanged file
+func sameStamp(a, b fileStamp) bool {
+	if a.info == nil || b.info == nil {
+		return a.info == nil && b.info == nil
+	}
+	return os.SameFile(a.info, b.info) && a.modTime.Equal(b.modTime) && a.size == b.size
+}
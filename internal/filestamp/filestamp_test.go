@@ -0,0 +1,80 @@
+package filestamp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChanged(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	expect := func(s *Stamps, want bool, what string) {
+		t.Helper()
+		if got := s.Changed(); got != want {
+			t.Errorf("Changed() after %s = %t, want %t", what, got, want)
+		}
+	}
+
+	file := write("app.env", "A=1")
+	s := New(file, "")
+	if len(s.Paths()) != 1 {
+		t.Fatalf("Paths() = %v, want only %s", s.Paths(), file)
+	}
+	expect(s, false, "nothing")
+
+	write("app.env", "A=12")
+	expect(s, true, "a write")
+	expect(s, false, "the write was seen")
+
+	// Kubernetes mounts ConfigMaps as symlinks into a ..data directory,
+	// which is swapped to update them
+	for _, name := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		write(filepath.Join(name, "tls.crt"), "same size")
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	linked := filepath.Join(dir, "tls.crt")
+	if err := os.Symlink(filepath.Join("..data", "tls.crt"), linked); err != nil {
+		t.Fatal(err)
+	}
+	// Same size and mtime, so only the file identity tells them apart
+	mtime := time.Now().Add(-time.Hour)
+	for _, name := range []string{"v1", "v2"} {
+		if err := os.Chtimes(filepath.Join(dir, name, "tls.crt"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s = New(linked)
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink("v2", tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	expect(s, true, "a symlink swap")
+
+	missing := filepath.Join(dir, "later.env")
+	s = New(missing)
+	expect(s, false, "nothing")
+	write("later.env", "B=2")
+	expect(s, true, "creation")
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	expect(s, true, "removal")
+	expect(s, false, "the removal was seen")
+}
@@ -0,0 +1,74 @@
+package geo
+
+import "math"
+
+// EarthRadiusKM is the mean Earth radius used for great-circle calculations
+const EarthRadiusKM = 6371.0088
+
+// Point is a WGS 84 coordinate in degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Valid reports whether p is within the latitude and longitude ranges
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+// DistanceKM returns the great-circle distance between two points (haversine formula)
+func DistanceKM(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := radians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKM * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBox returns the latitude and longitude deltas, in degrees, that
+// enclose a circle of radiusKM around p. The longitude delta is 180 near the poles.
+func BoundingBox(p Point, radiusKM float64) (dLat, dLon float64) {
+	dLat = degrees(radiusKM / EarthRadiusKM)
+	cos := math.Cos(radians(p.Lat))
+	if cos < 1e-6 {
+		return dLat, 180
+	}
+	dLon = math.Min(180, dLat/cos)
+	return dLat, dLon
+}
+
+// Intermediate returns the point a fraction f of the way from a to b along
+// the great circle through them
+func Intermediate(a, b Point, f float64) Point {
+	lat1, lon1, lat2, lon2 := radians(a.Lat), radians(a.Lon), radians(b.Lat), radians(b.Lon)
+	d := DistanceKM(a, b) / EarthRadiusKM
+	if d == 0 {
+		return a
+	}
+	x, y := math.Sin((1-f)*d)/math.Sin(d), math.Sin(f*d)/math.Sin(d)
+	cx := x*math.Cos(lat1)*math.Cos(lon1) + y*math.Cos(lat2)*math.Cos(lon2)
+	cy := x*math.Cos(lat1)*math.Sin(lon1) + y*math.Cos(lat2)*math.Sin(lon2)
+	cz := x*math.Sin(lat1) + y*math.Sin(lat2)
+	return Point{Lat: degrees(math.Atan2(cz, math.Hypot(cx, cy))), Lon: degrees(math.Atan2(cy, cx))}
+}
+
+// GreatCircle returns the points of the great circle from a to b, both
+// included, spaced at most stepKM apart. Antipodal points have no single
+// great circle; the result is then undefined.
+func GreatCircle(a, b Point, stepKM float64) []Point {
+	n := int(math.Ceil(DistanceKM(a, b) / stepKM))
+	if n < 1 {
+		n = 1
+	}
+	points := make([]Point, 0, n+1)
+	points = append(points, a)
+	for i := 1; i < n; i++ {
+		points = append(points, Intermediate(a, b, float64(i)/float64(n)))
+	}
+	return append(points, b)
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
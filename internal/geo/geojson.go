@@ -0,0 +1,57 @@
+package geo
+
+import "math"
+
+// GeoJSON geometry types (RFC 7946)
+const (
+	GeometryPoint           = "Point"
+	GeometryLineString      = "LineString"
+	GeometryMultiLineString = "MultiLineString"
+)
+
+// Geometry is a GeoJSON geometry. Coordinates are [longitude, latitude]
+// positions nested according to the type.
+type Geometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// NewPoint returns the Point geometry of p
+func NewPoint(p Point) Geometry {
+	return Geometry{Type: GeometryPoint, Coordinates: position(p)}
+}
+
+// NewLine returns the geometry of a line through points. Lines crossing the
+// antimeridian are cut there into a MultiLineString, as RFC 7946 requires,
+// so that maps don't draw them the long way around the world.
+func NewLine(points []Point) Geometry {
+	var (
+		parts [][][2]float64
+		part  [][2]float64
+	)
+	for i, p := range points {
+		if i > 0 {
+			prev := points[i-1]
+			if math.Abs(p.Lon-prev.Lon) > 180 {
+				// Interpolate the crossing latitude with p's longitude unwrapped
+				edge := math.Copysign(180, prev.Lon)
+				lon := p.Lon + math.Copysign(360, prev.Lon)
+				lat := prev.Lat + (p.Lat-prev.Lat)*(edge-prev.Lon)/(lon-prev.Lon)
+				part = append(part, position(Point{Lat: lat, Lon: edge}))
+				parts = append(parts, part)
+				part = [][2]float64{position(Point{Lat: lat, Lon: -edge})}
+			}
+		}
+		part = append(part, position(p))
+	}
+	parts = append(parts, part)
+	if len(parts) == 1 {
+		return Geometry{Type: GeometryLineString, Coordinates: part}
+	}
+	return Geometry{Type: GeometryMultiLineString, Coordinates: parts}
+}
+
+// position returns the GeoJSON position of p, rounded to 6 decimals (about 10 cm)
+func position(p Point) [2]float64 {
+	return [2]float64{math.Round(p.Lon*1e6) / 1e6, math.Round(p.Lat*1e6) / 1e6}
+}
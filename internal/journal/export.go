@@ -0,0 +1,155 @@
+package journal
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// dayLayout is the layout of the dates of journal entries
+const dayLayout = "2006-01-02"
+
+// dayTitleLayout is how days are named in exports
+const dayTitleLayout = "Monday 2 January 2006"
+
+// Photo is a photo illustrating an entry
+type Photo struct {
+	Filename string
+
+	// URL is a link to an image of the photo, empty when photos cannot be linked to
+	URL string
+}
+
+// Entry is a journal entry with the items and photos it links to
+type Entry struct {
+	*store.JournalEntry
+	Items  []*store.ItineraryItem
+	Photos []Photo
+}
+
+// Day is a day of a trip and the entries written about it
+type Day struct {
+	Date time.Time
+
+	// Index is the number of the day in the trip, from 1, or 0 for days
+	// before it starts
+	Index   int
+	Entries []Entry
+}
+
+// Export is a trip's journal, ready to be written as Markdown or HTML
+type Export struct {
+	Trip *store.Trip
+	Days []*Day
+}
+
+// NewExport loads the journal entries of a trip that userID can see, only
+// the shared ones with sharedOnly. photoURL returns the link to an image of
+// a photo attachment, or an empty string.
+func NewExport(ctx context.Context, st *store.Store, trip *store.Trip, userID string, sharedOnly bool,
+	photoURL func(*store.Attachment) string) (*Export, error) {
+	entries, err := st.Journal(ctx, trip.ID, userID, sharedOnly)
+	if err != nil {
+		return nil, err
+	}
+	items, err := st.AllItems(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+	photos, err := st.TripPhotos(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+	itemsByID := make(map[string]*store.ItineraryItem, len(items))
+	for _, it := range items {
+		itemsByID[it.ID] = it
+	}
+	photosByID := make(map[string]*store.Attachment, len(photos))
+	for _, a := range photos {
+		photosByID[a.ID] = a
+	}
+
+	e := &Export{Trip: trip}
+	var firstDay time.Time
+	if start, err := time.Parse(dayLayout, trip.StartDate); err == nil {
+		firstDay = start
+	}
+	for _, entry := range entries {
+		// Entries are validated on save
+		date, _ := time.Parse(dayLayout, entry.Day)
+		if firstDay.IsZero() {
+			firstDay = date
+		}
+		if len(e.Days) == 0 || !e.Days[len(e.Days)-1].Date.Equal(date) {
+			day := &Day{Date: date}
+			if !date.Before(firstDay) {
+				day.Index = int(math.Round(date.Sub(firstDay).Hours()/24)) + 1
+			}
+			e.Days = append(e.Days, day)
+		}
+
+		exported := Entry{JournalEntry: entry}
+		for _, id := range entry.ItemIDs {
+			if it := itemsByID[id]; it != nil {
+				exported.Items = append(exported.Items, it)
+			}
+		}
+		for _, id := range entry.PhotoIDs {
+			if a := photosByID[id]; a != nil {
+				exported.Photos = append(exported.Photos, Photo{Filename: a.Filename, URL: photoURL(a)})
+			}
+		}
+		day := e.Days[len(e.Days)-1]
+		day.Entries = append(day.Entries, exported)
+	}
+	return e, nil
+}
+
+// title returns the heading of a day
+func (d *Day) title() string {
+	if d.Index == 0 {
+		return d.Date.Format(dayTitleLayout)
+	}
+	return "Day " + strconv.Itoa(d.Index) + " · " + d.Date.Format(dayTitleLayout)
+}
+
+// dates returns the dates of a trip, as shown under its name
+func (e *Export) dates() string {
+	var dates []string
+	for _, d := range []string{e.Trip.StartDate, e.Trip.EndDate} {
+		if t, err := time.Parse(dayLayout, d); err == nil {
+			dates = append(dates, t.Format("2 January 2006"))
+		}
+	}
+	return strings.Join(dates, " – ")
+}
+
+// conditions returns the mood and weather of an entry as a line of text
+func (e *Entry) conditions() string {
+	var parts []string
+	if e.Mood != "" {
+		parts = append(parts, "Mood: "+label(e.Mood))
+	}
+	if e.Weather != "" {
+		parts = append(parts, "Weather: "+label(e.Weather))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// itemName returns how an item is named in exports
+func itemName(it *store.ItineraryItem) string {
+	if it.Location != "" && it.Location != it.Title {
+		return it.Title + " (" + it.Location + ")"
+	}
+	return it.Title
+}
+
+// label turns a value such as partly_cloudy into text such as Partly cloudy
+func label(value string) string {
+	value = strings.ReplaceAll(value, "_", " ")
+	return strings.ToUpper(value[:1]) + value[1:]
+}
@@ -0,0 +1,96 @@
+package journal
+
+import (
+	"html/template"
+	"io"
+)
+
+// HTMLContentType is the media type of HTML documents
+const HTMLContentType = "text/html; charset=utf-8"
+
+// htmlTemplate lays the journal out as a standalone page meant for printing.
+// Entry bodies are inserted as rendered, which escapes any HTML they hold.
+var htmlTemplate = template.Must(template.New("journal").Funcs(template.FuncMap{
+	"itemName": itemName,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Trip.Name}}</title>
+<style>
+body { font-family: Georgia, serif; line-height: 1.5; max-width: 42em; margin: 2em auto; padding: 0 1em; color: #222; }
+h1 { margin-bottom: 0; }
+.dates, .conditions, .links { color: #555; }
+h2 { margin-top: 2em; border-bottom: 1px solid #ccc; break-after: avoid; }
+h3 { break-after: avoid; }
+.entry + .entry { border-top: 1px dotted #ccc; }
+.body h1, .body h2, .body h3, .body h4, .body h5, .body h6 { font-size: 1em; }
+figure { margin: 1em 0; break-inside: avoid; }
+figure img { max-width: 100%; }
+figcaption { font-size: 0.85em; color: #555; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: 3px solid #ccc; }
+pre { white-space: pre-wrap; }
+@media print { a { color: inherit; } }
+</style>
+</head>
+<body>
+<h1>{{.Trip.Name}}</h1>
+{{with .Dates}}<p class="dates">{{.}}</p>
+{{end}}
+{{- range .Days}}
+<section class="day">
+<h2>{{.Title}}</h2>
+{{- range .Entries}}
+<article class="entry">
+{{with .Title}}<h3>{{.}}</h3>
+{{end}}
+{{- with .Conditions}}<p class="conditions"><em>{{.}}</em></p>
+{{end}}
+<div class="body">
+{{.Body}}</div>
+{{- with .Items}}
+<p class="links"><strong>Itinerary:</strong> {{range $i, $it := .}}{{if $i}}, {{end}}{{itemName $it}}{{end}}</p>
+{{- end}}
+{{- range .Photos}}
+<figure>{{if .URL}}<img src="{{.URL}}" alt="{{.Filename}}">{{end}}<figcaption>{{.Filename}}</figcaption></figure>
+{{- end}}
+</article>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// htmlEntry is an entry as laid out by htmlTemplate
+type htmlEntry struct {
+	*Entry
+	Conditions string
+	Body       template.HTML
+}
+
+// htmlDay is a day as laid out by htmlTemplate
+type htmlDay struct {
+	Title   string
+	Entries []htmlEntry
+}
+
+// WriteHTML writes the journal as a standalone HTML page, with the entries'
+// Markdown rendered
+func (e *Export) WriteHTML(w io.Writer) error {
+	data := struct {
+		*Export
+		Dates string
+		Days  []htmlDay
+	}{Export: e, Dates: e.dates()}
+	for _, day := range e.Days {
+		d := htmlDay{Title: day.title()}
+		for i := range day.Entries {
+			entry := &day.Entries[i]
+			// BodyHTML is sanitized Markdown output
+			d.Entries = append(d.Entries, htmlEntry{Entry: entry, Conditions: entry.conditions(), Body: template.HTML(entry.BodyHTML)})
+		}
+		data.Days = append(data.Days, d)
+	}
+	return htmlTemplate.Execute(w, data)
+}
@@ -0,0 +1,64 @@
+package journal
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"vibed-traveller/internal/markdown"
+)
+
+// MarkdownContentType is the media type of Markdown documents
+const MarkdownContentType = "text/markdown; charset=utf-8"
+
+// WriteMarkdown writes the journal as a Markdown document: the trip as the
+// title, a section per day and the entries of the day, their text as written
+func (e *Export) WriteMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# " + markdown.Escape(e.Trip.Name) + "\n")
+	if dates := e.dates(); dates != "" {
+		bw.WriteString("\n" + dates + "\n")
+	}
+	for _, day := range e.Days {
+		bw.WriteString("\n## " + day.title() + "\n")
+		for i, entry := range day.Entries {
+			if i > 0 && entry.Title == "" {
+				bw.WriteString("\n* * *\n")
+			}
+			if entry.Title != "" {
+				bw.WriteString("\n### " + markdown.Escape(entry.Title) + "\n")
+			}
+			if conditions := entry.conditions(); conditions != "" {
+				bw.WriteString("\n*" + conditions + "*\n")
+			}
+			if body := strings.TrimSpace(entry.Body); body != "" {
+				bw.WriteString("\n" + body + "\n")
+			}
+			if len(entry.Items) > 0 {
+				names := make([]string, len(entry.Items))
+				for i, it := range entry.Items {
+					names[i] = markdown.Escape(itemName(it))
+				}
+				bw.WriteString("\n**Itinerary:** " + strings.Join(names, ", ") + "\n")
+			}
+			entry.writeMarkdownPhotos(bw)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeMarkdownPhotos writes the photos of an entry as images, or by name
+// when they cannot be linked to
+func (e *Entry) writeMarkdownPhotos(bw *bufio.Writer) {
+	var names []string
+	for _, p := range e.Photos {
+		if p.URL == "" {
+			names = append(names, markdown.Escape(p.Filename))
+			continue
+		}
+		bw.WriteString("\n![" + markdown.Escape(p.Filename) + "](<" + p.URL + ">)\n")
+	}
+	if len(names) > 0 {
+		bw.WriteString("\n**Photos:** " + strings.Join(names, ", ") + "\n")
+	}
+}
@@ -0,0 +1,368 @@
+package listquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reserved query parameters
+const (
+	LimitParam  = "limit"
+	CursorParam = "cursor"
+	SortParam   = "sort"
+)
+
+// Limits on the page size
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Range filter parameter suffixes: start_date_from=2026-01-01&start_date_to=2026-12-31
+const (
+	FromSuffix = "_from"
+	ToSuffix   = "_to"
+)
+
+// dateLayout is the format of calendar dates
+const dateLayout = "2006-01-02"
+
+// Kind is the type of a sortable or filterable column
+type Kind int
+
+const (
+	// String columns compare as text
+	String Kind = iota
+	// Date columns hold YYYY-MM-DD text
+	Date
+	// Time columns hold timestamps; parameters are RFC 3339
+	Time
+	// Int columns hold integers
+	Int
+)
+
+// Op is a filter operator
+type Op int
+
+const (
+	// In matches any of a comma-separated list of values: status=planned,active
+	In Op = iota
+	// Range matches an inclusive range given by <name>_from and <name>_to
+	Range
+	// Tags matches rows carrying every listed tag: tag=beach,food
+	Tags
+)
+
+// Sort is a sortable column
+type Sort struct {
+	Column string
+	Kind   Kind
+}
+
+// Filter is a filterable column
+type Filter struct {
+	Column string
+	Op     Op
+	Kind   Kind
+
+	// Allowed restricts the values of In filters; nil allows any value
+	Allowed []string
+}
+
+// Spec whitelists the sort fields and filters of one resource
+type Spec struct {
+	// Sorts maps sort names to columns
+	Sorts map[string]Sort
+
+	// DefaultSort is used when no sort is given, e.g. "-created_at"
+	DefaultSort string
+
+	// Filters maps filter names to columns
+	Filters map[string]Filter
+}
+
+// Condition is a parsed filter
+type Condition struct {
+	Column string
+	Op     Op
+
+	// Values holds the accepted values for In, the tags for Tags, and
+	// the lower and upper bounds (nil if open) for Range
+	Values []any
+}
+
+// Cursor is the position after the last row of the previous page
+type Cursor struct {
+	// Value is the sort column value of the last row
+	Value any
+
+	// ID breaks ties between rows with equal sort values
+	ID string
+}
+
+// Query is a parsed list request
+type Query struct {
+	Limit int
+
+	// SortName is the API name of the sort field, Desc its direction
+	SortName string
+	Sort     Sort
+	Desc     bool
+
+	Filters []Condition
+
+	// After is nil for the first page
+	After *Cursor
+}
+
+// cursorPayload is the encoded form of a cursor. The sort is recorded so a
+// cursor cannot be replayed against a different ordering.
+type cursorPayload struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Parse reads limit, cursor, sort and filter parameters, rejecting any
+// parameter the spec does not allow. Errors are 400 problems listing every bad parameter.
+func Parse(values url.Values, spec Spec) (*Query, error) {
+	q := &Query{Limit: DefaultLimit}
+	var errs []problem.FieldError
+	fail := func(param, code, message string) {
+		errs = append(errs, problem.FieldError{Field: param, Code: code, Message: message})
+	}
+
+	if raw := values.Get(LimitParam); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		switch {
+		case err != nil:
+			fail(LimitParam, "integer", "must be an integer")
+		case limit < 1 || limit > MaxLimit:
+			fail(LimitParam, "range", fmt.Sprintf("must be between 1 and %d", MaxLimit))
+		default:
+			q.Limit = limit
+		}
+	}
+
+	sortName := values.Get(SortParam)
+	if sortName == "" {
+		sortName = spec.DefaultSort
+	}
+	q.Desc = strings.HasPrefix(sortName, "-")
+	q.SortName = sortName
+	if sort, ok := spec.Sorts[strings.TrimPrefix(sortName, "-")]; ok {
+		q.Sort = sort
+	} else {
+		fail(SortParam, "oneof", "must be one of "+strings.Join(sortNames(spec), ", ")+", optionally prefixed with -")
+	}
+
+	if raw := values.Get(CursorParam); raw != "" {
+		after, err := decodeCursor(raw, sortName, q.Sort.Kind)
+		if err != nil {
+			fail(CursorParam, "invalid", err.Error())
+		}
+		q.After = after
+	}
+
+	params := make([]string, 0, len(values))
+	for param := range values {
+		params = append(params, param)
+	}
+	slices.Sort(params)
+	for _, param := range params {
+		if param == LimitParam || param == CursorParam || param == SortParam {
+			continue
+		}
+		name, filter, ok := lookupFilter(spec, param)
+		if !ok {
+			fail(param, "unknown", "is not a supported parameter")
+			continue
+		}
+		vals := values[param]
+		cond, err := parseFilter(param, name, filter, vals[len(vals)-1])
+		if err != nil {
+			fail(param, "invalid", err.Error())
+			continue
+		}
+		q.Filters = append(q.Filters, cond)
+	}
+
+	if len(errs) > 0 {
+		p := problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "The query parameters are invalid")
+		p.Errors = errs
+		return nil, p
+	}
+	return q, nil
+}
+
+// lookupFilter finds the filter a parameter belongs to; range filters take
+// <name>_from and <name>_to rather than <name>
+func lookupFilter(spec Spec, param string) (string, Filter, bool) {
+	if f, ok := spec.Filters[param]; ok && f.Op != Range {
+		return param, f, true
+	}
+	for _, suffix := range []string{FromSuffix, ToSuffix} {
+		if name, ok := strings.CutSuffix(param, suffix); ok {
+			if f, ok := spec.Filters[name]; ok && f.Op == Range {
+				return name, f, true
+			}
+		}
+	}
+	return "", Filter{}, false
+}
+
+// parseFilter converts one filter parameter into a condition
+func parseFilter(param, name string, f Filter, raw string) (Condition, error) {
+	cond := Condition{Column: f.Column, Op: f.Op}
+
+	if f.Op == Range {
+		v, err := parseValue(raw, f.Kind)
+		if err != nil {
+			return cond, err
+		}
+		if strings.HasSuffix(param, FromSuffix) {
+			cond.Values = []any{v, nil}
+		} else {
+			cond.Values = []any{nil, v}
+		}
+		return cond, nil
+	}
+
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if f.Allowed != nil && !slices.Contains(f.Allowed, item) {
+			return cond, fmt.Errorf("%q is not one of %s", item, strings.Join(f.Allowed, ", "))
+		}
+		v, err := parseValue(item, f.Kind)
+		if err != nil {
+			return cond, err
+		}
+		cond.Values = append(cond.Values, v)
+	}
+	if len(cond.Values) == 0 {
+		return cond, fmt.Errorf("%s needs at least one value", name)
+	}
+	return cond, nil
+}
+
+// datePattern matches YYYY-MM-DD
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// parseValue converts a parameter to the column's type
+func parseValue(raw string, kind Kind) (any, error) {
+	switch kind {
+	case Date:
+		if _, err := time.Parse(dateLayout, raw); err != nil || !datePattern.MatchString(raw) {
+			return nil, fmt.Errorf("%q is not a date (YYYY-MM-DD)", raw)
+		}
+		return raw, nil
+	case Time:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an RFC 3339 timestamp", raw)
+		}
+		return t.UTC(), nil
+	case Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return n, nil
+	default:
+		return raw, nil
+	}
+}
+
+// Next returns the cursor of the page following a row with the given
+// sort column value and ID
+func (q *Query) Next(value any, id string) string {
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursorPayload{Sort: q.SortName, Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reverses Next, checking the cursor was issued for the same sort
+func decodeCursor(raw, sortName string, kind Kind) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("is not a valid cursor")
+	}
+	var payload cursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.ID == "" {
+		return nil, fmt.Errorf("is not a valid cursor")
+	}
+	if payload.Sort != sortName {
+		return nil, fmt.Errorf("was issued for sort %q, not %q", payload.Sort, sortName)
+	}
+
+	invalid := fmt.Errorf("is not a valid cursor")
+	switch v := payload.Value.(type) {
+	case string:
+		if kind == Int {
+			return nil, invalid
+		}
+		if kind == Time {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, invalid
+			}
+			return &Cursor{Value: t, ID: payload.ID}, nil
+		}
+		return &Cursor{Value: v, ID: payload.ID}, nil
+	case float64:
+		if kind != Int {
+			return nil, invalid
+		}
+		return &Cursor{Value: int64(v), ID: payload.ID}, nil
+	default:
+		return nil, invalid
+	}
+}
+
+// Page is the body of list responses
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty" doc:"Pass as cursor to fetch the next page; absent on the last page"`
+}
+
+// Respond writes a page of items, with a Link header to the next page if there is one
+func Respond[T any](c *gin.Context, items []T, next string) {
+	if items == nil {
+		items = []T{}
+	}
+	if next != "" {
+		u := *c.Request.URL
+		query := u.Query()
+		query.Set(CursorParam, next)
+		u.RawQuery = query.Encode()
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	c.JSON(http.StatusOK, Page[T]{Items: items, NextCursor: next})
+}
+
+// sortNames lists the sort names of a spec
+func sortNames(spec Spec) []string {
+	names := make([]string, 0, len(spec.Sorts))
+	for name := range spec.Sorts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,724 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNesting caps how deeply quotes, lists and links nest; deeper markup is
+// rendered as text
+const maxNesting = 16
+
+// schemes are the URL schemes links and images may use; others are shown as text
+var schemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+var (
+	headingPattern   = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	bulletPattern    = regexp.MustCompile(`^[ ]{0,3}[-*+][ \t]+`)
+	orderedPattern   = regexp.MustCompile(`^[ ]{0,3}(\d{1,9})[.)][ \t]+`)
+	rulePattern      = regexp.MustCompile(`^[ ]{0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fencePattern     = regexp.MustCompile("^[ ]{0,3}(```+|~~~+)")
+	quotePattern     = regexp.MustCompile(`^[ ]{0,3}>[ ]?`)
+	punctuationChars = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// ToHTML renders Markdown to HTML. It supports the common subset of
+// CommonMark: ATX headings, paragraphs, emphasis, code spans and fenced code
+// blocks, bullet and ordered lists, block quotes, horizontal rules, links,
+// images and autolinks. The result is safe to embed in a page: raw HTML is
+// escaped rather than passed through, and links and images are only kept for
+// http, https and mailto URLs.
+func ToHTML(src string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(normalize(src), "\n"), 0)
+	return b.String()
+}
+
+// normalize unifies line endings and replaces NUL characters
+func normalize(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	return strings.ReplaceAll(src, "\x00", "�")
+}
+
+// renderBlocks renders lines as a sequence of blocks, nested depth levels
+// deep in quotes and lists
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case fencePattern.MatchString(line):
+			i = renderFence(b, lines, i)
+		case headingPattern.MatchString(strings.TrimLeft(line, " ")) && leadingSpaces(line) < 4:
+			m := headingPattern.FindStringSubmatch(strings.TrimLeft(line, " "))
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">")
+			renderInline(b, strings.TrimSpace(m[2]))
+			b.WriteString("</h" + level + ">\n")
+			i++
+		case rulePattern.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+		case depth >= maxNesting:
+			// Deeper quotes and lists are rendered as text
+			i = renderParagraph(b, lines, i)
+		case quotePattern.MatchString(line):
+			i = renderQuote(b, lines, i, depth)
+		case bulletPattern.MatchString(line), orderedPattern.MatchString(line):
+			i = renderList(b, lines, i, depth)
+		case leadingSpaces(line) >= 4:
+			i = renderIndentedCode(b, lines, i)
+		default:
+			i = renderParagraph(b, lines, i)
+		}
+	}
+}
+
+// renderFence renders the fenced code block starting at lines[start] and
+// returns the index of the line after it
+func renderFence(b *strings.Builder, lines []string, start int) int {
+	fence := fencePattern.FindStringSubmatch(lines[start])[1]
+	info := strings.Fields(strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(lines[start]), fence[:1])))
+	if len(info) > 0 {
+		b.WriteString(`<pre><code class="language-` + html.EscapeString(info[0]) + `">`)
+	} else {
+		b.WriteString("<pre><code>")
+	}
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		b.WriteString(html.EscapeString(lines[i]))
+		b.WriteByte('\n')
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderIndentedCode renders the code block indented by four spaces starting
+// at lines[start]
+func renderIndentedCode(b *strings.Builder, lines []string, start int) int {
+	var code []string
+	i := start
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" && leadingSpaces(lines[i]) < 4 {
+			break
+		}
+		code = append(code, strings.TrimPrefix(expandTabs(lines[i]), "    "))
+	}
+	// Trailing blank lines separate the block from what follows
+	for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+		code = code[:len(code)-1]
+	}
+	b.WriteString("<pre><code>")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line))
+		b.WriteByte('\n')
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderQuote renders the block quote starting at lines[start]
+func renderQuote(b *strings.Builder, lines []string, start, depth int) int {
+	var inner []string
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m := quotePattern.FindString(line); m != "" {
+			inner = append(inner, line[len(m):])
+			continue
+		}
+		// Lazy continuation of a quoted paragraph
+		if strings.TrimSpace(line) == "" || startsBlock(line) || len(inner) == 0 || strings.TrimSpace(inner[len(inner)-1]) == "" {
+			break
+		}
+		inner = append(inner, line)
+	}
+	b.WriteString("<blockquote>\n")
+	renderBlocks(b, inner, depth+1)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// listMarker returns the marker of a list item line, its width including
+// the spaces after it, and whether the list is ordered
+func listMarker(line string) (marker string, width int, ordered bool, ok bool) {
+	if m := bulletPattern.FindString(line); m != "" {
+		return strings.TrimSpace(m), len(m), false, true
+	}
+	if m := orderedPattern.FindStringSubmatch(line); m != nil {
+		return m[1], len(m[0]), true, true
+	}
+	return "", 0, false, false
+}
+
+// renderList renders the list starting at lines[start]. Items are rendered
+// loosely, with paragraphs, when blank lines separate them.
+func renderList(b *strings.Builder, lines []string, start, depth int) int {
+	first, _, ordered, _ := listMarker(lines[start])
+	if ordered {
+		if n, _ := strconv.Atoi(first); n != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	var (
+		items [][]string
+		loose bool
+		i     = start
+	)
+	for i < len(lines) {
+		_, width, itemOrdered, ok := listMarker(lines[i])
+		if !ok || itemOrdered != ordered {
+			break
+		}
+		item := []string{lines[i][width:]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item only if indented content follows
+				j := i
+				for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+					j++
+				}
+				if j < len(lines) && leadingSpaces(lines[j]) >= width {
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					loose = true
+					continue
+				}
+				if j < len(lines) {
+					if _, _, o, ok := listMarker(lines[j]); ok && o == ordered {
+						loose = true
+						i = j
+					}
+				}
+				break
+			}
+			if leadingSpaces(line) >= width {
+				item = append(item, dedent(line, width))
+				i++
+				continue
+			}
+			if _, _, _, ok := listMarker(line); ok || startsBlock(line) {
+				break
+			}
+			// Lazy continuation of the item's paragraph
+			item = append(item, line)
+			i++
+		}
+		items = append(items, item)
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			break
+		}
+	}
+
+	for _, item := range items {
+		b.WriteString("<li>")
+		if loose {
+			b.WriteByte('\n')
+			renderBlocks(b, item, depth+1)
+		} else {
+			renderTightItem(b, item, depth+1)
+		}
+		b.WriteString("</li>\n")
+	}
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// renderTightItem renders a list item without wrapping its text in a paragraph
+func renderTightItem(b *strings.Builder, lines []string, depth int) {
+	end := 0
+	for end < len(lines) && (end == 0 || !startsBlock(lines[end])) {
+		end++
+	}
+	renderInline(b, strings.TrimSpace(strings.Join(trimAll(lines[:end]), "\n")))
+	if end < len(lines) {
+		b.WriteByte('\n')
+		renderBlocks(b, lines[end:], depth)
+	}
+}
+
+// renderParagraph renders the paragraph starting at lines[start]
+func renderParagraph(b *strings.Builder, lines []string, start int) int {
+	i := start + 1
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]) {
+		i++
+	}
+	b.WriteString("<p>")
+	renderInline(b, strings.TrimSpace(strings.Join(trimAll(lines[start:i]), "\n")))
+	b.WriteString("</p>\n")
+	return i
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	if leadingSpaces(line) >= 4 {
+		return false
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if m := orderedPattern.FindStringSubmatch(line); m != nil && m[1] != "1" {
+		// Only lists starting at 1 interrupt a paragraph, so that a sentence
+		// may begin with a number
+		return false
+	}
+	return headingPattern.MatchString(trimmed) || rulePattern.MatchString(line) || fencePattern.MatchString(line) ||
+		quotePattern.MatchString(line) || bulletPattern.MatchString(line) || orderedPattern.MatchString(line)
+}
+
+// leadingSpaces returns the indentation of a line in columns
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4 - n%4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// expandTabs replaces the tabs indenting a line with spaces
+func expandTabs(line string) string {
+	n := leadingSpaces(line)
+	return strings.Repeat(" ", n) + strings.TrimLeft(line, " \t")
+}
+
+// dedent removes up to width columns of indentation from a line
+func dedent(line string, width int) string {
+	line = expandTabs(line)
+	return line[min(width, leadingSpaces(line)):]
+}
+
+// trimAll trims the leading spaces of every line
+func trimAll(lines []string) []string {
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimLeft(line, " \t")
+	}
+	return trimmed
+}
+
+// inline renders the inline content of a block
+type inline struct {
+	// b holds what was rendered since the last delimiter run
+	b     *strings.Builder
+	s     string
+	depth int
+
+	// delims are the runs of * and _ that may open or close emphasis, in
+	// order, with what was rendered before each
+	delims []*delimiter
+
+	// closes maps the position of each bracket and parenthesis to that of
+	// the one closing it, or -1
+	closes []int
+
+	// unclosed maps delimiters to the position from which they have no
+	// closer left, so that unmatched delimiters are not searched again
+	unclosed map[string]int
+}
+
+// renderInline renders the inline content of a block: emphasis, code spans,
+// links, images and line breaks, escaping everything else
+func renderInline(b *strings.Builder, s string) {
+	renderNested(b, s, 0)
+}
+
+// renderNested renders inline content nested depth levels deep in links
+func renderNested(b *strings.Builder, s string, depth int) {
+	if depth > maxNesting {
+		b.WriteString(html.EscapeString(s))
+		return
+	}
+	in := &inline{b: &strings.Builder{}, s: s, depth: depth, closes: matchBrackets(s), unclosed: map[string]int{}}
+	in.render()
+	in.matchEmphasis()
+	for _, d := range in.delims {
+		b.WriteString(d.before)
+		b.WriteString(d.close)
+		b.WriteString(strings.Repeat(string(d.c), d.n))
+		b.WriteString(d.open)
+	}
+	b.WriteString(in.b.String())
+}
+
+func (in *inline) render() {
+	b, s := in.b, in.s
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(punctuationChars, s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\n':
+			if strings.HasSuffix(s[:i], "  ") && strings.TrimRight(s[:i], " ") != "" {
+				b.WriteString("<br>")
+			}
+			b.WriteByte('\n')
+			i++
+			continue
+		case c == '`':
+			if n := in.codeSpan(i); n > 0 {
+				i += n
+				continue
+			}
+			// An unmatched run of backticks is literal
+			run := runLength(s, i)
+			b.WriteString(s[i : i+run])
+			i += run
+			continue
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if text, dest, end, ok := in.link(i + 1); ok {
+				if u, ok := safeURL(dest); ok {
+					b.WriteString(`<img src="` + html.EscapeString(u) + `" alt="` + html.EscapeString(in.plainText(text)) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(in.plainText(text)))
+				}
+				i = end
+				continue
+			}
+		case c == '[':
+			if text, dest, end, ok := in.link(i); ok {
+				if u, ok := safeURL(dest); ok {
+					b.WriteString(`<a href="` + html.EscapeString(u) + `" rel="nofollow noopener">`)
+					renderNested(b, text, in.depth+1)
+					b.WriteString("</a>")
+				} else {
+					renderNested(b, text, in.depth+1)
+				}
+				i = end
+				continue
+			}
+		case c == '<':
+			// Autolinks hold no spaces, which bounds the search for their end
+			if end := strings.IndexAny(s[i+1:], " \t\n<>"); end > 0 && s[i+1+end] == '>' {
+				dest := s[i+1 : i+1+end]
+				if u, ok := safeURL(dest); ok {
+					b.WriteString(`<a href="` + html.EscapeString(u) + `" rel="nofollow noopener">` + html.EscapeString(strings.TrimPrefix(dest, "mailto:")) + "</a>")
+					i += end + 2
+					continue
+				}
+			}
+		case c == '*' || c == '_':
+			run := runLength(s, i)
+			in.delims = append(in.delims, in.delimiterRun(i, run))
+			i += run
+			continue
+		}
+		// Copy up to the next character that may start inline markup
+		j := i + 1
+		for j < len(s) && strings.IndexByte("\\\n`![<*_", s[j]) < 0 {
+			j++
+		}
+		text := s[i:j]
+		if j < len(s) && s[j] == '\n' {
+			// Spaces ending a line only matter as a hard line break
+			text = strings.TrimRight(text, " ")
+		}
+		b.WriteString(html.EscapeString(text))
+		i = j
+	}
+}
+
+// closer returns the position of the first delimiter at or after from for
+// which valid reports true, or -1
+func (in *inline) closer(delim string, from int, valid func(j int) bool) int {
+	if stop, ok := in.unclosed[delim]; ok && from >= stop {
+		return -1
+	}
+	for j := from; j < len(in.s); {
+		k := strings.Index(in.s[j:], delim)
+		if k < 0 {
+			break
+		}
+		k += j
+		if valid(k) {
+			return k
+		}
+		j = k + 1
+	}
+	if stop, ok := in.unclosed[delim]; !ok || from < stop {
+		in.unclosed[delim] = from
+	}
+	return -1
+}
+
+// codeSpan renders the code span opened by the backticks at s[i], returning
+// its length, or 0 if they are not closed
+func (in *inline) codeSpan(i int) int {
+	s := in.s
+	run := runLength(s, i)
+	fence := s[i : i+run]
+	// A longer run of backticks does not close the span
+	j := in.closer(fence, i+run, func(j int) bool {
+		return s[j-1] != '`' && (j+run == len(s) || s[j+run] != '`')
+	})
+	if j < 0 {
+		return 0
+	}
+	code := strings.ReplaceAll(s[i+run:j], "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	in.b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return j + run - i
+}
+
+// delimiter is a run of * or _ characters, which emphasis is made of
+type delimiter struct {
+	// before is what was rendered between the previous run and this one
+	before string
+
+	c byte
+
+	// n is the number of characters of the run left as text, out of length
+	n, length int
+
+	canOpen, canClose bool
+
+	// active is false once the run can no longer open emphasis
+	active bool
+
+	// open and close are the tags the run opens and closes
+	open, close string
+}
+
+// delimiterRun records the run of n delimiters at s[i], which can open
+// emphasis when it starts a word and close it when it ends one
+func (in *inline) delimiterRun(i, n int) *delimiter {
+	s := in.s
+	// The start and end of the text count as spaces
+	before, after := ' ', ' '
+	if i > 0 {
+		before, _ = utf8.DecodeLastRuneInString(s[:i])
+	}
+	if i+n < len(s) {
+		after, _ = utf8.DecodeRuneInString(s[i+n:])
+	}
+	left := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	right := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+
+	d := &delimiter{before: in.b.String(), c: s[i], n: n, length: n, active: true}
+	in.b.Reset()
+	if d.c == '*' {
+		d.canOpen, d.canClose = left, right
+	} else {
+		// Underscores inside words are literal, as in snake_case
+		d.canOpen = left && (!right || isPunct(before))
+		d.canClose = right && (!left || isPunct(after))
+	}
+	return d
+}
+
+// matchEmphasis pairs the delimiter runs into emphasis and strong emphasis,
+// following the CommonMark algorithm: each closer, from the first, is
+// matched with the nearest opener of the same character before it, and the
+// runs between them are left as text
+func (in *inline) matchEmphasis() {
+	// bottoms records, by character, whether the closer can open and its
+	// length modulo 3, the position at and below which there is no opener
+	// left for such a closer, so that openers are not searched again
+	type closerKind struct {
+		c       byte
+		canOpen bool
+		length  int
+	}
+	bottoms := map[closerKind]int{}
+
+	for ci, closer := range in.delims {
+		if !closer.canClose {
+			continue
+		}
+		kind := closerKind{closer.c, closer.canOpen, closer.length % 3}
+		for closer.n > 0 {
+			bottom, ok := bottoms[kind]
+			if !ok {
+				bottom = -1
+			}
+			oi := ci - 1
+			for ; oi > bottom; oi-- {
+				if in.delims[oi].opens(closer) {
+					break
+				}
+			}
+			if oi <= bottom {
+				bottoms[kind] = ci - 1
+				break
+			}
+
+			opener := in.delims[oi]
+			use, tag := 1, "em"
+			if opener.n >= 2 && closer.n >= 2 {
+				use, tag = 2, "strong"
+			}
+			opener.n -= use
+			closer.n -= use
+			// Later matches enclose the earlier ones
+			opener.open = "<" + tag + ">" + opener.open
+			closer.close += "</" + tag + ">"
+			for _, d := range in.delims[oi+1 : ci] {
+				d.active = false
+			}
+		}
+	}
+}
+
+// opens reports whether a delimiter run can open the emphasis closer closes
+func (opener *delimiter) opens(closer *delimiter) bool {
+	if !opener.active || !opener.canOpen || opener.n == 0 || opener.c != closer.c {
+		return false
+	}
+	// A run that can both open and close doesn't pair with one whose length
+	// sums with its own to a multiple of 3, so that in *a**b**c* the inner
+	// runs pair with each other
+	if (opener.canClose || closer.canOpen) && (opener.length+closer.length)%3 == 0 {
+		return opener.length%3 == 0 && closer.length%3 == 0
+	}
+	return true
+}
+
+// link parses a link of the form [text](destination "title") whose text
+// opens at s[i], returning its text, destination and the position after it
+func (in *inline) link(i int) (text, dest string, end int, ok bool) {
+	s := in.s
+	closeText := in.closes[i]
+	if closeText < 0 || closeText+1 >= len(s) || s[closeText+1] != '(' {
+		return "", "", 0, false
+	}
+	closeDest := in.closes[closeText+1]
+	if closeDest < 0 {
+		return "", "", 0, false
+	}
+	dest = strings.TrimSpace(s[closeText+2 : closeDest])
+	if strings.HasPrefix(dest, "<") {
+		if j := strings.IndexByte(dest, '>'); j > 0 {
+			dest = dest[1:j]
+		}
+	} else if j := strings.IndexAny(dest, " \t\n"); j >= 0 {
+		// Drop the title
+		dest = dest[:j]
+	}
+	return s[i+1 : closeText], dest, closeDest + 1, true
+}
+
+// plainText renders inline Markdown as text, as for the alt text of images
+func (in *inline) plainText(s string) string {
+	var b strings.Builder
+	renderNested(&b, s, in.depth+1)
+	return html.UnescapeString(tagPattern.ReplaceAllString(b.String(), ""))
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// matchBrackets pairs the brackets and the parentheses of s, skipping
+// escaped ones
+func matchBrackets(s string) []int {
+	closes := make([]int, len(s))
+	var brackets, parens []int
+	for i := 0; i < len(s); i++ {
+		closes[i] = -1
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				closes[i] = -1
+			}
+		case '[':
+			brackets = append(brackets, i)
+		case ']':
+			if n := len(brackets); n > 0 {
+				closes[brackets[n-1]] = i
+				brackets = brackets[:n-1]
+			}
+		case '(':
+			parens = append(parens, i)
+		case ')':
+			if n := len(parens); n > 0 {
+				closes[parens[n-1]] = i
+				parens = parens[:n-1]
+			}
+		}
+	}
+	return closes
+}
+
+// safeURL returns dest unescaped if it is an absolute URL with an allowed scheme
+func safeURL(dest string) (string, bool) {
+	dest = strings.TrimSpace(dest)
+	u, err := url.Parse(dest)
+	if err != nil || !schemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	if u.Scheme != "mailto" && u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// runLength returns the length of the run of the character at s[i]
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// Escape escapes the characters of text that Markdown would read as markup,
+// so that it is rendered as is
+func Escape(text string) string {
+	var b strings.Builder
+	for i, line := range strings.Split(normalize(text), "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		// Digits followed by a period would start an ordered list
+		if m := orderedPattern.FindStringSubmatchIndex(line); m != nil {
+			b.WriteString(line[:m[3]] + `\` + line[m[3]:m[3]+1])
+			line = line[m[3]+1:]
+		}
+		for j := 0; j < len(line); j++ {
+			if strings.IndexByte("\\`*_[]()#+-!<>|~", line[j]) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(line[j])
+		}
+	}
+	return b.String()
+}
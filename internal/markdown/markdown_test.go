@@ -0,0 +1,144 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+type htmlTest struct {
+	name, src, want string
+}
+
+func runHTMLTests(t *testing.T, tests []htmlTest) {
+	t.Helper()
+	for _, tt := range tests {
+		if got := ToHTML(tt.src); got != tt.want {
+			t.Errorf("%s: ToHTML(%q)\n got %q\nwant %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestToHTMLUnsafe(t *testing.T) {
+	runHTMLTests(t, []htmlTest{
+		{"javascript link", `[x](javascript:alert(1))`, "<p>x</p>\n"},
+		{"javascript link in mixed case", `[x](JaVaScRiPt:alert(1))`, "<p>x</p>\n"},
+		{"javascript link with a leading space", `[x]( javascript:alert(1))`, "<p>x</p>\n"},
+		{"javascript link with an entity", `[x](java&#115;cript:alert(1))`, "<p>x</p>\n"},
+		{"javascript link in brackets", `[x](<javascript:alert(1)>)`, "<p>x</p>\n"},
+		{"javascript image", `![x](javascript:alert(1))`, "<p>x</p>\n"},
+		{"javascript autolink", `<javascript:alert(1)>`, "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{"data link", `[x](data:text/html;base64,PHNjcmlwdD4=)`, "<p>x</p>\n"},
+		{"vbscript link", `[x](vbscript:msgbox)`, "<p>x</p>\n"},
+		{"protocol-relative link", `[x](//evil.example/a)`, "<p>x</p>\n"},
+		{"relative link", `[x](/relative)`, "<p>x</p>\n"},
+		{"script", `<script>alert(1)</script>`, "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"event handler", `<img src=x onerror=alert(1)>`, "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{"HTML link", `<a href="https://x.example">x</a>`, "<p>&lt;a href=&#34;https://x.example&#34;&gt;x&lt;/a&gt;</p>\n"},
+		{"HTML block", "<div>\n<iframe src=x>\n</div>", "<p>&lt;div&gt;\n&lt;iframe src=x&gt;\n&lt;/div&gt;</p>\n"},
+		{"entities", `&amp; &lt; & <`, "<p>&amp;amp; &amp;lt; &amp; &lt;</p>\n"},
+		{"quote in a link destination", `[x](https://x.example/"onmouseover="alert(1))`,
+			`<p><a href="https://x.example/%22onmouseover=%22alert%281%29" rel="nofollow noopener">x</a></p>` + "\n"},
+		{"quote in alt text", `![a" onerror="alert(1)](https://x.example/i.png)`,
+			`<p><img src="https://x.example/i.png" alt="a&#34; onerror=&#34;alert(1)"></p>` + "\n"},
+		{"markup in a link destination", `[x](https://x.example/a"b'c<d>)`,
+			`<p><a href="https://x.example/a%22b%27c%3Cd%3E" rel="nofollow noopener">x</a></p>` + "\n"},
+		{"quote in a code language", "```js\"onload=x\n<b>\n```", `<pre><code class="language-js&#34;onload=x">&lt;b&gt;` + "\n</code></pre>\n"},
+		{"markup in code", "`<b>` and\n\n    <i>", "<p><code>&lt;b&gt;</code> and</p>\n<pre><code>&lt;i&gt;\n</code></pre>\n"},
+		{"NUL", "a\x00b", "<p>a�b</p>\n"},
+	})
+}
+
+func TestToHTMLEmphasis(t *testing.T) {
+	runHTMLTests(t, []htmlTest{
+		{"emphasis", `*foo bar*`, "<p><em>foo bar</em></p>\n"},
+		{"underscore emphasis", `_foo bar_`, "<p><em>foo bar</em></p>\n"},
+		{"strong emphasis", `**foo bar**`, "<p><strong>foo bar</strong></p>\n"},
+		{"opener followed by a space", `a * foo bar*`, "<p>a * foo bar*</p>\n"},
+		{"closer preceded by a space", `**foo bar **`, "<p>**foo bar **</p>\n"},
+		{"inside a word", `foo*bar*baz`, "<p>foo<em>bar</em>baz</p>\n"},
+		{"underscores inside a word", `snake_case_name`, "<p>snake_case_name</p>\n"},
+		{"underscores inside emphasis", `_foo_bar_`, "<p><em>foo_bar</em></p>\n"},
+		{"punctuation", `*(*foo*)*`, "<p><em>(<em>foo</em>)</em></p>\n"},
+		{"emphasis in strong", `**foo*bar*baz**`, "<p><strong>foo<em>bar</em>baz</strong></p>\n"},
+		{"strong in emphasis", `*foo**bar**baz*`, "<p><em>foo<strong>bar</strong>baz</em></p>\n"},
+		{"nested strong", `__foo, __bar__, baz__`, "<p><strong>foo, <strong>bar</strong>, baz</strong></p>\n"},
+		{"both", `***strong emph***`, "<p><em><strong>strong emph</strong></em></p>\n"},
+		{"both inside a word", `foo***bar***baz`, "<p>foo<em><strong>bar</strong></em>baz</p>\n"},
+		{"overlapping", `*a **b* c**`, "<p><em>a <em><em>b</em> c</em></em></p>\n"},
+		{"crossing", `*foo _bar* baz_`, "<p><em>foo _bar</em> baz_</p>\n"},
+		{"unmatched opener", `**foo*`, "<p>*<em>foo</em></p>\n"},
+		{"unmatched closer", `*foo**`, "<p><em>foo</em>*</p>\n"},
+		{"different characters", `*foo_`, "<p>*foo_</p>\n"},
+		{"over lines", "*a\nb*", "<p><em>a\nb</em></p>\n"},
+		{"escaped", `\*foo*`, "<p>*foo*</p>\n"},
+		{"in code", "`*a*`*b*", "<p><code>*a*</code><em>b</em></p>\n"},
+		{"across a link", `*foo [bar*](https://x.example)`, `<p>*foo <a href="https://x.example" rel="nofollow noopener">bar*</a></p>` + "\n"},
+		{"in a link", `[a *b*](https://x.example)`, `<p><a href="https://x.example" rel="nofollow noopener">a <em>b</em></a></p>` + "\n"},
+		{"in a heading", "# Title *em*", "<h1>Title <em>em</em></h1>\n"},
+	})
+}
+
+func TestToHTMLLinks(t *testing.T) {
+	runHTMLTests(t, []htmlTest{
+		{"link", `[a](https://x.example)`, `<p><a href="https://x.example" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"title", `[a](https://x.example "title")`, `<p><a href="https://x.example" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"destination in brackets", `[a](<https://x.example/a b>)`, `<p><a href="https://x.example/a%20b" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"parentheses in the destination", `[a](https://x.example/(b))`, `<p><a href="https://x.example/(b)" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"mailto", `[a](mailto:me@x.example)`, `<p><a href="mailto:me@x.example" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"autolink", `<https://x.example/a?b=1&c=2>`,
+			`<p><a href="https://x.example/a?b=1&amp;c=2" rel="nofollow noopener">https://x.example/a?b=1&amp;c=2</a></p>` + "\n"},
+		{"email autolink", `<mailto:me@x.example>`, `<p><a href="mailto:me@x.example" rel="nofollow noopener">me@x.example</a></p>` + "\n"},
+		{"image", `![a *b*](https://x.example/i.png)`, `<p><img src="https://x.example/i.png" alt="a b"></p>` + "\n"},
+		{"brackets without a destination", `[a]`, "<p>[a]</p>\n"},
+		{"escaped bracket", `\[a](https://x.example)`, "<p>[a](https://x.example)</p>\n"},
+		{"unclosed destination", `[a](https://x.example`, "<p>[a](https://x.example</p>\n"},
+	})
+}
+
+func TestToHTMLBlocks(t *testing.T) {
+	runHTMLTests(t, []htmlTest{
+		{"paragraphs", "a\nb\n\nc", "<p>a\nb</p>\n<p>c</p>\n"},
+		{"hard break", "a  \nb", "<p>a<br>\nb</p>\n"},
+		{"heading", "## a ##", "<h2>a</h2>\n"},
+		{"rule", "***", "<hr>\n"},
+		{"bullet list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"loose list", "- a\n\n- b", "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"number starting a line", "a\n2024. was good", "<p>a\n2024. was good</p>\n"},
+		{"quote", "> quote\nlazy", "<blockquote>\n<p>quote\nlazy</p>\n</blockquote>\n"},
+		{"fenced code", "```go\na\n```", "<pre><code class=\"language-go\">a\n</code></pre>\n"},
+		{"indented code", "    a\n\n    b", "<pre><code>a\n\nb\n</code></pre>\n"},
+	})
+}
+
+func TestToHTMLDeepNesting(t *testing.T) {
+	// Deep nesting is rendered as text rather than recursing without bound
+	for _, src := range []string{
+		strings.Repeat(">", 10000) + " a",
+		strings.Repeat("[", 10000) + "a" + strings.Repeat("](https://x.example)", 10000),
+		strings.Repeat("*a ", 10000) + strings.Repeat("b* ", 10000),
+	} {
+		if got := ToHTML(src); !strings.Contains(got, "a") {
+			t.Errorf("ToHTML(%.20q...) = %.100q", src, got)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, text := range []string{
+		"*not emphasis* and _not_ either",
+		"[not a link](https://x.example)",
+		"# not a heading",
+		"1. not a list",
+		"- not a list",
+		"> not a quote",
+		"`not code` <not html> \\ backslash",
+		"a & b",
+	} {
+		want := "<p>" + html.EscapeString(text) + "</p>\n"
+		if got := ToHTML(Escape(text)); got != want {
+			t.Errorf("ToHTML(Escape(%q)) = %q, want %q", text, got, want)
+		}
+	}
+}
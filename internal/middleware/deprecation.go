@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DeprecationHeader announces that an endpoint is deprecated (RFC 9745)
+	DeprecationHeader = "Deprecation"
+
+	// SunsetHeader announces when an endpoint will stop working (RFC 8594)
+	SunsetHeader = "Sunset"
+)
+
+// Deprecation describes a deprecated endpoint
+type Deprecation struct {
+	// Since is when the endpoint was deprecated
+	Since time.Time
+
+	// Sunset is when the endpoint will be removed; zero if not scheduled
+	Sunset time.Time
+
+	// Successor is the path of the replacement endpoint, if any
+	Successor string
+}
+
+// DeprecationMiddleware adds Deprecation, Sunset and successor Link headers and
+// logs each use together with the calling client, as identified by clientID
+func DeprecationMiddleware(d Deprecation, clientID func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(DeprecationHeader, fmt.Sprintf("@%d", d.Since.Unix()))
+		if !d.Sunset.IsZero() {
+			c.Header(SunsetHeader, d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Successor != "" {
+			c.Header("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Successor))
+		}
+
+		c.Next()
+
+		slog.WarnContext(c.Request.Context(), "Deprecated endpoint used",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("client", clientID(c)),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Time("sunset", d.Sunset),
+		)
+	}
+}
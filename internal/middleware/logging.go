@@ -20,7 +20,7 @@ func RequestLoggingMiddleware() gin.HandlerFunc {
 		slog.InfoContext(c.Request.Context(), "HTTP Request Started",
 			"method", c.Request.Method,
 			"path", c.Request.URL.Path,
-			"query", c.Request.URL.RawQuery,
+			"query", RawQuery(c.Request.URL.RawQuery),
 			"client_ip", c.ClientIP(),
 			"user_agent", c.Request.UserAgent(),
 		)
@@ -42,7 +42,7 @@ func RequestLoggingMiddleware() gin.HandlerFunc {
 		slog.InfoContext(c.Request.Context(), "HTTP Request Completed",
 			"method", c.Request.Method,
 			"path", c.Request.URL.Path,
-			"query", c.Request.URL.RawQuery,
+			"query", RawQuery(c.Request.URL.RawQuery),
 			"status", status,
 			"status_text", statusText,
 			"latency", latency,
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdleTTL is how long an idle client's limiter is kept before being evicted
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// RateLimits returns the current requests per second and burst size;
+// it is called on every request so limits can change at runtime
+type RateLimits func() (rps float64, burst int)
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimitMiddleware limits requests per client IP with a token bucket.
+// A rate of 0 or less disables limiting.
+func RateLimitMiddleware(limits RateLimits) gin.HandlerFunc {
+	var (
+		mu        sync.Mutex
+		clients   = map[string]*clientLimiter{}
+		lastSweep = time.Now()
+	)
+
+	return func(c *gin.Context) {
+		rps, burst := limits()
+		if rps <= 0 {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		ip := c.ClientIP()
+
+		mu.Lock()
+		// Evict idle clients so the map does not grow without bound
+		if now.Sub(lastSweep) > rateLimiterIdleTTL {
+			for key, cl := range clients {
+				if now.Sub(cl.lastSeen) > rateLimiterIdleTTL {
+					delete(clients, key)
+				}
+			}
+			lastSweep = now
+		}
+
+		cl, ok := clients[ip]
+		if !ok {
+			cl = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+			clients[ip] = cl
+		}
+		cl.lastSeen = now
+
+		// Pick up limits changed by a configuration reload
+		if cl.limiter.Limit() != rate.Limit(rps) {
+			cl.limiter.SetLimitAt(now, rate.Limit(rps))
+		}
+		if cl.limiter.Burst() != burst {
+			cl.limiter.SetBurstAt(now, burst)
+		}
+		allowed := cl.limiter.AllowN(now, 1)
+		mu.Unlock()
+
+		if !allowed {
+			slog.WarnContext(c.Request.Context(), "Rate limit exceeded", slog.String("client_ip", ip))
+			c.Header("Retry-After", "1")
+			// The body is rendered by the error handler registered ahead of this middleware
+			c.Status(http.StatusTooManyRequests)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
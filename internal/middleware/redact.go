@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RedactedValue replaces sensitive values in logs
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactedKeys are attribute and query-parameter names whose values are always masked
+var DefaultRedactedKeys = []string{
+	"access_token",
+	"api_key",
+	"authorization",
+	"client_secret",
+	"code",
+	"cookie",
+	"email",
+	"id_token",
+	"password",
+	"refresh_token",
+	"secret",
+	"set-cookie",
+	"state",
+	"token",
+}
+
+// DefaultRedactedPatterns match sensitive substrings anywhere in logged strings
+var DefaultRedactedPatterns = []*regexp.Regexp{
+	// JWTs (header.payload.signature, base64url encoded)
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// Bearer credentials
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`),
+	// Email addresses
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+}
+
+// Redactor masks sensitive values in log attributes and query strings.
+// Keys match case-insensitively at any group depth; patterns are applied to string values.
+type Redactor struct {
+	keys     map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor creates a redactor for the given keys and patterns
+func NewRedactor(keys []string, patterns []*regexp.Regexp) *Redactor {
+	r := &Redactor{keys: make(map[string]struct{}, len(keys)), patterns: patterns}
+	for _, key := range keys {
+		r.keys[strings.ToLower(key)] = struct{}{}
+	}
+	return r
+}
+
+// DefaultRedactor masks DefaultRedactedKeys and DefaultRedactedPatterns
+var DefaultRedactor = NewRedactor(DefaultRedactedKeys, DefaultRedactedPatterns)
+
+// RawQuery is a raw URL query string; the log handler scrubs sensitive parameters from it
+type RawQuery string
+
+// LogValue scrubs the query with DefaultRedactor, for handlers that don't redact themselves
+func (q RawQuery) LogValue() slog.Value {
+	return slog.StringValue(DefaultRedactor.Query(string(q)))
+}
+
+// isKey reports whether values under key must be masked
+func (r *Redactor) isKey(key string) bool {
+	_, ok := r.keys[strings.ToLower(key)]
+	return ok
+}
+
+// String masks every pattern match in s
+func (r *Redactor) String(s string) string {
+	for _, p := range r.patterns {
+		s = p.ReplaceAllString(s, RedactedValue)
+	}
+	return s
+}
+
+// Query masks the values of sensitive parameters in a raw query string,
+// leaving parameter order and the other values untouched
+func (r *Redactor) Query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		name, val, hasValue := strings.Cut(part, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if hasValue && r.isKey(name) {
+			parts[i] = part[:strings.IndexByte(part, '=')+1] + RedactedValue
+			continue
+		}
+		if decoded, err := url.QueryUnescape(val); err == nil && r.String(decoded) != decoded {
+			parts[i] = part[:strings.IndexByte(part, '=')+1] + strings.ReplaceAll(url.QueryEscape(r.String(decoded)), url.QueryEscape(RedactedValue), RedactedValue)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Attr returns a copy of a with sensitive values masked, descending into groups
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	if r.isKey(a.Key) && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, RedactedValue)
+	}
+
+	// Scrub query strings parameter by parameter before resolving
+	if a.Value.Kind() == slog.KindAny {
+		if q, ok := a.Value.Any().(RawQuery); ok {
+			return slog.String(a.Key, r.Query(string(q)))
+		}
+	}
+
+	return slog.Attr{Key: a.Key, Value: r.value(a.Value.Resolve())}
+}
+
+// value masks a resolved slog value
+func (r *Redactor) value(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.StringValue(r.String(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]slog.Attr, 0, len(attrs))
+		for _, a := range attrs {
+			out = append(out, r.Attr(a))
+		}
+		return slog.GroupValue(out...)
+	case slog.KindAny:
+		return r.anyValue(v)
+	default:
+		return v
+	}
+}
+
+// anyValue masks arbitrary values. Errors are masked by message; maps,
+// structs, slices and values with their own JSON encoding (such as
+// json.RawMessage) are round-tripped through JSON so nested keys are masked too.
+func (r *Redactor) anyValue(v slog.Value) slog.Value {
+	switch t := v.Any().(type) {
+	case error:
+		if msg := t.Error(); r.String(msg) != msg {
+			return slog.StringValue(r.String(msg))
+		}
+		return v
+	case time.Time, time.Duration:
+		return v
+	case json.Marshaler:
+	default:
+		rv := reflect.ValueOf(v.Any())
+		for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+		switch rv.Kind() {
+		case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
+		default:
+			return v
+		}
+	}
+
+	// Values that can't be encoded are logged as text, masked by pattern
+	data, err := json.Marshal(v.Any())
+	if err != nil {
+		return slog.StringValue(r.String(fmt.Sprintf("%+v", v.Any())))
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return slog.StringValue(r.String(string(data)))
+	}
+	return slog.AnyValue(r.redactJSON(decoded))
+}
+
+// redactJSON masks a decoded JSON value, keeping its shape
+func (r *Redactor) redactJSON(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, item := range t {
+			if r.isKey(k) {
+				t[k] = RedactedValue
+				continue
+			}
+			t[k] = r.redactJSON(item)
+		}
+		return t
+	case []any:
+		for i, item := range t {
+			t[i] = r.redactJSON(item)
+		}
+		return t
+	case string:
+		return r.String(t)
+	default:
+		return t
+	}
+}
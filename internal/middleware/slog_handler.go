@@ -5,27 +5,45 @@ import (
 	"log/slog"
 )
 
-type Handler struct{ h slog.Handler }
+// Handler wraps a slog.Handler, adding attributes stored in the context with Add
+// and masking sensitive values before they reach the wrapped handler
+type Handler struct {
+	h        slog.Handler
+	redactor *Redactor
+}
+
+// New wraps h, redacting with DefaultRedactor
+func New(h slog.Handler) *Handler { return NewWithRedactor(h, DefaultRedactor) }
 
-func New(h slog.Handler) *Handler { return &Handler{h: h} }
+// NewWithRedactor wraps h, redacting with r
+func NewWithRedactor(h slog.Handler, r *Redactor) *Handler { return &Handler{h: h, redactor: r} }
 
 func (c *Handler) Enabled(ctx context.Context, lvl slog.Level) bool {
 	return c.h.Enabled(ctx, lvl)
 }
 
 func (c *Handler) Handle(ctx context.Context, r slog.Record) error {
-	if attrs := Get(ctx); len(attrs) > 0 {
-		r.AddAttrs(attrs...)
+	out := slog.NewRecord(r.Time, r.Level, c.redactor.String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(c.redactor.Attr(a))
+		return true
+	})
+	for _, a := range Get(ctx) {
+		out.AddAttrs(c.redactor.Attr(a))
 	}
-	return c.h.Handle(ctx, r)
+	return c.h.Handle(ctx, out)
 }
 
 func (c *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &Handler{h: c.h.WithAttrs(attrs)}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, c.redactor.Attr(a))
+	}
+	return &Handler{h: c.h.WithAttrs(redacted), redactor: c.redactor}
 }
 
 func (c *Handler) WithGroup(name string) slog.Handler {
-	return &Handler{h: c.h.WithGroup(name)}
+	return &Handler{h: c.h.WithGroup(name), redactor: c.redactor}
 }
 
 type key struct{}
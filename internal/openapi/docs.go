@@ -0,0 +1,9 @@
+package openapi
+
+import _ "embed"
+
+// DocsHTML is a self-contained API reference page that renders the document
+// served at openapi.json relative to its own URL
+//
+//go:embed docs.html
+var DocsHTML []byte
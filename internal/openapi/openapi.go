@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the OpenAPI specification version documents are written in
+const Version = "3.1.0"
+
+// Document is an OpenAPI 3.1 document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+	types      map[reflect.Type]bool // named types already added to Components.Schemas
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Server is a base URL the API is served from
+type Server struct {
+	URL string `json:"url"`
+}
+
+// Tag groups operations
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds reusable schemas and security schemes
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how clients authenticate
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// PathItem holds the operations of one path, keyed by lower-case HTTP method
+type PathItem map[string]*Operation
+
+// Operation describes a single endpoint
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the payload of an operation
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes one response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// MediaType pairs a content type with its schema
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// New creates an empty document
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]*PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]*SecurityScheme{},
+		},
+		types: map[reflect.Type]bool{},
+	}
+}
+
+// Add documents an operation. path uses gin syntax (/trips/:id); path
+// parameters missing from op.Parameters are added automatically.
+func (d *Document) Add(method, path string, op Operation) {
+	oaPath, params := convertPath(path)
+	for _, name := range params {
+		if !hasParameter(op.Parameters, name, "path") {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	if op.Responses == nil {
+		op.Responses = map[string]*Response{}
+	}
+
+	item, ok := d.Paths[oaPath]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[oaPath] = item
+	}
+	(*item)[strings.ToLower(method)] = &op
+}
+
+// Lookup returns the operation documented for a method and gin-syntax path
+func (d *Document) Lookup(method, path string) *Operation {
+	oaPath, _ := convertPath(path)
+	item, ok := d.Paths[oaPath]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// Has reports whether an operation is documented for a method and gin-syntax path
+func (d *Document) Has(method, path string) bool {
+	return d.Lookup(method, path) != nil
+}
+
+// Operations lists every documented method and OpenAPI path, sorted
+func (d *Document) Operations() [][2]string {
+	var ops [][2]string
+	for path, item := range d.Paths {
+		for method := range *item {
+			ops = append(ops, [2]string{strings.ToUpper(method), path})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i][1] != ops[j][1] {
+			return ops[i][1] < ops[j][1]
+		}
+		return ops[i][0] < ops[j][0]
+	})
+	return ops
+}
+
+// JSON returns a response with an application/json body of the given schema
+func JSON(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// Problem returns a response with an application/problem+json body of the given schema
+func Problem(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/problem+json": {Schema: schema}},
+	}
+}
+
+// JSONBody returns a required application/json request body of the given schema
+func JSONBody(schema *Schema) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// Redirect returns a redirect response
+func Redirect(description string) *Response {
+	return &Response{
+		Description: description,
+		Headers: map[string]Header{
+			"Location": {Description: "Redirect target", Schema: &Schema{Type: "string", Format: "uri"}},
+		},
+	}
+}
+
+// Status formats an HTTP status code as a Responses map key
+func Status(code int) string {
+	return strconv.Itoa(code)
+}
+
+// convertPath turns gin path syntax into OpenAPI syntax, returning the parameter names
+func convertPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// hasParameter reports whether params contains the named parameter
+func hasParameter(params []Parameter, name, in string) bool {
+	for _, p := range params {
+		if p.Name == name && p.In == in {
+			return true
+		}
+	}
+	return false
+}
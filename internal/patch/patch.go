@@ -0,0 +1,206 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/problem"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Patch media types
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// Operation is a JSON Patch (RFC 6902) operation, for API documentation
+type Operation struct {
+	Op    string `json:"op" doc:"add, remove, replace, move, copy or test"`
+	Path  string `json:"path" doc:"JSON Pointer to the target field, e.g. /name"`
+	From  string `json:"from,omitempty" doc:"Source pointer for move and copy"`
+	Value any    `json:"value,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Apply patches current with the request body, which may be a JSON Merge
+// Patch (RFC 7396) or a JSON Patch (RFC 6902) chosen by Content-Type, and
+// decodes the result into target, a pointer to the input struct of the
+// resource. current must marshal to the same JSON shape as target.
+//
+// The body is limited to maxBytes. The patched document is validated like a
+// full request body before the caller persists anything. Errors are
+// problems: 413 for bodies over the limit, 415 for other media types, 400 for
+// malformed patches, and 422 with one entry per invalid path, unknown field,
+// type mismatch or failed validation rule.
+func Apply(c *gin.Context, current, target any, maxBytes int64) error {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return problem.TooLarge(tooLarge.Limit)
+		}
+		return problem.Wrap(err, http.StatusBadRequest, problem.CodeInvalidRequest, "The request body could not be read")
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	switch contentType := c.ContentType(); contentType {
+	case MergePatchContentType:
+		patched, err = jsonpatch.MergePatch(doc, body)
+		if err != nil {
+			return problem.Wrap(err, http.StatusBadRequest, problem.CodeInvalidPatch, "The merge patch is not a JSON object")
+		}
+	case JSONPatchContentType:
+		patched, err = applyJSONPatch(doc, body)
+		if err != nil {
+			return err
+		}
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia,
+			fmt.Sprintf("PATCH accepts %s or %s, not %q", MergePatchContentType, JSONPatchContentType, contentType))
+	}
+
+	if fields := decodeFields(patched, target); len(fields) > 0 {
+		return problem.Validation(fields...)
+	}
+	if err := binding.Validator.ValidateStruct(target); err != nil {
+		return problem.FromBindError(err)
+	}
+	return nil
+}
+
+// applyJSONPatch applies the operations one at a time so a failure can be
+// reported against the path of the operation that caused it
+func applyJSONPatch(doc, body []byte) ([]byte, error) {
+	ops, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return nil, problem.Wrap(err, http.StatusBadRequest, problem.CodeInvalidPatch, "The JSON Patch is not an array of operations")
+	}
+
+	for i, op := range ops {
+		path, _ := op.Path()
+		doc, err = jsonpatch.Patch{op}.Apply(doc)
+		if err != nil {
+			return nil, problem.Validation(problem.FieldError{
+				Field:   fieldName(path),
+				Code:    "invalid_path",
+				Message: fmt.Sprintf("operation %d (%s %s) cannot be applied", i, op.Kind(), path),
+			})
+		}
+	}
+	return doc, nil
+}
+
+// decodeFields decodes a patched document into the target struct one field at
+// a time, reporting unknown fields and type mismatches by field name
+func decodeFields(doc []byte, target any) []problem.FieldError {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return []problem.FieldError{{Field: "", Code: "type", Message: "must be a JSON object"}}
+	}
+
+	val := reflect.ValueOf(target).Elem()
+	fields := map[string]reflect.Value{}
+	for i := 0; i < val.NumField(); i++ {
+		name, _, _ := strings.Cut(val.Type().Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = val.Field(i)
+		}
+	}
+
+	var errs []problem.FieldError
+	for _, name := range sortedKeys(raw) {
+		field, ok := fields[name]
+		if !ok {
+			errs = append(errs, problem.FieldError{Field: name, Code: "unknown", Message: "is not a field of this resource"})
+			continue
+		}
+		if err := json.Unmarshal(raw[name], field.Addr().Interface()); err != nil {
+			errs = append(errs, problem.FieldError{Field: name, Code: "type", Message: typeMessage(field.Type(), err)})
+		}
+	}
+	return errs
+}
+
+// typeMessage describes the JSON type a field expects
+func typeMessage(t reflect.Type, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		// A nested element, e.g. tags[1], has the wrong type
+		t = typeErr.Type
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "must be an RFC 3339 timestamp"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "must be a string"
+	case reflect.Bool:
+		return "must be a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "must be an integer"
+	case reflect.Float32, reflect.Float64:
+		return "must be a number"
+	case reflect.Slice, reflect.Array:
+		return "must be an array of " + strings.TrimPrefix(typeMessage(t.Elem(), nil), "must be ")
+	default:
+		return "must be an object"
+	}
+}
+
+// fieldName converts a JSON Pointer (/tags/1) to the field notation of validation errors (tags[1])
+func fieldName(pointer string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		switch {
+		case i == 0:
+			b.WriteString(part)
+		case isIndex(part):
+			b.WriteString("[" + part + "]")
+		default:
+			b.WriteString("." + part)
+		}
+	}
+	return b.String()
+}
+
+func isIndex(s string) bool {
+	if s == "-" {
+		return true
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package patch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vibed-traveller/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testInput struct {
+	Name  string   `json:"name" binding:"required,max=20"`
+	Notes string   `json:"notes"`
+	Tags  []string `json:"tags" binding:"max=3"`
+}
+
+// apply applies a patch body to a test input with a 1 KB limit
+func apply(contentType, body string) (*testInput, error) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", contentType)
+	var out testInput
+	err := Apply(c, &testInput{Name: "Alps", Tags: []string{"hiking"}}, &out, 1<<10)
+	return &out, err
+}
+
+func TestApply(t *testing.T) {
+	got, err := apply(MergePatchContentType, `{"notes": "bring boots", "tags": null}`)
+	if err != nil {
+		t.Fatalf("merge patch: %v", err)
+	}
+	if got.Name != "Alps" || got.Notes != "bring boots" || got.Tags != nil {
+		t.Errorf("merge patch gave %+v", got)
+	}
+
+	got, err = apply(JSONPatchContentType, `[{"op": "add", "path": "/tags/-", "value": "lakes"}]`)
+	if err != nil {
+		t.Fatalf("JSON Patch: %v", err)
+	}
+	if len(got.Tags) != 2 || got.Tags[1] != "lakes" {
+		t.Errorf("JSON Patch gave %+v", got)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"over the limit", MergePatchContentType, `{"notes": "` + strings.Repeat("x", 2<<10) + `"}`, http.StatusRequestEntityTooLarge},
+		{"JSON Patch over the limit", JSONPatchContentType, `[{"op": "add", "path": "/notes", "value": "` + strings.Repeat("x", 2<<10) + `"}]`, http.StatusRequestEntityTooLarge},
+		{"other media type", "application/json", `{}`, http.StatusUnsupportedMediaType},
+		{"malformed merge patch", MergePatchContentType, `{"notes":`, http.StatusBadRequest},
+		{"malformed JSON Patch", JSONPatchContentType, `{"op": "add"}`, http.StatusBadRequest},
+		{"invalid result", MergePatchContentType, `{"name": ""}`, http.StatusUnprocessableEntity},
+		{"unknown field", MergePatchContentType, `{"colour": "red"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		_, err := apply(tt.contentType, tt.body)
+		var p *problem.Problem
+		if !errors.As(err, &p) || p.Status != tt.status {
+			t.Errorf("%s: Apply = %v, want a %d problem", tt.name, err, tt.status)
+		}
+	}
+}
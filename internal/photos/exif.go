@@ -0,0 +1,351 @@
+package photos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/geo"
+)
+
+// ErrNoEXIF is returned for images without EXIF metadata
+var ErrNoEXIF = errors.New("no EXIF metadata")
+
+// exifDateLayout is the layout of EXIF dates and times
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// TIFF tags read from EXIF metadata
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+	tagGPSTimeStamp     = 0x0007
+	tagGPSDateStamp     = 0x001d
+)
+
+// EXIF is what a photo's EXIF metadata records about when and where it was taken
+type EXIF struct {
+	// DateTime is the camera's clock when the photo was taken, without a time
+	// zone; its fields are set as if it was UTC
+	DateTime time.Time
+
+	// Offset is the UTC offset of DateTime, when the camera recorded it
+	Offset *time.Duration
+
+	// GPSTime is the UTC time of the GPS fix
+	GPSTime *time.Time
+
+	Location *geo.Point
+
+	// Orientation is how the image must be rotated and flipped to be shown
+	// upright, from 1 (as stored) to 8; 0 when not recorded
+	Orientation int
+}
+
+// ReadEXIF reads the EXIF metadata of a JPEG or PNG image. Fields missing or
+// malformed in the metadata are left empty.
+func ReadEXIF(r io.Reader, contentType string) (*EXIF, error) {
+	var (
+		tiff []byte
+		err  error
+	)
+	switch contentType {
+	case "image/jpeg":
+		tiff, err = jpegEXIF(bufio.NewReader(r))
+	case "image/png":
+		tiff, err = pngEXIF(bufio.NewReader(r))
+	default:
+		return nil, ErrNoEXIF
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseTIFF(tiff)
+	if err != nil {
+		return nil, err
+	}
+	return t.exif(), nil
+}
+
+// Instant returns when the photo was taken: the camera's clock at its
+// recorded offset, or else the time of the GPS fix. ok is false when neither
+// is known.
+func (e *EXIF) Instant() (t time.Time, ok bool) {
+	switch {
+	case !e.DateTime.IsZero() && e.Offset != nil:
+		return e.DateTime.Add(-*e.Offset), true
+	case e.GPSTime != nil:
+		return *e.GPSTime, true
+	}
+	return time.Time{}, false
+}
+
+// jpegEXIF returns the TIFF structure of the Exif APP1 segment of a JPEG
+func jpegEXIF(r *bufio.Reader) ([]byte, error) {
+	var tiff []byte
+	err := walkJPEG(r, func(marker byte, payload []byte) ([]byte, error) {
+		if tiff == nil && marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			tiff = payload[len(exifHeader):]
+		}
+		return payload, nil
+	}, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	if tiff == nil {
+		return nil, ErrNoEXIF
+	}
+	return tiff, nil
+}
+
+// pngEXIF returns the TIFF structure of the eXIf chunk of a PNG
+func pngEXIF(r *bufio.Reader) ([]byte, error) {
+	var tiff []byte
+	err := walkPNG(r, func(chunkType string, data []byte) (bool, error) {
+		switch {
+		case chunkType == "IDAT":
+			// eXIf chunks come before the image data
+			return true, errStopWalk
+		case chunkType == "eXIf" && tiff == nil:
+			tiff = data
+		}
+		return true, nil
+	}, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	if tiff == nil {
+		return nil, ErrNoEXIF
+	}
+	return tiff, nil
+}
+
+// tiffEntry is an entry of a TIFF image file directory
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+
+	// pos is the position of the entry in the TIFF structure
+	pos int
+}
+
+// tiffTypeSizes are the sizes in bytes of the values of TIFF field types
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiff is a TIFF structure, as embedded in EXIF metadata
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseTIFF checks the header of a TIFF structure
+func parseTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("invalid EXIF metadata: truncated header")
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF metadata: unknown byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("invalid EXIF metadata: not a TIFF structure")
+	}
+	return t, nil
+}
+
+// ifd returns the entries of the image file directory at offset, or nil if
+// it lies outside the structure
+func (t *tiff) ifd(offset uint32) []tiffEntry {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.data)) {
+		return nil
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+12*n > len(t.data) {
+		n = (len(t.data) - start) / 12
+	}
+	entries := make([]tiffEntry, n)
+	for i := range entries {
+		pos := start + 12*i
+		entries[i] = tiffEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+			pos:   pos,
+		}
+	}
+	return entries
+}
+
+// value returns the bytes of an entry's value, or nil if they lie outside
+// the structure
+func (t *tiff) value(e tiffEntry) []byte {
+	size, ok := tiffTypeSizes[e.typ]
+	if !ok || e.count > math.MaxInt32/uint32(size) {
+		return nil
+	}
+	n := size * int(e.count)
+	if n <= 4 {
+		return t.data[e.pos+8 : e.pos+8+n]
+	}
+	offset := int(t.order.Uint32(t.data[e.pos+8:]))
+	if offset < 0 || offset+n > len(t.data) {
+		return nil
+	}
+	return t.data[offset : offset+n]
+}
+
+// uint returns the first value of a SHORT or LONG entry
+func (t *tiff) uint(e tiffEntry) (uint32, bool) {
+	v := t.value(e)
+	switch {
+	case e.typ == 3 && len(v) >= 2:
+		return uint32(t.order.Uint16(v)), true
+	case e.typ == 4 && len(v) >= 4:
+		return t.order.Uint32(v), true
+	}
+	return 0, false
+}
+
+// ascii returns the value of an ASCII entry, up to its terminating NUL
+func (t *tiff) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	v := t.value(e)
+	if i := bytes.IndexByte(v, 0); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(string(v))
+}
+
+// rationals returns the values of a RATIONAL entry
+func (t *tiff) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	v := t.value(e)
+	values := make([]float64, 0, len(v)/8)
+	for i := 0; i+8 <= len(v); i += 8 {
+		num, den := t.order.Uint32(v[i:]), t.order.Uint32(v[i+4:])
+		if den == 0 {
+			return nil
+		}
+		values = append(values, float64(num)/float64(den))
+	}
+	return values
+}
+
+// subIFD returns the entries of the directory an IFD0 entry points to
+func (t *tiff) subIFD(ifd0 []tiffEntry, tag uint16) []tiffEntry {
+	for _, e := range ifd0 {
+		if e.tag == tag {
+			if offset, ok := t.uint(e); ok {
+				return t.ifd(offset)
+			}
+		}
+	}
+	return nil
+}
+
+// exif collects the fields of interest from the directories of the structure
+func (t *tiff) exif() *EXIF {
+	e := &EXIF{}
+	ifd0 := t.ifd(t.order.Uint32(t.data[4:]))
+	var dateTime, original, offset string
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case tagOrientation:
+			if v, ok := t.uint(entry); ok && v >= 1 && v <= 8 {
+				e.Orientation = int(v)
+			}
+		case tagDateTime:
+			dateTime = t.ascii(entry)
+		}
+	}
+	for _, entry := range t.subIFD(ifd0, tagExifIFD) {
+		switch entry.tag {
+		case tagDateTimeOriginal:
+			original = t.ascii(entry)
+		case tagOffsetOriginal:
+			offset = t.ascii(entry)
+		}
+	}
+	if original == "" {
+		// The time the file was last changed, often by the camera itself
+		original, offset = dateTime, ""
+	}
+	if dt, err := time.Parse(exifDateLayout, original); err == nil {
+		e.DateTime = dt
+		if o, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := o.Zone()
+			d := time.Duration(seconds) * time.Second
+			e.Offset = &d
+		}
+	}
+
+	var (
+		latRef, lonRef, gpsDate string
+		lat, lon, gpsTime       []float64
+	)
+	for _, entry := range t.subIFD(ifd0, tagGPSIFD) {
+		switch entry.tag {
+		case tagGPSLatitudeRef:
+			latRef = t.ascii(entry)
+		case tagGPSLatitude:
+			lat = t.rationals(entry)
+		case tagGPSLongitudeRef:
+			lonRef = t.ascii(entry)
+		case tagGPSLongitude:
+			lon = t.rationals(entry)
+		case tagGPSTimeStamp:
+			gpsTime = t.rationals(entry)
+		case tagGPSDateStamp:
+			gpsDate = t.ascii(entry)
+		}
+	}
+	if len(lat) == 3 && len(lon) == 3 {
+		p := geo.Point{Lat: dms(lat), Lon: dms(lon)}
+		if latRef == "S" {
+			p.Lat = -p.Lat
+		}
+		if lonRef == "W" {
+			p.Lon = -p.Lon
+		}
+		// Cameras without a fix often record 0,0
+		if p.Valid() && (p.Lat != 0 || p.Lon != 0) {
+			e.Location = &p
+		}
+	}
+	if day, err := time.Parse("2006:01:02", gpsDate); err == nil && len(gpsTime) == 3 {
+		seconds := gpsTime[0]*3600 + gpsTime[1]*60 + gpsTime[2]
+		if seconds >= 0 && seconds < 86400 {
+			ts := day.Add(time.Duration(seconds * float64(time.Second))).Truncate(time.Second)
+			e.GPSTime = &ts
+		}
+	}
+	return e
+}
+
+// dms converts degrees, minutes and seconds to decimal degrees
+func dms(v []float64) float64 {
+	return v[0] + v[1]/60 + v[2]/3600
+}
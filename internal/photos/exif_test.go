@@ -0,0 +1,228 @@
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntry is an entry of a TIFF directory built by a test
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortEntry(tag, v uint16) testEntry {
+	return testEntry{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func longEntry(tag uint16, v uint32) testEntry {
+	return testEntry{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+// rationalEntry records each value in thousandths
+func rationalEntry(tag uint16, values ...float64) testEntry {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, uint32(math.Round(v*1000)))
+		data = binary.LittleEndian.AppendUint32(data, 1000)
+	}
+	return testEntry{tag, 5, uint32(len(values)), data}
+}
+
+// appendIFD appends a directory and the values that don't fit in its
+// entries to a little-endian TIFF structure, returning its offset
+func appendIFD(data []byte, entries ...testEntry) ([]byte, uint32) {
+	offset := uint32(len(data))
+	valuesAt := offset + uint32(2+12*len(entries)+4)
+	var values []byte
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(entries)))
+	for _, e := range entries {
+		data = binary.LittleEndian.AppendUint16(data, e.tag)
+		data = binary.LittleEndian.AppendUint16(data, e.typ)
+		data = binary.LittleEndian.AppendUint32(data, e.count)
+		if len(e.value) <= 4 {
+			data = append(data, e.value...)
+			data = append(data, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		data = binary.LittleEndian.AppendUint32(data, valuesAt+uint32(len(values)))
+		values = append(values, e.value...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	return append(data, values...), offset
+}
+
+// testTIFF is the TIFF structure of a photo taken in Interlaken at 10:00 on
+// 1 July 2024, in UTC+2, by a camera held sideways
+func testTIFF() []byte {
+	data := []byte("II\x2a\x00\x00\x00\x00\x00")
+	data, exifIFD := appendIFD(data,
+		asciiEntry(tagDateTimeOriginal, "2024:07:01 10:00:00"),
+		asciiEntry(tagOffsetOriginal, "+02:00"),
+	)
+	data, gpsIFD := appendIFD(data,
+		asciiEntry(tagGPSLatitudeRef, "N"),
+		rationalEntry(tagGPSLatitude, 46, 41, 6),
+		asciiEntry(tagGPSLongitudeRef, "E"),
+		rationalEntry(tagGPSLongitude, 7, 51, 36),
+		rationalEntry(tagGPSTimeStamp, 8, 0, 1),
+		asciiEntry(tagGPSDateStamp, "2024:07:01"),
+	)
+	data, ifd0 := appendIFD(data,
+		shortEntry(tagOrientation, 6),
+		asciiEntry(tagDateTime, "2024:07:02 18:30:00"),
+		longEntry(tagExifIFD, exifIFD),
+		longEntry(tagGPSIFD, gpsIFD),
+	)
+	binary.LittleEndian.PutUint32(data[4:], ifd0)
+	return data
+}
+
+// testImage is a small image with some detail to encode
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := range 16 {
+		for y := range 8 {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegSegment encodes a JPEG segment
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEG encodes testImage with segments inserted after the start of image
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return slices.Concat(data[:2], bytes.Join(segments, nil), data[2:])
+}
+
+// pngChunk encodes a PNG chunk
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// testPNG encodes testImage with chunks inserted after its header chunk
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// The signature and the IHDR chunk
+	ihdr := len(pngHeader) + 8 + 13 + 4
+	return slices.Concat(data[:ihdr], bytes.Join(chunks, nil), data[ihdr:])
+}
+
+func TestReadEXIF(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"JPEG", "image/jpeg", testJPEG(t, jpegSegment(markerAPP1, slices.Concat(exifHeader, testTIFF())))},
+		{"PNG", "image/png", testPNG(t, pngChunk("eXIf", testTIFF()))},
+	}
+	for _, tt := range tests {
+		e, err := ReadEXIF(bytes.NewReader(tt.data), tt.contentType)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if e.Orientation != 6 {
+			t.Errorf("%s: orientation %d, want 6", tt.name, e.Orientation)
+		}
+		if e.Location == nil || math.Abs(e.Location.Lat-46.685) > 1e-6 || math.Abs(e.Location.Lon-7.86) > 1e-6 {
+			t.Errorf("%s: location %v, want 46.685,7.86", tt.name, e.Location)
+		}
+		want := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+		if instant, ok := e.Instant(); !ok || !instant.Equal(want) {
+			t.Errorf("%s: taken at %v, want %v", tt.name, instant, want)
+		}
+		if e.GPSTime == nil || !e.GPSTime.Equal(want.Add(time.Second)) {
+			t.Errorf("%s: GPS time %v", tt.name, e.GPSTime)
+		}
+	}
+}
+
+func TestReadEXIFFallbacks(t *testing.T) {
+	data, ifd0 := appendIFD([]byte("II\x2a\x00\x00\x00\x00\x00"),
+		shortEntry(tagOrientation, 9),
+		asciiEntry(tagDateTime, "2024:07:02 18:30:00"),
+		// Points past the end of the structure
+		longEntry(tagGPSIFD, 1<<20),
+	)
+	binary.LittleEndian.PutUint32(data[4:], ifd0)
+
+	e, err := ReadEXIF(bytes.NewReader(testPNG(t, pngChunk("eXIf", data))), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Orientation != 0 || e.Location != nil || e.Offset != nil {
+		t.Errorf("ReadEXIF = %+v", e)
+	}
+	if !e.DateTime.Equal(time.Date(2024, 7, 2, 18, 30, 0, 0, time.UTC)) {
+		t.Errorf("date and time %v, want the time the file was changed", e.DateTime)
+	}
+	if _, ok := e.Instant(); ok {
+		t.Error("instant known without an offset or a GPS fix")
+	}
+}
+
+func TestReadEXIFErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		noEXIF      bool
+	}{
+		{"JPEG without EXIF", "image/jpeg", testJPEG(t), true},
+		{"PNG without EXIF", "image/png", testPNG(t), true},
+		{"GIF", "image/gif", []byte("GIF89a"), true},
+		{"not a JPEG", "image/jpeg", []byte("GIF89a"), false},
+		{"truncated JPEG", "image/jpeg", testJPEG(t)[:20], false},
+		{"not a TIFF structure", "image/png", testPNG(t, pngChunk("eXIf", []byte("XX\x2a\x00\x08\x00\x00\x00"))), false},
+	}
+	for _, tt := range tests {
+		_, err := ReadEXIF(bytes.NewReader(tt.data), tt.contentType)
+		if err == nil || errors.Is(err, ErrNoEXIF) != tt.noEXIF {
+			t.Errorf("%s: ReadEXIF = %v, want ErrNoEXIF: %t", tt.name, err, tt.noEXIF)
+			continue
+		}
+		if !tt.noEXIF && !strings.HasPrefix(err.Error(), "invalid") {
+			t.Errorf("%s: ReadEXIF = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package photos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image/gif"
+	"io"
+	"slices"
+)
+
+// JPEG markers
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerEOI  = 0xd9
+	markerAPP1 = 0xe1
+)
+
+// maxMetadataChunk caps the size of the PNG and WebP chunks read into memory
+const maxMetadataChunk = 16 << 20
+
+// vp8xXMPFlag is the flag of the WebP VP8X chunk announcing XMP metadata
+const vp8xXMPFlag = 0x04
+
+var (
+	exifHeader  = []byte("Exif\x00\x00")
+	pngHeader   = []byte("\x89PNG\r\n\x1a\n")
+	xmpPrefixes = [][]byte{
+		[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+		[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+	}
+)
+
+// errStopWalk stops walking the chunks of a PNG
+var errStopWalk = errors.New("stop")
+
+// ErrCannotStrip is returned by StripLocation for images it can't remove the
+// location from, which must not be shared
+var ErrCannotStrip = errors.New("can't remove the location from this type of image")
+
+// stripTypes are the media types of the images StripLocation handles
+var stripTypes = []string{"image/jpeg", "image/png", "image/webp", "image/gif"}
+
+// CanStripLocation reports whether StripLocation handles images of contentType
+func CanStripLocation(contentType string) bool {
+	return slices.Contains(stripTypes, contentType)
+}
+
+// StripLocation copies an image from r to w without the location it was
+// taken at. In JPEG, PNG and WebP images the GPS fields of the EXIF metadata
+// are blanked, keeping the rest such as the orientation, and XMP metadata,
+// which can repeat them, is removed; metadata that can't be parsed is removed
+// whole, as is anything after the end of the image. GIF images are
+// re-encoded with their frames only. Other types of images get ErrCannotStrip
+// before anything is written.
+func StripLocation(w io.Writer, r io.Reader, contentType string) error {
+	br := bufio.NewReader(r)
+	switch contentType {
+	case "image/jpeg":
+		strip := func(marker byte, payload []byte) ([]byte, error) {
+			if marker != markerAPP1 {
+				return payload, nil
+			}
+			if bytes.HasPrefix(payload, exifHeader) {
+				if !blankGPS(payload[len(exifHeader):]) {
+					return nil, nil
+				}
+				return payload, nil
+			}
+			for _, prefix := range xmpPrefixes {
+				if bytes.HasPrefix(payload, prefix) {
+					return nil, nil
+				}
+			}
+			return payload, nil
+		}
+		if err := walkJPEG(br, strip, w); err != nil {
+			return err
+		}
+		return walkJPEGScans(br, strip, w)
+	case "image/png":
+		return walkPNG(br, func(chunkType string, data []byte) (bool, error) {
+			switch chunkType {
+			case "eXIf":
+				return blankGPS(data), nil
+			case "iTXt", "tEXt", "zTXt":
+				// XMP, or EXIF and XMP kept as text by ImageMagick
+				keyword, _, _ := bytes.Cut(data, []byte{0})
+				return string(keyword) != "XML:com.adobe.xmp" && !bytes.HasPrefix(keyword, []byte("Raw profile type")), nil
+			}
+			return true, nil
+		}, w)
+	case "image/webp":
+		return walkWebP(br, func(fourCC string, data []byte) {
+			switch fourCC {
+			case "VP8X":
+				data[0] &^= vp8xXMPFlag
+			case "EXIF":
+				// Some writers keep the JPEG prefix
+				if !blankGPS(bytes.TrimPrefix(data, exifHeader)) {
+					clear(data)
+				}
+			case "XMP ":
+				clear(data)
+			}
+		}, w)
+	case "image/gif":
+		// GIF metadata lives in extension blocks, which the encoder leaves out
+		img, err := gif.DecodeAll(br)
+		if err != nil {
+			return fmt.Errorf("invalid GIF: %w", err)
+		}
+		return gif.EncodeAll(w, img)
+	default:
+		return fmt.Errorf("%w: %s", ErrCannotStrip, contentType)
+	}
+}
+
+// blankGPS empties the GPS directory of a TIFF structure in place, zeroing
+// its entries and their values. It returns false if data is not a TIFF
+// structure, which may then hide a location.
+func blankGPS(data []byte) bool {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return false
+	}
+	ifd0 := t.ifd(t.order.Uint32(data[4:]))
+	var offset uint32
+	for _, e := range ifd0 {
+		if e.tag == tagGPSIFD {
+			offset, _ = t.uint(e)
+		}
+	}
+	gps := t.ifd(offset)
+	if gps == nil {
+		return true
+	}
+	for _, e := range gps {
+		clear(t.value(e))
+		clear(data[e.pos : e.pos+12])
+	}
+	t.order.PutUint16(data[offset:], 0)
+	return true
+}
+
+// walkJPEG reads the segments of a JPEG up to the start of its image data,
+// passing each to fn and writing the payload fn returns to w, unless it is
+// nil. It stops after writing the scan header, leaving r at the image data.
+func walkJPEG(r *bufio.Reader, fn func(marker byte, payload []byte) ([]byte, error), w io.Writer) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return fmt.Errorf("invalid JPEG: missing start of image")
+	}
+	if _, err := w.Write(soi[:]); err != nil {
+		return err
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("invalid JPEG: %w", err)
+		}
+		if b != 0xff {
+			return fmt.Errorf("invalid JPEG: expected a marker")
+		}
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xff {
+			// Markers can be preceded by any number of fill bytes
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return fmt.Errorf("invalid JPEG: %w", err)
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			// Standalone markers have no payload
+			if _, err := w.Write([]byte{0xff, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copySegment(r, marker, fn, w); err != nil {
+			return err
+		}
+		if marker == markerSOS {
+			return nil
+		}
+	}
+}
+
+// walkJPEGScans copies the rest of a JPEG from r to w once walkJPEG has
+// stopped at its first scan. Segments between scans, as in progressive
+// JPEGs, are passed to fn like those before the first one, and anything
+// after the end of the image is left out.
+func walkJPEGScans(r *bufio.Reader, fn func(marker byte, payload []byte) ([]byte, error), w io.Writer) error {
+	for {
+		data, err := r.ReadSlice(0xff)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid JPEG: missing end of image: %w", err)
+		}
+		if _, err := w.Write(data[:len(data)-1]); err != nil {
+			return err
+		}
+
+		marker, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("invalid JPEG: %w", err)
+		}
+		switch {
+		case marker == 0xff:
+			// Fill bytes before a marker are dropped
+			if err := r.UnreadByte(); err != nil {
+				return err
+			}
+			continue
+		case marker == 0x00, marker == 0x01, marker >= 0xd0 && marker <= 0xd7:
+			// Stuffed data bytes and standalone markers
+			if _, err := w.Write([]byte{0xff, marker}); err != nil {
+				return err
+			}
+			continue
+		case marker == markerEOI:
+			_, err := w.Write([]byte{0xff, marker})
+			return err
+		}
+		if err := copySegment(r, marker, fn, w); err != nil {
+			return err
+		}
+	}
+}
+
+// copySegment reads the payload of a JPEG segment following its marker,
+// passes it to fn and writes the segment with the payload fn returns to w,
+// unless it is nil
+func copySegment(r *bufio.Reader, marker byte, fn func(marker byte, payload []byte) ([]byte, error), w io.Writer) error {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return fmt.Errorf("invalid JPEG: %w", err)
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n < 2 {
+		return fmt.Errorf("invalid JPEG: segment length %d", n)
+	}
+	payload := make([]byte, n-2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fmt.Errorf("invalid JPEG: %w", err)
+	}
+	payload, err := fn(marker, payload)
+	if err != nil || payload == nil {
+		return err
+	}
+	segment := append([]byte{0xff, marker, 0, 0}, payload...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	_, err = w.Write(segment)
+	return err
+}
+
+// walkPNG copies the chunks of a PNG from r to w, up to its end. The eXIf
+// chunks and text chunks (iTXt, tEXt and zTXt) are passed to fn, which can
+// change their data in place and reports whether to keep them; their
+// checksums are recomputed. fn is also called, without data, at the first
+// IDAT chunk, so that it can stop the walk by returning errStopWalk.
+func walkPNG(r *bufio.Reader, fn func(chunkType string, data []byte) (bool, error), w io.Writer) error {
+	header := make([]byte, len(pngHeader))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, pngHeader) {
+		return fmt.Errorf("invalid PNG: missing signature")
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	seenData := false
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return fmt.Errorf("invalid PNG: %w", err)
+		}
+		n := binary.BigEndian.Uint32(head[:4])
+		chunkType := string(head[4:])
+
+		switch chunkType {
+		case "eXIf", "iTXt", "tEXt", "zTXt":
+			if n > maxMetadataChunk {
+				return fmt.Errorf("invalid PNG: %s chunk of %d bytes", chunkType, n)
+			}
+			chunk := make([]byte, 8+n+4)
+			copy(chunk, head[:])
+			if _, err := io.ReadFull(r, chunk[8:]); err != nil {
+				return fmt.Errorf("invalid PNG: %w", err)
+			}
+			keep, err := fn(chunkType, chunk[8:8+n])
+			if err != nil {
+				return err
+			}
+			if keep {
+				binary.BigEndian.PutUint32(chunk[8+n:], crc32.ChecksumIEEE(chunk[4:8+n]))
+				if _, err := w.Write(chunk); err != nil {
+					return err
+				}
+			}
+			continue
+		case "IDAT":
+			if !seenData {
+				seenData = true
+				if _, err := fn(chunkType, nil); err != nil {
+					if errors.Is(err, errStopWalk) {
+						return nil
+					}
+					return err
+				}
+			}
+		}
+
+		if _, err := w.Write(head[:]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, int64(n)+4); err != nil {
+			return fmt.Errorf("invalid PNG: %w", err)
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// walkWebP copies the chunks of a WebP image from r to w. The VP8X, EXIF and
+// XMP chunks are passed to fn, which can change their data in place; the
+// others, such as the image data, are copied as they are read.
+func walkWebP(r *bufio.Reader, fn func(fourCC string, data []byte), w io.Writer) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return fmt.Errorf("invalid WebP: missing RIFF header")
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	// The RIFF size counts the WEBP signature
+	remaining := int64(binary.LittleEndian.Uint32(header[4:])) - 4
+	for remaining > 0 {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return fmt.Errorf("invalid WebP: %w", err)
+		}
+		fourCC := string(head[:4])
+		n := int64(binary.LittleEndian.Uint32(head[4:]))
+		// Chunks are padded to an even size
+		padded := n + n%2
+		if 8+padded > remaining {
+			return fmt.Errorf("invalid WebP: %s chunk of %d bytes overruns the file", fourCC, n)
+		}
+		remaining -= 8 + padded
+
+		if _, err := w.Write(head[:]); err != nil {
+			return err
+		}
+		switch fourCC {
+		case "VP8X", "EXIF", "XMP ":
+			if n > maxMetadataChunk || (fourCC == "VP8X" && n < 1) {
+				return fmt.Errorf("invalid WebP: %s chunk of %d bytes", fourCC, n)
+			}
+			data := make([]byte, padded)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("invalid WebP: %w", err)
+			}
+			fn(fourCC, data[:n])
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		default:
+			if _, err := io.CopyN(w, r, padded); err != nil {
+				return fmt.Errorf("invalid WebP: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package photos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// trailer is data hidden after the end of an image
+const trailer = "trailer at 46.685,7.86"
+
+var (
+	testXMP  = slices.Concat(xmpPrefixes[0], []byte(`<x:xmpmeta><exif:GPSLatitude>46,41.1N</exif:GPSLatitude></x:xmpmeta>`))
+	testEXIF = slices.Concat(exifHeader, testTIFF())
+)
+
+// strip strips the location from an image, failing the test on errors
+func strip(t *testing.T, data []byte, contentType string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := StripLocation(&out, bytes.NewReader(data), contentType); err != nil {
+		t.Fatalf("StripLocation: %v", err)
+	}
+	return out.Bytes()
+}
+
+// checkStripped checks that an image has EXIF metadata without a location
+// and without the XMP metadata or trailer it came with
+func checkStripped(t *testing.T, data []byte, contentType string) {
+	t.Helper()
+	e, err := ReadEXIF(bytes.NewReader(data), contentType)
+	if err != nil {
+		t.Fatalf("ReadEXIF: %v", err)
+	}
+	if e.Location != nil || e.GPSTime != nil {
+		t.Errorf("GPS fields kept: %v at %v", e.Location, e.GPSTime)
+	}
+	if e.Orientation != 6 || e.Offset == nil {
+		t.Errorf("other fields lost: %+v", e)
+	}
+	if bytes.Contains(data, []byte("xmpmeta")) {
+		t.Error("XMP metadata kept")
+	}
+	if bytes.Contains(data, []byte(trailer)) {
+		t.Error("trailer kept")
+	}
+}
+
+func TestStripLocationJPEG(t *testing.T) {
+	data := testJPEG(t,
+		jpegSegment(markerAPP1, testEXIF),
+		jpegSegment(markerAPP1, testXMP),
+	)
+	// A segment between scans, as in progressive JPEGs
+	eoi := len(data) - 2
+	data = slices.Concat(data[:eoi], jpegSegment(markerAPP1, testXMP), data[eoi:], []byte(trailer))
+
+	out := strip(t, data, "image/jpeg")
+	checkStripped(t, out, "image/jpeg")
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+	if !bytes.HasSuffix(out, []byte{0xff, markerEOI}) {
+		t.Error("stripped JPEG does not end with its end of image")
+	}
+}
+
+func TestStripLocationJPEGInvalidEXIF(t *testing.T) {
+	invalid := slices.Concat(exifHeader, []byte("MM\x00\x2b"), testTIFF()[4:])
+	out := strip(t, testJPEG(t, jpegSegment(markerAPP1, invalid)), "image/jpeg")
+	if _, err := ReadEXIF(bytes.NewReader(out), "image/jpeg"); !errors.Is(err, ErrNoEXIF) {
+		t.Errorf("ReadEXIF = %v, want the unreadable metadata removed", err)
+	}
+}
+
+func TestStripLocationPNG(t *testing.T) {
+	comment := pngChunk("tEXt", []byte("Comment\x00Sunrise"))
+	data := testPNG(t,
+		pngChunk("eXIf", testTIFF()),
+		pngChunk("iTXt", slices.Concat([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP)),
+		pngChunk("zTXt", slices.Concat([]byte("Raw profile type xmp\x00\x00"), testXMP)),
+		comment,
+	)
+	data = append(data, trailer...)
+
+	out := strip(t, data, "image/png")
+	checkStripped(t, out, "image/png")
+	if !bytes.Contains(out, comment) {
+		t.Error("other text chunks removed")
+	}
+	// Decoding checks the checksums of the chunks
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripLocationPNGInvalidEXIF(t *testing.T) {
+	out := strip(t, testPNG(t, pngChunk("eXIf", []byte("not a TIFF structure"))), "image/png")
+	if bytes.Contains(out, []byte("eXIf")) {
+		t.Error("unreadable EXIF metadata kept")
+	}
+}
+
+// webpChunk encodes a RIFF chunk, padded to an even size
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// testWebP builds an extended WebP file of chunks
+func testWebP(chunks ...[]byte) []byte {
+	body := slices.Concat([]byte("WEBP"), bytes.Join(chunks, nil))
+	return slices.Concat([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body))), body)
+}
+
+// webpChunks returns the data of the VP8X, EXIF and XMP chunks of a WebP file
+func webpChunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	chunks := map[string][]byte{}
+	err := walkWebP(bufio.NewReader(bytes.NewReader(data)), func(fourCC string, data []byte) {
+		chunks[fourCC] = data
+	}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func TestStripLocationWebP(t *testing.T) {
+	// EXIF and XMP flags, and a 16x8 canvas
+	vp8x := []byte{0x08 | vp8xXMPFlag, 0, 0, 0, 15, 0, 0, 7, 0, 0}
+	// Image data of an odd size, so padded
+	vp8l := webpChunk("VP8L", []byte("\x2f\x0f\x00\x07\x00"))
+
+	tests := []struct {
+		name string
+		exif []byte
+	}{
+		{"EXIF chunk", testTIFF()},
+		{"EXIF chunk with a JPEG prefix", testEXIF},
+	}
+	for _, tt := range tests {
+		data := testWebP(webpChunk("VP8X", vp8x), vp8l, webpChunk("EXIF", tt.exif), webpChunk("XMP ", testXMP))
+		data = append(data, trailer...)
+
+		out := strip(t, data, "image/webp")
+		if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+			t.Errorf("%s: RIFF size %d for %d bytes", tt.name, size, len(out)-8)
+		}
+		if bytes.Contains(out, []byte(trailer)) {
+			t.Errorf("%s: trailer kept", tt.name)
+		}
+		if !bytes.Contains(out, vp8l) {
+			t.Errorf("%s: image data changed", tt.name)
+		}
+
+		chunks := webpChunks(t, out)
+		if flags := chunks["VP8X"][0]; flags != 0x08 {
+			t.Errorf("%s: VP8X flags %#x, want only EXIF", tt.name, flags)
+		}
+		if xmp := chunks["XMP "]; len(xmp) != len(testXMP) || slices.ContainsFunc(xmp, func(b byte) bool { return b != 0 }) {
+			t.Errorf("%s: XMP metadata kept", tt.name)
+		}
+		tiff, err := parseTIFF(bytes.TrimPrefix(chunks["EXIF"], exifHeader))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if e := tiff.exif(); e.Location != nil || e.Orientation != 6 {
+			t.Errorf("%s: EXIF metadata %+v", tt.name, e)
+		}
+	}
+}
+
+func TestStripLocationWebPInvalidEXIF(t *testing.T) {
+	out := strip(t, testWebP(webpChunk("EXIF", []byte("not a TIFF structure"))), "image/webp")
+	if exif := webpChunks(t, out)["EXIF"]; slices.ContainsFunc(exif, func(b byte) bool { return b != 0 }) {
+		t.Errorf("unreadable EXIF metadata kept: %q", exif)
+	}
+}
+
+func TestStripLocationGIF(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 16, 8), palette.Plan9)
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// A comment extension before the GIF trailer, and data after it
+	end := len(data) - 1
+	data = slices.Concat(data[:end], []byte("\x21\xfe\x07xmpmeta\x00"), data[end:], []byte(trailer))
+
+	out := strip(t, data, "image/gif")
+	if bytes.Contains(out, []byte("xmpmeta")) || bytes.Contains(out, []byte(trailer)) {
+		t.Error("metadata kept")
+	}
+	decoded, err := gif.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("stripped GIF does not decode: %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("stripped GIF is %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+}
+
+func TestStripLocationErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		cannotStrip bool
+	}{
+		{"BMP", "image/bmp", []byte("BM"), true},
+		{"SVG", "image/svg+xml", []byte("<svg/>"), true},
+		{"not a JPEG", "image/jpeg", []byte("GIF89a"), false},
+		{"JPEG without an end", "image/jpeg", testJPEG(t)[:200], false},
+		{"not a PNG", "image/png", []byte("GIF89a"), false},
+		{"PNG without an end", "image/png", testPNG(t)[:60], false},
+		{"not a WebP", "image/webp", []byte("RIFF\x04\x00\x00\x00WAVE"), false},
+		{"WebP chunk overrunning the file", "image/webp", testWebP(webpChunk("EXIF", testTIFF()))[:40], false},
+		{"not a GIF", "image/gif", []byte("PNG"), false},
+	}
+	for _, tt := range tests {
+		if CanStripLocation(tt.contentType) == tt.cannotStrip {
+			t.Errorf("%s: CanStripLocation = %t", tt.name, !tt.cannotStrip)
+		}
+		var out bytes.Buffer
+		err := StripLocation(&out, bytes.NewReader(tt.data), tt.contentType)
+		if err == nil || errors.Is(err, ErrCannotStrip) != tt.cannotStrip {
+			t.Errorf("%s: StripLocation = %v, want ErrCannotStrip: %t", tt.name, err, tt.cannotStrip)
+		}
+		if tt.cannotStrip && out.Len() > 0 {
+			t.Errorf("%s: %d bytes written", tt.name, out.Len())
+		}
+		if err != nil && !tt.cannotStrip && !strings.HasPrefix(err.Error(), "invalid") {
+			t.Errorf("%s: StripLocation = %v", tt.name, err)
+		}
+	}
+}
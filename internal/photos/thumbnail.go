@@ -0,0 +1,161 @@
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	// Formats thumbnails are made of
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ThumbnailSizes are the sizes thumbnails are made in, by name, as the
+// length of their longest side in pixels
+var ThumbnailSizes = map[string]int{
+	"small": 320,
+	"large": 1280,
+}
+
+// DefaultThumbnailSize is the size of thumbnails requested without one
+const DefaultThumbnailSize = "small"
+
+// MaxPixels caps the size of the images thumbnails are made of, which are
+// decoded in memory
+const MaxPixels = 50_000_000
+
+// thumbnailQuality is the JPEG quality of thumbnails
+const thumbnailQuality = 80
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels
+var ErrTooLarge = errors.New("image too large")
+
+// Size returns the width and height of an image as shown upright
+func Size(r io.Reader, orientation int) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if orientation >= 5 {
+		return cfg.Height, cfg.Width, nil
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Decode decodes a JPEG, PNG or GIF image of up to MaxPixels pixels
+func Decode(r io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Thumbnail returns img as a JPEG scaled down to fit in size×size pixels and
+// turned upright according to its EXIF orientation. It carries no metadata.
+// Transparent areas become white.
+func Thumbnail(img image.Image, size, orientation int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, orient(shrink(img, size), orientation), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shrink scales img down to fit in size×size pixels, averaging the pixels
+// that make up each pixel of the result
+func shrink(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, (h*size+w/2)/w)
+		} else {
+			dw, dh = max(1, (w*size+h/2)/h), size
+		}
+	}
+
+	sums := make([]uint64, dw*dh*3)
+	counts := make([]uint32, dw*dh)
+	ycbcr, _ := img.(*image.YCbCr)
+	for y := 0; y < h; y++ {
+		row := (y * dh / h) * dw
+		for x := 0; x < w; x++ {
+			var r, g, bl uint8
+			if ycbcr != nil {
+				yi, ci := ycbcr.YOffset(b.Min.X+x, b.Min.Y+y), ycbcr.COffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl = color.YCbCrToRGB(ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci])
+			} else {
+				// Premultiplied colors over a white background
+				cr, cg, cb, ca := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				r, g, bl = uint8((cr+0xffff-ca)>>8), uint8((cg+0xffff-ca)>>8), uint8((cb+0xffff-ca)>>8)
+			}
+			i := row + x*dw/w
+			sums[3*i] += uint64(r)
+			sums[3*i+1] += uint64(g)
+			sums[3*i+2] += uint64(bl)
+			counts[i]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		half := uint64(n) / 2
+		dst.Pix[4*i] = uint8((sums[3*i] + half) / uint64(n))
+		dst.Pix[4*i+1] = uint8((sums[3*i+1] + half) / uint64(n))
+		dst.Pix[4*i+2] = uint8((sums[3*i+2] + half) / uint64(n))
+		dst.Pix[4*i+3] = 0xff
+	}
+	return dst
+}
+
+// orient rotates and flips an image stored with an EXIF orientation so that
+// it is upright
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,235 @@
+package places
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+)
+
+// File kinds recognised by Import, from the GeoNames export at
+// https://download.geonames.org/export/dump/
+const (
+	// FileCountries is countryInfo.txt
+	FileCountries = "countries"
+
+	// FileRegions is admin1CodesASCII.txt
+	FileRegions = "regions"
+
+	// FilePlaces is a main dump such as cities15000.txt or allCountries.txt
+	FilePlaces = "places"
+)
+
+// ImportStats counts the records stored by an import
+type ImportStats struct {
+	Kind      string
+	Countries int
+	Regions   int
+	Places    int
+	Skipped   int
+}
+
+// maxLineLength fits the longest alternate name lists of the main dump
+const maxLineLength = 1 << 20
+
+// Import loads a GeoNames file, or the first .txt file of a .zip archive,
+// in a single transaction. The file kind is detected from its name.
+func Import(ctx context.Context, st *store.Store, path string) (*ImportStats, error) {
+	var (
+		r    io.Reader
+		name = filepath.Base(path)
+	)
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		var entry *zip.File
+		for _, f := range zr.File {
+			if strings.EqualFold(filepath.Ext(f.Name), ".txt") && !strings.EqualFold(filepath.Base(f.Name), "readme.txt") {
+				entry = f
+				break
+			}
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("%s contains no .txt file", path)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		r, name = rc, filepath.Base(entry.Name)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	kind := fileKind(name)
+	imp, err := st.BeginPlaceImport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ImportStats{Kind: kind}
+	if err := importRows(r, kind, imp, stats); err != nil {
+		_ = imp.Rollback()
+		return nil, fmt.Errorf("importing %s: %w", name, err)
+	}
+	if err := imp.Commit(); err != nil {
+		return nil, fmt.Errorf("importing %s: %w", name, err)
+	}
+	return stats, nil
+}
+
+// fileKind detects the kind of a GeoNames file from its name
+func fileKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "countryinfo"):
+		return FileCountries
+	case strings.HasPrefix(lower, "admin1codes"):
+		return FileRegions
+	default:
+		return FilePlaces
+	}
+}
+
+// importRows reads tab-separated rows, skipping blank and # comment lines
+func importRows(r io.Reader, kind string, imp *store.PlaceImport, stats *ImportStats) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+
+		var err error
+		switch kind {
+		case FileCountries:
+			err = importCountry(fields, imp, stats)
+		case FileRegions:
+			err = importRegion(fields, imp, stats)
+		default:
+			err = importPlace(fields, imp, stats)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// importCountry stores a countryInfo.txt row
+func importCountry(fields []string, imp *store.PlaceImport, stats *ImportStats) error {
+	if len(fields) < 17 {
+		return fmt.Errorf("expected at least 17 columns, got %d", len(fields))
+	}
+	c := &store.Country{
+		Code:         fields[0],
+		ISO3:         fields[1],
+		Name:         fields[4],
+		Capital:      fields[5],
+		Continent:    fields[8],
+		CurrencyCode: fields[10],
+		Population:   parseInt(fields[7]),
+		GeonameID:    parseInt(fields[16]),
+	}
+	if err := imp.AddCountry(c, normalizeAll(c.Name, c.Code, c.ISO3)); err != nil {
+		return err
+	}
+	stats.Countries++
+	return nil
+}
+
+// importRegion stores an admin1CodesASCII.txt row
+func importRegion(fields []string, imp *store.PlaceImport, stats *ImportStats) error {
+	if len(fields) < 4 {
+		return fmt.Errorf("expected 4 columns, got %d", len(fields))
+	}
+	r := &store.Region{Code: fields[0], Name: fields[1], GeonameID: parseInt(fields[3])}
+	if err := imp.AddRegion(r, normalizeAll(r.Name, fields[2])); err != nil {
+		return err
+	}
+	stats.Regions++
+	return nil
+}
+
+// importPlace stores a main dump row that is a city, a first-level region
+// or a country; other features are skipped
+func importPlace(fields []string, imp *store.PlaceImport, stats *ImportStats) error {
+	if len(fields) < 19 {
+		return fmt.Errorf("expected 19 columns, got %d", len(fields))
+	}
+	featureClass, featureCode := fields[6], fields[7]
+	var kind string
+	switch {
+	case featureClass == "P":
+		kind = store.PlaceCity
+	case featureClass == "A" && featureCode == "ADM1":
+		kind = store.PlaceRegion
+	case featureClass == "A" && strings.HasPrefix(featureCode, "PCL"):
+		kind = store.PlaceCountry
+	default:
+		stats.Skipped++
+		return nil
+	}
+
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid geonameid %q", fields[0])
+	}
+	lat, errLat := strconv.ParseFloat(fields[4], 64)
+	lon, errLon := strconv.ParseFloat(fields[5], 64)
+	if errLat != nil || errLon != nil {
+		return fmt.Errorf("invalid coordinates %q, %q", fields[4], fields[5])
+	}
+
+	p := &store.Place{
+		ID:          id,
+		Name:        fields[1],
+		Kind:        kind,
+		CountryCode: fields[8],
+		Admin1Code:  fields[10],
+		Latitude:    &lat,
+		Longitude:   &lon,
+		Population:  parseInt(fields[14]),
+		TimeZone:    fields[17],
+	}
+	if p.TimeZone == "" {
+		p.TimeZone = timezone.Lookup(geo.Point{Lat: lat, Lon: lon})
+	}
+	if kind == store.PlaceCountry {
+		// Countries carry the placeholder admin1 code 00
+		p.Admin1Code = ""
+	}
+	names := append([]string{fields[1], fields[2]}, strings.Split(fields[3], ",")...)
+	if err := imp.AddPlace(p, normalizeAll(names...)); err != nil {
+		return err
+	}
+	stats.Places++
+	return nil
+}
+
+// parseInt parses an optional integer column, treating blanks and garbage as 0
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return n
+}
@@ -0,0 +1,242 @@
+package places
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/store"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Search limits
+const (
+	DefaultLimit = 10
+	MaxLimit     = 50
+
+	// candidateLimit caps the places scored per lookup
+	candidateLimit = 500
+)
+
+// Match scores, before the population bonus
+const (
+	exactScore  = 1.0
+	prefixScore = 0.7
+	fuzzyScore  = 0.5
+
+	// populationWeight scales log10(population + 1), so a city of ten
+	// million gains about 0.42 over an empty hamlet
+	populationWeight = 0.06
+)
+
+// Reverse geocoding radius in kilometres
+const (
+	DefaultRadiusKM = 50.0
+	MaxRadiusKM     = 500.0
+)
+
+// Result is a place matching a search, with its relevance
+type Result struct {
+	store.Place
+	Score float64 `json:"score" doc:"Relevance; higher is better"`
+}
+
+// Nearest is the place closest to a reverse geocoded point
+type Nearest struct {
+	store.Place
+	DistanceKM float64 `json:"distance_km"`
+}
+
+// Normalize folds a name for matching: accents are removed, letters are
+// lowercased and runs of anything but letters and digits become one space
+func Normalize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// normalizeAll returns the distinct non-empty normalized forms of names
+func normalizeAll(names ...string) []string {
+	seen := make(map[string]bool, len(names))
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		n := Normalize(name)
+		if n != "" && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Search finds places whose name or alternate name matches query exactly, as
+// a prefix or, when those are too few, within a small edit distance. Results
+// are ranked by match quality weighted by population.
+func Search(ctx context.Context, st *store.Store, query string, filter store.PlaceFilter, limit int) ([]Result, error) {
+	q := Normalize(query)
+	if q == "" {
+		return []Result{}, nil
+	}
+
+	candidates, err := st.PlacesByNamePrefix(ctx, q, filter, candidateLimit)
+	if err != nil {
+		return nil, err
+	}
+	scored := make(map[int64]Result, len(candidates))
+	for _, c := range candidates {
+		if s, ok := matchScore(q, c.Names, 0); ok {
+			scored[c.ID] = Result{Place: c.Place, Score: s}
+		}
+	}
+
+	// Typos: compare against the names sharing the most trigrams with the query
+	if len(scored) < limit && len([]rune(q)) >= 3 {
+		candidates, err := st.PlacesByTrigrams(ctx, q, filter, candidateLimit)
+		if err != nil {
+			return nil, err
+		}
+		maxDistance := 1
+		if len([]rune(q)) > 5 {
+			maxDistance = 2
+		}
+		for _, c := range candidates {
+			if _, ok := scored[c.ID]; ok {
+				continue
+			}
+			if s, ok := matchScore(q, c.Names, maxDistance); ok {
+				scored[c.ID] = Result{Place: c.Place, Score: s}
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(scored))
+	for _, r := range scored {
+		r.Score = math.Round((r.Score+populationWeight*math.Log10(float64(r.Population)+1))*1000) / 1000
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// matchScore returns the best score of q against any of names, allowing up to
+// maxDistance edits for fuzzy matches
+func matchScore(q string, names []string, maxDistance int) (float64, bool) {
+	best, ok := 0.0, false
+	for _, name := range names {
+		var s float64
+		switch {
+		case name == q:
+			s = exactScore
+		case strings.HasPrefix(name, q):
+			s = prefixScore
+		case maxDistance > 0:
+			d := editDistance(q, name, maxDistance)
+			if d > maxDistance {
+				continue
+			}
+			s = fuzzyScore - 0.1*float64(d)
+		default:
+			continue
+		}
+		if s > best {
+			best, ok = s, true
+		}
+	}
+	return best, ok
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and adjacent transpositions turning a into b, or limit+1 once it is known
+// to exceed limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	before := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], before[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		before, prev, cur = prev, cur, before
+	}
+	return prev[len(rb)]
+}
+
+// Reverse returns the city closest to p within radiusKM, or store.ErrNotFound
+func Reverse(ctx context.Context, st *store.Store, p geo.Point, radiusKM float64) (*Nearest, error) {
+	dLat, dLon := geo.BoundingBox(p, radiusKM)
+	minLon, maxLon := p.Lon-dLon, p.Lon+dLon
+	if dLon >= 180 {
+		minLon, maxLon = -180, 180
+	} else {
+		// Wrap boxes crossing the antimeridian
+		if minLon < -180 {
+			minLon += 360
+		}
+		if maxLon > 180 {
+			maxLon -= 360
+		}
+	}
+
+	candidates, err := st.PlacesInBox(ctx, p.Lat-dLat, p.Lat+dLat, minLon, maxLon, store.PlaceCity)
+	if err != nil {
+		return nil, err
+	}
+	var nearest *Nearest
+	for _, c := range candidates {
+		d := geo.DistanceKM(p, geo.Point{Lat: *c.Latitude, Lon: *c.Longitude})
+		if d <= radiusKM && (nearest == nil || d < nearest.DistanceKM) {
+			nearest = &Nearest{Place: *c, DistanceKM: d}
+		}
+	}
+	if nearest == nil {
+		return nil, store.ErrNotFound
+	}
+	nearest.DistanceKM = math.Round(nearest.DistanceKM*100) / 100
+	return nearest, nil
+}
@@ -0,0 +1,293 @@
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"vibed-traveller/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"github.com/go-playground/validator/v10"
+)
+
+// ContentType is the media type of problem details responses (RFC 9457)
+const ContentType = "application/problem+json"
+
+// Stable machine-readable error codes
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidQuery         = "invalid_query"
+	CodeInvalidPatch         = "invalid_patch"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeForbidden            = "forbidden"
+	CodeUserDisabled         = "user_disabled"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeUnsupportedMedia     = "unsupported_media_type"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeInvalidFile          = "invalid_file"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal_error"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeServiceUnavailable   = "service_unavailable"
+	CodeAuth0Error           = "auth0_error"
+	CodeMissingCode          = "missing_authorization_code"
+	CodeTokenExchangeFailed  = "token_exchange_failed"
+)
+
+// statusCodes maps statuses without a more specific error to their default code
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusPreconditionRequired:  CodePreconditionRequired,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMedia,
+	http.StatusUnprocessableEntity:   CodeValidationFailed,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusBadGateway:            CodeUpstreamUnavailable,
+	http.StatusServiceUnavailable:    CodeServiceUnavailable,
+}
+
+// Problem is an RFC 9457 problem details error. Detail is shown to clients;
+// the wrapped cause is only logged (and shown in gin debug mode).
+type Problem struct {
+	Type      string       `json:"type" doc:"URI reference identifying the problem type"`
+	Title     string       `json:"title" doc:"Short summary of the problem type"`
+	Status    int          `json:"status" doc:"HTTP status code"`
+	Detail    string       `json:"detail,omitempty" doc:"Explanation specific to this occurrence"`
+	Instance  string       `json:"instance,omitempty" doc:"Request path"`
+	Code      string       `json:"code" doc:"Stable machine-readable error code"`
+	RequestID string       `json:"request_id,omitempty" doc:"X-Request-ID of the failed request"`
+	Errors    []FieldError `json:"errors,omitempty" doc:"Invalid fields, for validation_failed"`
+	Debug     string       `json:"debug,omitempty" doc:"Internal error, only in debug mode"`
+
+	cause error
+}
+
+// FieldError describes one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New creates a problem with a client-facing detail message
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "/problems/" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Wrap creates a problem caused by an internal error that is never shown to clients in release mode
+func Wrap(err error, status int, code, detail string) *Problem {
+	p := New(status, code, detail)
+	p.cause = err
+	return p
+}
+
+// Internal wraps an unexpected error as a 500 problem
+func Internal(err error) *Problem {
+	return Wrap(err, http.StatusInternalServerError, CodeInternal, "An unexpected error occurred")
+}
+
+// BadRequest creates a 400 problem
+func BadRequest(code, detail string) *Problem {
+	return New(http.StatusBadRequest, code, detail)
+}
+
+// NotFound creates a 404 problem
+func NotFound(detail string) *Problem {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// TooLarge creates a 413 problem for a request body over limit bytes
+func TooLarge(limit int64) *Problem {
+	return New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("Request bodies are limited to %d KB", limit>>10))
+}
+
+// Validation creates a 422 problem listing invalid fields
+func Validation(fields ...FieldError) *Problem {
+	p := New(http.StatusUnprocessableEntity, CodeValidationFailed, "The request contains invalid fields")
+	p.Errors = fields
+	return p
+}
+
+// Error implements the error interface
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", p.Code, p.Detail, p.cause)
+	}
+	return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+}
+
+// Unwrap returns the internal cause
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// From converts any error to a problem; errors that are not problems become 500s
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	return Internal(err)
+}
+
+// Abort records err on the context and aborts; Middleware renders it
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// Middleware renders the last error recorded on the context, or a bare
+// error status set without a body, as application/problem+json
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Writer.Written() {
+			return
+		}
+
+		if last := c.Errors.Last(); last != nil {
+			Render(c, From(last.Err))
+			return
+		}
+
+		if status := c.Writer.Status(); status >= http.StatusBadRequest {
+			code, ok := statusCodes[status]
+			if !ok {
+				code = CodeInternal
+			}
+			Render(c, New(status, code, ""))
+		}
+	}
+}
+
+// Render writes p as the response, filling in request-specific fields
+func Render(c *gin.Context, p *Problem) {
+	out := *p
+	out.RequestID = middleware.GetRequestID(c)
+	out.Instance = c.Request.URL.Path
+
+	if p.cause != nil {
+		level := slog.LevelWarn
+		if p.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "Request failed",
+			slog.String("error_code", p.Code),
+			slog.Int("status", p.Status),
+			slog.Any("error", p.cause),
+		)
+		if gin.Mode() == gin.DebugMode {
+			out.Debug = p.cause.Error()
+		}
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.Render(out.Status, render.JSON{Data: out})
+}
+
+// FromBindError converts a gin binding error into a 400 or a 422 with field
+// errors, or a 413 if the body went over its http.MaxBytesReader limit
+func FromBindError(err error) *Problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return TooLarge(tooLarge.Limit)
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return Wrap(err, http.StatusBadRequest, CodeInvalidRequest, "The request body could not be parsed")
+	}
+
+	fields := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Code:    fe.Tag(),
+			Message: validationMessage(fe),
+		})
+	}
+	return Validation(fields...)
+}
+
+// fieldPath drops the struct name from a validator namespace (Trip.name -> name)
+func fieldPath(namespace string) string {
+	if _, rest, ok := strings.Cut(namespace, "."); ok {
+		return rest
+	}
+	return namespace
+}
+
+// validationMessage describes a failed validation rule
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return "must be at most " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "unique":
+		return "must not repeat values"
+	case "datetime":
+		return "must be a date in the format " + fe.Param()
+	case "iso4217":
+		return "must be an ISO 4217 currency code"
+	case "iso3166_1_alpha2":
+		return "must be an ISO 3166-1 alpha-2 country code such as FR"
+	case "nationality":
+		return "must be an ISO 3166-1 alpha-2 country code, or * for every nationality"
+	case "timezone":
+		return "must be an IANA time zone such as Europe/Paris"
+	case "bcp47_language_tag":
+		return "must be a BCP 47 language tag such as en-GB"
+	case "http_url":
+		return "must be an absolute http or https URL"
+	default:
+		return "failed the " + fe.Tag() + " rule"
+	}
+}
+
+func init() {
+	// Report JSON field names rather than Go field names in validation errors
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return f.Name
+			}
+			return name
+		})
+		// Entry rules name a passport's country, or * for all of them
+		v.RegisterAlias("nationality", "eq=*|iso3166_1_alpha2")
+	}
+}
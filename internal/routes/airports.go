@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"vibed-traveller/internal/airports"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/places"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Airport search limits
+const (
+	defaultAirportLimit = 10
+	maxAirportLimit     = 50
+)
+
+// FlightInput names the airports and airline of a flight item by code
+type FlightInput struct {
+	Airline          string `json:"airline" binding:"omitempty,min=2,max=3,alphanum" doc:"IATA or ICAO airline code"`
+	Number           string `json:"number" binding:"omitempty,max=10,alphanum" doc:"Flight number"`
+	DepartureAirport string `json:"departure_airport" binding:"required,min=3,max=4,alphanum" doc:"IATA or ICAO airport code"`
+	ArrivalAirport   string `json:"arrival_airport" binding:"required,min=3,max=4,alphanum" doc:"IATA or ICAO airport code"`
+}
+
+// newFlightInput returns the input that would recreate flight details
+func newFlightInput(f *store.Flight) *FlightInput {
+	if f == nil {
+		return nil
+	}
+	in := &FlightInput{
+		Number:           f.Number,
+		DepartureAirport: f.Departure.Code(),
+		ArrivalAirport:   f.Arrival.Code(),
+	}
+	if f.Airline != nil {
+		in.Airline = f.Airline.Code()
+	}
+	return in
+}
+
+// AirportResults is the response of an airport search
+type AirportResults struct {
+	Items []*store.Airport `json:"items"`
+}
+
+// resolveFlight looks up the airports and airline named by a flight item
+// input, keeping the details for apply to store
+func (api *tripAPI) resolveFlight(ctx context.Context, in *ItemInput) error {
+	in.flight = nil
+	if in.Flight == nil {
+		return nil
+	}
+	if in.Kind != "flight" {
+		return problem.Validation(problem.FieldError{Field: "flight", Code: "excluded_unless", Message: "is only allowed on flight items"})
+	}
+
+	var (
+		fields   []problem.FieldError
+		resolved [2]*store.Airport
+	)
+	for i, code := range []struct{ field, value string }{
+		{"flight.departure_airport", in.Flight.DepartureAirport},
+		{"flight.arrival_airport", in.Flight.ArrivalAirport},
+	} {
+		airport, err := api.store.GetAirport(ctx, code.value)
+		if errors.Is(err, store.ErrNotFound) {
+			fields = append(fields, problem.FieldError{Field: code.field, Code: "unknown_airport", Message: "is not a known airport code"})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resolved[i] = airport
+	}
+	var airline *store.Airline
+	if in.Flight.Airline != "" {
+		var err error
+		airline, err = api.store.GetAirline(ctx, in.Flight.Airline)
+		if errors.Is(err, store.ErrNotFound) {
+			fields = append(fields, problem.FieldError{Field: "flight.airline", Code: "unknown_airline", Message: "is not a known airline code"})
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(fields) > 0 {
+		return problem.Validation(fields...)
+	}
+
+	in.flight = airports.NewFlight(resolved[0], resolved[1], airline, strings.ToUpper(in.Flight.Number))
+	return nil
+}
+
+// airportAPI serves the airport reference database
+type airportAPI struct {
+	store *store.Store
+}
+
+// airportEndpoints lists the airport endpoints
+func airportEndpoints(st *store.Store) []apiEndpoint {
+	api := &airportAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/airports/search",
+			Handler:  api.search,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "searchAirports",
+					Summary:     "Search airports by code, name or city",
+					Description: "Exact IATA or ICAO code matches come first, then airports whose name, city or " +
+						"keywords contain words starting with every word of q; airports with scheduled service and larger airports rank higher.",
+					Tags: []string{"airports"},
+					Parameters: []openapi.Parameter{
+						{Name: "q", In: "query", Required: true, Description: "Code, or words of the name or city", Schema: &openapi.Schema{Type: "string"}},
+						{Name: "country", In: "query", Description: "ISO 3166-1 alpha-2 country code", Schema: &openapi.Schema{Type: "string"}},
+						{Name: "limit", In: "query", Description: fmt.Sprintf("Maximum results (default %d)", defaultAirportLimit),
+							Schema: &openapi.Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(maxAirportLimit))}},
+					},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         openapi.JSON("Matching airports, best first", doc.Schema(AirportResults{})),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/airports/:code",
+			Handler:  api.get,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "getAirport",
+					Summary:     "Get an airport by IATA or ICAO code",
+					Tags:        []string{"airports"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):       openapi.JSON("Airport", doc.Schema(store.Airport{})),
+						openapi.Status(http.StatusNotFound): problemResponse(doc, "Unknown airport code"),
+					},
+				}
+			},
+		},
+	}
+}
+
+// search finds airports matching the q parameter
+func (api *airportAPI) search(c *gin.Context) {
+	query := c.Request.URL.Query()
+	var errs []problem.FieldError
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		errs = append(errs, problem.FieldError{Field: "q", Code: "required", Message: "is required"})
+	}
+	limit, err := queryLimit(query.Get("limit"), defaultAirportLimit, maxAirportLimit)
+	if err != nil {
+		errs = append(errs, problem.FieldError{Field: "limit", Code: "range", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		problem.Abort(c, invalidQuery(errs))
+		return
+	}
+
+	results, err := api.store.SearchAirports(c.Request.Context(), q, places.Normalize(q), query.Get("country"), limit)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, AirportResults{Items: results})
+}
+
+// get returns the airport named by :code
+func (api *airportAPI) get(c *gin.Context) {
+	airport, err := api.store.GetAirport(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Unknown airport code"))
+		return
+	}
+	c.JSON(http.StatusOK, airport)
+}
@@ -0,0 +1,204 @@
+package routes
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/middleware"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API versions. Each is served under /api/<version>; the bare /api prefix
+// is an alias of CurrentAPIVersion for backward compatibility.
+const (
+	APIVersion1 = "v1"
+	APIVersion2 = "v2"
+
+	CurrentAPIVersion = APIVersion1
+)
+
+// apiVersions lists every served API version, oldest first
+var apiVersions = []string{APIVersion1, APIVersion2}
+
+// allVersions is used by endpoints that are identical in every version
+var allVersions = apiVersions
+
+// apiEndpoint is a protected endpoint served under one or more API versions
+type apiEndpoint struct {
+	Method string
+
+	// Path is relative to the version prefix, e.g. /profile
+	Path string
+
+	Handler gin.HandlerFunc
+
+	// Versions the endpoint is served under
+	Versions []string
+
+	// Deprecated maps versions in which the endpoint is deprecated to the deprecation details
+	Deprecated map[string]middleware.Deprecation
+
+	// Doc describes the endpoint in the OpenAPI document
+	Doc func(doc *openapi.Document) openapi.Operation
+}
+
+// apiEndpoints lists the protected endpoints. st and files may be nil when only documenting.
+func apiEndpoints(cfg *config.Config, st *store.Store, files *attachments.Service) []apiEndpoint {
+	endpoints := []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/profile",
+			Handler:  getUserProfile,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "getProfile",
+					Summary:     "Profile of the authenticated user",
+					Tags:        []string{"users"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK): openapi.JSON("User profile", doc.Schema(config.User{})),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/me",
+			Handler:  getMe,
+			Versions: []string{APIVersion1},
+			Deprecated: map[string]middleware.Deprecation{
+				APIVersion1: {
+					Since:     time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+					Sunset:    time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+					Successor: "/api/" + APIVersion1 + "/profile",
+				},
+			},
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "getMe",
+					Summary:     "Show the authenticated user",
+					Description: "Deprecated in favour of /profile; removed in v2.",
+					Tags:        []string{"users"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK): openapi.JSON("Authenticated user", doc.Schema(MeResponse{})),
+					},
+				}
+			},
+		},
+	}
+	endpoints = append(endpoints, preferenceEndpoints(st)...)
+	endpoints = append(endpoints, auditEndpoints(st)...)
+	endpoints = append(endpoints, webhookEndpoints(st)...)
+	endpoints = append(endpoints, documentEndpoints(st)...)
+	endpoints = append(endpoints, entryRuleEndpoints(st)...)
+	endpoints = append(endpoints, placeEndpoints(st)...)
+	endpoints = append(endpoints, airportEndpoints(st)...)
+	endpoints = append(endpoints, tripEndpoints(st)...)
+	endpoints = append(endpoints, attachmentEndpoints(st, files)...)
+	endpoints = append(endpoints, journalEndpoints(st)...)
+	return append(endpoints, trackEndpoints(st)...)
+}
+
+// versionPrefixes returns the route prefixes serving a version: /api/<version>,
+// plus the bare /api alias for the current version
+func versionPrefixes(version string) []string {
+	prefixes := []string{"/api/" + version}
+	if version == CurrentAPIVersion {
+		prefixes = append(prefixes, "/api")
+	}
+	return prefixes
+}
+
+// setupAPI registers every protected endpoint under each of its version prefixes
+func setupAPI(router *gin.Engine, endpoints []apiEndpoint, auth ...gin.HandlerFunc) {
+	for _, version := range apiVersions {
+		for _, prefix := range versionPrefixes(version) {
+			group := router.Group(prefix)
+			group.Use(auth...)
+			group.Use(apiVersionHeader(version))
+
+			for _, ep := range endpoints {
+				if !slices.Contains(ep.Versions, version) {
+					continue
+				}
+				handlers := []gin.HandlerFunc{ep.Handler}
+				if d, ok := ep.Deprecated[version]; ok {
+					handlers = []gin.HandlerFunc{middleware.DeprecationMiddleware(d, clientID), ep.Handler}
+				}
+				group.Handle(ep.Method, ep.Path, handlers...)
+			}
+		}
+	}
+}
+
+// documentAPI adds every protected endpoint under each of its version prefixes
+func documentAPI(doc *openapi.Document, endpoints []apiEndpoint) {
+	for _, version := range apiVersions {
+		for _, prefix := range versionPrefixes(version) {
+			for _, ep := range endpoints {
+				if !slices.Contains(ep.Versions, version) {
+					continue
+				}
+
+				op := ep.Doc(doc)
+				op.Security = authenticated
+				op.Tags = append(op.Tags, version)
+				if prefix != "/api" {
+					op.OperationID = version + "_" + op.OperationID
+				}
+				if _, ok := ep.Deprecated[version]; ok {
+					op.Deprecated = true
+				}
+				if _, ok := op.Responses[openapi.Status(http.StatusTemporaryRedirect)]; !ok {
+					op.Responses[openapi.Status(http.StatusTemporaryRedirect)] = openapi.Redirect("Not authenticated; redirects to the Auth0 login page")
+				}
+				if _, ok := op.Responses[openapi.Status(http.StatusForbidden)]; !ok {
+					op.Responses[openapi.Status(http.StatusForbidden)] = problemResponse(doc, "User account is disabled")
+				}
+				if ep.Method == http.MethodPost {
+					op.Parameters = append(op.Parameters, openapi.Parameter{
+						Name:        IdempotencyKeyHeader,
+						In:          "header",
+						Description: "Unique key making the request safe to retry; the first response is replayed to retries",
+						Schema:      &openapi.Schema{Type: "string", MaxLength: maxIdempotencyKeyLength},
+					})
+					op.Responses[openapi.Status(http.StatusConflict)] = problemResponse(doc, "A request with this Idempotency-Key is still in progress")
+					if _, ok := op.Responses[openapi.Status(http.StatusUnprocessableEntity)]; !ok {
+						op.Responses[openapi.Status(http.StatusUnprocessableEntity)] = problemResponse(doc, "Idempotency-Key reused for a different request")
+					}
+				}
+				if _, ok := op.Responses[openapi.Status(http.StatusInternalServerError)]; !ok {
+					op.Responses[openapi.Status(http.StatusInternalServerError)] = problemResponse(doc, "Unexpected error")
+				}
+
+				doc.Add(ep.Method, prefix+ep.Path, op)
+			}
+		}
+	}
+}
+
+// APIVersionHeader reports the API version that served a request
+const APIVersionHeader = "API-Version"
+
+// apiVersionHeader sets the API-Version response header
+func apiVersionHeader(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(APIVersionHeader, version)
+		c.Next()
+	}
+}
+
+// clientID identifies the caller for deprecation usage logs: the user if
+// authenticated, otherwise the client IP
+func clientID(c *gin.Context) string {
+	if user := config.GetUserFromContext(c); user != nil {
+		return "user:" + user.ID
+	}
+	return "ip:" + c.ClientIP()
+}
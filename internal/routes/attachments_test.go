@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+)
+
+func TestCheckLinkable(t *testing.T) {
+	for contentType, linkable := range map[string]bool{
+		"image/jpeg":      true,
+		"image/webp":      true,
+		"image/gif":       true,
+		"application/pdf": true,
+		"image/bmp":       false,
+		"image/tiff":      false,
+		"image/svg+xml":   false,
+	} {
+		err := checkLinkable(&store.Attachment{ContentType: contentType})
+		var p *problem.Problem
+		switch {
+		case linkable && err != nil:
+			t.Errorf("%s: checkLinkable = %v", contentType, err)
+		case !linkable && (!errors.As(err, &p) || p.Status != http.StatusUnprocessableEntity):
+			t.Errorf("%s: checkLinkable = %v, want a 422 problem", contentType, err)
+		}
+	}
+}
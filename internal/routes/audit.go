@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/middleware"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONLinesContentType is the media type of audit log exports
+const JSONLinesContentType = "application/jsonl"
+
+// userRecordKey is the gin context key holding the stored record of the authenticated user
+const userRecordKey = "user_record"
+
+// recordAudit appends an event describing the current request to the audit
+// log. before and after are the record before and after the change; either may
+// be nil. The change has already happened, so failures are logged, not returned.
+func recordAudit(c *gin.Context, st *store.Store, action, targetType, targetID string, before, after any) {
+	event := &store.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		RequestID:  middleware.GetRequestID(c),
+	}
+	if user := config.GetUserFromContext(c); user != nil {
+		event.ActorID = user.ID
+	}
+	if before != nil || after != nil {
+		changes, err := store.AuditDiff(before, after)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to diff audited record", slog.String("action", action), slog.Any("error", err))
+		}
+		event.Changes = changes
+	}
+	recordEvent(c, st, event)
+}
+
+// recordEvent stores a prepared audit event, logging failures
+func recordEvent(c *gin.Context, st *store.Store, event *store.AuditEvent) {
+	if err := st.RecordAudit(c.Request.Context(), event); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record audit event", slog.String("action", event.Action), slog.Any("error", err))
+	}
+}
+
+// auditAuthFailures records tokens rejected by config.AuthMiddleware, which runs after it
+func auditAuthFailures(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if reason := c.GetString(config.AuthFailureKey); reason != "" {
+			recordEvent(c, st, &store.AuditEvent{
+				Action:    store.AuditTokenRejected,
+				Detail:    reason,
+				IP:        c.ClientIP(),
+				UserAgent: c.Request.UserAgent(),
+				RequestID: middleware.GetRequestID(c),
+			})
+		}
+	}
+}
+
+// adminOnly refuses the request unless the authenticated user is an admin
+func adminOnly(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		record, _ := c.Get(userRecordKey)
+		if user, ok := record.(*store.User); !ok || user.Role != store.RoleAdmin {
+			problem.Abort(c, problem.New(http.StatusForbidden, problem.CodeForbidden, "Administrator role required"))
+			return
+		}
+		handler(c)
+	}
+}
+
+// auditAPI serves the audit log to administrators
+type auditAPI struct {
+	store *store.Store
+}
+
+// auditEndpoints lists the audit log endpoints
+func auditEndpoints(st *store.Store) []apiEndpoint {
+	api := &auditAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/admin/audit",
+			Handler:  adminOnly(api.list),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listAuditEvents",
+					Summary:     "List audit log events (admins only)",
+					Tags:        []string{"admin"},
+					Parameters:  listParameters(store.AuditListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Audit events", listquery.Page[*store.AuditEvent]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/admin/audit/export",
+			Handler:  adminOnly(api.export),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "exportAuditEvents",
+					Summary:     "Export audit log events as JSON Lines (admins only)",
+					Description: "Accepts the filters and sort of listAuditEvents and streams every matching event, one JSON object per line.",
+					Tags:        []string{"admin"},
+					Parameters: slices.DeleteFunc(listParameters(store.AuditListSpec), func(p openapi.Parameter) bool {
+						return p.Name == listquery.LimitParam || p.Name == listquery.CursorParam
+					}),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK): {
+							Description: "One audit event per line",
+							Content:     map[string]openapi.MediaType{JSONLinesContentType: {Schema: doc.Schema(store.AuditEvent{})}},
+						},
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+	}
+}
+
+// list returns one page of audit events
+func (api *auditAPI) list(c *gin.Context) {
+	q, err := listquery.Parse(c.Request.URL.Query(), store.AuditListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	events, next, err := api.store.ListAudit(c.Request.Context(), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, events, next)
+}
+
+// export streams every matching audit event as JSON Lines
+func (api *auditAPI) export(c *gin.Context) {
+	values := c.Request.URL.Query()
+	values.Del(listquery.LimitParam)
+	values.Del(listquery.CursorParam)
+	q, err := listquery.Parse(values, store.AuditListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+
+	c.Header("Content-Type", JSONLinesContentType)
+	c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	err = api.store.ExportAudit(c.Request.Context(), q, func(e *store.AuditEvent) error {
+		return enc.Encode(e)
+	})
+	if err != nil {
+		// Headers are gone; the truncated body is all the client will see
+		slog.ErrorContext(c.Request.Context(), "Audit export failed", slog.Any("error", err))
+	}
+}
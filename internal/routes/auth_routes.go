@@ -1,17 +1,24 @@
 package routes
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"time"
+
+	"vibed-traveller/internal/attachments"
 	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/middleware"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupAuthRoutes configures authenticated routes
-func SetupAuthRoutes(router *gin.Engine, cfg *config.Config) {
+func SetupAuthRoutes(router *gin.Engine, cfg *config.Config, st *store.Store, files *attachments.Service) {
 	// Only setup routes if Auth0 is properly configured
 	if !cfg.IsAuth0Configured() {
 		panic("Auth configuration is not configured")
@@ -34,11 +41,14 @@ func SetupAuthRoutes(router *gin.Engine, cfg *config.Config) {
 
 		// Callback endpoint - handles Auth0 response
 		auth.GET("/callback", func(c *gin.Context) {
-			handleAuth0Callback(c, cfg)
+			handleAuth0Callback(c, cfg, st)
 		})
 
 		// Logout endpoint
 		auth.GET("/logout", func(c *gin.Context) {
+			token, _ := config.GetAuthTokenFromCookie(c)
+			recordSessionEvent(c, cfg, st, store.AuditLogout, token)
+
 			// Clear the auth token cookie
 			config.ClearAuthTokenCookie(c)
 
@@ -52,40 +62,66 @@ func SetupAuthRoutes(router *gin.Engine, cfg *config.Config) {
 		})
 	}
 
-	// Protected routes group
-	protected := router.Group("/api")
-	protected.Use(config.AuthMiddleware(cfg))
-	{
-		// User profile endpoint
-		protected.GET("/profile", getUserProfile)
-
-		// Test endpoint to show current user
-		protected.GET("/me", func(c *gin.Context) {
-			user := config.GetUserFromContext(c)
-			c.JSON(http.StatusOK, gin.H{
-				"message": "You are authenticated!",
-				"user":    user,
-			})
-		})
+	// Protected routes, served under every API version
+	setupAPI(router, apiEndpoints(cfg, st, files), auditAuthFailures(st), config.AuthMiddleware(cfg), userAccessMiddleware(st), idempotencyMiddleware(st))
+}
+
+// userAccessMiddleware records the authenticated user and refuses disabled
+// accounts and tokens issued before the user's sessions were revoked
+func userAccessMiddleware(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := config.GetUserFromContext(c)
+		if user == nil {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "User not authenticated"))
+			return
+		}
+
+		// Without an issue time a token can't be checked against revocations
+		issuedAt := config.GetTokenIssuedAt(c)
+		if issuedAt.IsZero() {
+			c.Set(config.AuthFailureKey, "token has no issue time")
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "Token has no issue time"))
+			return
+		}
+
+		record, err := st.RecordUser(c.Request.Context(), user.ID, user.Email, user.Username, user.Metadata)
+		if err != nil {
+			problem.Abort(c, problem.Internal(fmt.Errorf("recording user: %w", err)))
+			return
+		}
+
+		if record.Disabled {
+			slog.WarnContext(c.Request.Context(), "Disabled user refused", slog.String("user_id", user.ID))
+			problem.Abort(c, problem.New(http.StatusForbidden, problem.CodeUserDisabled, "User account is disabled"))
+			return
+		}
+		c.Set(userRecordKey, record)
+
+		// Issue times have whole seconds, so tokens issued during the second
+		// of the revocation are kept
+		if record.SessionsRevokedAt != nil && issuedAt.Before(record.SessionsRevokedAt.Truncate(time.Second)) {
+			slog.InfoContext(c.Request.Context(), "Revoked session refused", slog.String("user_id", user.ID))
+			config.ClearAuthTokenCookie(c)
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "Session was revoked, sign in again"))
+			return
+		}
+
+		c.Next()
 	}
 }
 
 // handleAuth0Callback handles the Auth0 callback response
-func handleAuth0Callback(c *gin.Context, cfg *config.Config) {
+func handleAuth0Callback(c *gin.Context, cfg *config.Config, st *store.Store) {
 	// Check for errors
 	if err := c.Query("error"); err != "" {
-		errorDescription := c.Query("error_description")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":             err,
-			"error_description": errorDescription,
-		})
+		problem.Abort(c, problem.BadRequest(problem.CodeAuth0Error, fmt.Sprintf("%s: %s", err, c.Query("error_description"))))
 		return
 	}
 
 	// Get the authorization code
 	code := c.Query("code")
 	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
+		problem.Abort(c, problem.BadRequest(problem.CodeMissingCode, "Authorization code not provided"))
 		return
 	}
 
@@ -100,8 +136,7 @@ func handleAuth0Callback(c *gin.Context, cfg *config.Config) {
 	// Exchange the authorization code for an access token
 	tokenResponse, err := config.ExchangeCodeForToken(cfg, code)
 	if err != nil {
-		slog.ErrorContext(c.Request.Context(), "Failed to exchange code for token", slog.Any("error", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code for token", "details": err.Error()})
+		problem.Abort(c, problem.Wrap(err, http.StatusBadGateway, problem.CodeTokenExchangeFailed, "Failed to exchange code for token"))
 		return
 	}
 
@@ -110,25 +145,51 @@ func handleAuth0Callback(c *gin.Context, cfg *config.Config) {
 	// Extract the access token
 	accessToken, ok := tokenResponse["access_token"].(string)
 	if !ok {
-		slog.ErrorContext(c.Request.Context(), "Access token not found in response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Access token not found in response"})
+		problem.Abort(c, problem.Wrap(errors.New("access token not found in response"), http.StatusBadGateway, problem.CodeTokenExchangeFailed, "Failed to exchange code for token"))
 		return
 	}
 
 	config.SetAuthTokenCookie(c, accessToken)
 	slog.InfoContext(c.Request.Context(), "Token ready and cookie set")
+	recordSessionEvent(c, cfg, st, store.AuditLogin, accessToken)
 
 	// Redirect to the return URL
 	c.Redirect(http.StatusTemporaryRedirect, returnURL)
 }
 
+// getMe shows the current user
+func getMe(c *gin.Context) {
+	user := config.GetUserFromContext(c)
+	c.JSON(http.StatusOK, MeResponse{
+		Message: "You are authenticated!",
+		User:    user,
+	})
+}
+
 // getUserProfile returns the current user's profile
 func getUserProfile(c *gin.Context) {
 	user := config.GetUserFromContext(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "User not authenticated"))
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
+
+// recordSessionEvent audits a login or logout. The user is taken from token
+// and left empty if it is missing or does not validate.
+func recordSessionEvent(c *gin.Context, cfg *config.Config, st *store.Store, action, token string) {
+	event := &store.AuditEvent{
+		Action:    action,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: middleware.GetRequestID(c),
+	}
+	if token != "" {
+		if subject, err := config.TokenSubject(c.Request.Context(), cfg, token); err == nil {
+			event.ActorID, event.TargetType, event.TargetID = subject, "user", subject
+		}
+	}
+	recordEvent(c, st, event)
+}
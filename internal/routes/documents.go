@@ -0,0 +1,445 @@
+package routes
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/vault"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDocumentScanMaxMB applies when no configuration is pinned to the request
+const defaultDocumentScanMaxMB = 10
+
+// scanContentTypes are the accepted formats of document scans, as sniffed from their content
+var scanContentTypes = []string{"image/jpeg", "image/png", "image/webp", "application/pdf"}
+
+// DocumentInput is the body of document create and replace requests
+type DocumentInput struct {
+	Type           string `json:"type" binding:"required,oneof=passport visa id_card insurance"`
+	Name           string `json:"name" binding:"max=200" doc:"Label telling documents of the same type apart"`
+	Number         string `json:"number" binding:"required,max=100" doc:"Document or policy number; stored encrypted"`
+	IssuingCountry string `json:"issuing_country" binding:"required,iso3166_1_alpha2"`
+	IssuedOn       string `json:"issued_on" binding:"omitempty,datetime=2006-01-02"`
+	ExpiresOn      string `json:"expires_on" binding:"omitempty,datetime=2006-01-02"`
+	Notes          string `json:"notes" binding:"max=5000" doc:"Stored encrypted"`
+}
+
+// newDocumentInput returns the input that would recreate a document
+func newDocumentInput(d *store.Document) *DocumentInput {
+	return &DocumentInput{
+		Type:           d.Type,
+		Name:           d.Name,
+		Number:         d.Number,
+		IssuingCountry: d.IssuingCountry,
+		IssuedOn:       d.IssuedOn,
+		ExpiresOn:      d.ExpiresOn,
+		Notes:          d.Notes,
+	}
+}
+
+// validate checks rules spanning several fields
+func (in *DocumentInput) validate() error {
+	if in.IssuedOn != "" && in.ExpiresOn != "" && in.ExpiresOn < in.IssuedOn {
+		return problem.Validation(problem.FieldError{Field: "expires_on", Code: "gtefield", Message: "must not be before issued_on"})
+	}
+	return nil
+}
+
+// apply copies the input onto a document
+func (in *DocumentInput) apply(d *store.Document) {
+	d.Type = in.Type
+	d.Name = in.Name
+	d.Number = in.Number
+	d.IssuingCountry = in.IssuingCountry
+	d.IssuedOn = in.IssuedOn
+	d.ExpiresOn = in.ExpiresOn
+	d.Notes = in.Notes
+}
+
+// auditedDocument returns a copy of a document without its encrypted
+// fields, which are kept out of the audit log
+func auditedDocument(d *store.Document) *store.Document {
+	copied := *d
+	copied.Number, copied.Notes = "", ""
+	return &copied
+}
+
+// vaultAPI serves the authenticated user's travel documents
+type vaultAPI struct {
+	store *store.Store
+}
+
+// documentEndpoints lists the travel document endpoints
+func documentEndpoints(st *store.Store) []apiEndpoint {
+	api := &vaultAPI{store: st}
+	endpoints := []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/documents",
+			Handler:  api.list,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listDocuments",
+					Summary:     "List the user's travel documents",
+					Tags:        []string{"documents"},
+					Parameters:  listParameters(store.DocumentListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of documents", listquery.Page[*store.Document]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/documents",
+			Handler:  api.create,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createDocument", "Add a travel document", "documents", DocumentInput{}, store.Document{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/documents/:id",
+			Handler:  api.get,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getDocument", "Get a travel document", "documents", store.Document{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/documents/:id",
+			Handler:  api.replace,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replaceDocument", "Replace a travel document, keeping its scan", "documents", DocumentInput{}, store.Document{})
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/documents/:id",
+			Handler:  api.patch,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return patchOperation(doc, "patchDocument", "Update some fields of a travel document", "documents", DocumentInput{}, store.Document{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/documents/:id",
+			Handler:  api.delete,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return deleteOperation(doc, "deleteDocument", "Delete a travel document and its scan", "documents")
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/documents/:id/scan",
+			Handler:  api.putScan,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				file := &openapi.Schema{Type: "string", Format: "binary"}
+				content := map[string]openapi.MediaType{
+					"multipart/form-data": {Schema: &openapi.Schema{
+						Type:       "object",
+						Properties: map[string]*openapi.Schema{"file": file},
+						Required:   []string{"file"},
+					}},
+				}
+				for _, contentType := range scanContentTypes {
+					content[contentType] = openapi.MediaType{Schema: file}
+				}
+				return openapi.Operation{
+					OperationID: "putDocumentScan",
+					Summary:     "Upload the scan of a travel document",
+					Description: "Replaces the document's scan, a JPEG, PNG, WebP or PDF file sent as the request body " +
+						"or as the file field of a multipart form. The scan is stored encrypted.",
+					Tags:        []string{"documents"},
+					RequestBody: &openapi.RequestBody{Required: true, Content: content},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):                    withETag(openapi.JSON("Document with the new scan", doc.Schema(store.Document{}))),
+						openapi.Status(http.StatusNotFound):              problemResponse(doc, "Document not found"),
+						openapi.Status(http.StatusRequestEntityTooLarge): problemResponse(doc, "File larger than DOCUMENT_SCAN_MAX_MB"),
+						openapi.Status(http.StatusUnsupportedMediaType):  problemResponse(doc, "Not a JPEG, PNG, WebP or PDF file"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/documents/:id/scan",
+			Handler:  api.getScan,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				content := map[string]openapi.MediaType{}
+				for _, contentType := range scanContentTypes {
+					content[contentType] = openapi.MediaType{Schema: &openapi.Schema{Type: "string", Format: "binary"}}
+				}
+				return openapi.Operation{
+					OperationID: "getDocumentScan",
+					Summary:     "Download the scan of a travel document",
+					Tags:        []string{"documents"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):       {Description: "The scan", Content: content},
+						openapi.Status(http.StatusNotFound): problemResponse(doc, "Document or scan not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/documents/:id/scan",
+			Handler:  api.deleteScan,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "deleteDocumentScan",
+					Summary:     "Delete the scan of a travel document",
+					Tags:        []string{"documents"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusNoContent): {Description: "Deleted"},
+						openapi.Status(http.StatusNotFound):  problemResponse(doc, "Document or scan not found"),
+					},
+				}
+			},
+		},
+	}
+
+	// Every document endpoint needs the encryption keys
+	for i := range endpoints {
+		describe := endpoints[i].Doc
+		endpoints[i].Doc = func(doc *openapi.Document) openapi.Operation {
+			op := describe(doc)
+			op.Responses[openapi.Status(http.StatusServiceUnavailable)] = problemResponse(doc, "DOCUMENT_KEYS is not configured")
+			return op
+		}
+	}
+	return endpoints
+}
+
+// list lists the user's documents, soonest to expire first by default
+func (api *vaultAPI) list(c *gin.Context) {
+	kr, ok := documentKeyring(c)
+	if !ok {
+		return
+	}
+	q, err := listquery.Parse(c.Request.URL.Query(), store.DocumentListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	documents, next, err := api.store.ListDocuments(c.Request.Context(), kr, userID(c), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, documents, next)
+}
+
+// create adds a document of the user
+func (api *vaultAPI) create(c *gin.Context) {
+	kr, ok := documentKeyring(c)
+	if !ok {
+		return
+	}
+	var in DocumentInput
+	if !bindInput(c, &in, in.validate) {
+		return
+	}
+
+	document := &store.Document{OwnerID: userID(c)}
+	in.apply(document)
+	if err := api.store.CreateDocument(c.Request.Context(), kr, document); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditDocumentCreate, "document", document.ID, nil, auditedDocument(document))
+	created(c, document.ID, document.Version, document)
+}
+
+// get returns one of the user's documents
+func (api *vaultAPI) get(c *gin.Context) {
+	document, _, ok := api.load(c)
+	if !ok {
+		return
+	}
+	respond(c, document.Version, document)
+}
+
+// replace replaces a document
+func (api *vaultAPI) replace(c *gin.Context) {
+	document, kr, ok := api.load(c)
+	if !ok || !checkIfMatch(c, document.Version) {
+		return
+	}
+	var in DocumentInput
+	if !bindInput(c, &in, in.validate) {
+		return
+	}
+	api.save(c, kr, document, &in)
+}
+
+// patch applies a merge patch or JSON Patch to a document
+func (api *vaultAPI) patch(c *gin.Context) {
+	document, kr, ok := api.load(c)
+	if !ok || !checkIfMatch(c, document.Version) {
+		return
+	}
+	var in DocumentInput
+	if !patchInput(c, newDocumentInput(document), &in, in.validate) {
+		return
+	}
+	api.save(c, kr, document, &in)
+}
+
+// save applies a validated input to a document and stores it
+func (api *vaultAPI) save(c *gin.Context, kr *vault.Keyring, document *store.Document, in *DocumentInput) {
+	before := auditedDocument(document)
+	in.apply(document)
+	if err := api.store.UpdateDocument(c.Request.Context(), kr, document); err != nil {
+		problem.Abort(c, storeError(err, "Document not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditDocumentUpdate, "document", document.ID, before, auditedDocument(document))
+	respond(c, document.Version, document)
+}
+
+// delete deletes a document and its scan
+func (api *vaultAPI) delete(c *gin.Context) {
+	document, _, ok := api.load(c)
+	if !ok || !checkIfMatch(c, document.Version) {
+		return
+	}
+	if err := api.store.DeleteDocument(c.Request.Context(), document.ID, document.Version); err != nil {
+		problem.Abort(c, storeError(err, "Document not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditDocumentDelete, "document", document.ID, auditedDocument(document), nil)
+	c.Status(http.StatusNoContent)
+}
+
+// putScan stores the scan of a document, replacing any previous one
+func (api *vaultAPI) putScan(c *gin.Context) {
+	document, kr, ok := api.load(c)
+	if !ok {
+		return
+	}
+	maxMB := defaultDocumentScanMaxMB
+	if cfg := config.FromContext(c); cfg != nil {
+		maxMB = cfg.DocumentScanMaxMB
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxMB)<<20)
+
+	file, err := uploadedFile(c, body)
+	if err != nil {
+		problem.Abort(c, uploadError(err, maxMB))
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		problem.Abort(c, uploadError(err, maxMB))
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(scanContentTypes, contentType) {
+		problem.Abort(c, problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "Upload a JPEG, PNG, WebP or PDF file"))
+		return
+	}
+
+	before := auditedDocument(document)
+	if err := api.store.PutDocumentScan(c.Request.Context(), kr, document, contentType, data); err != nil {
+		problem.Abort(c, storeError(err, "Document not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditDocumentUpdate, "document", document.ID, before, auditedDocument(document))
+	respond(c, document.Version, document)
+}
+
+// getScan returns the decrypted scan of a document
+func (api *vaultAPI) getScan(c *gin.Context) {
+	document, kr, ok := api.load(c)
+	if !ok {
+		return
+	}
+	if document.Scan == nil {
+		problem.Abort(c, problem.NotFound("The document has no scan"))
+		return
+	}
+	data, err := api.store.DocumentScanData(c.Request.Context(), kr, document.ID)
+	if err != nil {
+		problem.Abort(c, storeError(err, "The document has no scan"))
+		return
+	}
+	// Scans are as sensitive as the documents themselves; keep them out of caches
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, document.Scan.ContentType, data)
+}
+
+// deleteScan deletes the scan of a document
+func (api *vaultAPI) deleteScan(c *gin.Context) {
+	document, _, ok := api.load(c)
+	if !ok {
+		return
+	}
+	if document.Scan == nil {
+		problem.Abort(c, problem.NotFound("The document has no scan"))
+		return
+	}
+	before := auditedDocument(document)
+	if err := api.store.DeleteDocumentScan(c.Request.Context(), document); err != nil {
+		problem.Abort(c, storeError(err, "Document not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditDocumentUpdate, "document", document.ID, before, auditedDocument(document))
+	c.Status(http.StatusNoContent)
+}
+
+// load loads the document named by :id, aborting with 404 unless it belongs
+// to the user. It also returns the keyring the document was decrypted with.
+func (api *vaultAPI) load(c *gin.Context) (*store.Document, *vault.Keyring, bool) {
+	kr, ok := documentKeyring(c)
+	if !ok {
+		return nil, nil, false
+	}
+	document, err := api.store.GetDocument(c.Request.Context(), kr, c.Param("id"))
+	if err == nil && document.OwnerID != userID(c) {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.Abort(c, storeError(err, "Document not found"))
+		return nil, nil, false
+	}
+	return document, kr, true
+}
+
+// documentKeyring returns the keys of DOCUMENT_KEYS, aborting with 503 if none are configured
+func documentKeyring(c *gin.Context) (*vault.Keyring, bool) {
+	var keys []string
+	if cfg := config.FromContext(c); cfg != nil {
+		keys = cfg.DocumentKeys
+	}
+	kr, err := vault.NewKeyring(keys)
+	if errors.Is(err, vault.ErrNoKeys) {
+		problem.Abort(c, problem.New(http.StatusServiceUnavailable, problem.CodeServiceUnavailable,
+			"The document vault is not configured"))
+		return nil, false
+	}
+	if err != nil {
+		problem.Abort(c, err)
+		return nil, false
+	}
+	return kr, true
+}
@@ -0,0 +1,206 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"vibed-traveller/internal/entryrules"
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/places"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EntryRequirements is the response of GET /trips/:id/entry-requirements
+type EntryRequirements struct {
+	Checks []*entryrules.Check `json:"checks"`
+}
+
+// entryRequirementsEndpoint describes GET /trips/:id/entry-requirements
+func (api *tripAPI) entryRequirementsEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/entry-requirements",
+		Handler:  api.getEntryRequirements,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getTripEntryRequirements",
+				Summary:     "Check each traveller of a trip against the entry requirements of each country it visits",
+				Description: "The countries are those of the airports flights arrive at and of items with coordinates, in the order " +
+					"they are visited; the country a trip starts with a flight from, and returns to, is home and not checked. " +
+					"The owner travels on the passports and visas in their document vault, companions on the passport given in the trip. " +
+					"Each check uses the rule that was in force on the day of entry.",
+				Tags: []string{"trips"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK):       openapi.JSON("One check per traveller and country visited", doc.Schema(EntryRequirements{})),
+					openapi.Status(http.StatusNotFound): problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// getEntryRequirements evaluates the entry requirements of a trip
+func (api *tripAPI) getEntryRequirements(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	items, err := api.store.AllItems(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	checks, err := api.entryChecks(c.Request.Context(), trip, items)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	if checks == nil {
+		checks = []*entryrules.Check{}
+	}
+	c.JSON(http.StatusOK, EntryRequirements{Checks: checks})
+}
+
+// entryChecks evaluates the travellers of a trip against the countries it visits
+func (api *tripAPI) entryChecks(ctx context.Context, trip *store.Trip, items []*store.ItineraryItem) ([]*entryrules.Check, error) {
+	visits, err := tripVisits(ctx, api.store, items)
+	if err != nil || len(visits) == 0 {
+		return nil, err
+	}
+	travellers, err := api.travellers(ctx, trip)
+	if err != nil {
+		return nil, err
+	}
+	return entryrules.Evaluate(ctx, api.store, travellers, visits)
+}
+
+// travellers returns the owner of a trip, with the passports and visas of
+// their document vault, followed by the companions listed in the trip
+func (api *tripAPI) travellers(ctx context.Context, trip *store.Trip) ([]entryrules.Traveller, error) {
+	owner, err := api.store.GetUser(ctx, trip.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	documents, err := api.store.DocumentsOfType(ctx, trip.OwnerID, store.DocumentPassport, store.DocumentVisa)
+	if err != nil {
+		return nil, err
+	}
+
+	self := entryrules.Traveller{Name: owner.Username}
+	if self.Name == "" {
+		self.Name = "The trip owner"
+	}
+	for _, d := range documents {
+		if d.Type == store.DocumentPassport {
+			self.Passports = append(self.Passports, entryrules.Passport{Nationality: d.IssuingCountry, ExpiresOn: d.ExpiresOn, DocumentID: d.ID})
+		} else {
+			self.Visas = append(self.Visas, entryrules.Visa{Country: d.IssuingCountry, ExpiresOn: d.ExpiresOn, DocumentID: d.ID})
+		}
+	}
+
+	travellers := []entryrules.Traveller{self}
+	for _, t := range trip.Travellers {
+		travellers = append(travellers, entryrules.Traveller{
+			Name:      t.Name,
+			Passports: []entryrules.Passport{{Nationality: t.Nationality, ExpiresOn: t.PassportExpiresOn}},
+		})
+	}
+	return travellers, nil
+}
+
+// countryStop is a day a trip is known to be in a country
+type countryStop struct {
+	country string
+	on      time.Time
+
+	// until is the last day of an item lasting several days
+	until time.Time
+
+	// departure and arrival mark the airports of flights
+	departure, arrival bool
+}
+
+// tripVisits returns the stays of a trip in each country, in order. The
+// countries are those of flight airports, and those items with coordinates
+// are reverse geocoded to; when a trip starts with a flight, the country it
+// leaves from, and returns to, is home.
+func tripVisits(ctx context.Context, st *store.Store, items []*store.ItineraryItem) ([]entryrules.Visit, error) {
+	var stops []countryStop
+	for _, it := range items {
+		if f := it.Flight; f != nil {
+			arrives := it.StartsAt
+			if it.EndsAt != nil {
+				arrives = *it.EndsAt
+			}
+			if f.Departure.CountryCode != "" {
+				day := localDate(it.StartsAt, f.Departure.TimeZone)
+				stops = append(stops, countryStop{country: f.Departure.CountryCode, on: day, until: day, departure: true})
+			}
+			if f.Arrival.CountryCode != "" {
+				day := localDate(arrives, f.Arrival.TimeZone)
+				stops = append(stops, countryStop{country: f.Arrival.CountryCode, on: day, until: day, arrival: true})
+			}
+			continue
+		}
+		if it.Latitude == nil || it.Longitude == nil {
+			continue
+		}
+		nearest, err := places.Reverse(ctx, st, geo.Point{Lat: *it.Latitude, Lon: *it.Longitude}, places.DefaultRadiusKM)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stop := countryStop{country: nearest.CountryCode, on: localDay(it)}
+		stop.until = stop.on
+		if it.EndsAt != nil {
+			stop.until = localDate(*it.EndsAt, it.TimeZone)
+		}
+		stops = append(stops, stop)
+	}
+	if len(stops) == 0 {
+		return nil, nil
+	}
+
+	type visit struct {
+		entryrules.Visit
+		// arrivalOnly is set while the visit is only the arrival of a flight
+		arrivalOnly bool
+	}
+	var visits []visit
+	for _, s := range stops {
+		if n := len(visits); n > 0 && visits[n-1].Country == s.country {
+			v := &visits[n-1]
+			if s.until.After(v.LeavesOn) {
+				v.LeavesOn = s.until
+			}
+			v.arrivalOnly = false
+			continue
+		}
+		visits = append(visits, visit{
+			Visit:       entryrules.Visit{Country: s.country, EntersOn: s.on, LeavesOn: s.until},
+			arrivalOnly: s.arrival,
+		})
+	}
+
+	if stops[0].departure {
+		home := visits[0].Country
+		visits = visits[1:]
+		if n := len(visits); n > 0 && visits[n-1].Country == home && visits[n-1].arrivalOnly {
+			visits = visits[:n-1]
+		}
+	}
+	result := make([]entryrules.Visit, len(visits))
+	for i, v := range visits {
+		result[i] = v.Visit
+	}
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"vibed-traveller/internal/entryrules"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// entryRuleUploadMaxMB limits the size of rule files
+const entryRuleUploadMaxMB = 10
+
+// EntryRuleInput is the body of entry rule create requests
+type EntryRuleInput struct {
+	Nationality            string `json:"nationality" binding:"required,nationality" doc:"ISO 3166-1 alpha-2 code of the passport, or * for passports without a rule of their own"`
+	Destination            string `json:"destination" binding:"required,iso3166_1_alpha2"`
+	Requirement            string `json:"requirement" binding:"required,oneof=visa_free visa_on_arrival e_visa visa_required"`
+	MaxStayDays            *int   `json:"max_stay_days" binding:"omitempty,min=1" doc:"Longest stay allowed per entry; unlimited when absent"`
+	PassportValidityMonths int    `json:"passport_validity_months" binding:"min=0,max=120" doc:"How long the passport must remain valid after leaving"`
+	EffectiveFrom          string `json:"effective_from" binding:"required,datetime=2006-01-02"`
+	EffectiveUntil         string `json:"effective_until" binding:"omitempty,datetime=2006-01-02" doc:"First day the rule is no longer in force; set automatically when a later rule is added"`
+	Notes                  string `json:"notes" binding:"max=2000"`
+}
+
+// validate checks rules spanning several fields
+func (in *EntryRuleInput) validate() error {
+	if in.EffectiveUntil != "" && in.EffectiveUntil <= in.EffectiveFrom {
+		return problem.Validation(problem.FieldError{Field: "effective_until", Code: "gtfield", Message: "must be after effective_from"})
+	}
+	return nil
+}
+
+// entryRuleAPI serves the entry requirement rules to administrators
+type entryRuleAPI struct {
+	store *store.Store
+}
+
+// entryRuleEndpoints lists the entry rule administration endpoints
+func entryRuleEndpoints(st *store.Store) []apiEndpoint {
+	api := &entryRuleAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/admin/entry-rules",
+			Handler:  adminOnly(api.list),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listEntryRules",
+					Summary:     "List entry requirement rules, past and current (admins only)",
+					Tags:        []string{"admin"},
+					Parameters:  listParameters(store.EntryRuleListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of rules", listquery.Page[*store.EntryRule]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/admin/entry-rules",
+			Handler:  adminOnly(api.create),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "createEntryRule",
+					Summary:     "Add an entry requirement rule (admins only)",
+					Description: "Rules are not edited: to change the requirements, add a rule taking effect on the day they change. " +
+						"It ends the current rule of the same nationality and destination, which still applies to trips entering before that day.",
+					Tags:        []string{"admin"},
+					RequestBody: openapi.JSONBody(doc.Schema(EntryRuleInput{})),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusCreated):             openapi.JSON("Created", doc.Schema(store.EntryRule{})),
+						openapi.Status(http.StatusBadRequest):          problemResponse(doc, "Malformed request body"),
+						openapi.Status(http.StatusConflict):            problemResponse(doc, "The rule overlaps another of the same nationality and destination"),
+						openapi.Status(http.StatusUnprocessableEntity): problemResponse(doc, "Invalid fields"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/admin/entry-rules/import",
+			Handler:  adminOnly(api.importRules),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				file := &openapi.Schema{Type: "string", Format: "binary"}
+				return openapi.Operation{
+					OperationID: "importEntryRules",
+					Summary:     "Import entry requirement rules from a CSV file (admins only)",
+					Description: "The header row names the columns nationality, destination, requirement and effective_from, and optionally " +
+						"max_stay_days, passport_validity_months, effective_until and notes. A rule replaces the one of the same nationality, " +
+						"destination and effective_from; the file is imported entirely or not at all.",
+					Tags: []string{"admin"},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]openapi.MediaType{
+							"text/csv": {Schema: file},
+							"multipart/form-data": {Schema: &openapi.Schema{
+								Type:       "object",
+								Properties: map[string]*openapi.Schema{"file": file},
+								Required:   []string{"file"},
+							}},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):                    openapi.JSON("Number of rules created and replaced", doc.Schema(entryrules.ImportStats{})),
+						openapi.Status(http.StatusRequestEntityTooLarge): problemResponse(doc, "File larger than 10 MB"),
+						openapi.Status(http.StatusUnprocessableEntity):   problemResponse(doc, "Invalid row or overlapping rules"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/admin/entry-rules/:id",
+			Handler:  adminOnly(api.get),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "getEntryRule",
+					Summary:     "Get an entry requirement rule (admins only)",
+					Tags:        []string{"admin"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):       openapi.JSON("Found", doc.Schema(store.EntryRule{})),
+						openapi.Status(http.StatusNotFound): problemResponse(doc, "Rule not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/admin/entry-rules/:id",
+			Handler:  adminOnly(api.delete),
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "deleteEntryRule",
+					Summary:     "Delete an entry requirement rule (admins only)",
+					Description: "The rule it ended, if any, is in force again until the day the deleted rule was.",
+					Tags:        []string{"admin"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusNoContent): {Description: "Deleted"},
+						openapi.Status(http.StatusNotFound):  problemResponse(doc, "Rule not found"),
+					},
+				}
+			},
+		},
+	}
+}
+
+// list returns one page of rules
+func (api *entryRuleAPI) list(c *gin.Context) {
+	q, err := listquery.Parse(c.Request.URL.Query(), store.EntryRuleListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	rules, next, err := api.store.ListEntryRules(c.Request.Context(), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, rules, next)
+}
+
+// create adds a rule
+func (api *entryRuleAPI) create(c *gin.Context) {
+	var in EntryRuleInput
+	if !bindInput(c, &in, in.validate) {
+		return
+	}
+	rule := &store.EntryRule{
+		Nationality:            in.Nationality,
+		Destination:            in.Destination,
+		Requirement:            in.Requirement,
+		MaxStayDays:            in.MaxStayDays,
+		PassportValidityMonths: in.PassportValidityMonths,
+		EffectiveFrom:          in.EffectiveFrom,
+		EffectiveUntil:         in.EffectiveUntil,
+		Notes:                  in.Notes,
+	}
+	if err := api.store.CreateEntryRule(c.Request.Context(), rule); err != nil {
+		if errors.Is(err, store.ErrEntryRuleOverlap) {
+			err = problem.New(http.StatusConflict, problem.CodeConflict, err.Error())
+		}
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditEntryRuleCreate, "entry_rule", rule.ID, nil, rule)
+	c.Header("Location", c.Request.URL.Path+"/"+rule.ID)
+	c.JSON(http.StatusCreated, rule)
+}
+
+// importRules imports a CSV file of rules
+func (api *entryRuleAPI) importRules(c *gin.Context) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, entryRuleUploadMaxMB<<20)
+	file, err := uploadedFile(c, body)
+	if err != nil {
+		problem.Abort(c, uploadError(err, entryRuleUploadMaxMB))
+		return
+	}
+	stats, err := entryrules.Import(c.Request.Context(), api.store, file)
+	if err != nil {
+		var invalid *entryrules.FormatError
+		if errors.As(err, &invalid) {
+			err = problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidFile, "Invalid file: "+invalid.Error())
+		}
+		problem.Abort(c, uploadError(err, entryRuleUploadMaxMB))
+		return
+	}
+	recordAudit(c, api.store, store.AuditEntryRuleImport, "entry_rule", "", nil, stats)
+	c.JSON(http.StatusOK, stats)
+}
+
+// get returns a rule
+func (api *entryRuleAPI) get(c *gin.Context) {
+	rule, err := api.store.GetEntryRule(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Entry rule not found"))
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// delete deletes a rule
+func (api *entryRuleAPI) delete(c *gin.Context) {
+	rule, err := api.store.GetEntryRule(c.Request.Context(), c.Param("id"))
+	if err == nil {
+		err = api.store.DeleteEntryRule(c.Request.Context(), rule.ID)
+	}
+	if err != nil {
+		problem.Abort(c, storeError(err, "Entry rule not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditEntryRuleDelete, "entry_rule", rule.ID, rule, nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,225 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// IdempotencyKeyHeader makes a POST request safe to retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader marks a response replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength bounds the stored key size
+	maxIdempotencyKeyLength = 255
+
+	// defaultIdempotencyKeyTTL applies when no configuration is pinned to the request
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+// replayedHeaders are the response headers stored and replayed with the body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Link"}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware honours Idempotency-Key on POST requests. The first
+// response for a user and key is stored for IDEMPOTENCY_KEY_TTL and replayed
+// byte-for-byte to retries; a retry while the first request is still running
+// gets 409, and reusing a key for a different request gets 422. Requests that
+// fail with a problem or a 5xx release the key so they can be retried.
+func idempotencyMiddleware(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			problem.Abort(c, problem.BadRequest(problem.CodeInvalidRequest, "Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		// The body is hashed before the handler runs, so it is read here, up
+		// to the size of the largest upload, and spooled for the handler
+		maxMB := maxIdempotentBodyMB(config.FromContext(c))
+		body, hash, err := spoolRequest(c.Request, http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxMB)<<20))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Abort(c, problem.New(http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge,
+					fmt.Sprintf("Requests are limited to %d MB", maxMB)))
+				return
+			}
+			problem.Abort(c, problem.Wrap(err, http.StatusBadRequest, problem.CodeInvalidRequest, "The request body could not be read"))
+			return
+		}
+		defer body.Close()
+		c.Request.Body = body
+
+		ttl := defaultIdempotencyKeyTTL
+		if cfg := config.FromContext(c); cfg != nil {
+			ttl = cfg.IdempotencyKeyTTL
+		}
+
+		ctx := c.Request.Context()
+		user := userID(c)
+		record, claimed, err := st.ClaimIdempotencyKey(ctx, user, key, hash, ttl)
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case record.RequestHash != hash:
+				problem.Abort(c, problem.New(http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused,
+					"This Idempotency-Key was already used for a different request"))
+			case record.Status == 0:
+				problem.Abort(c, problem.New(http.StatusConflict, problem.CodeIdempotencyKeyInUse,
+					"A request with this Idempotency-Key is still in progress"))
+			default:
+				for name, values := range record.Header {
+					for _, v := range values {
+						c.Writer.Header().Add(name, v)
+					}
+				}
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Writer.WriteHeader(record.Status)
+				_, _ = c.Writer.Write(record.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// The key must be completed or released even if the handler panics
+		completed := false
+		defer func() {
+			if !completed {
+				release(ctx, st, user, key)
+			}
+		}()
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if !w.Written() || w.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		header := map[string][]string{}
+		for _, name := range replayedHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+		if err := st.CompleteIdempotencyKey(context.WithoutCancel(ctx), user, key, w.Status(), header, w.body.Bytes()); err != nil {
+			slog.ErrorContext(ctx, "Failed to store idempotent response", slog.Any("error", err))
+			return
+		}
+		completed = true
+	}
+}
+
+// maxIdempotentBodyMB returns the size limit of the bodies read by
+// idempotencyMiddleware: that of the largest upload, which the upload
+// handlers enforce again for their own kind of file
+func maxIdempotentBodyMB(cfg *config.Config) int {
+	limits := []int{defaultAttachmentMaxMB, defaultDocumentScanMaxMB, defaultTrackUploadMaxMB}
+	if cfg != nil {
+		limits = []int{cfg.AttachmentMaxMB, cfg.DocumentScanMaxMB, cfg.TrackUploadMaxMB}
+	}
+	return max(slices.Max(limits), entryRuleUploadMaxMB)
+}
+
+// release frees a claimed key, even if the request context was cancelled
+func release(ctx context.Context, st *store.Store, user, key string) {
+	if err := st.ReleaseIdempotencyKey(context.WithoutCancel(ctx), user, key); err != nil {
+		slog.ErrorContext(ctx, "Failed to release idempotency key", slog.Any("error", err))
+	}
+}
+
+// spooledBody is a request body read ahead of its handler: held in memory
+// up to maxJSONBodySize, and in a temporary file removed on Close beyond that
+type spooledBody struct {
+	io.ReadSeeker
+	file *os.File
+}
+
+func (b *spooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// spoolRequest reads the body of r from body, returning a copy to hand to the
+// handler and a fingerprint of the request, so a key cannot be reused for
+// another one
+func spoolRequest(r *http.Request, body io.Reader) (*spooledBody, string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+
+	var head bytes.Buffer
+	n, err := io.Copy(io.MultiWriter(&head, h), io.LimitReader(body, maxJSONBodySize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if n <= maxJSONBodySize {
+		return &spooledBody{ReadSeeker: bytes.NewReader(head.Bytes())}, hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	// Uploads go to disk rather than being held in memory
+	tmp, err := os.CreateTemp("", "request-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("buffering request body: %w", err)
+	}
+	spooled := &spooledBody{ReadSeeker: tmp, file: tmp}
+	if _, err := head.WriteTo(tmp); err != nil {
+		spooled.Close()
+		return nil, "", fmt.Errorf("buffering request body: %w", err)
+	}
+	if _, err := io.Copy(io.MultiWriter(tmp, h), body); err != nil {
+		spooled.Close()
+		return nil, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, "", fmt.Errorf("buffering request body: %w", err)
+	}
+	return spooled, hex.EncodeToString(h.Sum(nil)), nil
+}
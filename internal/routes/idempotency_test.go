@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSpoolRequest(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		size   int
+		onDisk bool
+	}{
+		{"empty", 0, false},
+		{"json sized", maxJSONBodySize, false},
+		{"upload", 3*maxJSONBodySize + 7, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("0123456789"), tt.size/10+1)[:tt.size]
+			r := httptest.NewRequest(http.MethodPost, "/api/trips/1/tracks", nil)
+
+			body, hash, err := spoolRequest(r, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (body.file != nil) != tt.onDisk {
+				t.Errorf("spooled to disk: %t, want %t", body.file != nil, tt.onDisk)
+			}
+			want := sha256.Sum256(append([]byte("POST /api/trips/1/tracks\n"), data...))
+			if hash != hex.EncodeToString(want[:]) {
+				t.Error("hash does not cover the method, path and whole body")
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("handler reads %d bytes, want %d", len(got), len(data))
+			}
+
+			if err := body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if body.file != nil {
+				if _, err := os.Stat(body.file.Name()); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("temporary file left behind: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSpoolRequestTooLarge(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	limited := http.MaxBytesReader(w, io.NopCloser(bytes.NewReader(make([]byte, 2*maxJSONBodySize))), maxJSONBodySize+10)
+
+	_, _, err := spoolRequest(r, limited)
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("spoolRequest = %v, want *http.MaxBytesError", err)
+	}
+}
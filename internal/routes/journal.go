@@ -0,0 +1,414 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/journal"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JournalEntryInput is the body of journal entry create and replace requests
+type JournalEntryInput struct {
+	Day        string   `json:"day" binding:"required,datetime=2006-01-02" doc:"Within the trip's dates, when it has them"`
+	Title      string   `json:"title" binding:"max=200"`
+	Body       string   `json:"body" binding:"max=50000" doc:"Markdown"`
+	Mood       string   `json:"mood" binding:"omitempty,oneof=great good okay tired bad"`
+	Weather    string   `json:"weather" binding:"omitempty,oneof=sunny partly_cloudy cloudy rainy stormy snowy windy foggy"`
+	ItemIDs    []string `json:"item_ids" binding:"max=50,unique,dive,required" doc:"Itinerary items of the trip"`
+	PhotoIDs   []string `json:"photo_ids" binding:"max=50,unique,dive,required" doc:"Photo attachments of the trip or its items"`
+	Visibility string   `json:"visibility" binding:"omitempty,oneof=private shared" doc:"Defaults to private"`
+}
+
+// newJournalEntryInput returns the input that would recreate a journal entry
+func newJournalEntryInput(e *store.JournalEntry) *JournalEntryInput {
+	return &JournalEntryInput{
+		Day:        e.Day,
+		Title:      e.Title,
+		Body:       e.Body,
+		Mood:       e.Mood,
+		Weather:    e.Weather,
+		ItemIDs:    e.ItemIDs,
+		PhotoIDs:   e.PhotoIDs,
+		Visibility: e.Visibility,
+	}
+}
+
+// apply copies the input onto a journal entry
+func (in *JournalEntryInput) apply(e *store.JournalEntry) {
+	e.Day = in.Day
+	e.Title = in.Title
+	e.Body = in.Body
+	e.Mood = in.Mood
+	e.Weather = in.Weather
+	e.ItemIDs = in.ItemIDs
+	e.PhotoIDs = in.PhotoIDs
+	e.Visibility = in.Visibility
+	if e.Visibility == "" {
+		e.Visibility = store.VisibilityPrivate
+	}
+}
+
+// journalValidator returns the validation of a journal entry of trip: its day
+// falls within the trip and it links to the trip's own items and photos
+func (api *tripAPI) journalValidator(c *gin.Context, trip *store.Trip, in *JournalEntryInput) func() error {
+	return func() error {
+		var fields []problem.FieldError
+		if (trip.StartDate != "" && in.Day < trip.StartDate) || (trip.EndDate != "" && in.Day > trip.EndDate) {
+			fields = append(fields, problem.FieldError{Field: "day", Code: "trip_dates", Message: "must be within the dates of the trip"})
+		}
+		for i, id := range in.ItemIDs {
+			if _, err := api.store.GetItem(c.Request.Context(), trip.ID, id); err != nil {
+				if !errors.Is(err, store.ErrNotFound) {
+					return err
+				}
+				fields = append(fields, problem.FieldError{Field: fmt.Sprintf("item_ids[%d]", i), Code: "not_found",
+					Message: "is not an itinerary item of the trip"})
+			}
+		}
+		for i, id := range in.PhotoIDs {
+			a, err := api.store.GetAttachment(c.Request.Context(), trip.ID, id)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+			if a == nil || a.Photo == nil {
+				fields = append(fields, problem.FieldError{Field: fmt.Sprintf("photo_ids[%d]", i), Code: "not_found",
+					Message: "is not a photo attached to the trip"})
+			}
+		}
+		if len(fields) > 0 {
+			return problem.Validation(fields...)
+		}
+		return nil
+	}
+}
+
+// auditedJournalEntry returns a copy of a journal entry without its text,
+// which is kept out of the audit log
+func auditedJournalEntry(e *store.JournalEntry) *store.JournalEntry {
+	copied := *e
+	copied.Body, copied.BodyHTML = "", ""
+	return &copied
+}
+
+// journalEndpoints lists the journal endpoints of trips
+func journalEndpoints(st *store.Store) []apiEndpoint {
+	api := &tripAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/journal",
+			Handler:  api.listJournalEntries,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listJournalEntries",
+					Summary:     "List the journal entries of a trip",
+					Description: "Lists the user's own entries and the shared entries of others, by day by default.",
+					Tags:        []string{"journal"},
+					Parameters:  listParameters(store.JournalListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of journal entries", listquery.Page[*store.JournalEntry]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+						openapi.Status(http.StatusNotFound):   problemResponse(doc, "Trip not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/trips/:id/journal",
+			Handler:  api.createJournalEntry,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createJournalEntry", "Write a journal entry about a day of a trip", "journal",
+					JournalEntryInput{}, store.JournalEntry{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/journal/:entry_id",
+			Handler:  api.getJournalEntry,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getJournalEntry", "Get a journal entry", "journal", store.JournalEntry{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/trips/:id/journal/:entry_id",
+			Handler:  api.replaceJournalEntry,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				op := replaceOperation(doc, "replaceJournalEntry", "Replace a journal entry", "journal", JournalEntryInput{}, store.JournalEntry{})
+				op.Responses[openapi.Status(http.StatusForbidden)] = problemResponse(doc, "Entry written by another user")
+				return op
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/trips/:id/journal/:entry_id",
+			Handler:  api.patchJournalEntry,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				op := patchOperation(doc, "patchJournalEntry", "Update fields of a journal entry", "journal", JournalEntryInput{}, store.JournalEntry{})
+				op.Responses[openapi.Status(http.StatusForbidden)] = problemResponse(doc, "Entry written by another user")
+				return op
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id/journal/:entry_id",
+			Handler:  api.deleteJournalEntry,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				op := deleteOperation(doc, "deleteJournalEntry", "Delete a journal entry", "journal")
+				op.Responses[openapi.Status(http.StatusForbidden)] = problemResponse(doc, "Entry written by another user")
+				return op
+			},
+		},
+		api.journalExportEndpoint("md", "Markdown", journal.MarkdownContentType, (*journal.Export).WriteMarkdown),
+		api.journalExportEndpoint("html", "HTML", journal.HTMLContentType, (*journal.Export).WriteHTML),
+	}
+}
+
+// journalExportEndpoint describes GET /trips/:id/journal.<ext>, writing a
+// trip's journal as a single document
+func (api *tripAPI) journalExportEndpoint(ext, format, contentType string, write func(*journal.Export, io.Writer) error) apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/journal." + ext,
+		Handler:  api.exportJournal(ext, contentType, write),
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "exportJournal" + format,
+				Summary:     "Export the journal of a trip as " + format,
+				Description: "Writes the journal entries the user can see as one document meant for printing, with a " +
+					"section per day. Linked photos are shown through signed links valid for ATTACHMENT_URL_TTL " +
+					"when ATTACHMENT_URL_KEY is configured, and named otherwise.",
+				Tags: []string{"journal"},
+				Parameters: []openapi.Parameter{{
+					Name:        "visibility",
+					In:          "query",
+					Description: "shared leaves the user's private entries out; defaults to all",
+					Schema:      &openapi.Schema{Type: "string", Enum: []any{"all", store.VisibilityShared}},
+				}},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK): {
+						Description: format + " document",
+						Content:     map[string]openapi.MediaType{contentType: {Schema: &openapi.Schema{Type: "string"}}},
+					},
+					openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid visibility"),
+					openapi.Status(http.StatusNotFound):   problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// listJournalEntries lists the journal entries of a trip the user can see
+func (api *tripAPI) listJournalEntries(c *gin.Context) {
+	trip, ok := api.loadJournalTrip(c)
+	if !ok {
+		return
+	}
+	q, err := listquery.Parse(c.Request.URL.Query(), store.JournalListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	entries, next, err := api.store.ListJournalEntries(c.Request.Context(), trip.ID, userID(c), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, entries, next)
+}
+
+// createJournalEntry writes a journal entry on a trip
+func (api *tripAPI) createJournalEntry(c *gin.Context) {
+	trip, ok := api.loadJournalTrip(c)
+	if !ok {
+		return
+	}
+	var in JournalEntryInput
+	if !bindInput(c, &in, api.journalValidator(c, trip, &in)) {
+		return
+	}
+
+	entry := &store.JournalEntry{TripID: trip.ID, AuthorID: userID(c)}
+	in.apply(entry)
+	if err := api.store.CreateJournalEntry(c.Request.Context(), entry); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditJournalCreate, "journal_entry", entry.ID, nil, auditedJournalEntry(entry))
+	created(c, entry.ID, entry.Version, entry)
+}
+
+// getJournalEntry returns a journal entry of a trip
+func (api *tripAPI) getJournalEntry(c *gin.Context) {
+	_, entry, ok := api.loadJournalEntry(c)
+	if !ok {
+		return
+	}
+	respond(c, entry.Version, entry)
+}
+
+// replaceJournalEntry replaces a journal entry
+func (api *tripAPI) replaceJournalEntry(c *gin.Context) {
+	trip, entry, ok := api.loadOwnJournalEntry(c)
+	if !ok || !checkIfMatch(c, entry.Version) {
+		return
+	}
+	var in JournalEntryInput
+	if !bindInput(c, &in, api.journalValidator(c, trip, &in)) {
+		return
+	}
+	api.saveJournalEntry(c, entry, &in)
+}
+
+// patchJournalEntry applies a merge patch or JSON Patch to a journal entry
+func (api *tripAPI) patchJournalEntry(c *gin.Context) {
+	trip, entry, ok := api.loadOwnJournalEntry(c)
+	if !ok || !checkIfMatch(c, entry.Version) {
+		return
+	}
+	var in JournalEntryInput
+	if !patchInput(c, newJournalEntryInput(entry), &in, api.journalValidator(c, trip, &in)) {
+		return
+	}
+	api.saveJournalEntry(c, entry, &in)
+}
+
+// saveJournalEntry applies validated input to a journal entry and saves it
+func (api *tripAPI) saveJournalEntry(c *gin.Context, entry *store.JournalEntry, in *JournalEntryInput) {
+	before := auditedJournalEntry(entry)
+	in.apply(entry)
+	if err := api.store.UpdateJournalEntry(c.Request.Context(), entry); err != nil {
+		problem.Abort(c, storeError(err, "Journal entry not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditJournalUpdate, "journal_entry", entry.ID, before, auditedJournalEntry(entry))
+	respond(c, entry.Version, entry)
+}
+
+// deleteJournalEntry deletes a journal entry
+func (api *tripAPI) deleteJournalEntry(c *gin.Context) {
+	_, entry, ok := api.loadOwnJournalEntry(c)
+	if !ok || !checkIfMatch(c, entry.Version) {
+		return
+	}
+	if err := api.store.DeleteJournalEntry(c.Request.Context(), entry.ID, entry.Version); err != nil {
+		problem.Abort(c, storeError(err, "Journal entry not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditJournalDelete, "journal_entry", entry.ID, auditedJournalEntry(entry), nil)
+	c.Status(http.StatusNoContent)
+}
+
+// exportJournal returns a handler writing a trip's journal in a format
+func (api *tripAPI) exportJournal(ext, contentType string, write func(*journal.Export, io.Writer) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trip, ok := api.loadJournalTrip(c)
+		if !ok {
+			return
+		}
+		visibility := c.DefaultQuery("visibility", "all")
+		if visibility != "all" && visibility != store.VisibilityShared {
+			problem.Abort(c, invalidQuery([]problem.FieldError{{Field: "visibility", Code: "oneof", Message: "must be all or shared"}}))
+			return
+		}
+
+		export, err := journal.NewExport(c.Request.Context(), api.store, trip, userID(c), visibility == store.VisibilityShared,
+			journalPhotoURL(config.FromContext(c)))
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := write(export, &buf); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		disposition := "attachment"
+		if ext == "html" {
+			// Shown by browsers, ready to print; the page needs no scripts
+			disposition = "inline"
+			c.Header("Content-Security-Policy", "default-src 'none'; img-src http: https:; style-src 'unsafe-inline'")
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`%s; filename="journal-%s.%s"`, disposition, trip.ID, ext))
+		// Photo links are signed and expire
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, contentType, buf.Bytes())
+	}
+}
+
+// journalPhotoURL returns a function signing links to the large thumbnails
+// of photos, or to the photos themselves when they have none. The links are
+// empty when download links are not configured.
+func journalPhotoURL(cfg *config.Config) func(*store.Attachment) string {
+	key, err := attachmentLinkKey(cfg)
+	if err != nil {
+		return func(*store.Attachment) string { return "" }
+	}
+	expires := time.Now().Add(cfg.AttachmentURLTTL).Truncate(time.Second).UTC()
+	return func(a *store.Attachment) string {
+		if a.Photo == nil || !a.Photo.Thumbnails {
+			return fileURL(cfg, a.ID, "", attachments.SignLink(key, a.ID, attachments.LinkFile, expires))
+		}
+		query := attachments.SignLink(key, a.ID, attachments.LinkThumbnail, expires)
+		query.Set("size", "large")
+		return fileURL(cfg, a.ID, "/thumbnail", query)
+	}
+}
+
+// loadJournalTrip loads the trip named by :id for the journal endpoints,
+// which all go through it. Trips are only open to their owner for now; once
+// they have members, they are let in here and the store's visibility scope
+// decides which entries each of them sees.
+func (api *tripAPI) loadJournalTrip(c *gin.Context) (*store.Trip, bool) {
+	return api.loadTrip(c)
+}
+
+// loadJournalEntry loads the journal entry named by :entry_id in the trip
+// named by :id, if the user can see it
+func (api *tripAPI) loadJournalEntry(c *gin.Context) (*store.Trip, *store.JournalEntry, bool) {
+	trip, ok := api.loadJournalTrip(c)
+	if !ok {
+		return nil, nil, false
+	}
+	entry, err := api.store.GetJournalEntry(c.Request.Context(), trip.ID, userID(c), c.Param("entry_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Journal entry not found"))
+		return nil, nil, false
+	}
+	return trip, entry, true
+}
+
+// loadOwnJournalEntry loads a journal entry like loadJournalEntry, aborting
+// with 403 unless the user wrote it
+func (api *tripAPI) loadOwnJournalEntry(c *gin.Context) (*store.Trip, *store.JournalEntry, bool) {
+	trip, entry, ok := api.loadJournalEntry(c)
+	if !ok {
+		return nil, nil, false
+	}
+	if entry.AuthorID != userID(c) {
+		problem.Abort(c, problem.New(http.StatusForbidden, problem.CodeForbidden, "Only the author of a journal entry can change it"))
+		return nil, nil, false
+	}
+	return trip, entry, true
+}
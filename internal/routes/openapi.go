@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"net/http"
+
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIDocsFeature is the feature flag that enables the API reference page
+const APIDocsFeature = "api_docs"
+
+// Security scheme names used in the OpenAPI document
+const (
+	cookieAuthScheme = "cookieAuth"
+	bearerAuthScheme = "bearerAuth"
+)
+
+// undocumentedRoutes are served by the router but are not part of the API
+var undocumentedRoutes = map[string]bool{
+	"GET /":                  true,
+	"GET /static/*filepath":  true,
+	"HEAD /static/*filepath": true,
+}
+
+// MeResponse is the body of GET /api/me
+type MeResponse struct {
+	Message string       `json:"message"`
+	User    *config.User `json:"user"`
+}
+
+// authenticated is the security requirement of protected operations
+var authenticated = []map[string][]string{
+	{cookieAuthScheme: {}},
+	{bearerAuthScheme: {}},
+}
+
+// APISpec describes every route registered by SetupRoutes and SetupAuthRoutes
+func APISpec(cfg *config.Config) *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "Vibed Traveller API",
+		Version:     "1.0.0",
+		Description: "Backend API of the Vibed Traveller app.",
+	})
+	doc.Servers = []openapi.Server{{URL: cfg.APIURL}}
+	doc.Tags = []openapi.Tag{
+		{Name: "system", Description: "Service status and metadata"},
+		{Name: "auth", Description: "Auth0 login flow"},
+		{Name: "users", Description: "The authenticated user"},
+		{Name: "trips", Description: "Trips owned by the authenticated user"},
+		{Name: "itinerary", Description: "Scheduled items of a trip"},
+		{Name: "expenses", Description: "Money spent on a trip"},
+		{Name: "tracks", Description: "GPS tracks, routes and waypoints of a trip, and GPX and KML exports"},
+		{Name: "attachments", Description: "Files attached to trips and itinerary items, photo galleries and signed download links"},
+		{Name: "journal", Description: "Journal entries about the days of a trip, in Markdown, and printable exports"},
+		{Name: "documents", Description: "Passports, visas, ID cards and insurance policies, encrypted at rest"},
+		{Name: "places", Description: "Offline geocoding over imported GeoNames data"},
+		{Name: "airports", Description: "Airport reference data from OurAirports"},
+		{Name: "webhooks", Description: "Signed HTTP callbacks on trip, item and expense changes"},
+		{Name: "admin", Description: "Administration, restricted to users with the admin role"},
+		{Name: APIVersion1, Description: "API version 1"},
+		{Name: APIVersion2, Description: "API version 2"},
+	}
+	doc.Components.SecuritySchemes[cookieAuthScheme] = &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "cookie",
+		Name:        config.AuthTokenCookieName,
+		Description: "Access token set by /auth/callback",
+	}
+	doc.Components.SecuritySchemes[bearerAuthScheme] = &openapi.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "Auth0 access token",
+	}
+
+	errorResponse := func(description string) *openapi.Response {
+		return problemResponse(doc, description)
+	}
+
+	// System
+	doc.Add(http.MethodGet, "/health", openapi.Operation{
+		OperationID: "getHealth",
+		Summary:     "Health check",
+		Tags:        []string{"system"},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK): openapi.JSON("Service is healthy", doc.Schema(HealthResponse{})),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/openapi.json", openapi.Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "This OpenAPI document",
+		Tags:        []string{"system"},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK): openapi.JSON("OpenAPI 3.1 document", &openapi.Schema{Type: "object"}),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/docs", openapi.Operation{
+		OperationID: "getAPIDocs",
+		Summary:     "API reference page",
+		Description: "Enabled with the " + APIDocsFeature + " feature flag.",
+		Tags:        []string{"system"},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK): {
+				Description: "HTML page",
+				Content:     map[string]openapi.MediaType{"text/html": {Schema: &openapi.Schema{Type: "string"}}},
+			},
+			openapi.Status(http.StatusNotFound): errorResponse("API docs are disabled"),
+		},
+	})
+
+	// Auth
+	doc.Add(http.MethodGet, "/auth/login", openapi.Operation{
+		OperationID: "login",
+		Summary:     "Start the Auth0 login flow",
+		Tags:        []string{"auth"},
+		Parameters: []openapi.Parameter{
+			{Name: "return_url", In: "query", Description: "Where to go after login (defaults to /)", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusTemporaryRedirect): openapi.Redirect("Redirect to Auth0"),
+		},
+	})
+	doc.Add(http.MethodGet, "/auth/callback", openapi.Operation{
+		OperationID: "loginCallback",
+		Summary:     "Complete the Auth0 login flow",
+		Description: "Exchanges the authorization code for a token, stores it in the auth cookie and redirects to the state URL.",
+		Tags:        []string{"auth"},
+		Parameters: []openapi.Parameter{
+			{Name: "code", In: "query", Description: "Authorization code", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "state", In: "query", Description: "Return URL", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "error", In: "query", Description: "Error reported by Auth0", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "error_description", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusTemporaryRedirect): openapi.Redirect("Logged in; redirect to the return URL"),
+			openapi.Status(http.StatusBadRequest):        errorResponse("Auth0 reported an error or no code was given"),
+			openapi.Status(http.StatusBadGateway):        errorResponse("Token exchange failed"),
+		},
+	})
+	doc.Add(http.MethodGet, "/auth/logout", openapi.Operation{
+		OperationID: "logout",
+		Summary:     "Clear the auth cookie and log out of Auth0",
+		Tags:        []string{"auth"},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusTemporaryRedirect): openapi.Redirect("Redirect to Auth0 logout"),
+		},
+	})
+
+	// Attachments
+	doc.Add(http.MethodGet, "/files/:id", openapi.Operation{
+		OperationID: "downloadAttachment",
+		Summary:     "Download an attachment with a signed link",
+		Description: "Needs no authentication: the link, created by POST /api/trips/{id}/attachments/{attachment_id}/link, " +
+			"is signed for one attachment and stops working when it expires, the attachment is deleted or its owner is disabled. " +
+			"Images are served without the GPS fields of their EXIF metadata, without XMP metadata and without data after the image.",
+		Tags: []string{"attachments"},
+		Parameters: []openapi.Parameter{
+			{Name: attachments.ExpiresParam, In: "query", Required: true, Description: "Unix time the link expires at", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: attachments.SignatureParam, In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK):                  attachmentContentResponse(),
+			openapi.Status(http.StatusForbidden):           errorResponse("Invalid or expired link"),
+			openapi.Status(http.StatusNotFound):            errorResponse("Attachment deleted"),
+			openapi.Status(http.StatusUnprocessableEntity): errorResponse("Image of a type whose location can't be removed"),
+			openapi.Status(http.StatusServiceUnavailable):  errorResponse("ATTACHMENT_URL_KEY is not configured"),
+		},
+	})
+	doc.Add(http.MethodGet, "/files/:id/thumbnail", openapi.Operation{
+		OperationID: "downloadThumbnail",
+		Summary:     "Download a thumbnail of a photo with a signed link",
+		Description: "Takes the same links as /files/{id}, as listed by GET /api/trips/{id}/photos.",
+		Tags:        []string{"attachments"},
+		Parameters: []openapi.Parameter{
+			thumbnailSizeParameter(),
+			{Name: attachments.ExpiresParam, In: "query", Required: true, Description: "Unix time the link expires at", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: attachments.SignatureParam, In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK):                 thumbnailResponse(),
+			openapi.Status(http.StatusBadRequest):         errorResponse("Invalid size"),
+			openapi.Status(http.StatusForbidden):          errorResponse("Invalid or expired link"),
+			openapi.Status(http.StatusNotFound):           errorResponse("Attachment deleted, or not a photo with thumbnails"),
+			openapi.Status(http.StatusServiceUnavailable): errorResponse("ATTACHMENT_URL_KEY is not configured"),
+		},
+	})
+
+	// Protected endpoints of every API version
+	documentAPI(doc, apiEndpoints(cfg, nil, nil))
+
+	return doc
+}
+
+// problemResponse describes an application/problem+json error response
+func problemResponse(doc *openapi.Document, description string) *openapi.Response {
+	return openapi.Problem(description, doc.Schema(problem.Problem{}))
+}
+
+// setupAPIDocs serves the OpenAPI document and, behind a feature flag, the reference page
+func setupAPIDocs(router *gin.Engine, doc *openapi.Document) {
+	router.GET("/api/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	})
+
+	router.GET("/api/docs", func(c *gin.Context) {
+		if cfg := config.FromContext(c); cfg == nil || !cfg.FeatureEnabled(APIDocsFeature) {
+			problem.Abort(c, problem.NotFound("Not found"))
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.DocsHTML)
+	})
+}
+
+// UndocumentedRoutes returns the registered routes missing from the OpenAPI document
+func UndocumentedRoutes(r *gin.Engine, doc *openapi.Document) []gin.RouteInfo {
+	var missing []gin.RouteInfo
+	for _, route := range r.Routes() {
+		if undocumentedRoutes[route.Method+" "+route.Path] {
+			continue
+		}
+		if !doc.Has(route.Method, route.Path) {
+			missing = append(missing, route)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,407 @@
+package routes
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/photos"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+
+	"github.com/gin-gonic/gin"
+)
+
+// photoMatchRadiusKM is the farthest a photo can be taken from the item it
+// is matched to by its GPS position
+const photoMatchRadiusKM = 25
+
+// GalleryPhoto is a photo of a trip with the itinerary item it was taken at
+// and links to it
+type GalleryPhoto struct {
+	store.Attachment
+
+	// MatchedItemID is the itinerary item the photo was taken at: the item it
+	// is attached to, else the nearest item with coordinates within 25 km,
+	// preferring items of the same day, else the item last started when it
+	// was taken among those in progress and those without an end that day
+	MatchedItemID string   `json:"matched_item_id,omitempty"`
+	DistanceKM    *float64 `json:"distance_km,omitempty" doc:"Between the photo's GPS position and the matched item"`
+
+	URL           string            `json:"url" doc:"Signed link to the photo, without its GPS position"`
+	ThumbnailURLs map[string]string `json:"thumbnail_urls,omitempty" doc:"Signed links to the thumbnails by size: small (320 px) and large (1280 px)"`
+}
+
+// PhotoDay is the photos taken on one day, in the order they were taken
+type PhotoDay struct {
+	Date string `json:"date,omitempty" doc:"Local date the photos were taken on; absent for photos without a date"`
+
+	// DayIndex is the day of the trip, 1 being its start date (or the first
+	// item's day if it has none)
+	DayIndex *int `json:"day_index,omitempty"`
+
+	Photos []GalleryPhoto `json:"photos"`
+}
+
+// PhotoGallery is the photos of a trip grouped by day
+type PhotoGallery struct {
+	Days []PhotoDay `json:"days" doc:"In date order, followed by the photos without a date"`
+
+	// LinksExpireAt is when the links to the photos and thumbnails stop working
+	LinksExpireAt time.Time `json:"links_expire_at"`
+}
+
+// galleryEndpoint describes GET /trips/:id/photos
+func (api *attachmentAPI) galleryEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/photos",
+		Handler:  api.gallery,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getPhotoGallery",
+				Summary:     "List the photos of a trip by day",
+				Description: "Photos are the JPEG, PNG and GIF attachments of the trip and its items. Each is placed on " +
+					"the local day it was taken according to its EXIF metadata and matched to an itinerary item, " +
+					"with signed links valid for ATTACHMENT_URL_TTL.",
+				Tags: []string{"attachments"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK):                 openapi.JSON("Photos by day", doc.Schema(PhotoGallery{})),
+					openapi.Status(http.StatusNotFound):           problemResponse(doc, "Trip not found"),
+					openapi.Status(http.StatusServiceUnavailable): problemResponse(doc, "ATTACHMENT_URL_KEY is not configured"),
+				},
+			}
+		},
+	}
+}
+
+// thumbnailEndpoint describes GET /trips/:id/attachments/:attachment_id/thumbnail
+func (api *attachmentAPI) thumbnailEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/attachments/:attachment_id/thumbnail",
+		Handler:  api.getThumbnail,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getAttachmentThumbnail",
+				Summary:     "Download a thumbnail of a photo",
+				Tags:        []string{"attachments"},
+				Parameters:  []openapi.Parameter{thumbnailSizeParameter()},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK):         thumbnailResponse(),
+					openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid size"),
+					openapi.Status(http.StatusNotFound):   problemResponse(doc, "Trip or attachment not found, or not a photo with thumbnails"),
+				},
+			}
+		},
+	}
+}
+
+// thumbnailSizeParameter describes the size query parameter of thumbnails
+func thumbnailSizeParameter() openapi.Parameter {
+	sizes := make([]string, 0, len(photos.ThumbnailSizes))
+	for name := range photos.ThumbnailSizes {
+		sizes = append(sizes, name)
+	}
+	slices.Sort(sizes)
+	return openapi.Parameter{
+		Name:        "size",
+		In:          "query",
+		Description: "small (the default) fits in 320×320 pixels, large in 1280×1280",
+		Schema:      &openapi.Schema{Type: "string", Enum: stringsToAny(sizes)},
+	}
+}
+
+// thumbnailResponse describes a thumbnail
+func thumbnailResponse() *openapi.Response {
+	return &openapi.Response{
+		Description: "JPEG thumbnail, upright and without metadata",
+		Content:     map[string]openapi.MediaType{"image/jpeg": {Schema: &openapi.Schema{Type: "string", Format: "binary"}}},
+	}
+}
+
+// getThumbnail returns a thumbnail of a photo
+func (api *attachmentAPI) getThumbnail(c *gin.Context) {
+	a, ok := api.loadAttachment(c)
+	if !ok {
+		return
+	}
+	serveThumbnail(c, api.files, a)
+}
+
+// gallery returns the photos of a trip by day, with signed links to them
+func (api *attachmentAPI) gallery(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	cfg := config.FromContext(c)
+	key, err := attachmentLinkKey(cfg)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	items, err := api.store.AllItems(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	attached, err := api.store.TripPhotos(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+
+	expires := time.Now().Add(cfg.AttachmentURLTTL).Truncate(time.Second).UTC()
+	gallery := PhotoGallery{Days: buildGallery(trip, items, attached), LinksExpireAt: expires}
+	for _, day := range gallery.Days {
+		for i := range day.Photos {
+			p := &day.Photos[i]
+			p.URL = fileURL(cfg, p.ID, "", attachments.SignLink(key, p.ID, attachments.LinkFile, expires))
+			if p.Photo.Thumbnails {
+				query := attachments.SignLink(key, p.ID, attachments.LinkThumbnail, expires)
+				p.ThumbnailURLs = make(map[string]string, len(photos.ThumbnailSizes))
+				for name := range photos.ThumbnailSizes {
+					query.Set("size", name)
+					p.ThumbnailURLs[name] = fileURL(cfg, p.ID, "/thumbnail", query)
+				}
+			}
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gallery)
+}
+
+// buildGallery groups the photos of a trip by the local day they were taken
+// on and matches them to the trip's items, which are in schedule order
+func buildGallery(trip *store.Trip, items []*store.ItineraryItem, attached []*store.Attachment) []PhotoDay {
+	var firstDay time.Time
+	if start, err := time.Parse(time.DateOnly, trip.StartDate); err == nil {
+		firstDay = start
+	} else if len(items) > 0 {
+		firstDay = localDay(items[0])
+	}
+	itemDays := make(map[string]time.Time, len(items))
+	for _, it := range items {
+		itemDays[it.ID] = localDay(it)
+	}
+
+	byDay := map[time.Time][]GalleryPhoto{}
+	var undated []GalleryPhoto
+	for _, a := range attached {
+		photo := GalleryPhoto{Attachment: *a}
+		day, dated := photoDay(a.Photo)
+
+		// Photos known only by their local time are placed in the zone of the trip that day
+		at := a.Photo.TakenAt
+		if at == nil && dated {
+			at = resolvePhotoTime(a.Photo, day, items, itemDays)
+		}
+
+		var matched *store.ItineraryItem
+		if a.ItemID != "" {
+			matched = findItem(items, a.ItemID)
+		}
+		if matched == nil && a.Photo.Latitude != nil && a.Photo.Longitude != nil {
+			p := geo.Point{Lat: *a.Photo.Latitude, Lon: *a.Photo.Longitude}
+			if dated {
+				matched = nearestItem(p, items, func(it *store.ItineraryItem) bool { return itemDays[it.ID].Equal(day) })
+			}
+			if matched == nil {
+				matched = nearestItem(p, items, func(*store.ItineraryItem) bool { return true })
+			}
+		}
+		if matched == nil && at != nil {
+			matched = itemAt(*at, day, items, itemDays)
+		}
+		if matched != nil {
+			photo.MatchedItemID = matched.ID
+			if d, ok := photoDistance(a.Photo, matched); ok {
+				photo.DistanceKM = &d
+			}
+		}
+
+		if !dated {
+			undated = append(undated, photo)
+			continue
+		}
+		byDay[day] = append(byDay[day], photo)
+	}
+
+	days := make([]time.Time, 0, len(byDay))
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	if firstDay.IsZero() && len(days) > 0 {
+		firstDay = days[0]
+	}
+	gallery := make([]PhotoDay, 0, len(days)+1)
+	for _, day := range days {
+		taken := byDay[day]
+		sort.SliceStable(taken, func(i, j int) bool { return taken[i].Photo.LocalTime < taken[j].Photo.LocalTime })
+		index := int(math.Round(day.Sub(firstDay).Hours()/24)) + 1
+		gallery = append(gallery, PhotoDay{Date: day.Format(time.DateOnly), DayIndex: &index, Photos: taken})
+	}
+	if len(undated) > 0 {
+		gallery = append(gallery, PhotoDay{Photos: undated})
+	}
+	return gallery
+}
+
+// photoDay returns midnight UTC of the local date a photo was taken on, or of
+// its UTC date if only that is known
+func photoDay(p *store.Photo) (time.Time, bool) {
+	if t, err := time.Parse(store.LocalTimeLayout, p.LocalTime); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	}
+	if p.TakenAt != nil {
+		return localDate(*p.TakenAt, ""), true
+	}
+	return time.Time{}, false
+}
+
+// resolvePhotoTime returns when a photo known only by its local time was
+// taken, in the time zone of the first item of that day, or else of the last
+// item before it
+func resolvePhotoTime(p *store.Photo, day time.Time, items []*store.ItineraryItem, itemDays map[string]time.Time) *time.Time {
+	wall, err := time.Parse(store.LocalTimeLayout, p.LocalTime)
+	if err != nil {
+		return nil
+	}
+	var loc *time.Location
+	for _, it := range items {
+		if itemDays[it.ID].After(day) {
+			break
+		}
+		if l, err := timezone.Load(it.TimeZone); err == nil {
+			loc = l
+			if itemDays[it.ID].Equal(day) {
+				break
+			}
+		}
+	}
+	if loc == nil {
+		return nil
+	}
+	t := timezone.Resolve(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), loc)
+	return &t
+}
+
+// findItem returns the item with the given ID, or nil
+func findItem(items []*store.ItineraryItem, id string) *store.ItineraryItem {
+	for _, it := range items {
+		if it.ID == id {
+			return it
+		}
+	}
+	return nil
+}
+
+// nearestItem returns the item accepted by include closest to p, within
+// photoMatchRadiusKM, or nil
+func nearestItem(p geo.Point, items []*store.ItineraryItem, include func(*store.ItineraryItem) bool) *store.ItineraryItem {
+	var (
+		nearest *store.ItineraryItem
+		best    = math.Inf(1)
+	)
+	for _, it := range items {
+		if !include(it) {
+			continue
+		}
+		if d, ok := itemDistance(p, it); ok && d <= photoMatchRadiusKM && d < best {
+			nearest, best = it, d
+		}
+	}
+	return nearest
+}
+
+// itemAt returns the item last started before t among those still in
+// progress at t and those without an end started that day, or nil
+func itemAt(t, day time.Time, items []*store.ItineraryItem, itemDays map[string]time.Time) *store.ItineraryItem {
+	var current *store.ItineraryItem
+	for _, it := range items {
+		if it.StartsAt.After(t) {
+			break
+		}
+		if (it.EndsAt != nil && !it.EndsAt.Before(t)) || (it.EndsAt == nil && itemDays[it.ID].Equal(day)) {
+			current = it
+		}
+	}
+	return current
+}
+
+// itemDistance returns the distance from p to an item, or to the nearer
+// airport of a flight; ok is false for items without a location
+func itemDistance(p geo.Point, it *store.ItineraryItem) (float64, bool) {
+	start, end, ok := itemEnds(it)
+	if !ok {
+		return 0, false
+	}
+	return math.Min(geo.DistanceKM(p, start), geo.DistanceKM(p, end)), true
+}
+
+// photoDistance returns the distance, rounded to 10 m, between the position
+// of a photo and an item; ok is false unless both have one
+func photoDistance(p *store.Photo, it *store.ItineraryItem) (float64, bool) {
+	if p.Latitude == nil || p.Longitude == nil {
+		return 0, false
+	}
+	d, ok := itemDistance(geo.Point{Lat: *p.Latitude, Lon: *p.Longitude}, it)
+	return math.Round(d*100) / 100, ok
+}
+
+// fileURL returns the URL of a file served under /files/:id to holders of a
+// signed link
+func fileURL(cfg *config.Config, id, path string, query url.Values) string {
+	return strings.TrimSuffix(cfg.APIURL, "/") + "/files/" + id + path + "?" + query.Encode()
+}
+
+// downloadThumbnail serves a thumbnail of a photo to the holder of a signed
+// link to it
+func downloadThumbnail(st *store.Store, files *attachments.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, ok := linkedAttachment(c, st, attachments.LinkThumbnail)
+		if !ok {
+			return
+		}
+		c.Header("Referrer-Policy", "no-referrer")
+		serveThumbnail(c, files, a)
+	}
+}
+
+// serveThumbnail streams the thumbnail of a photo in the size requested by
+// the size query parameter
+func serveThumbnail(c *gin.Context, files *attachments.Service, a *store.Attachment) {
+	size := c.DefaultQuery("size", photos.DefaultThumbnailSize)
+	if _, ok := photos.ThumbnailSizes[size]; !ok {
+		problem.Abort(c, invalidQuery([]problem.FieldError{{Field: "size", Code: "oneof", Message: "must be small or large"}}))
+		return
+	}
+	content, err := files.OpenThumbnail(c.Request.Context(), a, size)
+	if err != nil {
+		if errors.Is(err, attachments.ErrNoThumbnail) {
+			err = problem.NotFound("The attachment has no thumbnails")
+		}
+		problem.Abort(c, err)
+		return
+	}
+	defer content.Close()
+
+	c.DataFromReader(http.StatusOK, -1, "image/jpeg", content, map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/places"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaceResults is the response of a place search
+type PlaceResults struct {
+	Items []places.Result `json:"items"`
+}
+
+// placeAPI serves search and reverse geocoding over the imported GeoNames data
+type placeAPI struct {
+	store *store.Store
+}
+
+// placeEndpoints lists the place endpoints
+func placeEndpoints(st *store.Store) []apiEndpoint {
+	api := &placeAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/places/search",
+			Handler:  api.search,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "searchPlaces",
+					Summary:     "Search cities, regions and countries by name",
+					Description: "Matches names and alternate names ignoring case and accents: exact matches rank " +
+						"first, then prefixes, then names one or two typos away. Ties favour larger populations.",
+					Tags: []string{"places"},
+					Parameters: []openapi.Parameter{
+						{Name: "q", In: "query", Required: true, Description: "Name or name prefix", Schema: &openapi.Schema{Type: "string"}},
+						{Name: "country", In: "query", Description: "ISO 3166-1 alpha-2 country code", Schema: &openapi.Schema{Type: "string"}},
+						{Name: "kind", In: "query", Description: "Kind of place", Schema: &openapi.Schema{Type: "string", Enum: stringsToAny(store.PlaceKinds)}},
+						{Name: "limit", In: "query", Description: fmt.Sprintf("Maximum results (default %d)", places.DefaultLimit),
+							Schema: &openapi.Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(places.MaxLimit))}},
+					},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         openapi.JSON("Matching places, best first", doc.Schema(PlaceResults{})),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/places/reverse",
+			Handler:  api.reverse,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "reverseGeocode",
+					Summary:     "Find the city nearest to a coordinate",
+					Tags:        []string{"places"},
+					Parameters: []openapi.Parameter{
+						{Name: "lat", In: "query", Required: true, Description: "Latitude in degrees",
+							Schema: &openapi.Schema{Type: "number", Minimum: ptr(-90.0), Maximum: ptr(90.0)}},
+						{Name: "lon", In: "query", Required: true, Description: "Longitude in degrees",
+							Schema: &openapi.Schema{Type: "number", Minimum: ptr(-180.0), Maximum: ptr(180.0)}},
+						{Name: "radius_km", In: "query", Description: fmt.Sprintf("Search radius (default %g)", places.DefaultRadiusKM),
+							Schema: &openapi.Schema{Type: "number", Maximum: ptr(places.MaxRadiusKM)}},
+					},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         openapi.JSON("Nearest city", doc.Schema(places.Nearest{})),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+						openapi.Status(http.StatusNotFound):   problemResponse(doc, "No city within the radius"),
+					},
+				}
+			},
+		},
+	}
+}
+
+// search ranks places matching the q parameter
+func (api *placeAPI) search(c *gin.Context) {
+	query := c.Request.URL.Query()
+	var errs []problem.FieldError
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		errs = append(errs, problem.FieldError{Field: "q", Code: "required", Message: "is required"})
+	}
+	filter := store.PlaceFilter{CountryCode: strings.ToUpper(query.Get("country")), Kind: query.Get("kind")}
+	if filter.Kind != "" && !slices.Contains(store.PlaceKinds, filter.Kind) {
+		errs = append(errs, problem.FieldError{Field: "kind", Code: "oneof", Message: "must be one of " + strings.Join(store.PlaceKinds, " ")})
+	}
+	limit, err := queryLimit(query.Get("limit"), places.DefaultLimit, places.MaxLimit)
+	if err != nil {
+		errs = append(errs, problem.FieldError{Field: "limit", Code: "range", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		problem.Abort(c, invalidQuery(errs))
+		return
+	}
+
+	results, err := places.Search(c.Request.Context(), api.store, q, filter, limit)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, PlaceResults{Items: results})
+}
+
+// reverse returns the city nearest to the lat and lon parameters
+func (api *placeAPI) reverse(c *gin.Context) {
+	query := c.Request.URL.Query()
+	var errs []problem.FieldError
+
+	var p geo.Point
+	for _, coord := range []struct {
+		name  string
+		value *float64
+		bound float64
+	}{{"lat", &p.Lat, 90}, {"lon", &p.Lon, 180}} {
+		raw := query.Get(coord.name)
+		if raw == "" {
+			errs = append(errs, problem.FieldError{Field: coord.name, Code: "required", Message: "is required"})
+			continue
+		}
+		n, err := queryNumber(raw, 0, -coord.bound, coord.bound)
+		if err != nil {
+			errs = append(errs, problem.FieldError{Field: coord.name, Code: "range", Message: err.Error()})
+		}
+		*coord.value = n
+	}
+	radius, err := queryNumber(query.Get("radius_km"), places.DefaultRadiusKM, 0, places.MaxRadiusKM)
+	if err != nil {
+		errs = append(errs, problem.FieldError{Field: "radius_km", Code: "range", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		problem.Abort(c, invalidQuery(errs))
+		return
+	}
+
+	nearest, err := places.Reverse(c.Request.Context(), api.store, p, radius)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Abort(c, problem.NotFound(fmt.Sprintf("No city within %g km", radius)))
+		return
+	}
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, nearest)
+}
+
+// queryNumber parses an optional numeric query parameter within [low, high]
+func queryNumber(value string, fallback, low, high float64) (float64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < low || n > high {
+		return 0, fmt.Errorf("must be a number between %g and %g", low, high)
+	}
+	return n, nil
+}
+
+// queryLimit parses an optional whole-number limit parameter within [1, high]
+func queryLimit(value string, fallback, high int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > high {
+		return 0, fmt.Errorf("must be a whole number between 1 and %d", high)
+	}
+	return n, nil
+}
+
+// invalidQuery creates the 400 problem of query parameters failing validation
+func invalidQuery(errs []problem.FieldError) *problem.Problem {
+	p := problem.BadRequest(problem.CodeInvalidQuery, "The query parameters are invalid")
+	p.Errors = errs
+	return p
+}
+
+// stringsToAny converts strings to an OpenAPI enum
+func stringsToAny(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
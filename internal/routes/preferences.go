@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"net/http"
+
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PreferencesInput is the body of preference replace requests
+type PreferencesInput struct {
+	HomeTimeZone string `json:"home_time_zone" binding:"required,timezone"`
+	Currency     string `json:"currency" binding:"omitempty,iso4217"`
+	DistanceUnit string `json:"distance_unit" binding:"required,oneof=km mi"`
+	Locale       string `json:"locale" binding:"required,bcp47_language_tag"`
+}
+
+// newPreferencesInput returns the input that would recreate the preferences
+func newPreferencesInput(p *store.Preferences) *PreferencesInput {
+	return &PreferencesInput{
+		HomeTimeZone: p.HomeTimeZone,
+		Currency:     p.Currency,
+		DistanceUnit: p.DistanceUnit,
+		Locale:       p.Locale,
+	}
+}
+
+// apply copies the input onto preferences
+func (in *PreferencesInput) apply(p *store.Preferences) {
+	p.HomeTimeZone = in.HomeTimeZone
+	p.Currency = in.Currency
+	p.DistanceUnit = in.DistanceUnit
+	p.Locale = in.Locale
+}
+
+// preferencesAPI serves the authenticated user's preferences
+type preferencesAPI struct {
+	store *store.Store
+}
+
+// preferenceEndpoints lists the user preference endpoints
+func preferenceEndpoints(st *store.Store) []apiEndpoint {
+	api := &preferencesAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/preferences",
+			Handler:  api.get,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getPreferences", "Preferences of the authenticated user", "users", store.Preferences{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/preferences",
+			Handler:  api.replace,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replacePreferences", "Replace the user's preferences", "users", PreferencesInput{}, store.Preferences{})
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/preferences",
+			Handler:  api.patch,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return patchOperation(doc, "patchPreferences", "Update some of the user's preferences", "users", PreferencesInput{}, store.Preferences{})
+			},
+		},
+	}
+}
+
+// get returns the user's preferences
+func (api *preferencesAPI) get(c *gin.Context) {
+	prefs, ok := api.load(c)
+	if !ok {
+		return
+	}
+	respond(c, prefs.Version, prefs)
+}
+
+// replace replaces the user's preferences
+func (api *preferencesAPI) replace(c *gin.Context) {
+	prefs, ok := api.load(c)
+	if !ok || !checkIfMatch(c, prefs.Version) {
+		return
+	}
+	var in PreferencesInput
+	if !bindInput(c, &in, nil) {
+		return
+	}
+	api.save(c, prefs, &in)
+}
+
+// patch applies a merge patch or JSON Patch to the user's preferences
+func (api *preferencesAPI) patch(c *gin.Context) {
+	prefs, ok := api.load(c)
+	if !ok || !checkIfMatch(c, prefs.Version) {
+		return
+	}
+	var in PreferencesInput
+	if !patchInput(c, newPreferencesInput(prefs), &in, nil) {
+		return
+	}
+	api.save(c, prefs, &in)
+}
+
+// load reads the user's preferences, aborting on failure
+func (api *preferencesAPI) load(c *gin.Context) (*store.Preferences, bool) {
+	prefs, err := api.store.GetPreferences(c.Request.Context(), userID(c))
+	if err != nil {
+		problem.Abort(c, err)
+		return nil, false
+	}
+	return prefs, true
+}
+
+// save stores updated preferences and responds with them
+func (api *preferencesAPI) save(c *gin.Context, prefs *store.Preferences, in *PreferencesInput) {
+	in.apply(prefs)
+	if err := api.store.UpdatePreferences(c.Request.Context(), prefs); err != nil {
+		problem.Abort(c, storeError(err, "Preferences not found"))
+		return
+	}
+	respond(c, prefs.Version, prefs)
+}
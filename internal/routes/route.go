@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeoJSONContentType is the media type of GeoJSON documents (RFC 7946)
+const GeoJSONContentType = "application/geo+json"
+
+// Route geometry settings
+const (
+	// flightStepKM is the spacing of the points densifying flight paths
+	flightStepKM = 100
+
+	// minGroundLegKM is the distance below which consecutive stops are
+	// considered the same place and not joined by a ground leg
+	minGroundLegKM = 0.1
+)
+
+// Route feature types
+const (
+	FeatureStop   = "stop"
+	FeatureFlight = "flight"
+	FeatureGround = "ground"
+)
+
+// RouteProperties describe a feature of a trip's route
+type RouteProperties struct {
+	FeatureType string `json:"feature_type" doc:"stop, flight or ground"`
+
+	// Sequence orders the features along the route, starting at 1
+	Sequence int `json:"sequence"`
+
+	// DayIndex is the day of the trip, 1 being its start date (or the first
+	// item's day if it has none), in the item's local time. Ground legs take
+	// the day of the item they lead to.
+	DayIndex int `json:"day_index"`
+
+	ItemID   string     `json:"item_id,omitempty" doc:"Itinerary item of a stop or flight"`
+	Kind     string     `json:"kind,omitempty" doc:"Itinerary item kind"`
+	Title    string     `json:"title,omitempty"`
+	Location string     `json:"location,omitempty"`
+	TimeZone string     `json:"time_zone,omitempty"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+
+	// Role and Airport are set on the stops at either end of a flight
+	Role    string `json:"role,omitempty" doc:"departure or arrival"`
+	Airport string `json:"airport,omitempty" doc:"IATA or ICAO airport code"`
+
+	// FromItemID and ToItemID are the items joined by a ground leg
+	FromItemID string `json:"from_item_id,omitempty"`
+	ToItemID   string `json:"to_item_id,omitempty"`
+
+	DistanceKM float64 `json:"distance_km,omitempty" doc:"Great-circle length of a flight or ground leg"`
+}
+
+// RouteFeature is a GeoJSON feature of a trip's route
+type RouteFeature struct {
+	Type       string          `json:"type" doc:"Always Feature"`
+	Geometry   geo.Geometry    `json:"geometry"`
+	Properties RouteProperties `json:"properties"`
+}
+
+// RouteCollection is a trip's route as a GeoJSON FeatureCollection
+type RouteCollection struct {
+	Type     string         `json:"type" doc:"Always FeatureCollection"`
+	Features []RouteFeature `json:"features"`
+}
+
+// routeEndpoint describes GET /trips/:id/route.geojson
+func (api *tripAPI) routeEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/route.geojson",
+		Handler:  api.route,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getTripRoute",
+				Summary:     "Get a trip's route as GeoJSON",
+				Description: "Returns a stop Point for every itinerary item with coordinates and for the airports of flights, " +
+					"a great-circle LineString for every flight and a straight LineString for every ground leg between " +
+					"consecutive stops, in schedule order. Lines crossing the antimeridian are split into MultiLineStrings.",
+				Tags: []string{"itinerary"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK): {
+						Description: "GeoJSON FeatureCollection",
+						Content:     map[string]openapi.MediaType{GeoJSONContentType: {Schema: doc.Schema(RouteCollection{})}},
+					},
+					openapi.Status(http.StatusNotFound): problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// route returns the GeoJSON route of a trip
+func (api *tripAPI) route(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	items, err := api.store.AllItems(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.Header("Content-Type", GeoJSONContentType)
+	c.JSON(http.StatusOK, buildRoute(trip, items))
+}
+
+// buildRoute lays out the route of a trip through its items in schedule order
+func buildRoute(trip *store.Trip, items []*store.ItineraryItem) RouteCollection {
+	route := RouteCollection{Type: "FeatureCollection", Features: []RouteFeature{}}
+	add := func(g geo.Geometry, props RouteProperties) {
+		props.Sequence = len(route.Features) + 1
+		route.Features = append(route.Features, RouteFeature{Type: "Feature", Geometry: g, Properties: props})
+	}
+
+	var (
+		firstDay time.Time
+		last     *store.ItineraryItem
+		lastEnd  geo.Point
+	)
+	if start, err := time.Parse(time.DateOnly, trip.StartDate); err == nil {
+		firstDay = start
+	}
+	for _, it := range items {
+		start, end, ok := itemEnds(it)
+		if !ok {
+			continue
+		}
+		day := localDay(it)
+		if firstDay.IsZero() {
+			firstDay = day
+		}
+		props := RouteProperties{
+			DayIndex: int(math.Round(day.Sub(firstDay).Hours()/24)) + 1,
+			ItemID:   it.ID,
+			Kind:     it.Kind,
+			Title:    it.Title,
+			Location: it.Location,
+			TimeZone: it.TimeZone,
+			StartsAt: &it.StartsAt,
+			EndsAt:   it.EndsAt,
+		}
+
+		if last != nil {
+			if distance := geo.DistanceKM(lastEnd, start); distance >= minGroundLegKM {
+				add(geo.NewLine([]geo.Point{lastEnd, start}), RouteProperties{
+					FeatureType: FeatureGround,
+					DayIndex:    props.DayIndex,
+					FromItemID:  last.ID,
+					ToItemID:    it.ID,
+					DistanceKM:  math.Round(distance*10) / 10,
+				})
+			}
+		}
+
+		if f := it.Flight; f != nil {
+			departure, arrival := props, props
+			departure.FeatureType, departure.Role, departure.Airport = FeatureStop, "departure", f.Departure.Code()
+			arrival.FeatureType, arrival.Role, arrival.Airport = FeatureStop, "arrival", f.Arrival.Code()
+			flight := props
+			flight.FeatureType, flight.DistanceKM = FeatureFlight, f.DistanceKM
+			add(geo.NewPoint(start), departure)
+			add(geo.NewLine(geo.GreatCircle(start, end, flightStepKM)), flight)
+			add(geo.NewPoint(end), arrival)
+		} else {
+			props.FeatureType = FeatureStop
+			add(geo.NewPoint(start), props)
+		}
+		last, lastEnd = it, end
+	}
+	return route
+}
+
+// itemEnds returns where an item starts and ends: the airports of flights,
+// otherwise its coordinates. ok is false for items without a location.
+func itemEnds(it *store.ItineraryItem) (start, end geo.Point, ok bool) {
+	if f := it.Flight; f != nil {
+		start = geo.Point{Lat: f.Departure.Latitude, Lon: f.Departure.Longitude}
+		end = geo.Point{Lat: f.Arrival.Latitude, Lon: f.Arrival.Longitude}
+		return start, end, true
+	}
+	if it.Latitude == nil || it.Longitude == nil {
+		return geo.Point{}, geo.Point{}, false
+	}
+	p := geo.Point{Lat: *it.Latitude, Lon: *it.Longitude}
+	return p, p, true
+}
+
+// localDay returns midnight UTC of the date an item starts on in its time
+// zone, or in UTC if it has none
+func localDay(it *store.ItineraryItem) time.Time {
+	return localDate(it.StartsAt, it.TimeZone)
+}
@@ -1,14 +1,19 @@
 package routes
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
+	"vibed-traveller/internal/attachments"
+	"vibed-traveller/internal/conditional"
 	"vibed-traveller/internal/config"
 	"vibed-traveller/internal/middleware"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,20 +26,30 @@ type HealthResponse struct {
 	Service   string    `json:"service"`
 }
 
-// SetupRoutes configures all the routes for the application
-func SetupRoutes(cfg *config.Config) *gin.Engine {
-	// Set Gin to release mode for production
-	gin.SetMode(gin.ReleaseMode)
+// SetupRoutes configures all the routes for the application.
+// Reloadable settings (CORS origins, rate limits) are read from the manager on every request.
+// files keeps the content of attachments.
+func SetupRoutes(configs *config.Manager, st *store.Store, files *attachments.Service) (*gin.Engine, error) {
+	cfg := configs.Current()
+
+	// Release mode unless GIN_MODE says otherwise
+	gin.SetMode(cfg.GinMode)
 
 	// Create Gin router (without default middleware)
 	r := gin.New()
 
+	// Only honour forwarding headers from configured proxies; none by default,
+	// as gin otherwise trusts X-Forwarded-For from every client
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("setting trusted proxies: %w", err)
+	}
+
 	// Setup CORS
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{cfg.GetBaseURL()},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOriginFunc:  func(origin string) bool { return isAllowedOrigin(configs.Current(), origin) },
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", conditional.IfMatchHeader, conditional.IfNoneMatchHeader, IdempotencyKeyHeader},
+		ExposeHeaders:    []string{"Content-Length", APIVersionHeader, middleware.DeprecationHeader, middleware.SunsetHeader, "Link", "Location", conditional.ETagHeader, IdempotentReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -42,17 +57,43 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 	// Add request ID middleware first
 	r.Use(middleware.RequestIDMiddleware())
 
+	// Pin the configuration snapshot for the duration of each request
+	r.Use(configs.Middleware())
+
 	// Add custom logging middleware
 	r.Use(middleware.RequestLoggingMiddleware())
 
-	// Add recovery middleware
-	r.Use(gin.Recovery())
+	// Render panics and handler errors as problem+json
+	r.Use(gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		problem.Render(c, problem.Internal(fmt.Errorf("panic: %v", recovered)))
+		c.Abort()
+	}))
+	r.Use(problem.Middleware())
+
+	// Ask browsers to stick to HTTPS
+	if cfg.HSTSMaxAge > 0 {
+		r.Use(middleware.HSTSMiddleware(cfg.HSTSMaxAge, config.IsSecureRequest))
+	}
+
+	// Limit requests per client IP
+	r.Use(middleware.RateLimitMiddleware(func() (float64, int) {
+		current := configs.Current()
+		return current.RateLimitRPS, current.RateLimitBurst
+	}))
 
 	// Health check endpoint
 	r.GET("/health", healthHandler)
 
+	// OpenAPI document and reference page
+	doc := APISpec(cfg)
+	setupAPIDocs(r, doc)
+
+	// Attachments downloaded with signed links, without authentication
+	r.GET("/files/:id", downloadAttachment(st, files))
+	r.GET("/files/:id/thumbnail", downloadThumbnail(st, files))
+
 	// Setup authenticated routes if Auth0 is configured
-	SetupAuthRoutes(r, cfg)
+	SetupAuthRoutes(r, cfg, st, files)
 
 	// Serve static files from dist directory
 	r.Static("/static", "./dist/static")
@@ -75,7 +116,22 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 		c.File("./dist/index.html")
 	})
 
-	return r
+	// Every API route should be described in the OpenAPI document
+	for _, route := range UndocumentedRoutes(r, doc) {
+		slog.Warn("Route missing from OpenAPI document", slog.String("method", route.Method), slog.String("path", route.Path))
+	}
+
+	return r, nil
+}
+
+// isAllowedOrigin reports whether origin is in the configured CORS allow-list
+func isAllowedOrigin(cfg *config.Config, origin string) bool {
+	for _, allowed := range cfg.GetCORSAllowOrigins() {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
 }
 
 // healthHandler handles the health check endpoint
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter builds the application's routes over a fresh database
+func newTestRouter(t *testing.T) (*gin.Engine, *config.Config) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"AUTH0_DOMAIN":        "example.auth0.com",
+		"AUTH0_AUDIENCE":      "https://api.example.com",
+		"AUTH0_ISSUER_URL":    "https://example.auth0.com/",
+		"AUTH0_CLIENT_ID":     "client",
+		"AUTH0_CLIENT_SECRET": "secret",
+		"GIN_MODE":            "test",
+		"DATABASE_PATH":       filepath.Join(t.TempDir(), "test.db"),
+	} {
+		t.Setenv(key, value)
+	}
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+
+	st, err := store.Open(context.Background(), cfg.DatabasePath)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	r, err := SetupRoutes(config.NewManager(cfg, nil), st, nil)
+	if err != nil {
+		t.Fatalf("setting up routes: %v", err)
+	}
+	return r, cfg
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	r, cfg := newTestRouter(t)
+	doc := APISpec(cfg)
+
+	if len(r.Routes()) == 0 {
+		t.Fatal("no routes registered")
+	}
+	for _, route := range UndocumentedRoutes(r, doc) {
+		t.Errorf("%s %s is missing from the OpenAPI document", route.Method, route.Path)
+	}
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"net/http"
+
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ItemTimes are the start and end of an item as seen in one time zone
+type ItemTimes struct {
+	StartsAt timezone.LocalTime  `json:"starts_at"`
+	EndsAt   *timezone.LocalTime `json:"ends_at,omitempty"`
+}
+
+// ScheduledItem is an itinerary item with its times in local and home time
+type ScheduledItem struct {
+	store.ItineraryItem
+
+	// Local is in the item's time zone, ending in the arrival airport's for
+	// flights; it is absent when the item has no time zone
+	Local *ItemTimes `json:"local,omitempty"`
+
+	// Home is in the user's home time zone
+	Home ItemTimes `json:"home"`
+}
+
+// Schedule is a trip's itinerary in schedule order
+type Schedule struct {
+	HomeTimeZone string          `json:"home_time_zone"`
+	Items        []ScheduledItem `json:"items"`
+}
+
+// scheduleEndpoint describes GET /trips/:id/schedule
+func (api *tripAPI) scheduleEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/schedule",
+		Handler:  api.schedule,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getSchedule",
+				Summary:     "List every itinerary item of a trip in local and home time",
+				Description: "Times carry the UTC offset, abbreviation and daylight saving state in effect at each instant.",
+				Tags:        []string{"itinerary"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK):       openapi.JSON("Itinerary in schedule order", doc.Schema(Schedule{})),
+					openapi.Status(http.StatusNotFound): problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// schedule returns a trip's items with their times in local and home time
+func (api *tripAPI) schedule(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	prefs, err := api.store.GetPreferences(c.Request.Context(), userID(c))
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	items, err := api.store.AllItems(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+
+	schedule := Schedule{HomeTimeZone: prefs.HomeTimeZone, Items: make([]ScheduledItem, 0, len(items))}
+	for _, it := range items {
+		home, err := itemTimes(it, prefs.HomeTimeZone, prefs.HomeTimeZone)
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		scheduled := ScheduledItem{ItineraryItem: *it, Home: *home}
+		if it.TimeZone != "" {
+			endZone := it.TimeZone
+			if it.Flight != nil && it.Flight.Arrival.TimeZone != "" {
+				endZone = it.Flight.Arrival.TimeZone
+			}
+			// Zones removed from the embedded database leave the item without local times
+			scheduled.Local, _ = itemTimes(it, it.TimeZone, endZone)
+		}
+		schedule.Items = append(schedule.Items, scheduled)
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// itemTimes returns an item's start in startZone and end in endZone
+func itemTimes(it *store.ItineraryItem, startZone, endZone string) (*ItemTimes, error) {
+	start, err := timezone.In(it.StartsAt, startZone)
+	if err != nil {
+		return nil, err
+	}
+	times := &ItemTimes{StartsAt: start}
+	if it.EndsAt != nil {
+		end, err := timezone.In(*it.EndsAt, endZone)
+		if err != nil {
+			return nil, err
+		}
+		times.EndsAt = &end
+	}
+	return times, nil
+}
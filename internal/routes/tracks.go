@@ -0,0 +1,332 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/tracks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTrackUploadMaxMB applies when no configuration is pinned to the request
+const defaultTrackUploadMaxMB = 50
+
+// TrackList is the response listing a trip's tracks and waypoints
+type TrackList struct {
+	Tracks    []*store.Track    `json:"tracks"`
+	Waypoints []*store.Waypoint `json:"waypoints"`
+}
+
+// trackEndpoints lists the GPS track endpoints of trips
+func trackEndpoints(st *store.Store) []apiEndpoint {
+	api := &tripAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/tracks",
+			Handler:  api.listTracks,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "listTracks", "List the tracks, routes and waypoints uploaded to a trip", "tracks", TrackList{})
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/trips/:id/tracks",
+			Handler:  api.uploadTracks,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				file := &openapi.Schema{Type: "string", Format: "binary"}
+				return openapi.Operation{
+					OperationID: "uploadTracks",
+					Summary:     "Upload a GPX or KML file to a trip",
+					Description: "Adds the file's tracks, routes and waypoints to the trip. The file is sent as the request body, " +
+						"or as the file field of a multipart form, and is parsed while it is received.",
+					Tags: []string{"tracks"},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: map[string]openapi.MediaType{
+							tracks.GPXContentType: {Schema: file},
+							tracks.KMLContentType: {Schema: file},
+							"multipart/form-data": {Schema: &openapi.Schema{
+								Type:       "object",
+								Properties: map[string]*openapi.Schema{"file": file},
+								Required:   []string{"file"},
+							}},
+						},
+					},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusCreated):               openapi.JSON("Imported tracks and waypoints", doc.Schema(tracks.Result{})),
+						openapi.Status(http.StatusNotFound):              problemResponse(doc, "Trip not found"),
+						openapi.Status(http.StatusRequestEntityTooLarge): problemResponse(doc, "File larger than TRACK_UPLOAD_MAX_MB"),
+						openapi.Status(http.StatusUnsupportedMediaType):  problemResponse(doc, "Not a GPX or KML file"),
+						openapi.Status(http.StatusUnprocessableEntity):   problemResponse(doc, "Invalid or empty file"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/tracks/:track_id",
+			Handler:  api.getTrack,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getTrack", "Get a track with its statistics", "tracks", store.Track{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id/tracks/:track_id",
+			Handler:  api.deleteTrack,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "deleteTrack",
+					Summary:     "Delete a track with its points",
+					Tags:        []string{"tracks"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusNoContent): {Description: "Deleted"},
+						openapi.Status(http.StatusNotFound):  problemResponse(doc, "Track not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id/waypoints/:waypoint_id",
+			Handler:  api.deleteWaypoint,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "deleteWaypoint",
+					Summary:     "Delete an uploaded waypoint",
+					Tags:        []string{"tracks"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusNoContent): {Description: "Deleted"},
+						openapi.Status(http.StatusNotFound):  problemResponse(doc, "Waypoint not found"),
+					},
+				}
+			},
+		},
+		api.exportEndpoint("gpx", "GPX 1.1", tracks.GPXContentType, (*tracks.Export).WriteGPX),
+		api.exportEndpoint("kml", "KML 2.2", tracks.KMLContentType, (*tracks.Export).WriteKML),
+	}
+}
+
+// exportEndpoint describes GET /trips/:id/export.<ext>, streaming a trip's
+// places, flights and tracks in a file format
+func (api *tripAPI) exportEndpoint(ext, format, contentType string,
+	write func(*tracks.Export, context.Context, io.Writer) error) apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/export." + ext,
+		Handler:  api.exportTracks(ext, contentType, write),
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "exportTrip" + strings.ToUpper(ext),
+				Summary:     "Export a trip's places, flights and tracks as " + format,
+				Description: "Located itinerary items and uploaded waypoints are written as points, flights as lines " +
+					"between their airports, and uploaded tracks and routes with every point.",
+				Tags: []string{"tracks"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK): {
+						Description: format + " document",
+						Content:     map[string]openapi.MediaType{contentType: {Schema: &openapi.Schema{Type: "string"}}},
+					},
+					openapi.Status(http.StatusNotFound): problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// listTracks lists the tracks and waypoints of a trip
+func (api *tripAPI) listTracks(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	list := TrackList{}
+	var err error
+	if list.Tracks, err = api.store.ListTracks(c.Request.Context(), trip.ID); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	if list.Waypoints, err = api.store.ListWaypoints(c.Request.Context(), trip.ID); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// uploadTracks imports a GPX or KML file into a trip
+func (api *tripAPI) uploadTracks(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	maxMB := defaultTrackUploadMaxMB
+	if cfg := config.FromContext(c); cfg != nil {
+		maxMB = cfg.TrackUploadMaxMB
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxMB)<<20)
+
+	file, err := uploadedFile(c, body)
+	if err != nil {
+		problem.Abort(c, uploadError(err, maxMB))
+		return
+	}
+	result, err := tracks.Import(c.Request.Context(), api.store, trip.ID, file)
+	if err != nil {
+		problem.Abort(c, uploadError(err, maxMB))
+		return
+	}
+	recordAudit(c, api.store, store.AuditTrackImport, "trip", trip.ID, nil,
+		gin.H{"format": result.Format, "tracks": len(result.Tracks), "waypoints": len(result.Waypoints)})
+	c.JSON(http.StatusCreated, result)
+}
+
+// uploadedFile returns the file of an upload: the file field of a multipart
+// form, or else the body itself
+func uploadedFile(c *gin.Context, body io.ReadCloser) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return body, nil
+	}
+	return multipartFile(c, body)
+}
+
+// multipartFile returns the file field of a multipart form body
+func multipartFile(c *gin.Context, body io.ReadCloser) (*multipart.Part, error) {
+	c.Request.Body = body
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := form.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, problem.Validation(problem.FieldError{Field: "file", Code: "required", Message: "is required"})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// uploadError maps the errors of reading and importing an upload to problems
+func uploadError(err error, maxMB int) error {
+	var (
+		tooLarge *http.MaxBytesError
+		invalid  *tracks.FormatError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge,
+			fmt.Sprintf("Files are limited to %d MB", maxMB))
+	case errors.Is(err, tracks.ErrUnsupportedFormat):
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "Upload a GPX or KML file")
+	case errors.As(err, &invalid):
+		return problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidFile, "Invalid file: "+invalid.Error())
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary), errors.As(err, new(textproto.ProtocolError)):
+		return problem.BadRequest(problem.CodeInvalidRequest, "Invalid multipart form")
+	default:
+		return err
+	}
+}
+
+// getTrack returns a track of a trip
+func (api *tripAPI) getTrack(c *gin.Context) {
+	track, ok := api.loadTrack(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, track)
+}
+
+// deleteTrack deletes a track of a trip
+func (api *tripAPI) deleteTrack(c *gin.Context) {
+	track, ok := api.loadTrack(c)
+	if !ok {
+		return
+	}
+	if err := api.store.DeleteTrack(c.Request.Context(), track.ID); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditTrackDelete, "track", track.ID, track, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// deleteWaypoint deletes an uploaded waypoint of a trip
+func (api *tripAPI) deleteWaypoint(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	waypoint, err := api.store.GetWaypoint(c.Request.Context(), trip.ID, c.Param("waypoint_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Waypoint not found"))
+		return
+	}
+	if err := api.store.DeleteWaypoint(c.Request.Context(), waypoint.ID); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditWaypointDelete, "waypoint", waypoint.ID, waypoint, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// exportTracks returns a handler streaming a trip in a file format
+func (api *tripAPI) exportTracks(ext, contentType string, write func(*tracks.Export, context.Context, io.Writer) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trip, ok := api.loadTrip(c)
+		if !ok {
+			return
+		}
+		export, err := tracks.NewExport(c.Request.Context(), api.store, trip)
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="trip-%s.%s"`, trip.ID, ext))
+		c.Status(http.StatusOK)
+		if err := write(export, c.Request.Context(), c.Writer); err != nil {
+			// Headers are gone; the truncated body is all the client will see
+			slog.ErrorContext(c.Request.Context(), "Trip export failed", slog.String("trip_id", trip.ID), slog.Any("error", err))
+		}
+	}
+}
+
+// loadTrack loads the track named by :track_id in the trip named by :id
+func (api *tripAPI) loadTrack(c *gin.Context) (*store.Track, bool) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return nil, false
+	}
+	track, err := api.store.GetTrack(c.Request.Context(), trip.ID, c.Param("track_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Track not found"))
+		return nil, false
+	}
+	return track, true
+}
@@ -0,0 +1,1071 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/airports"
+	"vibed-traveller/internal/conditional"
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/patch"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TripInput is the body of trip create and replace requests
+type TripInput struct {
+	Name        string           `json:"name" binding:"required,max=200"`
+	Description string           `json:"description" binding:"max=5000"`
+	StartDate   string           `json:"start_date" binding:"omitempty,datetime=2006-01-02"`
+	EndDate     string           `json:"end_date" binding:"omitempty,datetime=2006-01-02"`
+	Status      string           `json:"status" binding:"omitempty,oneof=planned active completed cancelled"`
+	Tags        []string         `json:"tags" binding:"max=20,dive,min=1,max=50"`
+	Travellers  []TravellerInput `json:"travellers" binding:"max=50,dive" doc:"People travelling with the owner"`
+}
+
+// TravellerInput is a companion in a trip input
+type TravellerInput struct {
+	Name              string `json:"name" binding:"required,max=200"`
+	Nationality       string `json:"nationality" binding:"required,iso3166_1_alpha2" doc:"ISO 3166-1 alpha-2 code of the passport travelled on"`
+	PassportExpiresOn string `json:"passport_expires_on" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// localTimeLayout is the format of local wall times in item inputs
+const localTimeLayout = "2006-01-02T15:04"
+
+// ItemInput is the body of itinerary item create and replace requests
+type ItemInput struct {
+	Kind      string     `json:"kind" binding:"required,oneof=flight lodging transport activity meal"`
+	Title     string     `json:"title" binding:"required,max=200"`
+	Location  string     `json:"location" binding:"max=200"`
+	Latitude  *float64   `json:"latitude" binding:"required_with=Longitude,omitempty,latitude"`
+	Longitude *float64   `json:"longitude" binding:"required_with=Latitude,omitempty,longitude"`
+	TimeZone  string     `json:"time_zone" binding:"omitempty,timezone" doc:"IANA time zone; resolved from the coordinates or departure airport when empty"`
+	StartsAt  time.Time  `json:"starts_at" binding:"required_without=StartsAtLocal"`
+	EndsAt    *time.Time `json:"ends_at"`
+
+	// StartsAtLocal and EndsAtLocal give the times as wall clock readings in
+	// the item's time zone (the arrival airport's for ends_at of flights),
+	// replacing starts_at and ends_at
+	StartsAtLocal string `json:"starts_at_local" binding:"omitempty,datetime=2006-01-02T15:04" doc:"Local wall time replacing starts_at"`
+	EndsAtLocal   string `json:"ends_at_local" binding:"omitempty,datetime=2006-01-02T15:04" doc:"Local wall time replacing ends_at"`
+
+	Notes  string       `json:"notes" binding:"max=5000"`
+	Tags   []string     `json:"tags" binding:"max=20,dive,min=1,max=50"`
+	Flight *FlightInput `json:"flight" doc:"Airports and airline of a flight item"`
+
+	// flight holds the details resolved from Flight by the item validator
+	flight *store.Flight
+}
+
+// ExpenseInput is the body of expense create and replace requests
+type ExpenseInput struct {
+	Description string   `json:"description" binding:"required,max=200"`
+	Category    string   `json:"category" binding:"required,oneof=transport lodging food activities shopping other"`
+	AmountMinor int64    `json:"amount_minor" binding:"min=0"`
+	Currency    string   `json:"currency" binding:"required,iso4217"`
+	SpentOn     string   `json:"spent_on" binding:"required,datetime=2006-01-02"`
+	Tags        []string `json:"tags" binding:"max=20,dive,min=1,max=50"`
+}
+
+// newTripInput returns the input that would recreate a trip, the document PATCH applies to
+func newTripInput(t *store.Trip) *TripInput {
+	in := &TripInput{
+		Name:        t.Name,
+		Description: t.Description,
+		StartDate:   t.StartDate,
+		EndDate:     t.EndDate,
+		Status:      t.Status,
+		Tags:        t.Tags,
+		Travellers:  make([]TravellerInput, len(t.Travellers)),
+	}
+	for i, tr := range t.Travellers {
+		in.Travellers[i] = TravellerInput(tr)
+	}
+	return in
+}
+
+// validate checks rules spanning several fields
+func (in *TripInput) validate() error {
+	if in.StartDate != "" && in.EndDate != "" && in.EndDate < in.StartDate {
+		return problem.Validation(problem.FieldError{Field: "end_date", Code: "gtefield", Message: "must not be before start_date"})
+	}
+	return nil
+}
+
+// apply copies the input onto a trip
+func (in *TripInput) apply(t *store.Trip) {
+	t.Name = in.Name
+	t.Description = in.Description
+	t.StartDate = in.StartDate
+	t.EndDate = in.EndDate
+	t.Status = in.Status
+	if t.Status == "" {
+		t.Status = store.TripStatuses[0]
+	}
+	t.Tags = in.Tags
+	t.Travellers = make([]store.Traveller, len(in.Travellers))
+	for i, tr := range in.Travellers {
+		t.Travellers[i] = store.Traveller(tr)
+	}
+}
+
+// newItemInput returns the input that would recreate an itinerary item
+func newItemInput(it *store.ItineraryItem) *ItemInput {
+	in := &ItemInput{
+		Kind:      it.Kind,
+		Title:     it.Title,
+		Location:  it.Location,
+		Latitude:  it.Latitude,
+		Longitude: it.Longitude,
+		StartsAt:  it.StartsAt,
+		EndsAt:    it.EndsAt,
+		Notes:     it.Notes,
+		Tags:      it.Tags,
+		Flight:    newFlightInput(it.Flight),
+	}
+	// A resolved time zone is left out so that it follows coordinate changes
+	if it.TimeZone != derivedTimeZone(it.Latitude, it.Longitude, it.Flight) {
+		in.TimeZone = it.TimeZone
+	}
+	return in
+}
+
+// validate checks rules spanning several fields
+func (in *ItemInput) validate() error {
+	if in.EndsAt != nil && in.EndsAt.Before(in.StartsAt) {
+		return problem.Validation(problem.FieldError{Field: "ends_at", Code: "gtefield", Message: "must not be before starts_at"})
+	}
+	return nil
+}
+
+// itemValidator returns the validate function of an item input: it resolves
+// the flight, the time zone and local times, then checks the result
+func (api *tripAPI) itemValidator(c *gin.Context, in *ItemInput) func() error {
+	return func() error {
+		if err := api.resolveFlight(c.Request.Context(), in); err != nil {
+			return err
+		}
+		if err := in.resolveTimes(); err != nil {
+			return err
+		}
+		return in.validate()
+	}
+}
+
+// resolveTimes fills in the time zone if not given and converts local wall
+// times to instants, then records the flight's local times
+func (in *ItemInput) resolveTimes() error {
+	if in.TimeZone == "" {
+		in.TimeZone = derivedTimeZone(in.Latitude, in.Longitude, in.flight)
+	}
+
+	var fields []problem.FieldError
+	endZone := in.TimeZone
+	if in.flight != nil && in.flight.Arrival.TimeZone != "" {
+		endZone = in.flight.Arrival.TimeZone
+	}
+	for _, local := range []struct {
+		field, value, zone string
+		set                func(time.Time)
+	}{
+		{"starts_at_local", in.StartsAtLocal, in.TimeZone, func(t time.Time) { in.StartsAt = t }},
+		{"ends_at_local", in.EndsAtLocal, endZone, func(t time.Time) { in.EndsAt = &t }},
+	} {
+		if local.value == "" {
+			continue
+		}
+		loc, err := timezone.Load(local.zone)
+		if err != nil {
+			fields = append(fields, problem.FieldError{Field: local.field, Code: "time_zone_unknown",
+				Message: "needs a time zone: set time_zone, coordinates or a flight"})
+			continue
+		}
+		// The datetime rule has checked the format
+		wall, _ := time.Parse(localTimeLayout, local.value)
+		local.set(timezone.Resolve(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, loc))
+	}
+	if len(fields) > 0 {
+		return problem.Validation(fields...)
+	}
+
+	if in.flight != nil {
+		airports.SetLocalTimes(in.flight, in.StartsAt, in.EndsAt)
+	}
+	return nil
+}
+
+// derivedTimeZone is the time zone of an item without an explicit one: that
+// of its coordinates, else of its departure airport
+func derivedTimeZone(lat, lon *float64, flight *store.Flight) string {
+	if lat != nil && lon != nil {
+		return timezone.Lookup(geo.Point{Lat: *lat, Lon: *lon})
+	}
+	if flight != nil {
+		return flight.Departure.TimeZone
+	}
+	return ""
+}
+
+// apply copies the input onto an itinerary item
+func (in *ItemInput) apply(it *store.ItineraryItem) {
+	it.Kind = in.Kind
+	it.Title = in.Title
+	it.Location = in.Location
+	it.Latitude = in.Latitude
+	it.Longitude = in.Longitude
+	it.TimeZone = in.TimeZone
+	it.StartsAt = in.StartsAt
+	it.EndsAt = in.EndsAt
+	it.Notes = in.Notes
+	it.Tags = in.Tags
+	it.Flight = in.flight
+}
+
+// newExpenseInput returns the input that would recreate an expense
+func newExpenseInput(e *store.Expense) *ExpenseInput {
+	return &ExpenseInput{
+		Description: e.Description,
+		Category:    e.Category,
+		AmountMinor: e.AmountMinor,
+		Currency:    e.Currency,
+		SpentOn:     e.SpentOn,
+		Tags:        e.Tags,
+	}
+}
+
+// apply copies the input onto an expense
+func (in *ExpenseInput) apply(e *store.Expense) {
+	e.Description = in.Description
+	e.Category = in.Category
+	e.AmountMinor = in.AmountMinor
+	e.Currency = in.Currency
+	e.SpentOn = in.SpentOn
+	e.Tags = in.Tags
+}
+
+// tripAPI serves trips and the items and expenses attached to them.
+// Users only see their own trips; other trips are reported as not found.
+type tripAPI struct {
+	store *store.Store
+}
+
+// tripEndpoints lists the trip, itinerary item and expense endpoints
+func tripEndpoints(st *store.Store) []apiEndpoint {
+	api := &tripAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips",
+			Handler:  api.listTrips,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listTrips",
+					Summary:     "List the user's trips",
+					Tags:        []string{"trips"},
+					Parameters:  listParameters(store.TripListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of trips", listquery.Page[*store.Trip]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/trips",
+			Handler:  api.createTrip,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createTrip", "Create a trip", "trips", TripInput{}, store.Trip{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id",
+			Handler:  api.getTrip,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getTrip", "Get a trip", "trips", store.Trip{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/trips/:id",
+			Handler:  api.replaceTrip,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replaceTrip", "Replace a trip", "trips", TripInput{}, store.Trip{})
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/trips/:id",
+			Handler:  api.patchTrip,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return patchOperation(doc, "patchTrip", "Update fields of a trip", "trips", TripInput{}, store.Trip{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id",
+			Handler:  api.deleteTrip,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return deleteOperation(doc, "deleteTrip", "Delete a trip with its items and expenses", "trips")
+			},
+		},
+		api.scheduleEndpoint(),
+		api.routeEndpoint(),
+		api.warningsEndpoint(),
+		api.entryRequirementsEndpoint(),
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/items",
+			Handler:  api.listItems,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listItems",
+					Summary:     "List the itinerary items of a trip",
+					Tags:        []string{"itinerary"},
+					Parameters:  listParameters(store.ItemListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of itinerary items", listquery.Page[*store.ItineraryItem]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+						openapi.Status(http.StatusNotFound):   problemResponse(doc, "Trip not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/trips/:id/items",
+			Handler:  api.createItem,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createItem", "Add an itinerary item to a trip", "itinerary", ItemInput{}, store.ItineraryItem{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/items/:item_id",
+			Handler:  api.getItem,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getItem", "Get an itinerary item", "itinerary", store.ItineraryItem{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/trips/:id/items/:item_id",
+			Handler:  api.replaceItem,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replaceItem", "Replace an itinerary item", "itinerary", ItemInput{}, store.ItineraryItem{})
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/trips/:id/items/:item_id",
+			Handler:  api.patchItem,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return patchOperation(doc, "patchItem", "Update fields of an itinerary item", "itinerary", ItemInput{}, store.ItineraryItem{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id/items/:item_id",
+			Handler:  api.deleteItem,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return deleteOperation(doc, "deleteItem", "Delete an itinerary item", "itinerary")
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/expenses",
+			Handler:  api.listExpenses,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listExpenses",
+					Summary:     "List the expenses of a trip",
+					Tags:        []string{"expenses"},
+					Parameters:  listParameters(store.ExpenseListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of expenses", listquery.Page[*store.Expense]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+						openapi.Status(http.StatusNotFound):   problemResponse(doc, "Trip not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/trips/:id/expenses",
+			Handler:  api.createExpense,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createExpense", "Record an expense on a trip", "expenses", ExpenseInput{}, store.Expense{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/trips/:id/expenses/:expense_id",
+			Handler:  api.getExpense,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getExpense", "Get an expense", "expenses", store.Expense{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/trips/:id/expenses/:expense_id",
+			Handler:  api.replaceExpense,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replaceExpense", "Replace an expense", "expenses", ExpenseInput{}, store.Expense{})
+			},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/trips/:id/expenses/:expense_id",
+			Handler:  api.patchExpense,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return patchOperation(doc, "patchExpense", "Update fields of an expense", "expenses", ExpenseInput{}, store.Expense{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/trips/:id/expenses/:expense_id",
+			Handler:  api.deleteExpense,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return deleteOperation(doc, "deleteExpense", "Delete an expense", "expenses")
+			},
+		},
+	}
+}
+
+// listTrips lists the user's trips
+func (api *tripAPI) listTrips(c *gin.Context) {
+	q, err := listquery.Parse(c.Request.URL.Query(), store.TripListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	trips, next, err := api.store.ListTrips(c.Request.Context(), userID(c), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, trips, next)
+}
+
+// createTrip creates a trip owned by the user
+func (api *tripAPI) createTrip(c *gin.Context) {
+	var in TripInput
+	if !bindInput(c, &in, in.validate) {
+		return
+	}
+
+	trip := &store.Trip{OwnerID: userID(c)}
+	in.apply(trip)
+	if err := api.store.CreateTrip(c.Request.Context(), trip); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditTripCreate, "trip", trip.ID, nil, trip)
+	publishEvent(c, api.store, store.EventTripCreated, trip)
+	created(c, trip.ID, trip.Version, trip)
+}
+
+// getTrip returns one of the user's trips
+func (api *tripAPI) getTrip(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	respond(c, trip.Version, trip)
+}
+
+// replaceTrip replaces a trip
+func (api *tripAPI) replaceTrip(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok || !checkIfMatch(c, trip.Version) {
+		return
+	}
+	var in TripInput
+	if !bindInput(c, &in, in.validate) {
+		return
+	}
+
+	before := *trip
+	in.apply(trip)
+	if err := api.store.UpdateTrip(c.Request.Context(), trip); err != nil {
+		problem.Abort(c, storeError(err, "Trip not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditTripUpdate, "trip", trip.ID, &before, trip)
+	publishEvent(c, api.store, store.EventTripUpdated, trip)
+	respond(c, trip.Version, trip)
+}
+
+// patchTrip applies a merge patch or JSON Patch to a trip
+func (api *tripAPI) patchTrip(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok || !checkIfMatch(c, trip.Version) {
+		return
+	}
+	var in TripInput
+	if !patchInput(c, newTripInput(trip), &in, in.validate) {
+		return
+	}
+
+	before := *trip
+	in.apply(trip)
+	if err := api.store.UpdateTrip(c.Request.Context(), trip); err != nil {
+		problem.Abort(c, storeError(err, "Trip not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditTripUpdate, "trip", trip.ID, &before, trip)
+	publishEvent(c, api.store, store.EventTripUpdated, trip)
+	respond(c, trip.Version, trip)
+}
+
+// deleteTrip deletes a trip with its items and expenses
+func (api *tripAPI) deleteTrip(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok || !checkIfMatch(c, trip.Version) {
+		return
+	}
+	if err := api.store.DeleteTrip(c.Request.Context(), trip.ID, trip.Version); err != nil {
+		problem.Abort(c, storeError(err, "Trip not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditTripDelete, "trip", trip.ID, trip, nil)
+	publishEvent(c, api.store, store.EventTripDeleted, trip)
+	c.Status(http.StatusNoContent)
+}
+
+// listItems lists the itinerary items of a trip
+func (api *tripAPI) listItems(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	q, err := listquery.Parse(c.Request.URL.Query(), store.ItemListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	items, next, err := api.store.ListItems(c.Request.Context(), trip.ID, q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, items, next)
+}
+
+// createItem adds an itinerary item to a trip
+func (api *tripAPI) createItem(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	var in ItemInput
+	if !bindInput(c, &in, api.itemValidator(c, &in)) {
+		return
+	}
+
+	item := &store.ItineraryItem{TripID: trip.ID}
+	in.apply(item)
+	if err := api.store.CreateItem(c.Request.Context(), item); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditItemCreate, "item", item.ID, nil, item)
+	publishEvent(c, api.store, store.EventItemCreated, item)
+	created(c, item.ID, item.Version, item)
+}
+
+// getItem returns an itinerary item of a trip
+func (api *tripAPI) getItem(c *gin.Context) {
+	item, ok := api.loadItem(c)
+	if !ok {
+		return
+	}
+	respond(c, item.Version, item)
+}
+
+// replaceItem replaces an itinerary item
+func (api *tripAPI) replaceItem(c *gin.Context) {
+	item, ok := api.loadItem(c)
+	if !ok || !checkIfMatch(c, item.Version) {
+		return
+	}
+	var in ItemInput
+	if !bindInput(c, &in, api.itemValidator(c, &in)) {
+		return
+	}
+
+	before := *item
+	in.apply(item)
+	if err := api.store.UpdateItem(c.Request.Context(), item); err != nil {
+		problem.Abort(c, storeError(err, "Itinerary item not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditItemUpdate, "item", item.ID, &before, item)
+	publishEvent(c, api.store, store.EventItemUpdated, item)
+	respond(c, item.Version, item)
+}
+
+// patchItem applies a merge patch or JSON Patch to an itinerary item
+func (api *tripAPI) patchItem(c *gin.Context) {
+	item, ok := api.loadItem(c)
+	if !ok || !checkIfMatch(c, item.Version) {
+		return
+	}
+	var in ItemInput
+	if !patchInput(c, newItemInput(item), &in, api.itemValidator(c, &in)) {
+		return
+	}
+
+	before := *item
+	in.apply(item)
+	if err := api.store.UpdateItem(c.Request.Context(), item); err != nil {
+		problem.Abort(c, storeError(err, "Itinerary item not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditItemUpdate, "item", item.ID, &before, item)
+	publishEvent(c, api.store, store.EventItemUpdated, item)
+	respond(c, item.Version, item)
+}
+
+// deleteItem deletes an itinerary item
+func (api *tripAPI) deleteItem(c *gin.Context) {
+	item, ok := api.loadItem(c)
+	if !ok || !checkIfMatch(c, item.Version) {
+		return
+	}
+	if err := api.store.DeleteItem(c.Request.Context(), item.ID, item.Version); err != nil {
+		problem.Abort(c, storeError(err, "Itinerary item not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditItemDelete, "item", item.ID, item, nil)
+	publishEvent(c, api.store, store.EventItemDeleted, item)
+	c.Status(http.StatusNoContent)
+}
+
+// listExpenses lists the expenses of a trip
+func (api *tripAPI) listExpenses(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	q, err := listquery.Parse(c.Request.URL.Query(), store.ExpenseListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	expenses, next, err := api.store.ListExpenses(c.Request.Context(), trip.ID, q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, expenses, next)
+}
+
+// createExpense records an expense on a trip
+func (api *tripAPI) createExpense(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	var in ExpenseInput
+	if !bindInput(c, &in, nil) {
+		return
+	}
+
+	expense := &store.Expense{TripID: trip.ID}
+	in.apply(expense)
+	if err := api.store.CreateExpense(c.Request.Context(), expense); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	recordAudit(c, api.store, store.AuditExpenseCreate, "expense", expense.ID, nil, expense)
+	publishEvent(c, api.store, store.EventExpenseAdded, expense)
+	created(c, expense.ID, expense.Version, expense)
+}
+
+// getExpense returns an expense of a trip
+func (api *tripAPI) getExpense(c *gin.Context) {
+	expense, ok := api.loadExpense(c)
+	if !ok {
+		return
+	}
+	respond(c, expense.Version, expense)
+}
+
+// replaceExpense replaces an expense
+func (api *tripAPI) replaceExpense(c *gin.Context) {
+	expense, ok := api.loadExpense(c)
+	if !ok || !checkIfMatch(c, expense.Version) {
+		return
+	}
+	var in ExpenseInput
+	if !bindInput(c, &in, nil) {
+		return
+	}
+
+	before := *expense
+	in.apply(expense)
+	if err := api.store.UpdateExpense(c.Request.Context(), expense); err != nil {
+		problem.Abort(c, storeError(err, "Expense not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditExpenseUpdate, "expense", expense.ID, &before, expense)
+	publishEvent(c, api.store, store.EventExpenseUpdated, expense)
+	respond(c, expense.Version, expense)
+}
+
+// patchExpense applies a merge patch or JSON Patch to an expense
+func (api *tripAPI) patchExpense(c *gin.Context) {
+	expense, ok := api.loadExpense(c)
+	if !ok || !checkIfMatch(c, expense.Version) {
+		return
+	}
+	var in ExpenseInput
+	if !patchInput(c, newExpenseInput(expense), &in, nil) {
+		return
+	}
+
+	before := *expense
+	in.apply(expense)
+	if err := api.store.UpdateExpense(c.Request.Context(), expense); err != nil {
+		problem.Abort(c, storeError(err, "Expense not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditExpenseUpdate, "expense", expense.ID, &before, expense)
+	publishEvent(c, api.store, store.EventExpenseUpdated, expense)
+	respond(c, expense.Version, expense)
+}
+
+// deleteExpense deletes an expense
+func (api *tripAPI) deleteExpense(c *gin.Context) {
+	expense, ok := api.loadExpense(c)
+	if !ok || !checkIfMatch(c, expense.Version) {
+		return
+	}
+	if err := api.store.DeleteExpense(c.Request.Context(), expense.ID, expense.Version); err != nil {
+		problem.Abort(c, storeError(err, "Expense not found"))
+		return
+	}
+	recordAudit(c, api.store, store.AuditExpenseDelete, "expense", expense.ID, expense, nil)
+	publishEvent(c, api.store, store.EventExpenseDeleted, expense)
+	c.Status(http.StatusNoContent)
+}
+
+// loadTrip loads the trip named by the :id parameter, aborting with 404
+// unless it exists and belongs to the user
+func (api *tripAPI) loadTrip(c *gin.Context) (*store.Trip, bool) {
+	trip, err := api.store.GetTrip(c.Request.Context(), c.Param("id"))
+	if err == nil && trip.OwnerID != userID(c) {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.Abort(c, storeError(err, "Trip not found"))
+		return nil, false
+	}
+	return trip, true
+}
+
+// loadItem loads the itinerary item named by :item_id in the trip named by :id
+func (api *tripAPI) loadItem(c *gin.Context) (*store.ItineraryItem, bool) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return nil, false
+	}
+	item, err := api.store.GetItem(c.Request.Context(), trip.ID, c.Param("item_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Itinerary item not found"))
+		return nil, false
+	}
+	return item, true
+}
+
+// loadExpense loads the expense named by :expense_id in the trip named by :id
+func (api *tripAPI) loadExpense(c *gin.Context) (*store.Expense, bool) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return nil, false
+	}
+	expense, err := api.store.GetExpense(c.Request.Context(), trip.ID, c.Param("expense_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Expense not found"))
+		return nil, false
+	}
+	return expense, true
+}
+
+// maxJSONBodySize limits JSON request bodies and patches, leaving room for
+// the longest journal entry written entirely in escaped characters
+const maxJSONBodySize = 1 << 20
+
+// bindInput decodes and validates the JSON body, aborting on failure.
+// validate, if not nil, checks rules spanning several fields.
+func bindInput(c *gin.Context, in any, validate func() error) bool {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodySize)
+	if err := c.ShouldBindJSON(in); err != nil {
+		problem.Abort(c, problem.FromBindError(err))
+		return false
+	}
+	if validate != nil {
+		if err := validate(); err != nil {
+			problem.Abort(c, err)
+			return false
+		}
+	}
+	return true
+}
+
+// patchInput applies the PATCH body to current, the input form of the
+// resource, decoding and validating the result into in; it aborts on failure.
+// validate, if not nil, checks rules spanning several fields.
+func patchInput(c *gin.Context, current, in any, validate func() error) bool {
+	if err := patch.Apply(c, current, in, maxJSONBodySize); err != nil {
+		problem.Abort(c, err)
+		return false
+	}
+	if validate != nil {
+		if err := validate(); err != nil {
+			problem.Abort(c, err)
+			return false
+		}
+	}
+	return true
+}
+
+// checkIfMatch checks If-Match against a resource version. Whether the header
+// is required follows REQUIRE_IF_MATCH.
+func checkIfMatch(c *gin.Context, version int64) bool {
+	cfg := config.FromContext(c)
+	return conditional.CheckIfMatch(c, conditional.ETag(version), cfg == nil || cfg.RequireIfMatch)
+}
+
+// respond writes a versioned resource with its ETag, or 304 if the client has it
+func respond(c *gin.Context, version int64, body any) {
+	if conditional.NotModified(c, conditional.ETag(version)) {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// userID returns the ID of the authenticated user
+func userID(c *gin.Context) string {
+	if user := config.GetUserFromContext(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// storeError maps store.ErrNotFound to 404 and store.ErrVersionConflict to 412
+func storeError(err error, notFoundDetail string) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return problem.NotFound(notFoundDetail)
+	case errors.Is(err, store.ErrVersionConflict):
+		return conditional.Failed()
+	default:
+		return err
+	}
+}
+
+// created responds 201 with the new resource, its Location and ETag
+func created(c *gin.Context, id string, version int64, body any) {
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+id)
+	conditional.SetETag(c, conditional.ETag(version))
+	c.JSON(http.StatusCreated, body)
+}
+
+// listParameters documents the query parameters accepted by a list spec
+func listParameters(spec listquery.Spec) []openapi.Parameter {
+	sorts := make([]any, 0, 2*len(spec.Sorts))
+	for name := range spec.Sorts {
+		sorts = append(sorts, name, "-"+name)
+	}
+	sort.Slice(sorts, func(i, j int) bool { return sorts[i].(string) < sorts[j].(string) })
+
+	params := []openapi.Parameter{
+		{Name: listquery.LimitParam, In: "query", Description: fmt.Sprintf("Page size (default %d)", listquery.DefaultLimit),
+			Schema: &openapi.Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(listquery.MaxLimit))}},
+		{Name: listquery.CursorParam, In: "query", Description: "Opaque cursor from next_cursor of the previous page", Schema: &openapi.Schema{Type: "string"}},
+		{Name: listquery.SortParam, In: "query", Description: "Sort field, prefixed with - for descending (default " + spec.DefaultSort + ")",
+			Schema: &openapi.Schema{Type: "string", Enum: sorts}},
+	}
+
+	names := make([]string, 0, len(spec.Filters))
+	for name := range spec.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := spec.Filters[name]
+		schema := filterSchema(f.Kind)
+		switch f.Op {
+		case listquery.Range:
+			params = append(params,
+				openapi.Parameter{Name: name + listquery.FromSuffix, In: "query", Description: "Lowest " + name + " (inclusive)", Schema: schema},
+				openapi.Parameter{Name: name + listquery.ToSuffix, In: "query", Description: "Highest " + name + " (inclusive)", Schema: schema},
+			)
+		case listquery.Tags:
+			params = append(params, openapi.Parameter{Name: name, In: "query", Description: "Comma-separated tags that must all be present", Schema: schema})
+		default:
+			desc := "Comma-separated " + name + " values to match"
+			if f.Allowed != nil {
+				desc += ": " + strings.Join(f.Allowed, ", ")
+			}
+			params = append(params, openapi.Parameter{Name: name, In: "query", Description: desc, Schema: schema})
+		}
+	}
+	return params
+}
+
+// filterSchema returns the schema of a filter value
+func filterSchema(kind listquery.Kind) *openapi.Schema {
+	switch kind {
+	case listquery.Date:
+		return &openapi.Schema{Type: "string", Format: "date"}
+	case listquery.Time:
+		return &openapi.Schema{Type: "string", Format: "date-time"}
+	case listquery.Int:
+		return &openapi.Schema{Type: "integer"}
+	default:
+		return &openapi.Schema{Type: "string"}
+	}
+}
+
+// pageResponse documents a page of a list endpoint
+func pageResponse(doc *openapi.Document, description string, page any) *openapi.Response {
+	resp := openapi.JSON(description, doc.Schema(page))
+	resp.Headers = map[string]openapi.Header{
+		"Link": {Description: `URL of the next page as rel="next", absent on the last page`, Schema: &openapi.Schema{Type: "string"}},
+	}
+	return resp
+}
+
+// createOperation documents a create endpoint
+func createOperation(doc *openapi.Document, id, summary, tag string, input, output any) openapi.Operation {
+	return openapi.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		RequestBody: openapi.JSONBody(doc.Schema(input)),
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusCreated):               withETag(openapi.JSON("Created", doc.Schema(output))),
+			openapi.Status(http.StatusBadRequest):            problemResponse(doc, "Malformed request body"),
+			openapi.Status(http.StatusNotFound):              problemResponse(doc, "Trip not found"),
+			openapi.Status(http.StatusRequestEntityTooLarge): problemResponse(doc, "Body over 1 MB"),
+			openapi.Status(http.StatusUnprocessableEntity):   problemResponse(doc, "Invalid fields"),
+		},
+	}
+}
+
+// getOperation documents a read endpoint of a versioned resource
+func getOperation(doc *openapi.Document, id, summary, tag string, output any) openapi.Operation {
+	return openapi.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		Parameters: []openapi.Parameter{
+			{Name: conditional.IfNoneMatchHeader, In: "header", Description: "ETag of a cached copy; answered with 304 if still current", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusOK):          withETag(openapi.JSON("Found", doc.Schema(output))),
+			openapi.Status(http.StatusNotModified): {Description: "The cached copy is current"},
+			openapi.Status(http.StatusNotFound):    problemResponse(doc, "Not found"),
+		},
+	}
+}
+
+// replaceOperation documents a PUT endpoint of a versioned resource
+func replaceOperation(doc *openapi.Document, id, summary, tag string, input, output any) openapi.Operation {
+	op := writeOperation(doc, id, summary, tag)
+	op.RequestBody = openapi.JSONBody(doc.Schema(input))
+	op.Responses[openapi.Status(http.StatusOK)] = withETag(openapi.JSON("Replaced", doc.Schema(output)))
+	op.Responses[openapi.Status(http.StatusBadRequest)] = problemResponse(doc, "Malformed request body")
+	op.Responses[openapi.Status(http.StatusRequestEntityTooLarge)] = problemResponse(doc, "Body over 1 MB")
+	op.Responses[openapi.Status(http.StatusUnprocessableEntity)] = problemResponse(doc, "Invalid fields")
+	return op
+}
+
+// patchOperation documents a PATCH endpoint of a versioned resource
+func patchOperation(doc *openapi.Document, id, summary, tag string, input, output any) openapi.Operation {
+	op := writeOperation(doc, id, summary, tag)
+	op.Description = "Accepts a JSON Merge Patch (RFC 7396) of the resource's input fields or a JSON Patch (RFC 6902). " +
+		"The patched resource is validated like a full replacement."
+	op.RequestBody = &openapi.RequestBody{
+		Required: true,
+		Content: map[string]openapi.MediaType{
+			patch.MergePatchContentType: {Schema: doc.Schema(input)},
+			patch.JSONPatchContentType:  {Schema: doc.Schema([]patch.Operation{})},
+		},
+	}
+	op.Responses[openapi.Status(http.StatusOK)] = withETag(openapi.JSON("Updated", doc.Schema(output)))
+	op.Responses[openapi.Status(http.StatusBadRequest)] = problemResponse(doc, "Malformed patch")
+	op.Responses[openapi.Status(http.StatusRequestEntityTooLarge)] = problemResponse(doc, "Patch over 1 MB")
+	op.Responses[openapi.Status(http.StatusUnsupportedMediaType)] = problemResponse(doc, "Not a merge patch or JSON Patch")
+	op.Responses[openapi.Status(http.StatusUnprocessableEntity)] = problemResponse(doc, "Invalid paths or fields in the patched resource")
+	return op
+}
+
+// deleteOperation documents a DELETE endpoint of a versioned resource
+func deleteOperation(doc *openapi.Document, id, summary, tag string) openapi.Operation {
+	op := writeOperation(doc, id, summary, tag)
+	op.Responses[openapi.Status(http.StatusNoContent)] = &openapi.Response{Description: "Deleted"}
+	return op
+}
+
+// writeOperation documents the If-Match precondition shared by PUT, PATCH and DELETE
+func writeOperation(doc *openapi.Document, id, summary, tag string) openapi.Operation {
+	return openapi.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		Parameters: []openapi.Parameter{
+			{Name: conditional.IfMatchHeader, In: "header", Description: "ETag of the version being modified; required unless REQUIRE_IF_MATCH is off", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			openapi.Status(http.StatusNotFound):             problemResponse(doc, "Not found"),
+			openapi.Status(http.StatusPreconditionFailed):   problemResponse(doc, "The resource changed since the given ETag"),
+			openapi.Status(http.StatusPreconditionRequired): problemResponse(doc, "If-Match is missing"),
+		},
+	}
+}
+
+// withETag documents the ETag header of a response
+func withETag(resp *openapi.Response) *openapi.Response {
+	resp.Headers = map[string]openapi.Header{
+		conditional.ETagHeader: {Description: "Strong entity tag of the returned version", Schema: &openapi.Schema{Type: "string"}},
+	}
+	return resp
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
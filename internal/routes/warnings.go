@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/entryrules"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/timezone"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Warning severities, shared with entry requirement findings
+const (
+	// SeverityError means the trip cannot go ahead as planned
+	SeverityError = entryrules.SeverityError
+	// SeverityWarning means something should be looked at before the trip
+	SeverityWarning = entryrules.SeverityWarning
+)
+
+// Warning codes
+const (
+	WarningDocumentExpired       = "document_expired"
+	WarningDocumentExpiresDuring = "document_expires_during_trip"
+	WarningDocumentExpiresSoon   = "document_expires_soon"
+)
+
+// defaultDocumentExpiryWarningMonths applies when no configuration is pinned to the request
+const defaultDocumentExpiryWarningMonths = 6
+
+// Warning is something about a trip that needs the traveller's attention
+type Warning struct {
+	Code        string `json:"code" doc:"Stable machine-readable warning code"`
+	Severity    string `json:"severity" doc:"error if the trip cannot go ahead as planned, otherwise warning"`
+	Message     string `json:"message"`
+	DocumentID  string `json:"document_id,omitempty" doc:"The travel document the warning is about"`
+	Traveller   string `json:"traveller,omitempty" doc:"The traveller an entry requirement warning is about"`
+	Destination string `json:"destination,omitempty" doc:"ISO 3166-1 alpha-2 code of the country an entry requirement warning is about"`
+}
+
+// WarningList is the response of GET /trips/:id/warnings
+type WarningList struct {
+	Warnings []Warning `json:"warnings"`
+}
+
+// documentTypeNames name documents without a name in warnings
+var documentTypeNames = map[string]string{
+	store.DocumentPassport:  "Passport",
+	store.DocumentVisa:      "Visa",
+	store.DocumentIDCard:    "ID card",
+	store.DocumentInsurance: "Insurance policy",
+}
+
+// warningsEndpoint describes GET /trips/:id/warnings
+func (api *tripAPI) warningsEndpoint() apiEndpoint {
+	return apiEndpoint{
+		Method:   http.MethodGet,
+		Path:     "/trips/:id/warnings",
+		Handler:  api.getWarnings,
+		Versions: allVersions,
+		Doc: func(doc *openapi.Document) openapi.Operation {
+			return openapi.Operation{
+				OperationID: "getTripWarnings",
+				Summary:     "List what needs attention before a trip",
+				Description: "Warns about travel documents of the user that expire before the trip ends, or within " +
+					"DOCUMENT_EXPIRY_WARNING_MONTHS after it, and lists the findings of getTripEntryRequirements. " +
+					"Trips that are completed or cancelled have no warnings.",
+				Tags: []string{"trips"},
+				Responses: map[string]*openapi.Response{
+					openapi.Status(http.StatusOK):       openapi.JSON("Warnings, most severe first", doc.Schema(WarningList{})),
+					openapi.Status(http.StatusNotFound): problemResponse(doc, "Trip not found"),
+				},
+			}
+		},
+	}
+}
+
+// getWarnings lists the warnings of a trip
+func (api *tripAPI) getWarnings(c *gin.Context) {
+	trip, ok := api.loadTrip(c)
+	if !ok {
+		return
+	}
+	months := defaultDocumentExpiryWarningMonths
+	if cfg := config.FromContext(c); cfg != nil {
+		months = cfg.DocumentExpiryWarningMonths
+	}
+
+	list := WarningList{Warnings: []Warning{}}
+	if trip.Status == "completed" || trip.Status == "cancelled" {
+		c.JSON(http.StatusOK, list)
+		return
+	}
+	items, err := api.store.AllItems(c.Request.Context(), trip.ID)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	if start, end, ok := tripPeriod(trip, items); ok {
+		warnings, err := api.documentWarnings(c.Request.Context(), trip.OwnerID, start, end, months)
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		list.Warnings = append(list.Warnings, warnings...)
+	}
+
+	checks, err := api.entryChecks(c.Request.Context(), trip, items)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	for _, check := range checks {
+		for _, f := range check.Findings {
+			list.Warnings = append(list.Warnings, Warning{
+				Code:        f.Code,
+				Severity:    f.Severity,
+				Message:     f.Message,
+				DocumentID:  check.DocumentID,
+				Traveller:   check.Traveller,
+				Destination: check.Destination,
+			})
+		}
+	}
+	slices.SortStableFunc(list.Warnings, func(a, b Warning) int {
+		return cmp.Compare(severityRank(a.Severity), severityRank(b.Severity))
+	})
+	c.JSON(http.StatusOK, list)
+}
+
+// severityRank orders errors before warnings
+func severityRank(severity string) int {
+	if severity == SeverityError {
+		return 0
+	}
+	return 1
+}
+
+// documentWarnings warns about documents expiring before a trip starts,
+// during it, or within months after it ends
+func (api *tripAPI) documentWarnings(ctx context.Context, ownerID string, start, end time.Time, months int) ([]Warning, error) {
+	documents, err := api.store.ExpiringDocuments(ctx, ownerID, end.AddDate(0, months, 0).Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []Warning
+	for _, d := range documents {
+		name := d.Name
+		if name == "" {
+			name = fmt.Sprintf("%s (%s)", documentTypeNames[d.Type], d.IssuingCountry)
+		}
+		switch expires := d.ExpiresOn; {
+		case expires < start.Format(time.DateOnly):
+			warnings = append(warnings, Warning{
+				Code:       WarningDocumentExpired,
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("%s expires on %s, before the trip starts", name, expires),
+				DocumentID: d.ID,
+			})
+		case expires < end.Format(time.DateOnly):
+			warnings = append(warnings, Warning{
+				Code:       WarningDocumentExpiresDuring,
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("%s expires on %s, during the trip", name, expires),
+				DocumentID: d.ID,
+			})
+		default:
+			warnings = append(warnings, Warning{
+				Code:       WarningDocumentExpiresSoon,
+				Severity:   SeverityWarning,
+				Message:    fmt.Sprintf("%s expires on %s, less than %d months after the trip ends", name, expires, months),
+				DocumentID: d.ID,
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// tripPeriod returns the first and last day of a trip: its dates if set,
+// otherwise the local dates its items start and end on. A trip with only one
+// known date lasts that day; ok is false if none is known.
+func tripPeriod(trip *store.Trip, items []*store.ItineraryItem) (start, end time.Time, ok bool) {
+	for _, it := range items {
+		first, last := localDay(it), localDay(it)
+		if it.EndsAt != nil {
+			zone := it.TimeZone
+			if it.Flight != nil {
+				zone = it.Flight.Arrival.TimeZone
+			}
+			last = localDate(*it.EndsAt, zone)
+		}
+		if start.IsZero() || first.Before(start) {
+			start = first
+		}
+		if last.After(end) {
+			end = last
+		}
+	}
+	if d, err := time.Parse(time.DateOnly, trip.StartDate); err == nil {
+		start = d
+	}
+	if d, err := time.Parse(time.DateOnly, trip.EndDate); err == nil {
+		end = d
+	}
+	switch {
+	case start.IsZero() && end.IsZero():
+		return time.Time{}, time.Time{}, false
+	case start.IsZero():
+		start = end
+	case end.IsZero():
+		end = start
+	}
+	return start, end, true
+}
+
+// localDate returns midnight UTC of the date t falls on in a time zone, or in UTC if it is unknown
+func localDate(t time.Time, zone string) time.Time {
+	if loc, err := timezone.Load(zone); err == nil {
+		t = t.In(loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,327 @@
+package routes
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/openapi"
+	"vibed-traveller/internal/problem"
+	"vibed-traveller/internal/store"
+	"vibed-traveller/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookInput is the body of webhook create and replace requests
+type WebhookInput struct {
+	URL         string   `json:"url" binding:"required,http_url,max=2048"`
+	Description string   `json:"description" binding:"max=200"`
+	Events      []string `json:"events" binding:"required,min=1,max=20,dive,oneof=trip.created trip.updated trip.deleted item.created item.updated item.deleted expense.added expense.updated expense.deleted"`
+	Active      *bool    `json:"active" doc:"Whether events are delivered (default true)"`
+}
+
+// apply copies the input onto a webhook
+func (in *WebhookInput) apply(w *store.Webhook) {
+	w.URL = in.URL
+	w.Description = in.Description
+	w.Events = in.Events
+	w.Active = in.Active == nil || *in.Active
+}
+
+// CreatedWebhook is a new webhook with its signing secret, which is not shown again
+type CreatedWebhook struct {
+	store.Webhook
+	Secret string `json:"secret" doc:"HMAC-SHA256 key of the Webhook-Signature header"`
+}
+
+// webhookValidator returns the validation of a webhook: its host must not
+// be on the server's own networks, unless WEBHOOK_ALLOW_PRIVATE is set
+func webhookValidator(c *gin.Context, in *WebhookInput) func() error {
+	return func() error {
+		if cfg := config.FromContext(c); cfg != nil && cfg.WebhookAllowPrivate {
+			return nil
+		}
+		err := webhooks.CheckURL(c.Request.Context(), in.URL)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, webhooks.ErrForbiddenAddress):
+			return problem.Validation(problem.FieldError{Field: "url", Code: "forbidden_address",
+				Message: "must not point to a loopback, link-local or private address"})
+		default:
+			return problem.Validation(problem.FieldError{Field: "url", Code: "unresolvable", Message: "has a host that could not be resolved"})
+		}
+	}
+}
+
+// publishEvent queues a webhook event about a resource of the authenticated
+// user. The change has already happened, so failures are logged, not returned.
+func publishEvent(c *gin.Context, st *store.Store, eventType string, data any) {
+	if err := st.EnqueueWebhookEvent(c.Request.Context(), userID(c), eventType, data); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to queue webhook event", slog.String("event_type", eventType), slog.Any("error", err))
+	}
+}
+
+// webhookAPI serves the user's webhooks and their deliveries
+type webhookAPI struct {
+	store *store.Store
+}
+
+// webhookEndpoints lists the webhook endpoints
+func webhookEndpoints(st *store.Store) []apiEndpoint {
+	api := &webhookAPI{store: st}
+	return []apiEndpoint{
+		{
+			Method:   http.MethodGet,
+			Path:     "/webhooks",
+			Handler:  api.list,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listWebhooks",
+					Summary:     "List the user's webhooks",
+					Tags:        []string{"webhooks"},
+					Parameters:  listParameters(store.WebhookListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of webhooks", listquery.Page[*store.Webhook]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/webhooks",
+			Handler:  api.create,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return createOperation(doc, "createWebhook", "Register a webhook", "webhooks", WebhookInput{}, CreatedWebhook{})
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/webhooks/:id",
+			Handler:  api.get,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return getOperation(doc, "getWebhook", "Get a webhook", "webhooks", store.Webhook{})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Path:     "/webhooks/:id",
+			Handler:  api.replace,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return replaceOperation(doc, "replaceWebhook", "Replace a webhook", "webhooks", WebhookInput{}, store.Webhook{})
+			},
+		},
+		{
+			Method:   http.MethodDelete,
+			Path:     "/webhooks/:id",
+			Handler:  api.delete,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return deleteOperation(doc, "deleteWebhook", "Delete a webhook and its delivery log", "webhooks")
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/webhooks/:id/deliveries",
+			Handler:  api.listDeliveries,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "listWebhookDeliveries",
+					Summary:     "List the deliveries of a webhook",
+					Tags:        []string{"webhooks"},
+					Parameters:  listParameters(store.DeliveryListSpec),
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):         pageResponse(doc, "Page of deliveries", listquery.Page[*store.WebhookDelivery]{}),
+						openapi.Status(http.StatusBadRequest): problemResponse(doc, "Invalid query parameters"),
+						openapi.Status(http.StatusNotFound):   problemResponse(doc, "Webhook not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/webhooks/:id/deliveries/:delivery_id",
+			Handler:  api.getDelivery,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "getWebhookDelivery",
+					Summary:     "Get a delivery with every attempt and its response code",
+					Tags:        []string{"webhooks"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusOK):       openapi.JSON("Delivery", doc.Schema(store.WebhookDelivery{})),
+						openapi.Status(http.StatusNotFound): problemResponse(doc, "Webhook or delivery not found"),
+					},
+				}
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/:id/deliveries/:delivery_id/redeliver",
+			Handler:  api.redeliver,
+			Versions: allVersions,
+			Doc: func(doc *openapi.Document) openapi.Operation {
+				return openapi.Operation{
+					OperationID: "redeliverWebhook",
+					Summary:     "Queue the event of a delivery again",
+					Description: "Creates a new delivery with the same event ID and payload, sent as soon as the queue reaches it.",
+					Tags:        []string{"webhooks"},
+					Responses: map[string]*openapi.Response{
+						openapi.Status(http.StatusAccepted): openapi.JSON("Delivery queued", doc.Schema(store.WebhookDelivery{})),
+						openapi.Status(http.StatusNotFound): problemResponse(doc, "Webhook or delivery not found"),
+					},
+				}
+			},
+		},
+	}
+}
+
+// list lists the user's webhooks
+func (api *webhookAPI) list(c *gin.Context) {
+	q, err := listquery.Parse(c.Request.URL.Query(), store.WebhookListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	hooks, next, err := api.store.ListWebhooks(c.Request.Context(), userID(c), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, hooks, next)
+}
+
+// create registers a webhook, returning its signing secret once
+func (api *webhookAPI) create(c *gin.Context) {
+	var in WebhookInput
+	if !bindInput(c, &in, webhookValidator(c, &in)) {
+		return
+	}
+
+	hook := &store.Webhook{OwnerID: userID(c)}
+	in.apply(hook)
+	if err := api.store.CreateWebhook(c.Request.Context(), hook); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	created(c, hook.ID, hook.Version, CreatedWebhook{Webhook: *hook, Secret: hook.Secret})
+}
+
+// get returns one of the user's webhooks
+func (api *webhookAPI) get(c *gin.Context) {
+	hook, ok := api.load(c)
+	if !ok {
+		return
+	}
+	respond(c, hook.Version, hook)
+}
+
+// replace replaces a webhook, keeping its secret
+func (api *webhookAPI) replace(c *gin.Context) {
+	hook, ok := api.load(c)
+	if !ok || !checkIfMatch(c, hook.Version) {
+		return
+	}
+	var in WebhookInput
+	if !bindInput(c, &in, webhookValidator(c, &in)) {
+		return
+	}
+
+	in.apply(hook)
+	if err := api.store.UpdateWebhook(c.Request.Context(), hook); err != nil {
+		problem.Abort(c, storeError(err, "Webhook not found"))
+		return
+	}
+	respond(c, hook.Version, hook)
+}
+
+// delete deletes a webhook and its deliveries
+func (api *webhookAPI) delete(c *gin.Context) {
+	hook, ok := api.load(c)
+	if !ok || !checkIfMatch(c, hook.Version) {
+		return
+	}
+	if err := api.store.DeleteWebhook(c.Request.Context(), hook.ID, hook.Version); err != nil {
+		problem.Abort(c, storeError(err, "Webhook not found"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listDeliveries lists the deliveries of a webhook, newest first by default
+func (api *webhookAPI) listDeliveries(c *gin.Context) {
+	hook, ok := api.load(c)
+	if !ok {
+		return
+	}
+	q, err := listquery.Parse(c.Request.URL.Query(), store.DeliveryListSpec)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	deliveries, next, err := api.store.ListDeliveries(c.Request.Context(), hook.ID, q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	listquery.Respond(c, deliveries, next)
+}
+
+// getDelivery returns a delivery with its attempts
+func (api *webhookAPI) getDelivery(c *gin.Context) {
+	delivery, ok := api.loadDelivery(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+// redeliver queues a delivery's event again
+func (api *webhookAPI) redeliver(c *gin.Context) {
+	delivery, ok := api.loadDelivery(c)
+	if !ok {
+		return
+	}
+	queued, err := api.store.Redeliver(c.Request.Context(), delivery)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, queued)
+}
+
+// load loads the webhook named by :id, aborting with 404 unless it belongs to the user
+func (api *webhookAPI) load(c *gin.Context) (*store.Webhook, bool) {
+	hook, err := api.store.GetWebhook(c.Request.Context(), c.Param("id"))
+	if err == nil && hook.OwnerID != userID(c) {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.Abort(c, storeError(err, "Webhook not found"))
+		return nil, false
+	}
+	return hook, true
+}
+
+// loadDelivery loads the delivery named by :delivery_id of the webhook named by :id
+func (api *webhookAPI) loadDelivery(c *gin.Context) (*store.WebhookDelivery, bool) {
+	hook, ok := api.load(c)
+	if !ok {
+		return nil, false
+	}
+	delivery, err := api.store.GetDelivery(c.Request.Context(), hook.ID, c.Param("delivery_id"))
+	if err != nil {
+		problem.Abort(c, storeError(err, "Delivery not found"))
+		return nil, false
+	}
+	return delivery, true
+}
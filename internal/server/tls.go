@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"vibed-traveller/internal/filestamp"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloadDebounce coalesces the write events of a certificate renewal into one reload
+const certReloadDebounce = time.Second
+
+// CertReloader serves a TLS certificate that is reloaded from disk whenever
+// the certificate or key file changes, so renewals need no restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader loads the certificate pair, failing if it is unusable
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate pair from disk and swaps it in
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// TLSConfig returns a server TLS configuration using the reloadable certificate
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Watch reloads the certificate when either file changes, until ctx is cancelled.
+// A pair that fails to load (e.g. mid-renewal) leaves the current certificate in place.
+func (r *CertReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating certificate watcher: %w", err)
+	}
+
+	// Watch parent directories and look at the files again on every event
+	// there, so atomic replacements (e.g. certbot or Kubernetes secrets
+	// swapping a symlink) are seen
+	stamps := filestamp.New(r.certFile, r.keyFile)
+	watched := map[string]bool{}
+	for _, abs := range stamps.Paths() {
+		watched[abs] = true
+		if err := watcher.Add(filepath.Dir(abs)); err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("watching %s: %w", filepath.Dir(abs), err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				abs, _ := filepath.Abs(event.Name)
+				if stamps.Changed() || watched[abs] {
+					debounce = time.After(certReloadDebounce)
+				}
+			case <-debounce:
+				if err := r.reload(); err != nil {
+					slog.Error("TLS certificate reload failed, keeping current certificate", slog.Any("error", err))
+					continue
+				}
+				slog.Info("TLS certificate reloaded", slog.String("cert_file", r.certFile))
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("TLS certificate watcher error", slog.Any("error", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RedirectHandler redirects plain HTTP requests to the same URL over HTTPS on httpsPort
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(w, req, target, http.StatusPermanentRedirect)
+	})
+}
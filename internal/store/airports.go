@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Airport types kept from OurAirports data, busiest first
+const (
+	AirportLarge  = "large_airport"
+	AirportMedium = "medium_airport"
+	AirportSmall  = "small_airport"
+)
+
+// AirportTypes are the imported airport types
+var AirportTypes = []string{AirportLarge, AirportMedium, AirportSmall}
+
+// Airport is an airport of the reference database
+type Airport struct {
+	Ident            string  `json:"ident" doc:"OurAirports identifier, usually the ICAO code"`
+	IATA             string  `json:"iata,omitempty"`
+	ICAO             string  `json:"icao,omitempty"`
+	Type             string  `json:"type"`
+	Name             string  `json:"name"`
+	City             string  `json:"city,omitempty"`
+	CountryCode      string  `json:"country_code" doc:"ISO 3166-1 alpha-2 code"`
+	RegionCode       string  `json:"region_code,omitempty" doc:"ISO 3166-2 code"`
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	ElevationFT      *int64  `json:"elevation_ft,omitempty"`
+	TimeZone         string  `json:"time_zone,omitempty" doc:"IANA time zone"`
+	ScheduledService bool    `json:"scheduled_service"`
+}
+
+// Code returns the code travellers know the airport by: IATA if it has one
+func (a *Airport) Code() string {
+	if a.IATA != "" {
+		return a.IATA
+	}
+	if a.ICAO != "" {
+		return a.ICAO
+	}
+	return a.Ident
+}
+
+// Airline is an airline of the reference database
+type Airline struct {
+	IATA     string `json:"iata,omitempty"`
+	ICAO     string `json:"icao,omitempty"`
+	Name     string `json:"name"`
+	Callsign string `json:"callsign,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Active   bool   `json:"active"`
+}
+
+// Code returns the airline's IATA code, or its ICAO code if it has none
+func (a *Airline) Code() string {
+	if a.IATA != "" {
+		return a.IATA
+	}
+	return a.ICAO
+}
+
+const airportColumns = `ident, iata, icao, type, name, city, country_code, region_code, latitude, longitude,
+	elevation_ft, time_zone, scheduled_service`
+
+// airportRank orders airports sharing a code or matching a search, busiest first
+const airportRank = `scheduled_service DESC,
+	CASE type WHEN 'large_airport' THEN 0 WHEN 'medium_airport' THEN 1 ELSE 2 END`
+
+// scanAirport reads a row selected with airportColumns
+func scanAirport(row rowScanner) (*Airport, error) {
+	var (
+		a         Airport
+		elevation sql.NullInt64
+	)
+	if err := row.Scan(&a.Ident, &a.IATA, &a.ICAO, &a.Type, &a.Name, &a.City, &a.CountryCode, &a.RegionCode,
+		&a.Latitude, &a.Longitude, &elevation, &a.TimeZone, &a.ScheduledService); err != nil {
+		return nil, notFound(err)
+	}
+	if elevation.Valid {
+		a.ElevationFT = &elevation.Int64
+	}
+	return &a, nil
+}
+
+// AirportImport writes imported airports in a single transaction
+type AirportImport struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	upsert *sql.Stmt
+}
+
+// BeginAirportImport starts an import; call Commit to keep it or Rollback to discard it
+func (s *Store) BeginAirportImport(ctx context.Context) (*AirportImport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning airport import: %w", err)
+	}
+	upsert, err := tx.PrepareContext(ctx, `
+		INSERT INTO airports (`+airportColumns+`, search) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ident) DO UPDATE SET iata = excluded.iata, icao = excluded.icao, type = excluded.type,
+			name = excluded.name, city = excluded.city, country_code = excluded.country_code,
+			region_code = excluded.region_code, latitude = excluded.latitude, longitude = excluded.longitude,
+			elevation_ft = excluded.elevation_ft, time_zone = excluded.time_zone,
+			scheduled_service = excluded.scheduled_service, search = excluded.search`)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("preparing airport import: %w", err)
+	}
+	return &AirportImport{ctx: ctx, tx: tx, upsert: upsert}, nil
+}
+
+// AddAirport stores an airport. search holds the normalized words it is found by.
+func (imp *AirportImport) AddAirport(a *Airport, search string) error {
+	_, err := imp.upsert.ExecContext(imp.ctx, a.Ident, a.IATA, a.ICAO, a.Type, a.Name, a.City, a.CountryCode,
+		a.RegionCode, a.Latitude, a.Longitude, a.ElevationFT, a.TimeZone, a.ScheduledService, " "+search)
+	if err != nil {
+		return fmt.Errorf("importing airport %s: %w", a.Ident, err)
+	}
+	return nil
+}
+
+// Commit keeps the imported data
+func (imp *AirportImport) Commit() error {
+	return imp.tx.Commit()
+}
+
+// Rollback discards the imported data
+func (imp *AirportImport) Rollback() error {
+	return imp.tx.Rollback()
+}
+
+// GetAirport returns the airport with an IATA (3 characters) or ICAO code,
+// or OurAirports identifier. Shared IATA codes resolve to the busiest airport.
+func (s *Store) GetAirport(ctx context.Context, code string) (*Airport, error) {
+	code = strings.ToUpper(code)
+	query := `SELECT ` + airportColumns + ` FROM airports WHERE icao = ? OR ident = ? ORDER BY ` + airportRank + ` LIMIT 1`
+	args := []any{code, code}
+	if len(code) == 3 {
+		query = `SELECT ` + airportColumns + ` FROM airports WHERE iata = ? ORDER BY ` + airportRank + ` LIMIT 1`
+		args = args[:1]
+	}
+	return scanAirport(s.db.QueryRowContext(ctx, query, args...))
+}
+
+// SearchAirports returns up to limit airports whose code is code, or whose
+// search words start with the normalized words of text, code matches and
+// busy airports first. countryCode, if not empty, restricts the results.
+func (s *Store) SearchAirports(ctx context.Context, code, text, countryCode string, limit int) ([]*Airport, error) {
+	code = strings.ToUpper(code)
+	conds := []string{"(iata = ? OR icao = ? OR ident = ?)"}
+	args := []any{code, code, code}
+	var words []string
+	for _, word := range strings.Fields(text) {
+		words = append(words, "search LIKE ?")
+		args = append(args, "% "+word+"%")
+	}
+	if len(words) > 0 {
+		conds = append(conds, "("+strings.Join(words, " AND ")+")")
+	}
+	where := "(" + strings.Join(conds, " OR ") + ")"
+	if countryCode != "" {
+		where += " AND country_code = ?"
+		args = append(args, strings.ToUpper(countryCode))
+	}
+	args = append(args, code, code, code, limit)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+airportColumns+` FROM airports
+		WHERE `+where+`
+		ORDER BY (iata = ? OR icao = ? OR ident = ?) DESC, `+airportRank+`, name
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching airports: %w", err)
+	}
+	defer rows.Close()
+
+	airports := []*Airport{}
+	for rows.Next() {
+		a, err := scanAirport(rows)
+		if err != nil {
+			return nil, err
+		}
+		airports = append(airports, a)
+	}
+	return airports, rows.Err()
+}
+
+// ReplaceAirlines replaces the airline table in a single transaction
+func (s *Store) ReplaceAirlines(ctx context.Context, airlines []*Airline) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM airlines`); err != nil {
+			return fmt.Errorf("clearing airlines: %w", err)
+		}
+		stmt, err := tx.PrepareContext(ctx, `INSERT INTO airlines (iata, icao, name, callsign, country, active) VALUES (?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return fmt.Errorf("preparing airline import: %w", err)
+		}
+		defer stmt.Close()
+		for _, a := range airlines {
+			if _, err := stmt.ExecContext(ctx, a.IATA, a.ICAO, a.Name, a.Callsign, a.Country, a.Active); err != nil {
+				return fmt.Errorf("importing airline %s: %w", a.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetAirline returns the airline with an IATA (2 characters) or ICAO (3
+// characters) code. Codes reused over time resolve to the active airline.
+func (s *Store) GetAirline(ctx context.Context, code string) (*Airline, error) {
+	column := "icao"
+	if len(code) == 2 {
+		column = "iata"
+	}
+	var a Airline
+	err := s.db.QueryRowContext(ctx, `
+		SELECT iata, icao, name, callsign, country, active FROM airlines
+		WHERE `+column+` = ? ORDER BY active DESC, id LIMIT 1`, strings.ToUpper(code)).
+		Scan(&a.IATA, &a.ICAO, &a.Name, &a.Callsign, &a.Country, &a.Active)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is a file attached to a trip or one of its itinerary items. Its
+// content is a blob, shared by every attachment with the same content.
+type Attachment struct {
+	ID          string    `json:"id"`
+	TripID      string    `json:"trip_id"`
+	ItemID      string    `json:"item_id,omitempty" doc:"Itinerary item the file is attached to; absent for files attached to the trip itself"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type" doc:"Detected from the content, not from the upload"`
+	Size        int64     `json:"size" doc:"In bytes"`
+	SHA256      string    `json:"sha256" doc:"Hex-encoded SHA-256 of the content"`
+	Photo       *Photo    `json:"photo,omitempty" doc:"What was read from a JPEG, PNG or GIF image on upload"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentListSpec describes the sorting and filtering of attachment lists
+var AttachmentListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+		"filename":   {Column: "filename", Kind: listquery.String},
+		"size":       {Column: "size", Kind: listquery.Int},
+	},
+	DefaultSort: "created_at",
+	Filters: map[string]listquery.Filter{
+		"item_id":      {Column: "item_id", Op: listquery.In},
+		"content_type": {Column: "content_type", Op: listquery.In},
+	},
+}
+
+const attachmentColumns = `id, trip_id, item_id, blob_hash, filename, content_type, size, created_at`
+
+// scanAttachment reads a row selected with attachmentColumns
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var (
+		a      Attachment
+		itemID sql.NullString
+	)
+	if err := row.Scan(&a.ID, &a.TripID, &itemID, &a.SHA256, &a.Filename, &a.ContentType, &a.Size, &a.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	a.ItemID = itemID.String
+	return &a, nil
+}
+
+// BlobStored reports whether the content with the given hash is stored
+func (s *Store) BlobStored(ctx context.Context, hash string) (bool, error) {
+	var stored bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM blobs WHERE hash = ?)`, hash).Scan(&stored); err != nil {
+		return false, fmt.Errorf("looking up blob: %w", err)
+	}
+	return stored, nil
+}
+
+// CreateAttachment stores a new attachment, assigning its ID and creation
+// time, and records its content and photo details if no other attachment
+// shares it
+func (s *Store) CreateAttachment(ctx context.Context, a *Attachment) error {
+	a.ID = uuid.NewString()
+	a.CreatedAt = time.Now().UTC()
+	var itemID any
+	if a.ItemID != "" {
+		itemID = a.ItemID
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO blobs (hash, size, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (hash) DO NOTHING`, a.SHA256, a.Size, a.CreatedAt); err != nil {
+			return fmt.Errorf("recording blob: %w", err)
+		}
+		if err := insertPhoto(ctx, tx, a.SHA256, a.Photo); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO attachments (`+attachmentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.ID, a.TripID, itemID, a.SHA256, a.Filename, a.ContentType, a.Size, a.CreatedAt); err != nil {
+			return fmt.Errorf("creating attachment: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetAttachment returns an attachment of a trip
+func (s *Store) GetAttachment(ctx context.Context, tripID, id string) (*Attachment, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE trip_id = ? AND id = ?`, tripID, id)
+	return s.scanAttachmentWithPhoto(ctx, row)
+}
+
+// AttachmentByID returns an attachment of any trip
+func (s *Store) AttachmentByID(ctx context.Context, id string) (*Attachment, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id)
+	return s.scanAttachmentWithPhoto(ctx, row)
+}
+
+// scanAttachmentWithPhoto reads an attachment row and its photo details
+func (s *Store) scanAttachmentWithPhoto(ctx context.Context, row rowScanner) (*Attachment, error) {
+	a, err := scanAttachment(row)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadPhotos(ctx, []*Attachment{a}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListAttachments returns one page of the attachments of a trip, or of one of
+// its items if itemID is not empty, and the cursor of the next page
+func (s *Store) ListAttachments(ctx context.Context, tripID, itemID string, q *listquery.Query) ([]*Attachment, string, error) {
+	scope, args := "attachments.trip_id = ?", []any{tripID}
+	if itemID != "" {
+		scope, args = scope+" AND attachments.item_id = ?", append(args, itemID)
+	}
+	attached, next, err := listPage(ctx, s.db, "attachments", attachmentColumns, scope, args, q, scanAttachment,
+		func(a *Attachment) string { return a.ID })
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.loadPhotos(ctx, attached); err != nil {
+		return nil, "", err
+	}
+	return attached, next, nil
+}
+
+// DeleteAttachment deletes an attachment. Its content stays stored until
+// ReleaseBlob finds it unused.
+func (s *Store) DeleteAttachment(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM attachments WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting attachment: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseBlob forgets the content with the given hash unless an attachment
+// still uses it, and reports whether it did
+func (s *Store) ReleaseBlob(ctx context.Context, hash string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM blobs WHERE hash = ? AND NOT EXISTS (SELECT 1 FROM attachments WHERE blob_hash = blobs.hash)`, hash)
+	if err != nil {
+		return false, fmt.Errorf("releasing blob: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnusedBlobs returns up to limit hashes of content no attachment uses any
+// more, left behind by deleted trips and items
+func (s *Store) UnusedBlobs(ctx context.Context, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT hash FROM blobs
+		WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE blob_hash = blobs.hash)
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing unused blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// Audited actions
+const (
+	AuditLogin          = "auth.login"
+	AuditLogout         = "auth.logout"
+	AuditTokenRejected  = "auth.token_rejected"
+	AuditRoleChange     = "user.role_change"
+	AuditUserDisable    = "user.disable"
+	AuditUserEnable     = "user.enable"
+	AuditSessionsRevoke = "user.sessions_revoke"
+	AuditTripCreate     = "trip.create"
+	AuditTripUpdate     = "trip.update"
+	AuditTripDelete     = "trip.delete"
+	AuditItemCreate     = "item.create"
+	AuditItemUpdate     = "item.update"
+	AuditItemDelete     = "item.delete"
+	AuditExpenseCreate  = "expense.create"
+	AuditExpenseUpdate  = "expense.update"
+	AuditExpenseDelete  = "expense.delete"
+	AuditTrackImport    = "track.import"
+	AuditTrackDelete    = "track.delete"
+	AuditWaypointDelete = "waypoint.delete"
+	AuditDocumentCreate = "document.create"
+	AuditDocumentUpdate = "document.update"
+	AuditDocumentDelete = "document.delete"
+
+	AuditEntryRuleCreate = "entry_rule.create"
+	AuditEntryRuleDelete = "entry_rule.delete"
+	AuditEntryRuleImport = "entry_rule.import"
+
+	AuditAttachmentCreate = "attachment.create"
+	AuditAttachmentDelete = "attachment.delete"
+	AuditAttachmentLink   = "attachment.link"
+
+	AuditJournalCreate = "journal.create"
+	AuditJournalUpdate = "journal.update"
+	AuditJournalDelete = "journal.delete"
+)
+
+// AuditActions lists every audited action
+var AuditActions = []string{
+	AuditLogin, AuditLogout, AuditTokenRejected,
+	AuditRoleChange, AuditUserDisable, AuditUserEnable, AuditSessionsRevoke,
+	AuditTripCreate, AuditTripUpdate, AuditTripDelete,
+	AuditItemCreate, AuditItemUpdate, AuditItemDelete,
+	AuditExpenseCreate, AuditExpenseUpdate, AuditExpenseDelete,
+	AuditTrackImport, AuditTrackDelete, AuditWaypointDelete,
+	AuditDocumentCreate, AuditDocumentUpdate, AuditDocumentDelete,
+	AuditEntryRuleCreate, AuditEntryRuleDelete, AuditEntryRuleImport,
+	AuditAttachmentCreate, AuditAttachmentDelete, AuditAttachmentLink,
+	AuditJournalCreate, AuditJournalUpdate, AuditJournalDelete,
+}
+
+// CLIActor is the actor of changes made with the admin commands
+const CLIActor = "cli"
+
+// AuditChange is the value of one field before and after a change. Before is
+// absent for created records and After for deleted ones.
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditEvent is an entry of the append-only audit log
+type AuditEvent struct {
+	ID         string                 `json:"id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Action     string                 `json:"action"`
+	ActorID    string                 `json:"actor_id,omitempty" doc:"User who acted, or cli; empty when unknown"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   string                 `json:"target_id,omitempty"`
+	Changes    map[string]AuditChange `json:"changes,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"`
+}
+
+// AuditListSpec whitelists the sorts and filters of audit log listings
+var AuditListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"occurred_at": {Column: "occurred_at", Kind: listquery.Time},
+	},
+	DefaultSort: "-occurred_at",
+	Filters: map[string]listquery.Filter{
+		"action":      {Column: "action", Op: listquery.In, Allowed: AuditActions},
+		"actor_id":    {Column: "actor_id", Op: listquery.In},
+		"target_type": {Column: "target_type", Op: listquery.In},
+		"target_id":   {Column: "target_id", Op: listquery.In},
+		"request_id":  {Column: "request_id", Op: listquery.In},
+		"occurred_at": {Column: "occurred_at", Op: listquery.Range, Kind: listquery.Time},
+	},
+}
+
+const auditColumns = `id, occurred_at, action, actor_id, target_type, target_id, changes, detail, ip, user_agent, request_id`
+
+// scanAuditEvent reads a row selected with auditColumns
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	var (
+		e       AuditEvent
+		changes string
+	)
+	if err := row.Scan(&e.ID, &e.OccurredAt, &e.Action, &e.ActorID, &e.TargetType, &e.TargetID, &changes,
+		&e.Detail, &e.IP, &e.UserAgent, &e.RequestID); err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+		return nil, fmt.Errorf("decoding audit changes: %w", err)
+	}
+	return &e, nil
+}
+
+// RecordAudit appends an event to the audit log, assigning its ID and time
+func (s *Store) RecordAudit(ctx context.Context, e *AuditEvent) error {
+	e.ID = uuid.NewString()
+	e.OccurredAt = time.Now().UTC()
+
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return fmt.Errorf("encoding audit changes: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.OccurredAt, e.Action, e.ActorID, e.TargetType, e.TargetID, string(changes),
+		e.Detail, e.IP, e.UserAgent, e.RequestID)
+	if err != nil {
+		return fmt.Errorf("recording audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns one page of audit events and the cursor of the next page
+func (s *Store) ListAudit(ctx context.Context, q *listquery.Query) ([]*AuditEvent, string, error) {
+	return listPage(ctx, s.db, "audit_log", auditColumns, "1 = 1", nil, q, scanAuditEvent,
+		func(e *AuditEvent) string { return e.ID })
+}
+
+// ExportAudit calls fn with every audit event matching q's filters, in q's
+// order. Limit and cursor are ignored, so the whole log can be streamed.
+func (s *Store) ExportAudit(ctx context.Context, q *listquery.Query, fn func(*AuditEvent) error) error {
+	where := []string{"1 = 1"}
+	var args []any
+	for _, cond := range q.Filters {
+		clause, condArgs := conditionSQL("audit_log", cond)
+		where = append(where, clause)
+		args = append(args, condArgs...)
+	}
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM audit_log WHERE %s ORDER BY %s %s, id %s`,
+		auditColumns, strings.Join(where, " AND "), q.Sort.Column, direction, direction)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exporting audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// AuditDiff compares the JSON forms of two versions of a record field by
+// field. before is nil for created records and after for deleted ones.
+func AuditDiff(before, after any) (map[string]AuditChange, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+	for field, value := range b {
+		if other, ok := a[field]; !ok || !bytes.Equal(value, other) {
+			changes[field] = AuditChange{Before: value, After: other}
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// jsonFields returns the top-level fields of v's JSON object form
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if v == nil {
+		return fields, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding audited record: %w", err)
+	}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, fmt.Errorf("decoding audited record: %w", err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,423 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/vault"
+
+	"github.com/google/uuid"
+)
+
+// Document types
+const (
+	DocumentPassport  = "passport"
+	DocumentVisa      = "visa"
+	DocumentIDCard    = "id_card"
+	DocumentInsurance = "insurance"
+)
+
+// DocumentTypes are the allowed travel document types
+var DocumentTypes = []string{DocumentPassport, DocumentVisa, DocumentIDCard, DocumentInsurance}
+
+// Document is a passport, visa, ID card or insurance policy of a user. The
+// number, notes and scan are encrypted at rest; dates and the issuing country
+// are not, so that expiries can be checked without the key.
+type Document struct {
+	ID             string        `json:"id"`
+	OwnerID        string        `json:"owner_id"`
+	Type           string        `json:"type"`
+	Name           string        `json:"name"`
+	Number         string        `json:"number"`
+	IssuingCountry string        `json:"issuing_country" doc:"ISO 3166-1 alpha-2 code"`
+	IssuedOn       string        `json:"issued_on,omitempty"`
+	ExpiresOn      string        `json:"expires_on,omitempty"`
+	Notes          string        `json:"notes"`
+	Scan           *DocumentScan `json:"scan,omitempty" doc:"The uploaded scan, if any"`
+	Version        int64         `json:"version"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// DocumentScan describes the scan of a document
+type DocumentScan struct {
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// DocumentListSpec whitelists the sorts and filters of document listings
+var DocumentListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"name":       {Column: "name", Kind: listquery.String},
+		"expires_on": {Column: "expires_on", Kind: listquery.Date},
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "expires_on",
+	Filters: map[string]listquery.Filter{
+		"type":            {Column: "type", Op: listquery.In, Allowed: DocumentTypes},
+		"issuing_country": {Column: "issuing_country", Op: listquery.In},
+		"expires_on":      {Column: "expires_on", Op: listquery.Range, Kind: listquery.Date},
+	},
+}
+
+// documentColumns are followed by the sealed columns, number and notes
+const documentColumns = `id, owner_id, type, name, issuing_country, issued_on, expires_on,
+	scan_content_type, scan_size, scan_uploaded_at, version, created_at, updated_at, number, notes`
+
+// documentAD is the additional data sealed document fields are bound to, so
+// that a value copied to another document or field fails to decrypt
+func documentAD(id, field string) []byte {
+	return []byte("document/" + id + "/" + field)
+}
+
+// sealDocumentField encrypts a document field; empty values are stored as NULL
+func sealDocumentField(kr *vault.Keyring, id, field, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	sealed, err := kr.Seal([]byte(value), documentAD(id, field))
+	if err != nil {
+		return nil, fmt.Errorf("encrypting document %s: %w", field, err)
+	}
+	return sealed, nil
+}
+
+// openDocumentField decrypts a document field
+func openDocumentField(kr *vault.Keyring, id, field string, sealed []byte) (string, error) {
+	if sealed == nil {
+		return "", nil
+	}
+	plain, err := kr.Open(sealed, documentAD(id, field))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s of document %s: %w", field, id, err)
+	}
+	return string(plain), nil
+}
+
+// scanDocument reads a row selected with documentColumns. The sealed fields
+// are decrypted with kr, or left empty if kr is nil.
+func scanDocument(row rowScanner, kr *vault.Keyring) (*Document, error) {
+	var (
+		d             Document
+		scanType      sql.NullString
+		scanSize      sql.NullInt64
+		scanUploaded  sql.NullTime
+		number, notes []byte
+	)
+	if err := row.Scan(&d.ID, &d.OwnerID, &d.Type, &d.Name, &d.IssuingCountry, &d.IssuedOn, &d.ExpiresOn,
+		&scanType, &scanSize, &scanUploaded, &d.Version, &d.CreatedAt, &d.UpdatedAt, &number, &notes); err != nil {
+		return nil, notFound(err)
+	}
+	if scanType.Valid {
+		d.Scan = &DocumentScan{ContentType: scanType.String, Size: scanSize.Int64, UploadedAt: scanUploaded.Time}
+	}
+	if kr == nil {
+		return &d, nil
+	}
+	var err error
+	if d.Number, err = openDocumentField(kr, d.ID, "number", number); err != nil {
+		return nil, err
+	}
+	if d.Notes, err = openDocumentField(kr, d.ID, "notes", notes); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// CreateDocument stores a new document, assigning its ID and timestamps
+func (s *Store) CreateDocument(ctx context.Context, kr *vault.Keyring, d *Document) error {
+	d.ID = uuid.NewString()
+	d.Version = 1
+	d.CreatedAt = time.Now().UTC()
+	d.UpdatedAt = d.CreatedAt
+
+	number, err := sealDocumentField(kr, d.ID, "number", d.Number)
+	if err != nil {
+		return err
+	}
+	notes, err := sealDocumentField(kr, d.ID, "notes", d.Notes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO documents (id, owner_id, type, name, issuing_country, issued_on, expires_on, version, created_at, updated_at, number, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.OwnerID, d.Type, d.Name, d.IssuingCountry, d.IssuedOn, d.ExpiresOn, d.Version, d.CreatedAt, d.UpdatedAt, number, notes)
+	if err != nil {
+		return fmt.Errorf("creating document: %w", err)
+	}
+	return nil
+}
+
+// UpdateDocument saves d if the stored document is still at d.Version, then
+// increments d.Version. The scan is not changed.
+func (s *Store) UpdateDocument(ctx context.Context, kr *vault.Keyring, d *Document) error {
+	number, err := sealDocumentField(kr, d.ID, "number", d.Number)
+	if err != nil {
+		return err
+	}
+	notes, err := sealDocumentField(kr, d.ID, "notes", d.Notes)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE documents SET type = ?, name = ?, issuing_country = ?, issued_on = ?, expires_on = ?, number = ?, notes = ?,
+			version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?`,
+		d.Type, d.Name, d.IssuingCountry, d.IssuedOn, d.ExpiresOn, number, notes, now, d.ID, d.Version)
+	if err != nil {
+		return fmt.Errorf("updating document: %w", err)
+	}
+	if err := s.requireVersion(ctx, res, "documents", d.ID); err != nil {
+		return err
+	}
+	d.Version++
+	d.UpdatedAt = now
+	return nil
+}
+
+// DeleteDocument deletes a document and its scan if it is still at version
+func (s *Store) DeleteDocument(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM documents WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting document: %w", err)
+	}
+	return s.requireVersion(ctx, res, "documents", id)
+}
+
+// GetDocument returns the document with the given ID, decrypted with kr
+func (s *Store) GetDocument(ctx context.Context, kr *vault.Keyring, id string) (*Document, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+documentColumns+` FROM documents WHERE id = ?`, id)
+	return scanDocument(row, kr)
+}
+
+// ListDocuments returns one page of a user's documents, decrypted with kr, and the cursor of the next page
+func (s *Store) ListDocuments(ctx context.Context, kr *vault.Keyring, ownerID string, q *listquery.Query) ([]*Document, string, error) {
+	scan := func(row rowScanner) (*Document, error) { return scanDocument(row, kr) }
+	return listPage(ctx, s.db, "documents", documentColumns, "documents.owner_id = ?", []any{ownerID}, q, scan,
+		func(d *Document) string { return d.ID })
+}
+
+// ExpiringDocuments returns a user's documents expiring before a date, soonest
+// first. Their sealed fields are not decrypted.
+func (s *Store) ExpiringDocuments(ctx context.Context, ownerID, before string) ([]*Document, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+documentColumns+` FROM documents
+		WHERE owner_id = ? AND expires_on != '' AND expires_on < ?
+		ORDER BY expires_on, id`, ownerID, before)
+	if err != nil {
+		return nil, fmt.Errorf("listing expiring documents: %w", err)
+	}
+	defer rows.Close()
+
+	var documents []*Document
+	for rows.Next() {
+		d, err := scanDocument(rows, nil)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+// DocumentsOfType returns a user's documents of the given types without
+// their encrypted fields, latest expiry first
+func (s *Store) DocumentsOfType(ctx context.Context, ownerID string, types ...string) ([]*Document, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	args := []any{ownerID}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+documentColumns+` FROM documents
+		WHERE owner_id = ? AND type IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", ")+`)
+		ORDER BY expires_on DESC, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing documents: %w", err)
+	}
+	defer rows.Close()
+
+	var documents []*Document
+	for rows.Next() {
+		d, err := scanDocument(rows, nil)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+// PutDocumentScan encrypts and stores the scan of a document, replacing any
+// previous one, and increments d.Version
+func (s *Store) PutDocumentScan(ctx context.Context, kr *vault.Keyring, d *Document, contentType string, data []byte) error {
+	sealed, err := kr.Seal(data, documentAD(d.ID, "scan"))
+	if err != nil {
+		return fmt.Errorf("encrypting document scan: %w", err)
+	}
+	scan := &DocumentScan{ContentType: contentType, Size: int64(len(data)), UploadedAt: time.Now().UTC()}
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE documents SET scan_content_type = ?, scan_size = ?, scan_uploaded_at = ?, version = version + 1, updated_at = ?
+			WHERE id = ?`,
+			scan.ContentType, scan.Size, scan.UploadedAt, scan.UploadedAt, d.ID)
+		if err != nil {
+			return fmt.Errorf("updating document: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO document_scans (document_id, data) VALUES (?, ?)
+			ON CONFLICT (document_id) DO UPDATE SET data = excluded.data`, d.ID, sealed)
+		if err != nil {
+			return fmt.Errorf("storing document scan: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.Scan = scan
+	d.Version++
+	d.UpdatedAt = scan.UploadedAt
+	return nil
+}
+
+// DocumentScanData returns the decrypted scan of a document
+func (s *Store) DocumentScanData(ctx context.Context, kr *vault.Keyring, id string) ([]byte, error) {
+	var sealed []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT data FROM document_scans WHERE document_id = ?`, id).Scan(&sealed); err != nil {
+		return nil, notFound(err)
+	}
+	data, err := kr.Open(sealed, documentAD(id, "scan"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting scan of document %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// DeleteDocumentScan deletes the scan of a document and increments d.Version
+func (s *Store) DeleteDocumentScan(ctx context.Context, d *Document) error {
+	now := time.Now().UTC()
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM document_scans WHERE document_id = ?`, d.ID); err != nil {
+			return fmt.Errorf("deleting document scan: %w", err)
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE documents SET scan_content_type = NULL, scan_size = NULL, scan_uploaded_at = NULL,
+				version = version + 1, updated_at = ?
+			WHERE id = ?`, now, d.ID)
+		if err != nil {
+			return fmt.Errorf("updating document: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.Scan = nil
+	d.Version++
+	d.UpdatedAt = now
+	return nil
+}
+
+// RekeyDocuments encrypts every document field and scan that is not
+// encrypted with the first key of kr again, so that older keys can be
+// removed. It returns the number of documents rewritten.
+func (s *Store) RekeyDocuments(ctx context.Context, kr *vault.Keyring) (int, error) {
+	// The header of sealed values, which names their key, is enough to tell
+	// whether they are current; scans are only read when they are not
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.id, d.number, d.notes, substr(s.data, 1, 16)
+		FROM documents d LEFT JOIN document_scans s ON s.document_id = d.id`)
+	if err != nil {
+		return 0, fmt.Errorf("listing documents: %w", err)
+	}
+	type stale struct {
+		id            string
+		number, notes []byte
+		scan          bool
+	}
+	var documents []stale
+	for rows.Next() {
+		var (
+			d          stale
+			scanHeader []byte
+		)
+		if err := rows.Scan(&d.id, &d.number, &d.notes, &scanHeader); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("listing documents: %w", err)
+		}
+		d.scan = scanHeader != nil && !kr.Current(scanHeader)
+		if d.scan || !kr.Current(d.number) || (d.notes != nil && !kr.Current(d.notes)) {
+			documents = append(documents, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("listing documents: %w", err)
+	}
+
+	for _, d := range documents {
+		err := s.withTx(ctx, func(tx *sql.Tx) error {
+			number, err := resealDocumentField(kr, d.id, "number", d.number)
+			if err != nil {
+				return err
+			}
+			notes, err := resealDocumentField(kr, d.id, "notes", d.notes)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE documents SET number = ?, notes = ? WHERE id = ?`, number, notes, d.id); err != nil {
+				return fmt.Errorf("updating document: %w", err)
+			}
+			if !d.scan {
+				return nil
+			}
+			var scan []byte
+			if err := tx.QueryRowContext(ctx, `SELECT data FROM document_scans WHERE document_id = ?`, d.id).Scan(&scan); err != nil {
+				return fmt.Errorf("reading document scan: %w", err)
+			}
+			if scan, err = resealDocumentField(kr, d.id, "scan", scan); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE document_scans SET data = ? WHERE document_id = ?`, scan, d.id); err != nil {
+				return fmt.Errorf("updating document scan: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(documents), nil
+}
+
+// resealDocumentField encrypts a sealed field with the first key of kr, unless it already is
+func resealDocumentField(kr *vault.Keyring, id, field string, sealed []byte) ([]byte, error) {
+	if sealed == nil || kr.Current(sealed) {
+		return sealed, nil
+	}
+	plain, err := kr.Open(sealed, documentAD(id, field))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s of document %s: %w", field, id, err)
+	}
+	return kr.Seal(plain, documentAD(id, field))
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// Entry requirements, from least to most effort for the traveller
+const (
+	EntryVisaFree      = "visa_free"
+	EntryVisaOnArrival = "visa_on_arrival"
+	EntryEVisa         = "e_visa"
+	EntryVisaRequired  = "visa_required"
+)
+
+// EntryRequirements are the allowed entry requirements
+var EntryRequirements = []string{EntryVisaFree, EntryVisaOnArrival, EntryEVisa, EntryVisaRequired}
+
+// AnyNationality is the nationality of rules that apply to every passport
+// without a rule of its own for the destination
+const AnyNationality = "*"
+
+// ErrEntryRuleOverlap is returned when two rules for the same nationality
+// and destination would be in force on the same day
+var ErrEntryRuleOverlap = errors.New("entry rules overlap")
+
+// EntryRule is what holders of a passport need to enter a country during a
+// period. Rules are never edited: a change is a new rule taking effect on a
+// later day, which ends the rule it replaces, so trips are always evaluated
+// with the rule that was in force when they enter the country.
+type EntryRule struct {
+	ID          string `json:"id"`
+	Nationality string `json:"nationality" doc:"ISO 3166-1 alpha-2 code of the passport, or * for passports without a rule of their own"`
+	Destination string `json:"destination" doc:"ISO 3166-1 alpha-2 code"`
+	Requirement string `json:"requirement" doc:"visa_free, visa_on_arrival, e_visa or visa_required"`
+
+	// MaxStayDays is the longest stay allowed per entry; absent if unlimited
+	MaxStayDays *int `json:"max_stay_days,omitempty"`
+
+	// PassportValidityMonths is how long the passport must remain valid after leaving the destination
+	PassportValidityMonths int `json:"passport_validity_months"`
+
+	EffectiveFrom  string    `json:"effective_from" doc:"First day the rule is in force"`
+	EffectiveUntil string    `json:"effective_until,omitempty" doc:"First day the rule is no longer in force; empty while it is current"`
+	Notes          string    `json:"notes,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// EntryRuleListSpec whitelists the sorts and filters of entry rule listings
+var EntryRuleListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"destination":    {Column: "destination", Kind: listquery.String},
+		"nationality":    {Column: "nationality", Kind: listquery.String},
+		"effective_from": {Column: "effective_from", Kind: listquery.Date},
+		"created_at":     {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "destination",
+	Filters: map[string]listquery.Filter{
+		"nationality":    {Column: "nationality", Op: listquery.In},
+		"destination":    {Column: "destination", Op: listquery.In},
+		"requirement":    {Column: "requirement", Op: listquery.In, Allowed: EntryRequirements},
+		"effective_from": {Column: "effective_from", Op: listquery.Range, Kind: listquery.Date},
+	},
+}
+
+const entryRuleColumns = `id, nationality, destination, requirement, max_stay_days, passport_validity_months,
+	effective_from, effective_until, notes, created_at`
+
+// scanEntryRule reads a row selected with entryRuleColumns
+func scanEntryRule(row rowScanner) (*EntryRule, error) {
+	var (
+		r       EntryRule
+		maxStay sql.NullInt64
+	)
+	if err := row.Scan(&r.ID, &r.Nationality, &r.Destination, &r.Requirement, &maxStay, &r.PassportValidityMonths,
+		&r.EffectiveFrom, &r.EffectiveUntil, &r.Notes, &r.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if maxStay.Valid {
+		days := int(maxStay.Int64)
+		r.MaxStayDays = &days
+	}
+	return &r, nil
+}
+
+// CreateEntryRule stores a new rule, assigning its ID. A current rule for
+// the same nationality and destination that took effect earlier ends the day
+// r takes effect; ErrEntryRuleOverlap is returned if r overlaps any other.
+func (s *Store) CreateEntryRule(ctx context.Context, r *EntryRule) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if err := insertEntryRule(ctx, tx, r); err != nil {
+			return err
+		}
+		return settleEntryRules(ctx, tx, r.Nationality, r.Destination)
+	})
+}
+
+// insertEntryRule stores a new rule, assigning its ID and creation time
+func insertEntryRule(ctx context.Context, tx *sql.Tx, r *EntryRule) error {
+	r.ID = uuid.NewString()
+	r.CreatedAt = time.Now().UTC()
+	_, err := tx.ExecContext(ctx, `INSERT INTO entry_rules (`+entryRuleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.Nationality, r.Destination, r.Requirement, r.MaxStayDays, r.PassportValidityMonths,
+		r.EffectiveFrom, r.EffectiveUntil, r.Notes, r.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("creating entry rule: %w", err)
+	}
+	return nil
+}
+
+// settleEntryRules ends the current rules of a nationality and destination
+// that are followed by a later one, and checks that no two rules overlap
+func settleEntryRules(ctx context.Context, tx *sql.Tx, nationality, destination string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+entryRuleColumns+` FROM entry_rules
+		WHERE nationality = ? AND destination = ?
+		ORDER BY effective_from, id`, nationality, destination)
+	if err != nil {
+		return fmt.Errorf("listing entry rules: %w", err)
+	}
+	var rules []*EntryRule
+	for rows.Next() {
+		r, err := scanEntryRule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing entry rules: %w", err)
+	}
+
+	for i := 1; i < len(rules); i++ {
+		previous, r := rules[i-1], rules[i]
+		switch {
+		case previous.EffectiveFrom == r.EffectiveFrom:
+			return fmt.Errorf("%w: two rules for %s passports in %s take effect on %s",
+				ErrEntryRuleOverlap, nationality, destination, r.EffectiveFrom)
+		case previous.EffectiveUntil == "":
+			if _, err := tx.ExecContext(ctx, `UPDATE entry_rules SET effective_until = ? WHERE id = ?`, r.EffectiveFrom, previous.ID); err != nil {
+				return fmt.Errorf("ending entry rule: %w", err)
+			}
+		case previous.EffectiveUntil > r.EffectiveFrom:
+			return fmt.Errorf("%w: the rule for %s passports in %s from %s is in force until %s, after the rule from %s takes effect",
+				ErrEntryRuleOverlap, nationality, destination, previous.EffectiveFrom, previous.EffectiveUntil, r.EffectiveFrom)
+		}
+	}
+	return nil
+}
+
+// DeleteEntryRule deletes a rule. The rule it ended, if any, is extended to
+// the day the deleted rule was in force until.
+func (s *Store) DeleteEntryRule(ctx context.Context, id string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		r, err := scanEntryRule(tx.QueryRowContext(ctx, `SELECT `+entryRuleColumns+` FROM entry_rules WHERE id = ?`, id))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM entry_rules WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("deleting entry rule: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE entry_rules SET effective_until = ?
+			WHERE nationality = ? AND destination = ? AND effective_until = ?`,
+			r.EffectiveUntil, r.Nationality, r.Destination, r.EffectiveFrom)
+		if err != nil {
+			return fmt.Errorf("extending entry rule: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetEntryRule returns the rule with the given ID
+func (s *Store) GetEntryRule(ctx context.Context, id string) (*EntryRule, error) {
+	return scanEntryRule(s.db.QueryRowContext(ctx, `SELECT `+entryRuleColumns+` FROM entry_rules WHERE id = ?`, id))
+}
+
+// ListEntryRules returns one page of rules and the cursor of the next page
+func (s *Store) ListEntryRules(ctx context.Context, q *listquery.Query) ([]*EntryRule, string, error) {
+	return listPage(ctx, s.db, "entry_rules", entryRuleColumns, "1 = 1", nil, q, scanEntryRule,
+		func(r *EntryRule) string { return r.ID })
+}
+
+// EntryRuleOn returns the rule in force on a day (YYYY-MM-DD) for holders of
+// a passport entering a country: the nationality's own rule, else the
+// destination's rule for every nationality, else ErrNotFound
+func (s *Store) EntryRuleOn(ctx context.Context, nationality, destination, day string) (*EntryRule, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+entryRuleColumns+` FROM entry_rules
+		WHERE destination = ? AND nationality IN (?, ?)
+			AND effective_from <= ? AND (effective_until = '' OR effective_until > ?)
+		ORDER BY nationality = ? LIMIT 1`,
+		destination, nationality, AnyNationality, day, day, AnyNationality)
+	return scanEntryRule(row)
+}
+
+// EntryRuleImport writes imported rules in a single transaction
+type EntryRuleImport struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	pairs map[[2]string]bool
+}
+
+// BeginEntryRuleImport starts an import; call Commit to keep it or Rollback to discard it
+func (s *Store) BeginEntryRuleImport(ctx context.Context) (*EntryRuleImport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning entry rule import: %w", err)
+	}
+	return &EntryRuleImport{ctx: ctx, tx: tx, pairs: map[[2]string]bool{}}, nil
+}
+
+// AddRule stores a rule, replacing the rule of the same nationality and
+// destination taking effect the same day if there is one. created reports
+// whether the rule is new.
+func (imp *EntryRuleImport) AddRule(r *EntryRule) (created bool, err error) {
+	imp.pairs[[2]string{r.Nationality, r.Destination}] = true
+	err = imp.tx.QueryRowContext(imp.ctx, `
+		UPDATE entry_rules SET requirement = ?, max_stay_days = ?, passport_validity_months = ?, effective_until = ?, notes = ?
+		WHERE nationality = ? AND destination = ? AND effective_from = ?
+		RETURNING id, created_at`,
+		r.Requirement, r.MaxStayDays, r.PassportValidityMonths, r.EffectiveUntil, r.Notes,
+		r.Nationality, r.Destination, r.EffectiveFrom).Scan(&r.ID, &r.CreatedAt)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("importing entry rule: %w", err)
+	}
+	return true, insertEntryRule(imp.ctx, imp.tx, r)
+}
+
+// Commit ends the rules followed by imported ones and keeps the imported
+// data, or returns ErrEntryRuleOverlap and keeps nothing
+func (imp *EntryRuleImport) Commit() error {
+	for pair := range imp.pairs {
+		if err := settleEntryRules(imp.ctx, imp.tx, pair[0], pair[1]); err != nil {
+			_ = imp.tx.Rollback()
+			return err
+		}
+	}
+	return imp.tx.Commit()
+}
+
+// Rollback discards the imported data
+func (imp *EntryRuleImport) Rollback() error {
+	return imp.tx.Rollback()
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// ExpenseCategories are the allowed expense categories
+var ExpenseCategories = []string{"transport", "lodging", "food", "activities", "shopping", "other"}
+
+// Expense is money spent on a trip. Amounts are in the currency's minor unit (e.g. cents).
+type Expense struct {
+	ID          string    `json:"id"`
+	TripID      string    `json:"trip_id"`
+	Description string    `json:"description"`
+	Category    string    `json:"category"`
+	AmountMinor int64     `json:"amount_minor"`
+	Currency    string    `json:"currency"`
+	SpentOn     string    `json:"spent_on"`
+	Tags        []string  `json:"tags"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ExpenseListSpec whitelists the sorts and filters of expense listings
+var ExpenseListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"spent_on":   {Column: "spent_on", Kind: listquery.Date},
+		"amount":     {Column: "amount_minor", Kind: listquery.Int},
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "-spent_on",
+	Filters: map[string]listquery.Filter{
+		"category": {Column: "category", Op: listquery.In, Allowed: ExpenseCategories},
+		"currency": {Column: "currency", Op: listquery.In},
+		"spent_on": {Column: "spent_on", Op: listquery.Range, Kind: listquery.Date},
+		"tag":      {Column: "tags", Op: listquery.Tags},
+	},
+}
+
+const expenseColumns = `id, trip_id, description, category, amount_minor, currency, spent_on, tags, version, created_at, updated_at`
+
+// scanExpense reads a row selected with expenseColumns
+func scanExpense(row rowScanner) (*Expense, error) {
+	var (
+		e    Expense
+		tags string
+	)
+	if err := row.Scan(&e.ID, &e.TripID, &e.Description, &e.Category, &e.AmountMinor, &e.Currency, &e.SpentOn, &tags, &e.Version, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(tags), &e.Tags); err != nil {
+		return nil, fmt.Errorf("decoding expense tags: %w", err)
+	}
+	return &e, nil
+}
+
+// CreateExpense stores a new expense, assigning its ID and timestamps
+func (s *Store) CreateExpense(ctx context.Context, e *Expense) error {
+	e.ID = uuid.NewString()
+	e.Version = 1
+	e.CreatedAt = time.Now().UTC()
+	e.UpdatedAt = e.CreatedAt
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO expenses (`+expenseColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.TripID, e.Description, e.Category, e.AmountMinor, e.Currency, e.SpentOn, encodeTags(e.Tags), e.Version, e.CreatedAt, e.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("creating expense: %w", err)
+	}
+	return nil
+}
+
+// UpdateExpense saves e if the stored expense is still at e.Version, then
+// increments e.Version. It returns ErrVersionConflict if the expense changed meanwhile.
+func (s *Store) UpdateExpense(ctx context.Context, e *Expense) error {
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE expenses SET description = ?, category = ?, amount_minor = ?, currency = ?, spent_on = ?, tags = ?,
+			version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?`,
+		e.Description, e.Category, e.AmountMinor, e.Currency, e.SpentOn, encodeTags(e.Tags), now, e.ID, e.Version)
+	if err != nil {
+		return fmt.Errorf("updating expense: %w", err)
+	}
+	if err := s.requireVersion(ctx, res, "expenses", e.ID); err != nil {
+		return err
+	}
+	e.Version++
+	e.UpdatedAt = now
+	return nil
+}
+
+// DeleteExpense deletes an expense if it is still at version
+func (s *Store) DeleteExpense(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM expenses WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting expense: %w", err)
+	}
+	return s.requireVersion(ctx, res, "expenses", id)
+}
+
+// GetExpense returns an expense of a trip
+func (s *Store) GetExpense(ctx context.Context, tripID, id string) (*Expense, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+expenseColumns+` FROM expenses WHERE trip_id = ? AND id = ?`, tripID, id)
+	return scanExpense(row)
+}
+
+// ListExpenses returns one page of a trip's expenses and the cursor of the next page
+func (s *Store) ListExpenses(ctx context.Context, tripID string, q *listquery.Query) ([]*Expense, string, error) {
+	return listPage(ctx, s.db, "expenses", expenseColumns, "expenses.trip_id = ?", []any{tripID}, q, scanExpense,
+		func(e *Expense) string { return e.ID })
+}
+
+// allExpenses returns every expense of a trip by date
+func (s *Store) allExpenses(ctx context.Context, tripID string) ([]*Expense, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+expenseColumns+` FROM expenses WHERE trip_id = ? ORDER BY spent_on, id`, tripID)
+	if err != nil {
+		return nil, fmt.Errorf("listing expenses: %w", err)
+	}
+	defer rows.Close()
+
+	expenses := []*Expense{}
+	for rows.Next() {
+		e, err := scanExpense(rows)
+		if err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, e)
+	}
+	return expenses, rows.Err()
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// idempotencyClaimTimeout is how long an unfinished request holds its key;
+// after that the claim is assumed abandoned (e.g. the server crashed) and may be taken over
+const idempotencyClaimTimeout = time.Minute
+
+// IdempotencyRecord is the outcome of a request made with an Idempotency-Key
+type IdempotencyRecord struct {
+	RequestHash string
+
+	// Status is 0 while the first request is still in progress
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// ClaimIdempotencyKey reserves a user's idempotency key for a request. It returns
+// claimed=true if the caller now owns the key and must complete or release it;
+// otherwise it returns the record left by an earlier request with the same key.
+// Expired records are purged first.
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, userID, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now().UTC()
+
+	var (
+		record  *IdempotencyRecord
+		claimed bool
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE expires_at < ? OR (user_id = ? AND key = ? AND status = 0 AND created_at < ?)`,
+			now, userID, key, now.Add(-idempotencyClaimTimeout)); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, request_hash, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (user_id, key) DO NOTHING`,
+			userID, key, requestHash, now, now.Add(ttl))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			claimed = n == 1
+			return err
+		}
+
+		var (
+			r      IdempotencyRecord
+			header string
+		)
+		err = tx.QueryRowContext(ctx, `SELECT request_hash, status, header, body FROM idempotency_keys WHERE user_id = ? AND key = ?`,
+			userID, key).Scan(&r.RequestHash, &r.Status, &header, &r.Body)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(header), &r.Header); err != nil {
+			return fmt.Errorf("decoding stored header: %w", err)
+		}
+		record = &r
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("claiming idempotency key: %w", err)
+	}
+	return record, claimed, nil
+}
+
+// CompleteIdempotencyKey stores the response of a claimed key for replay
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, userID, key string, status int, header map[string][]string, body []byte) error {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("encoding header: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE idempotency_keys SET status = ?, header = ?, body = ? WHERE user_id = ? AND key = ?`,
+		status, string(encoded), body, userID, key)
+	if err != nil {
+		return fmt.Errorf("completing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a claimed key so the request can be retried
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND status = 0`, userID, key); err != nil {
+		return fmt.Errorf("releasing idempotency key: %w", err)
+	}
+	return nil
+}
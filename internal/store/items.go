@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// ItemKinds are the allowed itinerary item kinds
+var ItemKinds = []string{"flight", "lodging", "transport", "activity", "meal"}
+
+// ItineraryItem is a scheduled part of a trip
+type ItineraryItem struct {
+	ID        string     `json:"id"`
+	TripID    string     `json:"trip_id"`
+	Kind      string     `json:"kind"`
+	Title     string     `json:"title"`
+	Location  string     `json:"location,omitempty"`
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	TimeZone  string     `json:"time_zone,omitempty" doc:"IANA time zone of the item, resolved from its coordinates or departure airport unless given"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	Notes     string     `json:"notes,omitempty"`
+	Tags      []string   `json:"tags"`
+	Flight    *Flight    `json:"flight,omitempty" doc:"Airports and airline of a flight, resolved when the item is saved"`
+	Version   int64      `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Flight is the reference data of a flight item at the time it was saved
+type Flight struct {
+	Airline   *Airline `json:"airline,omitempty"`
+	Number    string   `json:"number,omitempty"`
+	Departure *Airport `json:"departure"`
+	Arrival   *Airport `json:"arrival"`
+
+	// DistanceKM is the great-circle distance between the airports
+	DistanceKM float64 `json:"distance_km"`
+
+	// DepartsLocal and ArrivesLocal are starts_at and ends_at in the time
+	// zones of the airports, when those are known
+	DepartsLocal string `json:"departs_local,omitempty" doc:"RFC 3339 time at the departure airport"`
+	ArrivesLocal string `json:"arrives_local,omitempty" doc:"RFC 3339 time at the arrival airport"`
+}
+
+// ItemListSpec whitelists the sorts and filters of itinerary item listings
+var ItemListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"starts_at":  {Column: "starts_at", Kind: listquery.Time},
+		"title":      {Column: "title", Kind: listquery.String},
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "starts_at",
+	Filters: map[string]listquery.Filter{
+		"kind":      {Column: "kind", Op: listquery.In, Allowed: ItemKinds},
+		"starts_at": {Column: "starts_at", Op: listquery.Range, Kind: listquery.Time},
+		"tag":       {Column: "tags", Op: listquery.Tags},
+	},
+}
+
+const itemColumns = `id, trip_id, kind, title, location, latitude, longitude, time_zone, starts_at, ends_at, notes, tags, flight, version, created_at, updated_at`
+
+// scanItem reads a row selected with itemColumns
+func scanItem(row rowScanner) (*ItineraryItem, error) {
+	var (
+		it     ItineraryItem
+		endsAt sql.NullTime
+		tags   string
+		flight sql.NullString
+		lat    sql.NullFloat64
+		lon    sql.NullFloat64
+	)
+	if err := row.Scan(&it.ID, &it.TripID, &it.Kind, &it.Title, &it.Location, &lat, &lon, &it.TimeZone, &it.StartsAt, &endsAt, &it.Notes, &tags, &flight, &it.Version, &it.CreatedAt, &it.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if endsAt.Valid {
+		it.EndsAt = &endsAt.Time
+	}
+	if lat.Valid && lon.Valid {
+		it.Latitude, it.Longitude = &lat.Float64, &lon.Float64
+	}
+	if err := json.Unmarshal([]byte(tags), &it.Tags); err != nil {
+		return nil, fmt.Errorf("decoding item tags: %w", err)
+	}
+	if flight.Valid {
+		if err := json.Unmarshal([]byte(flight.String), &it.Flight); err != nil {
+			return nil, fmt.Errorf("decoding item flight: %w", err)
+		}
+	}
+	return &it, nil
+}
+
+// CreateItem stores a new itinerary item, assigning its ID and timestamps
+func (s *Store) CreateItem(ctx context.Context, it *ItineraryItem) error {
+	it.ID = uuid.NewString()
+	it.Version = 1
+	it.CreatedAt = time.Now().UTC()
+	it.UpdatedAt = it.CreatedAt
+	it.normalize()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO itinerary_items (`+itemColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		it.ID, it.TripID, it.Kind, it.Title, it.Location, it.Latitude, it.Longitude, it.TimeZone, it.StartsAt, it.EndsAt, it.Notes, encodeTags(it.Tags), encodeFlight(it.Flight), it.Version, it.CreatedAt, it.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("creating itinerary item: %w", err)
+	}
+	return nil
+}
+
+// UpdateItem saves it if the stored item is still at it.Version, then
+// increments it.Version. It returns ErrVersionConflict if the item changed meanwhile.
+func (s *Store) UpdateItem(ctx context.Context, it *ItineraryItem) error {
+	it.normalize()
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE itinerary_items SET kind = ?, title = ?, location = ?, latitude = ?, longitude = ?, time_zone = ?, starts_at = ?, ends_at = ?, notes = ?, tags = ?,
+			flight = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?`,
+		it.Kind, it.Title, it.Location, it.Latitude, it.Longitude, it.TimeZone, it.StartsAt, it.EndsAt, it.Notes, encodeTags(it.Tags), encodeFlight(it.Flight), now, it.ID, it.Version)
+	if err != nil {
+		return fmt.Errorf("updating itinerary item: %w", err)
+	}
+	if err := s.requireVersion(ctx, res, "itinerary_items", it.ID); err != nil {
+		return err
+	}
+	it.Version++
+	it.UpdatedAt = now
+	return nil
+}
+
+// DeleteItem deletes an itinerary item if it is still at version
+func (s *Store) DeleteItem(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM itinerary_items WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting itinerary item: %w", err)
+	}
+	return s.requireVersion(ctx, res, "itinerary_items", id)
+}
+
+// normalize converts times to UTC so they sort correctly
+func (it *ItineraryItem) normalize() {
+	it.StartsAt = it.StartsAt.UTC()
+	if it.EndsAt != nil {
+		endsAt := it.EndsAt.UTC()
+		it.EndsAt = &endsAt
+	}
+	if it.Tags == nil {
+		it.Tags = []string{}
+	}
+}
+
+// GetItem returns an itinerary item of a trip
+func (s *Store) GetItem(ctx context.Context, tripID, id string) (*ItineraryItem, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+itemColumns+` FROM itinerary_items WHERE trip_id = ? AND id = ?`, tripID, id)
+	return scanItem(row)
+}
+
+// ListItems returns one page of a trip's itinerary items and the cursor of the next page
+func (s *Store) ListItems(ctx context.Context, tripID string, q *listquery.Query) ([]*ItineraryItem, string, error) {
+	return listPage(ctx, s.db, "itinerary_items", itemColumns, "itinerary_items.trip_id = ?", []any{tripID}, q, scanItem,
+		func(it *ItineraryItem) string { return it.ID })
+}
+
+// AllItems returns every itinerary item of a trip in schedule order
+func (s *Store) AllItems(ctx context.Context, tripID string) ([]*ItineraryItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+itemColumns+` FROM itinerary_items WHERE trip_id = ? ORDER BY starts_at, id`, tripID)
+	if err != nil {
+		return nil, fmt.Errorf("listing itinerary items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []*ItineraryItem{}
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// encodeFlight serializes flight details for the flight column, NULL when absent
+func encodeFlight(f *Flight) any {
+	if f == nil {
+		return nil
+	}
+	b, _ := json.Marshal(f)
+	return string(b)
+}
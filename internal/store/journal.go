@@ -0,0 +1,293 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/markdown"
+
+	"github.com/google/uuid"
+)
+
+// JournalMoods are the allowed moods of journal entries
+var JournalMoods = []string{"great", "good", "okay", "tired", "bad"}
+
+// JournalWeather are the allowed weather conditions of journal entries
+var JournalWeather = []string{"sunny", "partly_cloudy", "cloudy", "rainy", "stormy", "snowy", "windy", "foggy"}
+
+// Journal entry visibilities
+const (
+	VisibilityPrivate = "private"
+	VisibilityShared  = "shared"
+)
+
+// JournalVisibilities are the allowed visibilities of journal entries
+var JournalVisibilities = []string{VisibilityPrivate, VisibilityShared}
+
+// ErrInvalidVisibility is returned when saving a journal entry with a
+// visibility other than JournalVisibilities
+var ErrInvalidVisibility = errors.New("invalid journal entry visibility")
+
+// JournalEntry is what a traveller wrote about a day of a trip
+type JournalEntry struct {
+	ID         string    `json:"id"`
+	TripID     string    `json:"trip_id"`
+	AuthorID   string    `json:"author_id"`
+	Day        string    `json:"day" doc:"Local date of the trip the entry is about"`
+	Title      string    `json:"title,omitempty"`
+	Body       string    `json:"body" doc:"Markdown"`
+	BodyHTML   string    `json:"body_html" doc:"Body rendered to HTML, without raw HTML and with links restricted to http, https and mailto URLs"`
+	Mood       string    `json:"mood,omitempty"`
+	Weather    string    `json:"weather,omitempty"`
+	ItemIDs    []string  `json:"item_ids" doc:"Itinerary items the entry is about; deleted items drop out"`
+	PhotoIDs   []string  `json:"photo_ids" doc:"Photo attachments of the trip illustrating the entry; deleted attachments drop out"`
+	Visibility string    `json:"visibility" doc:"private entries are only seen by their author, shared ones by everyone with access to the trip"`
+	Version    int64     `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// JournalListSpec whitelists the sorts and filters of journal entry listings
+var JournalListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"day":        {Column: "day", Kind: listquery.Date},
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "day",
+	Filters: map[string]listquery.Filter{
+		"day":        {Column: "day", Op: listquery.Range, Kind: listquery.Date},
+		"mood":       {Column: "mood", Op: listquery.In, Allowed: JournalMoods},
+		"weather":    {Column: "weather", Op: listquery.In, Allowed: JournalWeather},
+		"visibility": {Column: "visibility", Op: listquery.In, Allowed: JournalVisibilities},
+	},
+}
+
+const journalColumns = `id, trip_id, author_id, day, title, body, mood, weather, visibility, version, created_at, updated_at`
+
+// journalScope returns the condition and arguments selecting the entries of
+// a trip userID can see: their own and the shared ones, or only the shared
+// ones with sharedOnly. Every read of journal entries goes through it.
+func journalScope(tripID, userID string, sharedOnly bool) (string, []any) {
+	if sharedOnly {
+		return `journal_entries.trip_id = ? AND journal_entries.visibility = 'shared'`, []any{tripID}
+	}
+	return `journal_entries.trip_id = ? AND (journal_entries.visibility = 'shared' OR journal_entries.author_id = ?)`,
+		[]any{tripID, userID}
+}
+
+// scanJournalEntry reads a row selected with journalColumns and renders its body
+func scanJournalEntry(row rowScanner) (*JournalEntry, error) {
+	var e JournalEntry
+	if err := row.Scan(&e.ID, &e.TripID, &e.AuthorID, &e.Day, &e.Title, &e.Body, &e.Mood, &e.Weather, &e.Visibility,
+		&e.Version, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	e.BodyHTML = markdown.ToHTML(e.Body)
+	return &e, nil
+}
+
+// CreateJournalEntry stores a new journal entry with its links, assigning its
+// ID and timestamps
+func (s *Store) CreateJournalEntry(ctx context.Context, e *JournalEntry) error {
+	e.ID = uuid.NewString()
+	e.Version = 1
+	e.CreatedAt = time.Now().UTC()
+	e.UpdatedAt = e.CreatedAt
+	if err := e.normalize(); err != nil {
+		return err
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO journal_entries (`+journalColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.ID, e.TripID, e.AuthorID, e.Day, e.Title, e.Body, e.Mood, e.Weather, e.Visibility, e.Version, e.CreatedAt, e.UpdatedAt); err != nil {
+			return fmt.Errorf("creating journal entry: %w", err)
+		}
+		return insertJournalLinks(ctx, tx, e)
+	})
+}
+
+// UpdateJournalEntry saves e and its links if the stored entry is still at
+// e.Version, then increments e.Version. It returns ErrVersionConflict if the
+// entry changed meanwhile.
+func (s *Store) UpdateJournalEntry(ctx context.Context, e *JournalEntry) error {
+	if err := e.normalize(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE journal_entries SET day = ?, title = ?, body = ?, mood = ?, weather = ?, visibility = ?,
+				version = version + 1, updated_at = ?
+			WHERE id = ? AND version = ?`,
+			e.Day, e.Title, e.Body, e.Mood, e.Weather, e.Visibility, now, e.ID, e.Version)
+		if err != nil {
+			return fmt.Errorf("updating journal entry: %w", err)
+		}
+		if err := s.requireVersion(ctx, res, "journal_entries", e.ID); err != nil {
+			return err
+		}
+		for _, table := range []string{"journal_entry_items", "journal_entry_photos"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE entry_id = ?`, e.ID); err != nil {
+				return fmt.Errorf("updating journal entry links: %w", err)
+			}
+		}
+		return insertJournalLinks(ctx, tx, e)
+	})
+	if err != nil {
+		return err
+	}
+	e.Version++
+	e.UpdatedAt = now
+	return nil
+}
+
+// DeleteJournalEntry deletes a journal entry if it is still at version
+func (s *Store) DeleteJournalEntry(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM journal_entries WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting journal entry: %w", err)
+	}
+	return s.requireVersion(ctx, res, "journal_entries", id)
+}
+
+// GetJournalEntry returns a journal entry of a trip that userID can see
+func (s *Store) GetJournalEntry(ctx context.Context, tripID, userID, id string) (*JournalEntry, error) {
+	scope, args := journalScope(tripID, userID, false)
+	row := s.db.QueryRowContext(ctx, `SELECT `+journalColumns+` FROM journal_entries WHERE `+scope+` AND id = ?`,
+		append(args, id)...)
+	e, err := scanJournalEntry(row)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadJournalLinks(ctx, []*JournalEntry{e}); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ListJournalEntries returns one page of the journal entries of a trip that
+// userID can see, and the cursor of the next page
+func (s *Store) ListJournalEntries(ctx context.Context, tripID, userID string, q *listquery.Query) ([]*JournalEntry, string, error) {
+	scope, args := journalScope(tripID, userID, false)
+	entries, next, err := listPage(ctx, s.db, "journal_entries", journalColumns, scope, args, q,
+		scanJournalEntry, func(e *JournalEntry) string { return e.ID })
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.loadJournalLinks(ctx, entries); err != nil {
+		return nil, "", err
+	}
+	return entries, next, nil
+}
+
+// Journal returns every journal entry of a trip that userID can see, by day
+// and then in the order they were written. With sharedOnly, the private
+// entries of userID are left out too.
+func (s *Store) Journal(ctx context.Context, tripID, userID string, sharedOnly bool) ([]*JournalEntry, error) {
+	scope, args := journalScope(tripID, userID, sharedOnly)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+journalColumns+` FROM journal_entries WHERE `+scope+` ORDER BY day, created_at, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing journal entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*JournalEntry{}
+	for rows.Next() {
+		e, err := scanJournalEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadJournalLinks(ctx, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// normalize replaces nil links with empty ones, so that they encode as [],
+// defaults the visibility to private and renders the body. It returns
+// ErrInvalidVisibility for unknown visibilities.
+func (e *JournalEntry) normalize() error {
+	if e.Visibility == "" {
+		e.Visibility = VisibilityPrivate
+	}
+	if !slices.Contains(JournalVisibilities, e.Visibility) {
+		return fmt.Errorf("%w: %q", ErrInvalidVisibility, e.Visibility)
+	}
+	if e.ItemIDs == nil {
+		e.ItemIDs = []string{}
+	}
+	if e.PhotoIDs == nil {
+		e.PhotoIDs = []string{}
+	}
+	e.BodyHTML = markdown.ToHTML(e.Body)
+	return nil
+}
+
+// insertJournalLinks records the items and photos an entry links to, in order
+func insertJournalLinks(ctx context.Context, tx *sql.Tx, e *JournalEntry) error {
+	for i, id := range e.ItemIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO journal_entry_items (entry_id, item_id, position) VALUES (?, ?, ?)`,
+			e.ID, id, i); err != nil {
+			return fmt.Errorf("linking journal entry to item: %w", err)
+		}
+	}
+	for i, id := range e.PhotoIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO journal_entry_photos (entry_id, attachment_id, position) VALUES (?, ?, ?)`,
+			e.ID, id, i); err != nil {
+			return fmt.Errorf("linking journal entry to photo: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadJournalLinks sets the item and photo links of entries
+func (s *Store) loadJournalLinks(ctx context.Context, entries []*JournalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	byID := make(map[string]*JournalEntry, len(entries))
+	args := make([]any, len(entries))
+	for i, e := range entries {
+		e.ItemIDs, e.PhotoIDs = []string{}, []string{}
+		byID[e.ID] = e
+		args[i] = e.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT entry_id, 'item', item_id, position FROM journal_entry_items WHERE entry_id IN (`+placeholders+`)
+		UNION ALL
+		SELECT entry_id, 'photo', attachment_id, position FROM journal_entry_photos WHERE entry_id IN (`+placeholders+`)
+		ORDER BY 1, 2, 4`, append(args, args...)...)
+	if err != nil {
+		return fmt.Errorf("loading journal entry links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			entryID, kind, id string
+			position          int
+		)
+		if err := rows.Scan(&entryID, &kind, &id, &position); err != nil {
+			return err
+		}
+		e := byID[entryID]
+		if kind == "item" {
+			e.ItemIDs = append(e.ItemIDs, id)
+		} else {
+			e.PhotoIDs = append(e.PhotoIDs, id)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"vibed-traveller/internal/listquery"
+)
+
+// openTestStore opens a store over a fresh database
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestJournalVisibility(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	for _, id := range []string{"alice", "bob"} {
+		if _, err := s.UpsertUser(ctx, id, id+"@example.com", id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trip := &Trip{OwnerID: "alice", Name: "Alps"}
+	if err := s.CreateTrip(ctx, trip); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := map[string]string{}
+	for _, e := range []*JournalEntry{
+		{AuthorID: "alice", Title: "alice private"},
+		{AuthorID: "alice", Title: "alice shared", Visibility: VisibilityShared},
+		{AuthorID: "bob", Title: "bob private", Visibility: VisibilityPrivate},
+		{AuthorID: "bob", Title: "bob shared", Visibility: VisibilityShared},
+	} {
+		e.TripID, e.Day = trip.ID, "2024-07-01"
+		if err := s.CreateJournalEntry(ctx, e); err != nil {
+			t.Fatalf("creating %s: %v", e.Title, err)
+		}
+		titles[e.ID] = e.Title
+	}
+
+	seen := func(entries []*JournalEntry) []string {
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Title)
+		}
+		slices.Sort(got)
+		return got
+	}
+	tests := []struct {
+		user       string
+		sharedOnly bool
+		want       []string
+	}{
+		{"alice", false, []string{"alice private", "alice shared", "bob shared"}},
+		{"bob", false, []string{"alice shared", "bob private", "bob shared"}},
+		{"alice", true, []string{"alice shared", "bob shared"}},
+		{"carol", false, []string{"alice shared", "bob shared"}},
+	}
+	for _, tt := range tests {
+		entries, err := s.Journal(ctx, trip.ID, tt.user, tt.sharedOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := seen(entries); !slices.Equal(got, tt.want) {
+			t.Errorf("Journal(%s, shared only %t) = %v, want %v", tt.user, tt.sharedOnly, got, tt.want)
+		}
+		if tt.sharedOnly {
+			continue
+		}
+		q, err := listquery.Parse(url.Values{}, JournalListSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed, _, err := s.ListJournalEntries(ctx, trip.ID, tt.user, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := seen(listed); !slices.Equal(got, tt.want) {
+			t.Errorf("ListJournalEntries(%s) = %v, want %v", tt.user, got, tt.want)
+		}
+		for id, title := range titles {
+			_, err := s.GetJournalEntry(ctx, trip.ID, tt.user, id)
+			if visible := slices.Contains(tt.want, title); visible != (err == nil) {
+				t.Errorf("GetJournalEntry(%s, %s) = %v", tt.user, title, err)
+			}
+		}
+	}
+}
+
+func TestJournalEntryVisibilityIsValidated(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	if _, err := s.UpsertUser(ctx, "alice", "alice@example.com", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	trip := &Trip{OwnerID: "alice", Name: "Alps"}
+	if err := s.CreateTrip(ctx, trip); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &JournalEntry{TripID: trip.ID, AuthorID: "alice", Day: "2024-07-01"}
+	if err := s.CreateJournalEntry(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Visibility != VisibilityPrivate {
+		t.Errorf("visibility defaulted to %q, want private", e.Visibility)
+	}
+	e.Visibility = "public"
+	if err := s.UpdateJournalEntry(ctx, e); !errors.Is(err, ErrInvalidVisibility) {
+		t.Errorf("updating with visibility public = %v, want ErrInvalidVisibility", err)
+	}
+	bad := &JournalEntry{TripID: trip.ID, AuthorID: "alice", Day: "2024-07-01", Visibility: "friends"}
+	if err := s.CreateJournalEntry(ctx, bad); !errors.Is(err, ErrInvalidVisibility) {
+		t.Errorf("creating with visibility friends = %v, want ErrInvalidVisibility", err)
+	}
+}
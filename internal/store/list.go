@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"vibed-traveller/internal/listquery"
+)
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// sortKeyScanner appends the sort key column to every Scan
+type sortKeyScanner struct {
+	rows    *sql.Rows
+	sortKey *any
+}
+
+func (s sortKeyScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.sortKey)...)
+}
+
+// listPage selects one page of rows from table matching scope and q. The page is
+// cut in SQL with keyset pagination on (sort column, id), so cursors stay stable
+// while rows are inserted or deleted. It returns the rows and the next cursor,
+// empty on the last page.
+func listPage[T any](ctx context.Context, db *sql.DB, table, columns, scope string, scopeArgs []any,
+	q *listquery.Query, scan func(rowScanner) (T, error), id func(T) string) ([]T, string, error) {
+
+	where := []string{scope}
+	args := append([]any{}, scopeArgs...)
+
+	for _, cond := range q.Filters {
+		clause, condArgs := conditionSQL(table, cond)
+		where = append(where, clause)
+		args = append(args, condArgs...)
+	}
+
+	sortColumn := table + "." + q.Sort.Column
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND %s.id %s ?))", sortColumn, cmp, sortColumn, table, cmp))
+		args = append(args, q.After.Value, q.After.Value, q.After.ID)
+	}
+
+	query := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s ORDER BY %s %s, %s.id %s LIMIT ?`,
+		columns, sortColumn, table, strings.Join(where, " AND "), sortColumn, direction, table, direction)
+	// Fetch one extra row to learn whether there is a next page
+	args = append(args, q.Limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var (
+		items    []T
+		sortKeys []any
+	)
+	for rows.Next() {
+		var sortKey any
+		item, err := scan(sortKeyScanner{rows: rows, sortKey: &sortKey})
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+		sortKeys = append(sortKeys, sortKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("listing %s: %w", table, err)
+	}
+
+	if len(items) <= q.Limit {
+		return items, "", nil
+	}
+	items = items[:q.Limit]
+	last := len(items) - 1
+	return items, q.Next(sortKeys[last], id(items[last])), nil
+}
+
+// conditionSQL translates a filter condition into a WHERE clause
+func conditionSQL(table string, cond listquery.Condition) (string, []any) {
+	column := table + "." + cond.Column
+	switch cond.Op {
+	case listquery.Range:
+		if cond.Values[0] != nil {
+			return column + " >= ?", []any{cond.Values[0]}
+		}
+		return column + " <= ?", []any{cond.Values[1]}
+	case listquery.Tags:
+		clauses := make([]string, len(cond.Values))
+		for i := range cond.Values {
+			clauses[i] = fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE json_each.value = ?)", column)
+		}
+		return "(" + strings.Join(clauses, " AND ") + ")", cond.Values
+	default:
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cond.Values)), ", ")
+		return fmt.Sprintf("%s IN (%s)", column, placeholders), cond.Values
+	}
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order; append new ones, never edit applied ones
+var migrations = []string{
+	// 1: users and trips
+	`CREATE TABLE users (
+		id                  TEXT PRIMARY KEY,
+		email               TEXT NOT NULL DEFAULT '',
+		username            TEXT NOT NULL DEFAULT '',
+		metadata            TEXT NOT NULL DEFAULT '{}',
+		disabled            INTEGER NOT NULL DEFAULT 0,
+		sessions_revoked_at TIMESTAMP,
+		created_at          TIMESTAMP NOT NULL,
+		last_seen_at        TIMESTAMP NOT NULL
+	);
+	CREATE TABLE trips (
+		id          TEXT PRIMARY KEY,
+		owner_id    TEXT NOT NULL REFERENCES users(id),
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		start_date  TEXT NOT NULL DEFAULT '',
+		end_date    TEXT NOT NULL DEFAULT '',
+		created_at  TIMESTAMP NOT NULL,
+		updated_at  TIMESTAMP NOT NULL
+	);
+	CREATE INDEX trips_owner_id ON trips(owner_id);`,
+
+	// 2: trip status and tags, itinerary items and expenses
+	`ALTER TABLE trips ADD COLUMN status TEXT NOT NULL DEFAULT 'planned';
+	ALTER TABLE trips ADD COLUMN tags TEXT NOT NULL DEFAULT '[]';
+	CREATE TABLE itinerary_items (
+		id         TEXT PRIMARY KEY,
+		trip_id    TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		kind       TEXT NOT NULL,
+		title      TEXT NOT NULL,
+		location   TEXT NOT NULL DEFAULT '',
+		starts_at  TIMESTAMP NOT NULL,
+		ends_at    TIMESTAMP,
+		notes      TEXT NOT NULL DEFAULT '',
+		tags       TEXT NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX itinerary_items_trip_id ON itinerary_items(trip_id, starts_at);
+	CREATE TABLE expenses (
+		id           TEXT PRIMARY KEY,
+		trip_id      TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		description  TEXT NOT NULL,
+		category     TEXT NOT NULL,
+		amount_minor INTEGER NOT NULL,
+		currency     TEXT NOT NULL,
+		spent_on     TEXT NOT NULL,
+		tags         TEXT NOT NULL DEFAULT '[]',
+		created_at   TIMESTAMP NOT NULL,
+		updated_at   TIMESTAMP NOT NULL
+	);
+	CREATE INDEX expenses_trip_id ON expenses(trip_id, spent_on);`,
+
+	// 3: optimistic concurrency versions
+	`ALTER TABLE trips ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE itinerary_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE expenses ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+
+	// 4: idempotency keys of POST requests
+	`CREATE TABLE idempotency_keys (
+		user_id      TEXT NOT NULL,
+		key          TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status       INTEGER NOT NULL DEFAULT 0,
+		header       TEXT NOT NULL DEFAULT '{}',
+		body         BLOB,
+		created_at   TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);
+	CREATE INDEX idempotency_keys_expires_at ON idempotency_keys(expires_at);`,
+
+	// 5: user preferences
+	`CREATE TABLE user_preferences (
+		user_id        TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		home_time_zone TEXT NOT NULL DEFAULT 'UTC',
+		currency       TEXT NOT NULL DEFAULT '',
+		distance_unit  TEXT NOT NULL DEFAULT 'km',
+		locale         TEXT NOT NULL DEFAULT 'en',
+		version        INTEGER NOT NULL DEFAULT 1,
+		updated_at     TIMESTAMP NOT NULL
+	);`,
+
+	// 6: user roles and the append-only audit log
+	`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+	CREATE TABLE audit_log (
+		id          TEXT PRIMARY KEY,
+		occurred_at TIMESTAMP NOT NULL,
+		action      TEXT NOT NULL,
+		actor_id    TEXT NOT NULL DEFAULT '',
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id   TEXT NOT NULL DEFAULT '',
+		changes     TEXT NOT NULL DEFAULT 'null',
+		detail      TEXT NOT NULL DEFAULT '',
+		ip          TEXT NOT NULL DEFAULT '',
+		user_agent  TEXT NOT NULL DEFAULT '',
+		request_id  TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX audit_log_occurred_at ON audit_log(occurred_at, id);
+	CREATE INDEX audit_log_actor_id ON audit_log(actor_id, occurred_at);
+	CREATE INDEX audit_log_target ON audit_log(target_type, target_id, occurred_at);
+	CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;`,
+
+	// 7: outbound webhooks and their delivery queue
+	`CREATE TABLE webhooks (
+		id          TEXT PRIMARY KEY,
+		owner_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		url         TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		events      TEXT NOT NULL DEFAULT '[]',
+		active      INTEGER NOT NULL DEFAULT 1,
+		secret      TEXT NOT NULL,
+		version     INTEGER NOT NULL DEFAULT 1,
+		created_at  TIMESTAMP NOT NULL,
+		updated_at  TIMESTAMP NOT NULL
+	);
+	CREATE INDEX webhooks_owner_id ON webhooks(owner_id, created_at);
+	CREATE TABLE webhook_deliveries (
+		id               TEXT PRIMARY KEY,
+		webhook_id       TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_id         TEXT NOT NULL,
+		event_type       TEXT NOT NULL,
+		payload          TEXT NOT NULL,
+		status           TEXT NOT NULL,
+		attempt_count    INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at  TIMESTAMP,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error       TEXT NOT NULL DEFAULT '',
+		created_at       TIMESTAMP NOT NULL,
+		updated_at       TIMESTAMP NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE TABLE webhook_attempts (
+		delivery_id  TEXT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		number       INTEGER NOT NULL,
+		attempted_at TIMESTAMP NOT NULL,
+		status_code  INTEGER NOT NULL DEFAULT 0,
+		error        TEXT NOT NULL DEFAULT '',
+		duration_ms  INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (delivery_id, number)
+	);`,
+
+	// 8: places imported from GeoNames, with normalized names for search
+	`CREATE TABLE countries (
+		code          TEXT PRIMARY KEY,
+		iso3          TEXT NOT NULL DEFAULT '',
+		name          TEXT NOT NULL,
+		capital       TEXT NOT NULL DEFAULT '',
+		continent     TEXT NOT NULL DEFAULT '',
+		currency_code TEXT NOT NULL DEFAULT '',
+		population    INTEGER NOT NULL DEFAULT 0,
+		geoname_id    INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE regions (
+		code       TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		geoname_id INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE places (
+		id           INTEGER PRIMARY KEY,
+		name         TEXT NOT NULL,
+		kind         TEXT NOT NULL,
+		country_code TEXT NOT NULL DEFAULT '',
+		admin1_code  TEXT NOT NULL DEFAULT '',
+		latitude     REAL,
+		longitude    REAL,
+		population   INTEGER NOT NULL DEFAULT 0,
+		time_zone    TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX places_location ON places(latitude, longitude);
+	CREATE TABLE place_names (
+		name     TEXT NOT NULL,
+		place_id INTEGER NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		PRIMARY KEY (name, place_id)
+	) WITHOUT ROWID;
+	CREATE INDEX place_names_place_id ON place_names(place_id);`,
+
+	// 9: airports and airlines, and flight details of itinerary items
+	`CREATE TABLE airports (
+		ident             TEXT PRIMARY KEY,
+		iata              TEXT NOT NULL DEFAULT '',
+		icao              TEXT NOT NULL DEFAULT '',
+		type              TEXT NOT NULL,
+		name              TEXT NOT NULL,
+		city              TEXT NOT NULL DEFAULT '',
+		country_code      TEXT NOT NULL DEFAULT '',
+		region_code       TEXT NOT NULL DEFAULT '',
+		latitude          REAL NOT NULL,
+		longitude         REAL NOT NULL,
+		elevation_ft      INTEGER,
+		time_zone         TEXT NOT NULL DEFAULT '',
+		scheduled_service INTEGER NOT NULL DEFAULT 0,
+		search            TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX airports_iata ON airports(iata);
+	CREATE INDEX airports_icao ON airports(icao);
+	CREATE TABLE airlines (
+		id       INTEGER PRIMARY KEY,
+		iata     TEXT NOT NULL DEFAULT '',
+		icao     TEXT NOT NULL DEFAULT '',
+		name     TEXT NOT NULL,
+		callsign TEXT NOT NULL DEFAULT '',
+		country  TEXT NOT NULL DEFAULT '',
+		active   INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX airlines_iata ON airlines(iata);
+	CREATE INDEX airlines_icao ON airlines(icao);
+	ALTER TABLE itinerary_items ADD COLUMN flight TEXT;`,
+
+	// 10: item coordinates and time zone
+	`ALTER TABLE itinerary_items ADD COLUMN latitude REAL;
+	ALTER TABLE itinerary_items ADD COLUMN longitude REAL;
+	ALTER TABLE itinerary_items ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';`,
+
+	// 11: GPS tracks and waypoints
+	`CREATE TABLE tracks (
+		id               TEXT PRIMARY KEY,
+		trip_id          TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		name             TEXT NOT NULL DEFAULT '',
+		kind             TEXT NOT NULL,
+		source           TEXT NOT NULL,
+		segment_count    INTEGER NOT NULL DEFAULT 0,
+		point_count      INTEGER NOT NULL DEFAULT 0,
+		timed            INTEGER NOT NULL DEFAULT 0,
+		distance_km      REAL NOT NULL DEFAULT 0,
+		elevation_gain_m REAL,
+		elevation_loss_m REAL,
+		started_at       TIMESTAMP,
+		ended_at         TIMESTAMP,
+		created_at       TIMESTAMP NOT NULL
+	);
+	CREATE INDEX tracks_trip ON tracks(trip_id, created_at);
+	CREATE TABLE track_points (
+		track_id  TEXT NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+		segment   INTEGER NOT NULL,
+		seq       INTEGER NOT NULL,
+		latitude  REAL NOT NULL,
+		longitude REAL NOT NULL,
+		elevation REAL,
+		time      TIMESTAMP,
+		PRIMARY KEY (track_id, segment, seq)
+	) WITHOUT ROWID;
+	CREATE TABLE waypoints (
+		id          TEXT PRIMARY KEY,
+		trip_id     TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		name        TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		latitude    REAL NOT NULL,
+		longitude   REAL NOT NULL,
+		elevation   REAL,
+		time        TIMESTAMP,
+		created_at  TIMESTAMP NOT NULL
+	);
+	CREATE INDEX waypoints_trip ON waypoints(trip_id, created_at);`,
+
+	// 12: travel documents, with the number, notes and scan encrypted
+	`CREATE TABLE documents (
+		id                TEXT PRIMARY KEY,
+		owner_id          TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type              TEXT NOT NULL,
+		name              TEXT NOT NULL DEFAULT '',
+		issuing_country   TEXT NOT NULL,
+		issued_on         TEXT NOT NULL DEFAULT '',
+		expires_on        TEXT NOT NULL DEFAULT '',
+		scan_content_type TEXT,
+		scan_size         INTEGER,
+		scan_uploaded_at  TIMESTAMP,
+		version           INTEGER NOT NULL DEFAULT 1,
+		created_at        TIMESTAMP NOT NULL,
+		updated_at        TIMESTAMP NOT NULL,
+		number            BLOB NOT NULL,
+		notes             BLOB
+	);
+	CREATE INDEX documents_owner_id ON documents(owner_id, expires_on);
+	CREATE TABLE document_scans (
+		document_id TEXT PRIMARY KEY REFERENCES documents(id) ON DELETE CASCADE,
+		data        BLOB NOT NULL
+	);`,
+
+	// 13: entry requirement rules and the travellers of trips
+	`CREATE TABLE entry_rules (
+		id                       TEXT PRIMARY KEY,
+		nationality              TEXT NOT NULL,
+		destination              TEXT NOT NULL,
+		requirement              TEXT NOT NULL,
+		max_stay_days            INTEGER,
+		passport_validity_months INTEGER NOT NULL DEFAULT 0,
+		effective_from           TEXT NOT NULL,
+		effective_until          TEXT NOT NULL DEFAULT '',
+		notes                    TEXT NOT NULL DEFAULT '',
+		created_at               TIMESTAMP NOT NULL
+	);
+	CREATE INDEX entry_rules_lookup ON entry_rules(destination, nationality, effective_from);
+	ALTER TABLE trips ADD COLUMN travellers TEXT NOT NULL DEFAULT '[]';`,
+
+	// 14: files attached to trips and itinerary items, stored once per content hash
+	`CREATE TABLE blobs (
+		hash       TEXT PRIMARY KEY,
+		size       INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE attachments (
+		id           TEXT PRIMARY KEY,
+		trip_id      TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		item_id      TEXT REFERENCES itinerary_items(id) ON DELETE CASCADE,
+		blob_hash    TEXT NOT NULL REFERENCES blobs(hash),
+		filename     TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size         INTEGER NOT NULL,
+		created_at   TIMESTAMP NOT NULL
+	);
+	CREATE INDEX attachments_trip_id ON attachments(trip_id, created_at);
+	CREATE INDEX attachments_item_id ON attachments(item_id);
+	CREATE INDEX attachments_blob_hash ON attachments(blob_hash);`,
+
+	// 15: what was read from attached photos, per content
+	`CREATE TABLE photos (
+		hash       TEXT PRIMARY KEY REFERENCES blobs(hash) ON DELETE CASCADE,
+		width      INTEGER NOT NULL,
+		height     INTEGER NOT NULL,
+		local_time TEXT NOT NULL DEFAULT '',
+		taken_at   TIMESTAMP,
+		latitude   REAL,
+		longitude  REAL,
+		thumbnails INTEGER NOT NULL DEFAULT 0
+	);`,
+
+	// 16: journal entries about the days of trips, linking to items and photos
+	`CREATE TABLE journal_entries (
+		id         TEXT PRIMARY KEY,
+		trip_id    TEXT NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		author_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		day        TEXT NOT NULL,
+		title      TEXT NOT NULL DEFAULT '',
+		body       TEXT NOT NULL DEFAULT '',
+		mood       TEXT NOT NULL DEFAULT '',
+		weather    TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		version    INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX journal_entries_trip_id ON journal_entries(trip_id, day);
+	CREATE TABLE journal_entry_items (
+		entry_id TEXT NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+		item_id  TEXT NOT NULL REFERENCES itinerary_items(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (entry_id, item_id)
+	);
+	CREATE INDEX journal_entry_items_item_id ON journal_entry_items(item_id);
+	CREATE TABLE journal_entry_photos (
+		entry_id      TEXT NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+		attachment_id TEXT NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+		position      INTEGER NOT NULL,
+		PRIMARY KEY (entry_id, attachment_id)
+	);
+	CREATE INDEX journal_entry_photos_attachment_id ON journal_entry_photos(attachment_id);`,
+
+	// 17: trigram index of the search names of each place, for typo-tolerant search
+	`CREATE VIRTUAL TABLE place_search USING fts5(names, tokenize = 'trigram');
+	INSERT INTO place_search (rowid, names)
+		SELECT place_id, group_concat(name, char(10)) FROM place_names GROUP BY place_id;
+	CREATE TRIGGER places_search_delete AFTER DELETE ON places BEGIN
+		DELETE FROM place_search WHERE rowid = old.id;
+	END;`,
+}
+
+// migrate applies every migration newer than the recorded schema version
+func (s *Store) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		err := s.withTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LocalTimeLayout is the layout of wall clock times without a UTC offset
+const LocalTimeLayout = "2006-01-02T15:04:05"
+
+// Photo is what was read from an image and its EXIF metadata on upload
+type Photo struct {
+	Width  int `json:"width" doc:"In pixels, as shown upright"`
+	Height int `json:"height" doc:"In pixels, as shown upright"`
+
+	// LocalTime is the wall clock time where the photo was taken, from the
+	// camera's clock, or from the GPS time in the time zone of the GPS
+	// position when the camera recorded both
+	LocalTime string `json:"local_time,omitempty" doc:"Wall clock time without UTC offset, e.g. 2024-05-01T14:30:00"`
+
+	// TakenAt is when the photo was taken, when the camera recorded its UTC
+	// offset, a GPS time, or a GPS position placing its clock in a time zone
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+
+	Latitude  *float64 `json:"latitude,omitempty" doc:"GPS position recorded by the camera"`
+	Longitude *float64 `json:"longitude,omitempty" doc:"GPS position recorded by the camera"`
+
+	Thumbnails bool `json:"thumbnails" doc:"Whether thumbnails were made; they are not for images over 50 megapixels"`
+}
+
+const photoColumns = `hash, width, height, local_time, taken_at, latitude, longitude, thumbnails`
+
+// insertPhoto records the photo details of content, unless they already are
+func insertPhoto(ctx context.Context, tx *sql.Tx, hash string, p *Photo) error {
+	if p == nil {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO photos (`+photoColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING`,
+		hash, p.Width, p.Height, p.LocalTime, p.TakenAt, p.Latitude, p.Longitude, p.Thumbnails); err != nil {
+		return fmt.Errorf("recording photo: %w", err)
+	}
+	return nil
+}
+
+// loadPhotos sets the photo details of the attachments whose content is a photo
+func (s *Store) loadPhotos(ctx context.Context, attached []*Attachment) error {
+	if len(attached) == 0 {
+		return nil
+	}
+	byHash := make(map[string][]*Attachment, len(attached))
+	args := make([]any, 0, len(attached))
+	for _, a := range attached {
+		if byHash[a.SHA256] == nil {
+			args = append(args, a.SHA256)
+		}
+		byHash[a.SHA256] = append(byHash[a.SHA256], a)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := s.db.QueryContext(ctx, `SELECT `+photoColumns+` FROM photos WHERE hash IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("loading photos: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			hash    string
+			p       Photo
+			takenAt sql.NullTime
+		)
+		if err := rows.Scan(&hash, &p.Width, &p.Height, &p.LocalTime, &takenAt, &p.Latitude, &p.Longitude, &p.Thumbnails); err != nil {
+			return err
+		}
+		if takenAt.Valid {
+			t := takenAt.Time.UTC()
+			p.TakenAt = &t
+		}
+		for _, a := range byHash[hash] {
+			a.Photo = &p
+		}
+	}
+	return rows.Err()
+}
+
+// TripPhotos returns the attachments of a trip and its items that are photos,
+// with their photo details, in the order they were uploaded
+func (s *Store) TripPhotos(ctx context.Context, tripID string) ([]*Attachment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+attachmentColumns+` FROM attachments
+		WHERE trip_id = ? AND blob_hash IN (SELECT hash FROM photos)
+		ORDER BY created_at, id`, tripID)
+	if err != nil {
+		return nil, fmt.Errorf("listing photos: %w", err)
+	}
+	defer rows.Close()
+
+	photos := []*Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadPhotos(ctx, photos); err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
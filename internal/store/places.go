@@ -0,0 +1,363 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Place kinds
+const (
+	PlaceCity    = "city"
+	PlaceRegion  = "region"
+	PlaceCountry = "country"
+)
+
+// PlaceKinds are the kinds of imported places
+var PlaceKinds = []string{PlaceCity, PlaceRegion, PlaceCountry}
+
+// Place is a city, first-level administrative region or country imported from GeoNames
+type Place struct {
+	ID          int64    `json:"id" doc:"GeoNames ID"`
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	CountryCode string   `json:"country_code,omitempty" doc:"ISO 3166-1 alpha-2 code"`
+	CountryName string   `json:"country_name,omitempty"`
+	Admin1Code  string   `json:"admin1_code,omitempty"`
+	RegionName  string   `json:"region_name,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Population  int64    `json:"population"`
+	TimeZone    string   `json:"time_zone,omitempty" doc:"IANA time zone"`
+}
+
+// Country is a row of the GeoNames country table
+type Country struct {
+	Code         string
+	ISO3         string
+	Name         string
+	Capital      string
+	Continent    string
+	CurrencyCode string
+	Population   int64
+	GeonameID    int64
+}
+
+// Region is a first-level administrative division (state, province, …)
+type Region struct {
+	// Code is <country code>.<admin1 code>, e.g. US.CA
+	Code      string
+	Name      string
+	GeonameID int64
+}
+
+// PlaceCandidate is a place whose search names start with a searched prefix
+type PlaceCandidate struct {
+	Place
+	// Names are the place's normalized search names that matched
+	Names []string
+}
+
+const placeColumns = `p.id, p.name, p.kind, p.country_code, COALESCE(c.name, ''), p.admin1_code, COALESCE(r.name, ''),
+	p.latitude, p.longitude, p.population, p.time_zone`
+
+const placeJoins = `LEFT JOIN countries c ON c.code = p.country_code
+	LEFT JOIN regions r ON r.code = p.country_code || '.' || p.admin1_code`
+
+// scanPlace reads a row selected with placeColumns
+func scanPlace(row rowScanner) (*Place, error) {
+	var (
+		p        Place
+		lat, lon sql.NullFloat64
+	)
+	if err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.CountryCode, &p.CountryName, &p.Admin1Code, &p.RegionName,
+		&lat, &lon, &p.Population, &p.TimeZone); err != nil {
+		return nil, notFound(err)
+	}
+	if lat.Valid && lon.Valid {
+		p.Latitude, p.Longitude = &lat.Float64, &lon.Float64
+	}
+	return &p, nil
+}
+
+// PlaceImport writes imported places in a single transaction
+type PlaceImport struct {
+	ctx context.Context
+	tx  *sql.Tx
+
+	upsertPlace, insertPlace, deleteNames, insertName, deleteSearch, insertSearch, upsertCountry, upsertRegion *sql.Stmt
+}
+
+// BeginPlaceImport starts an import; call Commit to keep it or Rollback to discard it
+func (s *Store) BeginPlaceImport(ctx context.Context) (*PlaceImport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning place import: %w", err)
+	}
+	imp := &PlaceImport{ctx: ctx, tx: tx}
+
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&imp.upsertPlace, `
+			INSERT INTO places (id, name, kind, country_code, admin1_code, latitude, longitude, population, time_zone)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET name = excluded.name, kind = excluded.kind, country_code = excluded.country_code,
+				admin1_code = excluded.admin1_code, latitude = excluded.latitude, longitude = excluded.longitude,
+				population = excluded.population, time_zone = excluded.time_zone`},
+		// Countries and regions from their tables have no coordinates; keep any the dump provided
+		{&imp.insertPlace, `
+			INSERT INTO places (id, name, kind, country_code, admin1_code, population)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING`},
+		{&imp.deleteNames, `DELETE FROM place_names WHERE place_id = ?`},
+		{&imp.insertName, `INSERT OR IGNORE INTO place_names (name, place_id) VALUES (?, ?)`},
+		{&imp.deleteSearch, `DELETE FROM place_search WHERE rowid = ?`},
+		{&imp.insertSearch, `
+			INSERT INTO place_search (rowid, names)
+			SELECT place_id, group_concat(name, char(10)) FROM place_names WHERE place_id = ? GROUP BY place_id`},
+		{&imp.upsertCountry, `
+			INSERT INTO countries (code, iso3, name, capital, continent, currency_code, population, geoname_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (code) DO UPDATE SET iso3 = excluded.iso3, name = excluded.name, capital = excluded.capital,
+				continent = excluded.continent, currency_code = excluded.currency_code,
+				population = excluded.population, geoname_id = excluded.geoname_id`},
+		{&imp.upsertRegion, `
+			INSERT INTO regions (code, name, geoname_id) VALUES (?, ?, ?)
+			ON CONFLICT (code) DO UPDATE SET name = excluded.name, geoname_id = excluded.geoname_id`},
+	}
+	for _, s := range statements {
+		stmt, err := tx.PrepareContext(ctx, s.query)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("preparing place import: %w", err)
+		}
+		*s.stmt = stmt
+	}
+	return imp, nil
+}
+
+// AddPlace stores a place and replaces its normalized search names
+func (imp *PlaceImport) AddPlace(p *Place, names []string) error {
+	if _, err := imp.upsertPlace.ExecContext(imp.ctx, p.ID, p.Name, p.Kind, p.CountryCode, p.Admin1Code,
+		p.Latitude, p.Longitude, p.Population, p.TimeZone); err != nil {
+		return fmt.Errorf("importing place %d: %w", p.ID, err)
+	}
+	if _, err := imp.deleteNames.ExecContext(imp.ctx, p.ID); err != nil {
+		return fmt.Errorf("importing place %d: %w", p.ID, err)
+	}
+	return imp.addNames(p.ID, names)
+}
+
+// AddCountry stores a country and makes it searchable by name
+func (imp *PlaceImport) AddCountry(c *Country, names []string) error {
+	if _, err := imp.upsertCountry.ExecContext(imp.ctx, c.Code, c.ISO3, c.Name, c.Capital, c.Continent,
+		c.CurrencyCode, c.Population, c.GeonameID); err != nil {
+		return fmt.Errorf("importing country %s: %w", c.Code, err)
+	}
+	if c.GeonameID == 0 {
+		return nil
+	}
+	if _, err := imp.insertPlace.ExecContext(imp.ctx, c.GeonameID, c.Name, PlaceCountry, c.Code, "", c.Population); err != nil {
+		return fmt.Errorf("importing country %s: %w", c.Code, err)
+	}
+	return imp.addNames(c.GeonameID, names)
+}
+
+// AddRegion stores a first-level administrative region and makes it searchable by name
+func (imp *PlaceImport) AddRegion(r *Region, names []string) error {
+	if _, err := imp.upsertRegion.ExecContext(imp.ctx, r.Code, r.Name, r.GeonameID); err != nil {
+		return fmt.Errorf("importing region %s: %w", r.Code, err)
+	}
+	if r.GeonameID == 0 {
+		return nil
+	}
+	country, admin1, _ := strings.Cut(r.Code, ".")
+	if _, err := imp.insertPlace.ExecContext(imp.ctx, r.GeonameID, r.Name, PlaceRegion, country, admin1, 0); err != nil {
+		return fmt.Errorf("importing region %s: %w", r.Code, err)
+	}
+	return imp.addNames(r.GeonameID, names)
+}
+
+// addNames adds normalized search names of a place and indexes all its
+// names again for fuzzy search
+func (imp *PlaceImport) addNames(id int64, names []string) error {
+	for _, name := range names {
+		if _, err := imp.insertName.ExecContext(imp.ctx, name, id); err != nil {
+			return fmt.Errorf("importing names of place %d: %w", id, err)
+		}
+	}
+	if _, err := imp.deleteSearch.ExecContext(imp.ctx, id); err != nil {
+		return fmt.Errorf("indexing names of place %d: %w", id, err)
+	}
+	if _, err := imp.insertSearch.ExecContext(imp.ctx, id); err != nil {
+		return fmt.Errorf("indexing names of place %d: %w", id, err)
+	}
+	return nil
+}
+
+// Commit keeps the imported data
+func (imp *PlaceImport) Commit() error {
+	return imp.tx.Commit()
+}
+
+// Rollback discards the imported data
+func (imp *PlaceImport) Rollback() error {
+	return imp.tx.Rollback()
+}
+
+// PlaceFilter narrows place searches; empty fields match everything
+type PlaceFilter struct {
+	CountryCode string
+	Kind        string
+}
+
+// where returns the SQL conditions and arguments of the filter on places aliased p
+func (f PlaceFilter) where() (string, []any) {
+	var (
+		clauses []string
+		args    []any
+	)
+	if f.CountryCode != "" {
+		clauses = append(clauses, "p.country_code = ?")
+		args = append(args, f.CountryCode)
+	}
+	if f.Kind != "" {
+		clauses = append(clauses, "p.kind = ?")
+		args = append(args, f.Kind)
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+// PlacesByNamePrefix returns up to limit places having a normalized search
+// name that starts with prefix, most populous first
+func (s *Store) PlacesByNamePrefix(ctx context.Context, prefix string, filter PlaceFilter, limit int) ([]*PlaceCandidate, error) {
+	cond, condArgs := filter.where()
+	// Names sharing the prefix sort between prefix and prefix followed by the highest code point
+	args := append([]any{prefix, prefix + "\U0010FFFF"}, condArgs...)
+	args = append(args, limit)
+
+	// Only the places kept are joined and have their names gathered
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+placeColumns+`, (SELECT group_concat(n.name, char(31)) FROM place_names n WHERE n.place_id = p.id)
+		FROM (
+			SELECT p.id FROM places p
+			WHERE p.id IN (SELECT place_id FROM place_names WHERE name >= ? AND name < ?)`+cond+`
+			ORDER BY p.population DESC, p.id
+			LIMIT ?
+		) m
+		JOIN places p ON p.id = m.id `+placeJoins+`
+		ORDER BY p.population DESC, p.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching places: %w", err)
+	}
+	return scanPlaceCandidates(rows)
+}
+
+// PlacesByTrigrams returns up to limit places whose normalized search names
+// share the most three-character sequences with query, which must have at
+// least three characters. Names a typo or two away from the query share
+// some of them, wherever the typos are.
+func (s *Store) PlacesByTrigrams(ctx context.Context, query string, filter PlaceFilter, limit int) ([]*PlaceCandidate, error) {
+	runes := []rune(query)
+	seen := map[string]bool{}
+	var terms []string
+	for i := 0; i+3 <= len(runes); i++ {
+		// Normalized names have no quotes to escape
+		if t := string(runes[i : i+3]); !seen[t] {
+			seen[t] = true
+			terms = append(terms, `"`+t+`"`)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	cond, condArgs := filter.where()
+	args := append([]any{strings.Join(terms, " OR ")}, condArgs...)
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+placeColumns+`, group_concat(n.name, char(31))
+		FROM (
+			SELECT place_search.rowid AS id, place_search.rank AS rank
+			FROM place_search JOIN places p ON p.id = place_search.rowid
+			WHERE place_search MATCH ?`+cond+`
+			ORDER BY place_search.rank
+			LIMIT ?
+		) m
+		JOIN places p ON p.id = m.id
+		JOIN place_names n ON n.place_id = p.id `+placeJoins+`
+		GROUP BY p.id
+		ORDER BY min(m.rank), p.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching places: %w", err)
+	}
+	return scanPlaceCandidates(rows)
+}
+
+// scanPlaceCandidates reads rows selected with placeColumns followed by the
+// search names joined with the unit separator
+func scanPlaceCandidates(rows *sql.Rows) ([]*PlaceCandidate, error) {
+	defer rows.Close()
+
+	var candidates []*PlaceCandidate
+	for rows.Next() {
+		var names string
+		p, err := scanPlace(extraScanner{rows: rows, extra: []any{&names}})
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, &PlaceCandidate{Place: *p, Names: strings.Split(names, "\x1f")})
+	}
+	return candidates, rows.Err()
+}
+
+// PlacesInBox returns the located places of a kind within the latitude and
+// longitude ranges. minLon may exceed maxLon for boxes crossing the antimeridian.
+func (s *Store) PlacesInBox(ctx context.Context, minLat, maxLat, minLon, maxLon float64, kind string) ([]*Place, error) {
+	lonCond := "p.longitude BETWEEN ? AND ?"
+	if minLon > maxLon {
+		lonCond = "(p.longitude >= ? OR p.longitude <= ?)"
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+placeColumns+`
+		FROM places p `+placeJoins+`
+		WHERE p.kind = ? AND p.latitude BETWEEN ? AND ? AND `+lonCond,
+		kind, minLat, maxLat, minLon, maxLon)
+	if err != nil {
+		return nil, fmt.Errorf("finding nearby places: %w", err)
+	}
+	defer rows.Close()
+
+	var places []*Place
+	for rows.Next() {
+		p, err := scanPlace(rows)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, p)
+	}
+	return places, rows.Err()
+}
+
+// GetPlace returns the place with the given GeoNames ID
+func (s *Store) GetPlace(ctx context.Context, id int64) (*Place, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+placeColumns+` FROM places p `+placeJoins+` WHERE p.id = ?`, id)
+	return scanPlace(row)
+}
+
+// CountryName returns the name of the country with an ISO 3166-1 alpha-2
+// code, or ErrNotFound if no country table has been imported for it
+func (s *Store) CountryName(ctx context.Context, code string) (string, error) {
+	var name string
+	if err := s.db.QueryRowContext(ctx, `SELECT name FROM countries WHERE code = ?`, code).Scan(&name); err != nil {
+		return "", notFound(err)
+	}
+	return name, nil
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Preferences are a user's settings for displaying trips
+type Preferences struct {
+	UserID       string    `json:"-"`
+	HomeTimeZone string    `json:"home_time_zone"`
+	Currency     string    `json:"currency,omitempty"`
+	DistanceUnit string    `json:"distance_unit"`
+	Locale       string    `json:"locale"`
+	Version      int64     `json:"version"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const preferenceColumns = `user_id, home_time_zone, currency, distance_unit, locale, version, updated_at`
+
+// GetPreferences returns a user's preferences, creating the defaults on first access
+func (s *Store) GetPreferences(ctx context.Context, userID string) (*Preferences, error) {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_preferences (user_id, updated_at) VALUES (?, ?) ON CONFLICT (user_id) DO NOTHING`,
+		userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("creating default preferences: %w", err)
+	}
+
+	var p Preferences
+	err = s.db.QueryRowContext(ctx, `SELECT `+preferenceColumns+` FROM user_preferences WHERE user_id = ?`, userID).
+		Scan(&p.UserID, &p.HomeTimeZone, &p.Currency, &p.DistanceUnit, &p.Locale, &p.Version, &p.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+// UpdatePreferences saves p if the stored preferences are still at p.Version,
+// then increments p.Version. It returns ErrVersionConflict if they changed meanwhile.
+func (s *Store) UpdatePreferences(ctx context.Context, p *Preferences) error {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_preferences SET home_time_zone = ?, currency = ?, distance_unit = ?, locale = ?,
+			version = version + 1, updated_at = ?
+		WHERE user_id = ? AND version = ?`,
+		p.HomeTimeZone, p.Currency, p.DistanceUnit, p.Locale, now, p.UserID, p.Version)
+	if err != nil {
+		return fmt.Errorf("updating preferences: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	p.Version++
+	p.UpdatedAt = now
+	return nil
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Track kinds: recorded tracks and planned routes
+const (
+	TrackKindTrack = "track"
+	TrackKindRoute = "route"
+)
+
+// Track file formats
+const (
+	TrackSourceGPX = "gpx"
+	TrackSourceKML = "kml"
+)
+
+// Track is a recorded track or planned route uploaded to a trip. Its points
+// are stored separately and read with TrackPoints.
+type Track struct {
+	ID       string `json:"id"`
+	TripID   string `json:"trip_id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind" doc:"track or route"`
+	Source   string `json:"source" doc:"Format of the uploaded file: gpx or kml"`
+	Segments int    `json:"segments" doc:"Continuous parts of the track, split where recording paused"`
+	Points   int    `json:"points"`
+
+	// Timed is set when every point has a timestamp
+	Timed bool `json:"timed"`
+
+	// DistanceKM sums the great-circle distances between consecutive points of each segment
+	DistanceKM float64 `json:"distance_km"`
+
+	// ElevationGainM and ElevationLossM are the total climb and descent,
+	// absent when the points have no elevation
+	ElevationGainM *float64 `json:"elevation_gain_m,omitempty"`
+	ElevationLossM *float64 `json:"elevation_loss_m,omitempty"`
+
+	StartedAt       *time.Time `json:"started_at,omitempty" doc:"Earliest point timestamp"`
+	EndedAt         *time.Time `json:"ended_at,omitempty" doc:"Latest point timestamp"`
+	DurationSeconds *int64     `json:"duration_seconds,omitempty" doc:"Time from the first to the last timestamp"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// TrackPoint is a point of a track
+type TrackPoint struct {
+	Segment   int
+	Latitude  float64
+	Longitude float64
+	Elevation *float64
+	Time      *time.Time
+}
+
+// Waypoint is a named point uploaded to a trip
+type Waypoint struct {
+	ID          string     `json:"id"`
+	TripID      string     `json:"trip_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Latitude    float64    `json:"latitude"`
+	Longitude   float64    `json:"longitude"`
+	Elevation   *float64   `json:"elevation,omitempty" doc:"Meters above sea level"`
+	Time        *time.Time `json:"time,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+const trackColumns = `id, trip_id, name, kind, source, segment_count, point_count, timed, distance_km,
+	elevation_gain_m, elevation_loss_m, started_at, ended_at, created_at`
+
+const waypointColumns = `id, trip_id, name, description, latitude, longitude, elevation, time, created_at`
+
+// scanTrack reads a row selected with trackColumns
+func scanTrack(row rowScanner) (*Track, error) {
+	var (
+		t                 Track
+		gain, loss        sql.NullFloat64
+		started, finished sql.NullTime
+	)
+	if err := row.Scan(&t.ID, &t.TripID, &t.Name, &t.Kind, &t.Source, &t.Segments, &t.Points, &t.Timed, &t.DistanceKM,
+		&gain, &loss, &started, &finished, &t.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if gain.Valid && loss.Valid {
+		t.ElevationGainM, t.ElevationLossM = &gain.Float64, &loss.Float64
+	}
+	if started.Valid && finished.Valid {
+		t.StartedAt, t.EndedAt = &started.Time, &finished.Time
+		duration := int64(finished.Time.Sub(started.Time) / time.Second)
+		t.DurationSeconds = &duration
+	}
+	return &t, nil
+}
+
+// scanWaypoint reads a row selected with waypointColumns
+func scanWaypoint(row rowScanner) (*Waypoint, error) {
+	var (
+		w         Waypoint
+		elevation sql.NullFloat64
+		at        sql.NullTime
+	)
+	if err := row.Scan(&w.ID, &w.TripID, &w.Name, &w.Description, &w.Latitude, &w.Longitude, &elevation, &at, &w.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if elevation.Valid {
+		w.Elevation = &elevation.Float64
+	}
+	if at.Valid {
+		w.Time = &at.Time
+	}
+	return &w, nil
+}
+
+// TrackImport writes the tracks and waypoints of an uploaded file in a
+// single transaction, point by point. The transaction holds the database's
+// write lock, so the file must already be received: feed it from disk, not
+// from the client.
+type TrackImport struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	tripID string
+	seq    int64
+
+	insertTrack, finishTrack, insertPoint, insertWaypoint *sql.Stmt
+}
+
+// BeginTrackImport starts an import into a trip; call Commit to keep it or Rollback to discard it
+func (s *Store) BeginTrackImport(ctx context.Context, tripID string) (*TrackImport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning track import: %w", err)
+	}
+	imp := &TrackImport{ctx: ctx, tx: tx, tripID: tripID}
+
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&imp.insertTrack, `INSERT INTO tracks (id, trip_id, name, kind, source, created_at) VALUES (?, ?, ?, ?, ?, ?)`},
+		{&imp.finishTrack, `
+			UPDATE tracks SET name = ?, segment_count = ?, point_count = ?, timed = ?, distance_km = ?,
+				elevation_gain_m = ?, elevation_loss_m = ?, started_at = ?, ended_at = ?
+			WHERE id = ?`},
+		{&imp.insertPoint, `
+			INSERT INTO track_points (track_id, segment, seq, latitude, longitude, elevation, time)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`},
+		{&imp.insertWaypoint, `INSERT INTO waypoints (` + waypointColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+	}
+	for _, s := range statements {
+		stmt, err := tx.PrepareContext(ctx, s.query)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("preparing track import: %w", err)
+		}
+		*s.stmt = stmt
+	}
+	return imp, nil
+}
+
+// AddTrack stores a new track, assigning its ID and creation time. Add its
+// points with AddTrackPoint, then save its name and statistics with FinishTrack.
+func (imp *TrackImport) AddTrack(t *Track) error {
+	t.ID = uuid.NewString()
+	t.TripID = imp.tripID
+	t.CreatedAt = time.Now().UTC()
+	if _, err := imp.insertTrack.ExecContext(imp.ctx, t.ID, t.TripID, t.Name, t.Kind, t.Source, t.CreatedAt); err != nil {
+		return fmt.Errorf("importing track: %w", err)
+	}
+	return nil
+}
+
+// AddTrackPoint appends a point to a track
+func (imp *TrackImport) AddTrackPoint(t *Track, p *TrackPoint) error {
+	imp.seq++
+	var at any
+	if p.Time != nil {
+		at = p.Time.UTC()
+	}
+	if _, err := imp.insertPoint.ExecContext(imp.ctx, t.ID, p.Segment, imp.seq, p.Latitude, p.Longitude, p.Elevation, at); err != nil {
+		return fmt.Errorf("importing track point: %w", err)
+	}
+	return nil
+}
+
+// FinishTrack saves the name and statistics of a track
+func (imp *TrackImport) FinishTrack(t *Track) error {
+	var started, ended any
+	if t.StartedAt != nil && t.EndedAt != nil {
+		started, ended = t.StartedAt.UTC(), t.EndedAt.UTC()
+	}
+	if _, err := imp.finishTrack.ExecContext(imp.ctx, t.Name, t.Segments, t.Points, t.Timed, t.DistanceKM,
+		t.ElevationGainM, t.ElevationLossM, started, ended, t.ID); err != nil {
+		return fmt.Errorf("importing track: %w", err)
+	}
+	return nil
+}
+
+// AddWaypoint stores a new waypoint, assigning its ID and creation time
+func (imp *TrackImport) AddWaypoint(w *Waypoint) error {
+	w.ID = uuid.NewString()
+	w.TripID = imp.tripID
+	w.CreatedAt = time.Now().UTC()
+	var at any
+	if w.Time != nil {
+		at = w.Time.UTC()
+	}
+	if _, err := imp.insertWaypoint.ExecContext(imp.ctx, w.ID, w.TripID, w.Name, w.Description, w.Latitude, w.Longitude,
+		w.Elevation, at, w.CreatedAt); err != nil {
+		return fmt.Errorf("importing waypoint: %w", err)
+	}
+	return nil
+}
+
+// Commit keeps the imported data
+func (imp *TrackImport) Commit() error {
+	return imp.tx.Commit()
+}
+
+// Rollback discards the imported data
+func (imp *TrackImport) Rollback() error {
+	return imp.tx.Rollback()
+}
+
+// ListTracks returns the tracks of a trip in upload order
+func (s *Store) ListTracks(ctx context.Context, tripID string) ([]*Track, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+trackColumns+` FROM tracks WHERE trip_id = ? ORDER BY created_at, id`, tripID)
+	if err != nil {
+		return nil, fmt.Errorf("listing tracks: %w", err)
+	}
+	defer rows.Close()
+
+	tracks := []*Track{}
+	for rows.Next() {
+		t, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// GetTrack returns a track of a trip
+func (s *Store) GetTrack(ctx context.Context, tripID, id string) (*Track, error) {
+	return scanTrack(s.db.QueryRowContext(ctx, `SELECT `+trackColumns+` FROM tracks WHERE trip_id = ? AND id = ?`, tripID, id))
+}
+
+// DeleteTrack deletes a track with its points
+func (s *Store) DeleteTrack(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM tracks WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("deleting track: %w", err)
+	}
+	return nil
+}
+
+// TrackPoints calls fn with the points of a track in order. A segment of -1
+// reads every segment. The points are streamed, so tracks of any size can be read.
+func (s *Store) TrackPoints(ctx context.Context, trackID string, segment int, fn func(*TrackPoint) error) error {
+	query := `SELECT segment, latitude, longitude, elevation, time FROM track_points WHERE track_id = ?`
+	args := []any{trackID}
+	if segment >= 0 {
+		query += ` AND segment = ?`
+		args = append(args, segment)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY segment, seq`, args...)
+	if err != nil {
+		return fmt.Errorf("reading track points: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p         TrackPoint
+			elevation sql.NullFloat64
+			at        sql.NullTime
+		)
+		if err := rows.Scan(&p.Segment, &p.Latitude, &p.Longitude, &elevation, &at); err != nil {
+			return fmt.Errorf("reading track points: %w", err)
+		}
+		if elevation.Valid {
+			p.Elevation = &elevation.Float64
+		}
+		if at.Valid {
+			p.Time = &at.Time
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListWaypoints returns the waypoints of a trip in upload order
+func (s *Store) ListWaypoints(ctx context.Context, tripID string) ([]*Waypoint, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+waypointColumns+` FROM waypoints WHERE trip_id = ? ORDER BY created_at, id`, tripID)
+	if err != nil {
+		return nil, fmt.Errorf("listing waypoints: %w", err)
+	}
+	defer rows.Close()
+
+	waypoints := []*Waypoint{}
+	for rows.Next() {
+		w, err := scanWaypoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		waypoints = append(waypoints, w)
+	}
+	return waypoints, rows.Err()
+}
+
+// GetWaypoint returns a waypoint of a trip
+func (s *Store) GetWaypoint(ctx context.Context, tripID, id string) (*Waypoint, error) {
+	return scanWaypoint(s.db.QueryRowContext(ctx, `SELECT `+waypointColumns+` FROM waypoints WHERE trip_id = ? AND id = ?`, tripID, id))
+}
+
+// DeleteWaypoint deletes a waypoint
+func (s *Store) DeleteWaypoint(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM waypoints WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("deleting waypoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// TripStatuses are the allowed trip statuses
+var TripStatuses = []string{"planned", "active", "completed", "cancelled"}
+
+// Trip is a planned or past journey owned by a user
+type Trip struct {
+	ID          string      `json:"id"`
+	OwnerID     string      `json:"owner_id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	StartDate   string      `json:"start_date,omitempty"`
+	EndDate     string      `json:"end_date,omitempty"`
+	Status      string      `json:"status"`
+	Tags        []string    `json:"tags"`
+	Travellers  []Traveller `json:"travellers" doc:"People travelling with the owner, whose entry requirements are checked along with the owner's"`
+	Version     int64       `json:"version"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Traveller is a companion on a trip
+type Traveller struct {
+	Name              string `json:"name"`
+	Nationality       string `json:"nationality" doc:"ISO 3166-1 alpha-2 code of the passport travelled on"`
+	PassportExpiresOn string `json:"passport_expires_on,omitempty"`
+}
+
+// TripListSpec whitelists the sorts and filters of trip listings
+var TripListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"name":       {Column: "name", Kind: listquery.String},
+		"start_date": {Column: "start_date", Kind: listquery.Date},
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listquery.Filter{
+		"status":     {Column: "status", Op: listquery.In, Allowed: TripStatuses},
+		"start_date": {Column: "start_date", Op: listquery.Range, Kind: listquery.Date},
+		"end_date":   {Column: "end_date", Op: listquery.Range, Kind: listquery.Date},
+		"tag":        {Column: "tags", Op: listquery.Tags},
+	},
+}
+
+const tripColumns = `id, owner_id, name, description, start_date, end_date, status, tags, travellers, version, created_at, updated_at`
+
+// scanTrip reads a row selected with tripColumns
+func scanTrip(row rowScanner) (*Trip, error) {
+	var (
+		t          Trip
+		tags       string
+		travellers string
+	)
+	if err := row.Scan(&t.ID, &t.OwnerID, &t.Name, &t.Description, &t.StartDate, &t.EndDate, &t.Status, &tags, &travellers,
+		&t.Version, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+		return nil, fmt.Errorf("decoding trip tags: %w", err)
+	}
+	if err := json.Unmarshal([]byte(travellers), &t.Travellers); err != nil {
+		return nil, fmt.Errorf("decoding trip travellers: %w", err)
+	}
+	return &t, nil
+}
+
+// CreateTrip stores a new trip, assigning its ID and timestamps
+func (s *Store) CreateTrip(ctx context.Context, t *Trip) error {
+	t.ID = uuid.NewString()
+	t.Version = 1
+	t.CreatedAt = time.Now().UTC()
+	t.UpdatedAt = t.CreatedAt
+	if t.Status == "" {
+		t.Status = TripStatuses[0]
+	}
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	if t.Travellers == nil {
+		t.Travellers = []Traveller{}
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO trips (`+tripColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.OwnerID, t.Name, t.Description, t.StartDate, t.EndDate, t.Status, encodeTags(t.Tags), encodeTravellers(t.Travellers),
+		t.Version, t.CreatedAt, t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("creating trip: %w", err)
+	}
+	return nil
+}
+
+// UpdateTrip saves t if the stored trip is still at t.Version, then
+// increments t.Version. It returns ErrVersionConflict if the trip changed meanwhile.
+func (s *Store) UpdateTrip(ctx context.Context, t *Trip) error {
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	if t.Travellers == nil {
+		t.Travellers = []Traveller{}
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE trips SET name = ?, description = ?, start_date = ?, end_date = ?, status = ?, tags = ?, travellers = ?,
+			version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?`,
+		t.Name, t.Description, t.StartDate, t.EndDate, t.Status, encodeTags(t.Tags), encodeTravellers(t.Travellers),
+		now, t.ID, t.Version)
+	if err != nil {
+		return fmt.Errorf("updating trip: %w", err)
+	}
+	if err := s.requireVersion(ctx, res, "trips", t.ID); err != nil {
+		return err
+	}
+	t.Version++
+	t.UpdatedAt = now
+	return nil
+}
+
+// DeleteTrip deletes a trip and everything attached to it if it is still at version
+func (s *Store) DeleteTrip(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM trips WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting trip: %w", err)
+	}
+	return s.requireVersion(ctx, res, "trips", id)
+}
+
+// GetTrip returns the trip with the given ID
+func (s *Store) GetTrip(ctx context.Context, id string) (*Trip, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+tripColumns+` FROM trips WHERE id = ?`, id)
+	return scanTrip(row)
+}
+
+// ListTrips returns one page of the trips owned by a user and the cursor of the next page
+func (s *Store) ListTrips(ctx context.Context, ownerID string, q *listquery.Query) ([]*Trip, string, error) {
+	return listPage(ctx, s.db, "trips", tripColumns, "trips.owner_id = ?", []any{ownerID}, q, scanTrip,
+		func(t *Trip) string { return t.ID })
+}
+
+// TripExport is a self-contained snapshot of a trip and everything attached to it
+type TripExport struct {
+	Trip       *Trip            `json:"trip"`
+	Items      []*ItineraryItem `json:"items"`
+	Expenses   []*Expense       `json:"expenses"`
+	ExportedAt time.Time        `json:"exported_at"`
+}
+
+// ExportTrip collects a trip and its related records for export
+func (s *Store) ExportTrip(ctx context.Context, id string) (*TripExport, error) {
+	trip, err := s.GetTrip(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.AllItems(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	expenses, err := s.allExpenses(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &TripExport{Trip: trip, Items: items, Expenses: expenses, ExportedAt: time.Now().UTC()}, nil
+}
+
+// encodeTags serializes tags for a tags column
+func encodeTags(tags []string) string {
+	if tags == nil {
+		tags = []string{}
+	}
+	data, _ := json.Marshal(tags)
+	return string(data)
+}
+
+// encodeTravellers serializes the travellers of a trip
+func encodeTravellers(travellers []Traveller) string {
+	if travellers == nil {
+		travellers = []Traveller{}
+	}
+	data, _ := json.Marshal(travellers)
+	return string(data)
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserRoles are the allowed user roles
+var UserRoles = []string{RoleUser, RoleAdmin}
+
+// userSeenInterval is how stale the last-seen time of an active user may get
+const userSeenInterval = 5 * time.Minute
+
+// User is a user that has signed in at least once
+type User struct {
+	ID                string            `json:"id"`
+	Email             string            `json:"email"`
+	Username          string            `json:"username"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	Role              string            `json:"role"`
+	Disabled          bool              `json:"disabled"`
+	SessionsRevokedAt *time.Time        `json:"sessions_revoked_at,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	LastSeenAt        time.Time         `json:"last_seen_at"`
+}
+
+const userColumns = `id, email, username, metadata, role, disabled, sessions_revoked_at, created_at, last_seen_at`
+
+// scanUser reads a row selected with userColumns
+func scanUser(row rowScanner) (*User, error) {
+	var (
+		u        User
+		metadata string
+		revoked  sql.NullTime
+	)
+	if err := row.Scan(&u.ID, &u.Email, &u.Username, &metadata, &u.Role, &u.Disabled, &revoked, &u.CreatedAt, &u.LastSeenAt); err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(metadata), &u.Metadata); err != nil {
+		return nil, fmt.Errorf("decoding user metadata: %w", err)
+	}
+	if revoked.Valid {
+		u.SessionsRevokedAt = &revoked.Time
+	}
+	return &u, nil
+}
+
+// UpsertUser records a signed-in user, refreshing their profile and last-seen time,
+// and returns the stored record
+func (s *Store) UpsertUser(ctx context.Context, id, email, username string, metadata map[string]string) (*User, error) {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("encoding user metadata: %w", err)
+	}
+
+	now := time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO users (id, email, username, metadata, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			email = excluded.email,
+			username = excluded.username,
+			metadata = excluded.metadata,
+			last_seen_at = excluded.last_seen_at`,
+		id, email, username, string(encoded), now, now)
+	if err != nil {
+		return nil, fmt.Errorf("upserting user: %w", err)
+	}
+
+	return s.GetUser(ctx, id)
+}
+
+// RecordUser returns the stored record of a signed-in user, upserting it only
+// when it is missing, its profile changed or it was last seen more than
+// userSeenInterval ago, so that most requests only read from the database
+func (s *Store) RecordUser(ctx context.Context, id, email, username string, metadata map[string]string) (*User, error) {
+	u, err := s.GetUser(ctx, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if u != nil && u.Email == email && u.Username == username && maps.Equal(u.Metadata, metadata) &&
+		time.Since(u.LastSeenAt) < userSeenInterval {
+		return u, nil
+	}
+	return s.UpsertUser(ctx, id, email, username, metadata)
+}
+
+// GetUser returns the user with the given ID
+func (s *Store) GetUser(ctx context.Context, id string) (*User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+	return scanUser(row)
+}
+
+// ListUsers returns all users ordered by creation time
+func (s *Store) ListUsers(ctx context.Context) ([]*User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserDisabled disables or re-enables a user
+func (s *Store) SetUserDisabled(ctx context.Context, id string, disabled bool) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET disabled = ? WHERE id = ?`, disabled, id)
+	if err != nil {
+		return fmt.Errorf("updating user: %w", err)
+	}
+	return requireRow(res)
+}
+
+// SetUserRole changes the role of a user
+func (s *Store) SetUserRole(ctx context.Context, id, role string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return fmt.Errorf("updating user: %w", err)
+	}
+	return requireRow(res)
+}
+
+// RevokeSessions invalidates every token issued to the user before now
+func (s *Store) RevokeSessions(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET sessions_revoked_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("revoking sessions: %w", err)
+	}
+	return requireRow(res)
+}
+
+// requireRow returns ErrNotFound if an update touched no rows
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"vibed-traveller/internal/listquery"
+
+	"github.com/google/uuid"
+)
+
+// Webhook event types
+const (
+	EventTripCreated    = "trip.created"
+	EventTripUpdated    = "trip.updated"
+	EventTripDeleted    = "trip.deleted"
+	EventItemCreated    = "item.created"
+	EventItemUpdated    = "item.updated"
+	EventItemDeleted    = "item.deleted"
+	EventExpenseAdded   = "expense.added"
+	EventExpenseUpdated = "expense.updated"
+	EventExpenseDeleted = "expense.deleted"
+)
+
+// WebhookEventTypes lists the event types webhooks can subscribe to
+var WebhookEventTypes = []string{
+	EventTripCreated, EventTripUpdated, EventTripDeleted,
+	EventItemCreated, EventItemUpdated, EventItemDeleted,
+	EventExpenseAdded, EventExpenseUpdated, EventExpenseDeleted,
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryStatuses are the statuses a webhook delivery can be in
+var DeliveryStatuses = []string{DeliveryPending, DeliverySucceeded, DeliveryFailed}
+
+// webhookSecretPrefix marks webhook signing secrets
+const webhookSecretPrefix = "whsec_"
+
+// Webhook is an endpoint a user registered to receive events. The signing
+// secret is only returned when the webhook is created.
+type Webhook struct {
+	ID          string    `json:"id"`
+	OwnerID     string    `json:"owner_id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"-"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookEvent is the JSON body posted to webhook endpoints
+type WebhookEvent struct {
+	ID        string          `json:"id" doc:"Stays the same across redeliveries"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data" doc:"The resource after the change, or before it for deletions"`
+}
+
+// WebhookDelivery is one event queued for one webhook
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	WebhookID      string           `json:"webhook_id"`
+	EventID        string           `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	AttemptCount   int              `json:"attempt_count"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	LastStatusCode int              `json:"last_status_code,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Attempts       []WebhookAttempt `json:"attempts,omitempty"`
+}
+
+// WebhookAttempt records one try at sending a delivery
+type WebhookAttempt struct {
+	Number      int       `json:"number"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty" doc:"HTTP status of the response; absent if none was received"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+// DueDelivery is a claimed delivery with what is needed to send it
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// WebhookListSpec whitelists the sorts and filters of webhook listings
+var WebhookListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listquery.Filter{
+		"event": {Column: "events", Op: listquery.Tags},
+	},
+}
+
+// DeliveryListSpec whitelists the sorts and filters of delivery listings
+var DeliveryListSpec = listquery.Spec{
+	Sorts: map[string]listquery.Sort{
+		"created_at": {Column: "created_at", Kind: listquery.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listquery.Filter{
+		"status":     {Column: "status", Op: listquery.In, Allowed: DeliveryStatuses},
+		"event_type": {Column: "event_type", Op: listquery.In, Allowed: WebhookEventTypes},
+		"created_at": {Column: "created_at", Op: listquery.Range, Kind: listquery.Time},
+	},
+}
+
+const webhookColumns = `id, owner_id, url, description, events, active, secret, version, created_at, updated_at`
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempt_count, next_attempt_at,
+	last_status_code, last_error, created_at, updated_at`
+
+// scanWebhook reads a row selected with webhookColumns
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var (
+		w      Webhook
+		events string
+	)
+	if err := row.Scan(&w.ID, &w.OwnerID, &w.URL, &w.Description, &events, &w.Active, &w.Secret, &w.Version, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(events), &w.Events); err != nil {
+		return nil, fmt.Errorf("decoding webhook events: %w", err)
+	}
+	return &w, nil
+}
+
+// scanDelivery reads a row selected with deliveryColumns
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var (
+		d       WebhookDelivery
+		payload string
+		next    sql.NullTime
+	)
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.AttemptCount, &next,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	d.Payload = json.RawMessage(payload)
+	if next.Valid {
+		d.NextAttemptAt = &next.Time
+	}
+	return &d, nil
+}
+
+// newWebhookSecret generates a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating webhook secret: %w", err)
+	}
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateWebhook stores a new webhook, assigning its ID, signing secret and timestamps
+func (s *Store) CreateWebhook(ctx context.Context, w *Webhook) error {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	w.ID = uuid.NewString()
+	w.Secret = secret
+	w.Version = 1
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = w.CreatedAt
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		w.ID, w.OwnerID, w.URL, w.Description, encodeTags(w.Events), w.Active, w.Secret, w.Version, w.CreatedAt, w.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("creating webhook: %w", err)
+	}
+	return nil
+}
+
+// UpdateWebhook saves w if the stored webhook is still at w.Version, then
+// increments w.Version. The signing secret is not changed.
+func (s *Store) UpdateWebhook(ctx context.Context, w *Webhook) error {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhooks SET url = ?, description = ?, events = ?, active = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?`,
+		w.URL, w.Description, encodeTags(w.Events), w.Active, now, w.ID, w.Version)
+	if err != nil {
+		return fmt.Errorf("updating webhook: %w", err)
+	}
+	if err := s.requireVersion(ctx, res, "webhooks", w.ID); err != nil {
+		return err
+	}
+	w.Version++
+	w.UpdatedAt = now
+	return nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries if it is still at version
+func (s *Store) DeleteWebhook(ctx context.Context, id string, version int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND version = ?`, id, version)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+	return s.requireVersion(ctx, res, "webhooks", id)
+}
+
+// GetWebhook returns the webhook with the given ID
+func (s *Store) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	return scanWebhook(row)
+}
+
+// ListWebhooks returns one page of a user's webhooks and the cursor of the next page
+func (s *Store) ListWebhooks(ctx context.Context, ownerID string, q *listquery.Query) ([]*Webhook, string, error) {
+	return listPage(ctx, s.db, "webhooks", webhookColumns, "webhooks.owner_id = ?", []any{ownerID}, q, scanWebhook,
+		func(w *Webhook) string { return w.ID })
+}
+
+// EnqueueWebhookEvent queues an event for every active webhook of the owner
+// subscribed to its type. data is the resource the event is about.
+func (s *Store) EnqueueWebhookEvent(ctx context.Context, ownerID, eventType string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding event data: %w", err)
+	}
+	event := WebhookEvent{ID: uuid.NewString(), Type: eventType, CreatedAt: time.Now().UTC(), Data: encoded}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id FROM webhooks
+			WHERE owner_id = ? AND active AND EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE json_each.value = ?)`,
+			ownerID, eventType)
+		if err != nil {
+			return fmt.Errorf("finding subscribed webhooks: %w", err)
+		}
+		var webhookIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("finding subscribed webhooks: %w", err)
+			}
+			webhookIDs = append(webhookIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("finding subscribed webhooks: %w", err)
+		}
+
+		for _, webhookID := range webhookIDs {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				uuid.NewString(), webhookID, event.ID, eventType, string(payload), DeliveryPending,
+				event.CreatedAt, event.CreatedAt, event.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("queueing webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetDelivery returns a delivery of a webhook with its attempts
+func (s *Store) GetDelivery(ctx context.Context, webhookID, id string) (*WebhookDelivery, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? AND id = ?`, webhookID, id)
+	d, err := scanDelivery(row)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT number, attempted_at, status_code, error, duration_ms
+		FROM webhook_attempts WHERE delivery_id = ? ORDER BY number`, id)
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook attempts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a WebhookAttempt
+		if err := rows.Scan(&a.Number, &a.AttemptedAt, &a.StatusCode, &a.Error, &a.DurationMS); err != nil {
+			return nil, fmt.Errorf("listing webhook attempts: %w", err)
+		}
+		d.Attempts = append(d.Attempts, a)
+	}
+	return d, rows.Err()
+}
+
+// ListDeliveries returns one page of a webhook's deliveries and the cursor of the next page
+func (s *Store) ListDeliveries(ctx context.Context, webhookID string, q *listquery.Query) ([]*WebhookDelivery, string, error) {
+	return listPage(ctx, s.db, "webhook_deliveries", deliveryColumns, "webhook_deliveries.webhook_id = ?", []any{webhookID}, q, scanDelivery,
+		func(d *WebhookDelivery) string { return d.ID })
+}
+
+// Redeliver queues a new delivery of the same event, which keeps its ID and payload
+func (s *Store) Redeliver(ctx context.Context, d *WebhookDelivery) (*WebhookDelivery, error) {
+	now := time.Now().UTC()
+	copied := &WebhookDelivery{
+		ID:            uuid.NewString(),
+		WebhookID:     d.WebhookID,
+		EventID:       d.EventID,
+		EventType:     d.EventType,
+		Payload:       d.Payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		copied.ID, copied.WebhookID, copied.EventID, copied.EventType, string(copied.Payload), copied.Status, now, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("queueing redelivery: %w", err)
+	}
+	return copied, nil
+}
+
+// ClaimDueDeliveries returns up to limit pending deliveries whose next attempt
+// is due, pushing their next attempt lease into the future so that a delivery
+// is retried, not lost, if the process dies while sending it.
+func (s *Store) ClaimDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*DueDelivery, error) {
+	now := time.Now().UTC()
+	var due []*DueDelivery
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT `+deliveryColumns+`, url, secret FROM (
+				SELECT d.*, w.url AS url, w.secret AS secret
+				FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+				WHERE d.status = ? AND d.next_attempt_at <= ?
+			)
+			ORDER BY next_attempt_at, id
+			LIMIT ?`, DeliveryPending, now, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var item DueDelivery
+			d, err := scanDelivery(extraScanner{rows: rows, extra: []any{&item.URL, &item.Secret}})
+			if err != nil {
+				return err
+			}
+			item.WebhookDelivery = *d
+			due = append(due, &item)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		leaseUntil := now.Add(lease)
+		for _, d := range due {
+			if _, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?`, leaseUntil, d.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	return due, nil
+}
+
+// RecordDeliveryAttempt logs an attempt and moves the delivery to status.
+// next is when to try again and must be set for pending deliveries.
+func (s *Store) RecordDeliveryAttempt(ctx context.Context, d *WebhookDelivery, a WebhookAttempt, status string, next *time.Time) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_attempts (delivery_id, number, attempted_at, status_code, error, duration_ms)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			d.ID, a.Number, a.AttemptedAt, a.StatusCode, a.Error, a.DurationMS)
+		if err != nil {
+			return fmt.Errorf("recording webhook attempt: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = ?, attempt_count = ?, next_attempt_at = ?,
+				last_status_code = ?, last_error = ?, updated_at = ?
+			WHERE id = ?`,
+			status, a.Number, next, a.StatusCode, a.Error, time.Now().UTC(), d.ID)
+		if err != nil {
+			return fmt.Errorf("updating webhook delivery: %w", err)
+		}
+		return nil
+	})
+}
+
+// extraScanner appends extra destinations to every Scan
+type extraScanner struct {
+	rows  *sql.Rows
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,114 @@
+package timezone
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"vibed-traveller/internal/geo"
+
+	"github.com/ringsaturn/tzf"
+
+	// The runtime image has no zoneinfo files; embed the IANA database
+	_ "time/tzdata"
+)
+
+var (
+	finderOnce sync.Once
+	finder     tzf.F
+)
+
+// Lookup returns the IANA time zone containing p, from the boundary dataset
+// embedded in the binary, or "" if p is invalid. Points at sea resolve to
+// the nautical Etc/GMT±n zones. The dataset is loaded on first use.
+func Lookup(p geo.Point) string {
+	if !p.Valid() {
+		return ""
+	}
+	finderOnce.Do(func() {
+		f, err := tzf.NewDefaultFinder()
+		if err != nil {
+			slog.Error("Failed to load time zone boundaries", slog.Any("error", err))
+			return
+		}
+		finder = f
+	})
+	if finder == nil {
+		return ""
+	}
+	return finder.GetTimezoneName(p.Lon, p.Lat)
+}
+
+// Load returns the location of an IANA time zone name
+func Load(zone string) (*time.Location, error) {
+	if zone == "" {
+		return nil, fmt.Errorf("empty time zone")
+	}
+	return time.LoadLocation(zone)
+}
+
+// LocalTime is an instant as seen in a time zone
+type LocalTime struct {
+	Time     time.Time `json:"time" doc:"RFC 3339 time with the zone's UTC offset"`
+	TimeZone string    `json:"time_zone" doc:"IANA time zone"`
+
+	// Abbreviation is the zone abbreviation in effect, e.g. CEST
+	Abbreviation string `json:"abbreviation"`
+
+	// OffsetMinutes is the UTC offset in effect, which may be a multiple of 15 or 30 minutes
+	OffsetMinutes int  `json:"offset_minutes"`
+	DST           bool `json:"dst" doc:"Whether daylight saving time is in effect"`
+}
+
+// In returns t as seen in the named zone, with the offset and DST state in
+// effect at that instant
+func In(t time.Time, zone string) (LocalTime, error) {
+	loc, err := Load(zone)
+	if err != nil {
+		return LocalTime{}, err
+	}
+	local := t.In(loc)
+	abbreviation, offset := local.Zone()
+	return LocalTime{
+		Time:          local,
+		TimeZone:      zone,
+		Abbreviation:  abbreviation,
+		OffsetMinutes: offset / 60,
+		DST:           local.IsDST(),
+	}, nil
+}
+
+// Resolve returns the instant at which clocks in loc show the given wall
+// time. Wall times skipped by a forward transition resolve to the same
+// elapsed time after the transition (02:30 during a 02:00→03:00 jump becomes
+// 03:30); wall times repeated by a backward transition resolve to the first
+// occurrence.
+func Resolve(year int, month time.Month, day, hour, min, sec int, loc *time.Location) time.Time {
+	// Interpret the wall time with each offset in effect around it, and keep
+	// the candidates whose wall time reads back unchanged
+	wall := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	var first time.Time
+	for _, probe := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, offset := wall.Add(probe).In(loc).Zone()
+		t := wall.Add(-time.Duration(offset) * time.Second)
+		if sameWall(t.In(loc), wall) && (first.IsZero() || t.Before(first)) {
+			first = t
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+
+	// Skipped: apply the offset from before the transition, which lands the
+	// same distance past it
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	return wall.Add(-time.Duration(before) * time.Second)
+}
+
+// sameWall reports whether t shows the same wall clock reading as wall
+func sameWall(t, wall time.Time) bool {
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := wall.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2 && t.Hour() == wall.Hour() && t.Minute() == wall.Minute() && t.Second() == wall.Second()
+}
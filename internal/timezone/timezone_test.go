@@ -0,0 +1,110 @@
+package timezone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		wall string
+		want string
+	}{
+		// America/New_York: 02:00 EST jumps to 03:00 EDT, 02:00 EDT falls back to 01:00 EST
+		{"new york before spring forward", "America/New_York", "2024-03-10 01:30", "2024-03-10T06:30:00Z"},
+		{"new york skipped hour", "America/New_York", "2024-03-10 02:30", "2024-03-10T07:30:00Z"},
+		{"new york after spring forward", "America/New_York", "2024-03-10 03:30", "2024-03-10T07:30:00Z"},
+		{"new york repeated hour", "America/New_York", "2024-11-03 01:30", "2024-11-03T05:30:00Z"},
+		{"new york after fall back", "America/New_York", "2024-11-03 02:00", "2024-11-03T07:00:00Z"},
+
+		// Europe/London: 01:00 GMT jumps to 02:00 BST, 02:00 BST falls back to 01:00 GMT
+		{"london skipped hour", "Europe/London", "2024-03-31 01:30", "2024-03-31T01:30:00Z"},
+		{"london repeated hour", "Europe/London", "2024-10-27 01:30", "2024-10-27T00:30:00Z"},
+		{"london winter", "Europe/London", "2024-12-01 09:00", "2024-12-01T09:00:00Z"},
+
+		// Australia/Lord_Howe: +10:30, moving half an hour to +11:00 in summer
+		{"lord howe skipped half hour", "Australia/Lord_Howe", "2024-10-06 02:15", "2024-10-05T15:45:00Z"},
+		{"lord howe after spring forward", "Australia/Lord_Howe", "2024-10-06 02:45", "2024-10-05T15:45:00Z"},
+		{"lord howe repeated half hour", "Australia/Lord_Howe", "2024-04-07 01:45", "2024-04-06T14:45:00Z"},
+		{"lord howe after fall back", "Australia/Lord_Howe", "2024-04-07 02:00", "2024-04-06T15:30:00Z"},
+
+		// Asia/Kathmandu: +05:45 all year
+		{"kathmandu", "Asia/Kathmandu", "2024-06-01 12:00", "2024-06-01T06:15:00Z"},
+		{"kathmandu midnight", "Asia/Kathmandu", "2024-01-01 00:00", "2023-12-31T18:15:00Z"},
+
+		// Pacific/Chatham: +12:45, +13:45 in summer, changing at 02:45 standard time
+		{"chatham winter", "Pacific/Chatham", "2024-07-01 12:00", "2024-06-30T23:15:00Z"},
+		{"chatham summer", "Pacific/Chatham", "2024-01-01 12:00", "2023-12-31T22:15:00Z"},
+		{"chatham skipped hour", "Pacific/Chatham", "2024-09-29 03:00", "2024-09-28T14:15:00Z"},
+		{"chatham repeated hour", "Pacific/Chatham", "2024-04-07 03:00", "2024-04-06T13:15:00Z"},
+
+		// America/St_Johns: -03:30, -02:30 in summer
+		{"st johns skipped hour", "America/St_Johns", "2024-03-10 02:30", "2024-03-10T06:00:00Z"},
+		{"st johns repeated hour", "America/St_Johns", "2024-11-03 01:30", "2024-11-03T04:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := Load(tt.zone)
+			if err != nil {
+				t.Fatalf("loading %s: %v", tt.zone, err)
+			}
+			wall, err := time.Parse("2006-01-02 15:04", tt.wall)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Resolve(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, loc)
+			if got.UTC().Format(time.RFC3339) != tt.want {
+				t.Errorf("Resolve(%s in %s) = %s, want %s", tt.wall, tt.zone, got.UTC().Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestIn(t *testing.T) {
+	tests := []struct {
+		zone         string
+		instant      string
+		offset       int
+		dst          bool
+		abbreviation string
+		local        string
+	}{
+		{"America/New_York", "2024-07-01T12:00:00Z", -240, true, "EDT", "2024-07-01T08:00:00-04:00"},
+		{"America/New_York", "2024-03-10T06:59:00Z", -300, false, "EST", "2024-03-10T01:59:00-05:00"},
+		{"America/New_York", "2024-03-10T07:00:00Z", -240, true, "EDT", "2024-03-10T03:00:00-04:00"},
+		{"Europe/London", "2024-01-15T12:00:00Z", 0, false, "GMT", "2024-01-15T12:00:00Z"},
+		{"Europe/London", "2024-10-27T00:59:00Z", 60, true, "BST", "2024-10-27T01:59:00+01:00"},
+		{"Europe/London", "2024-10-27T01:00:00Z", 0, false, "GMT", "2024-10-27T01:00:00Z"},
+		{"Australia/Lord_Howe", "2024-01-15T00:00:00Z", 660, true, "+11", "2024-01-15T11:00:00+11:00"},
+		{"Australia/Lord_Howe", "2024-07-15T00:00:00Z", 630, false, "+1030", "2024-07-15T10:30:00+10:30"},
+		{"Asia/Kathmandu", "2024-06-01T06:15:00Z", 345, false, "+0545", "2024-06-01T12:00:00+05:45"},
+		{"Pacific/Chatham", "2024-01-15T00:00:00Z", 825, true, "+1345", "2024-01-15T13:45:00+13:45"},
+		{"Pacific/Chatham", "2024-07-15T00:00:00Z", 765, false, "+1245", "2024-07-15T12:45:00+12:45"},
+		{"America/St_Johns", "2024-01-15T12:00:00Z", -210, false, "NST", "2024-01-15T08:30:00-03:30"},
+	}
+	for _, tt := range tests {
+		instant, err := time.Parse(time.RFC3339, tt.instant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := In(instant, tt.zone)
+		if err != nil {
+			t.Fatalf("In(%s, %s): %v", tt.instant, tt.zone, err)
+		}
+		if got.OffsetMinutes != tt.offset || got.DST != tt.dst || got.Abbreviation != tt.abbreviation ||
+			got.Time.Format(time.RFC3339) != tt.local || got.TimeZone != tt.zone {
+			t.Errorf("In(%s, %s) = %+v, want offset %d, DST %t, %s at %s",
+				tt.instant, tt.zone, got, tt.offset, tt.dst, tt.abbreviation, tt.local)
+		}
+	}
+}
+
+func TestInUnknownZone(t *testing.T) {
+	for _, zone := range []string{"", "Mars/Olympus_Mons"} {
+		if _, err := In(time.Now(), zone); err == nil {
+			t.Errorf("In(%q) succeeded", zone)
+		}
+	}
+}
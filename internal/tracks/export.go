@@ -0,0 +1,184 @@
+package tracks
+
+import (
+	"context"
+	"encoding/xml"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// Point is a named point of an export
+type Point struct {
+	Name        string
+	Description string
+	// Type is the itinerary item kind of points made from items
+	Type      string
+	Latitude  float64
+	Longitude float64
+	Elevation *float64
+	Time      *time.Time
+}
+
+// Flight is a flight item between two airports
+type Flight struct {
+	Name               string
+	Departure, Arrival *Point
+}
+
+// Export is a trip's points, flights and tracks, ready to be written as GPX or KML
+type Export struct {
+	Trip *store.Trip
+
+	// Points are the located itinerary items in schedule order, then the uploaded waypoints
+	Points  []*Point
+	Flights []*Flight
+	Tracks  []*store.Track
+
+	store *store.Store
+}
+
+// NewExport loads everything of a trip but track points, which are read
+// while the export is written
+func NewExport(ctx context.Context, st *store.Store, trip *store.Trip) (*Export, error) {
+	items, err := st.AllItems(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+	waypoints, err := st.ListWaypoints(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := st.ListTracks(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Export{Trip: trip, Tracks: tracks, store: st}
+	for _, it := range items {
+		if f := it.Flight; f != nil {
+			e.Flights = append(e.Flights, &Flight{
+				Name:      it.Title,
+				Departure: airportPoint(f.Departure, startTime(it)),
+				Arrival:   airportPoint(f.Arrival, it.EndsAt),
+			})
+			continue
+		}
+		if it.Latitude == nil || it.Longitude == nil {
+			continue
+		}
+		description := it.Location
+		if description == "" {
+			description = it.Notes
+		}
+		e.Points = append(e.Points, &Point{
+			Name:        it.Title,
+			Description: description,
+			Type:        it.Kind,
+			Latitude:    *it.Latitude,
+			Longitude:   *it.Longitude,
+			Time:        startTime(it),
+		})
+	}
+	for _, w := range waypoints {
+		e.Points = append(e.Points, &Point{
+			Name:        w.Name,
+			Description: w.Description,
+			Latitude:    w.Latitude,
+			Longitude:   w.Longitude,
+			Elevation:   w.Elevation,
+			Time:        w.Time,
+		})
+	}
+	return e, nil
+}
+
+// startTime returns the start of an item, or nil for items without one
+func startTime(it *store.ItineraryItem) *time.Time {
+	if it.StartsAt.IsZero() {
+		return nil
+	}
+	startsAt := it.StartsAt
+	return &startsAt
+}
+
+// airportPoint returns the point of an airport at the time a flight leaves or reaches it
+func airportPoint(a *store.Airport, at *time.Time) *Point {
+	p := &Point{Name: a.Code(), Description: a.Name, Latitude: a.Latitude, Longitude: a.Longitude, Time: at}
+	if a.ElevationFT != nil {
+		meters := float64(*a.ElevationFT) * 0.3048
+		p.Elevation = &meters
+	}
+	return p
+}
+
+// trackPoint returns a track point as an export point
+func trackPoint(p *store.TrackPoint) *Point {
+	return &Point{Latitude: p.Latitude, Longitude: p.Longitude, Elevation: p.Elevation, Time: p.Time}
+}
+
+// xmlWriter writes XML tokens, keeping the first error so that documents
+// can be written without checking every call
+type xmlWriter struct {
+	enc *xml.Encoder
+	err error
+}
+
+// setErr records err unless an error was already recorded
+func (x *xmlWriter) setErr(err error) {
+	if x.err == nil {
+		x.err = err
+	}
+}
+
+// start opens an element with attributes given as name, value pairs
+func (x *xmlWriter) start(name string, attrs ...string) {
+	if x.err != nil {
+		return
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	x.err = x.enc.EncodeToken(start)
+}
+
+// end closes an element
+func (x *xmlWriter) end(name string) {
+	if x.err != nil {
+		return
+	}
+	x.err = x.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+// text writes an element holding text, unless the text is empty
+func (x *xmlWriter) text(name, value string) {
+	if value == "" {
+		return
+	}
+	x.element(name, value)
+}
+
+// chars writes character data inside the current element
+func (x *xmlWriter) chars(s string) {
+	if x.err != nil {
+		return
+	}
+	x.err = x.enc.EncodeToken(xml.CharData(s))
+}
+
+// element writes v as an element
+func (x *xmlWriter) element(name string, v any) {
+	if x.err != nil {
+		return
+	}
+	x.err = x.enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+// flush writes buffered output and returns the first error
+func (x *xmlWriter) flush() error {
+	if x.err != nil {
+		return x.err
+	}
+	return x.enc.Flush()
+}
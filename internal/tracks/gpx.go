@@ -0,0 +1,229 @@
+package tracks
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// GPXContentType is the media type of GPX files
+const GPXContentType = "application/gpx+xml"
+
+// gpxPoint is a wpt, rtept or trkpt element
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  string `xml:"ele"`
+	Time string `xml:"time"`
+	Name string `xml:"name"`
+	Desc string `xml:"desc"`
+	Cmt  string `xml:"cmt"`
+}
+
+// readGPX reads the waypoints, routes and tracks of a GPX document whose
+// root element has been read
+func (im *importer) readGPX() error {
+	stack := []string{"gpx"}
+	for {
+		tok, err := im.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			switch name := t.Name.Local; {
+			case name == "wpt" && parent == "gpx":
+				if err := im.readGPXWaypoint(&t); err != nil {
+					return err
+				}
+				continue
+			case (name == "rtept" && parent == "rte") || (name == "trkpt" && parent == "trkseg"):
+				if err := im.readGPXPoint(&t); err != nil {
+					return err
+				}
+				continue
+			case name == "name" && (parent == "rte" || parent == "trk") && im.track != nil:
+				if err := im.dec.DecodeElement(&im.track.Name, &t); err != nil {
+					return err
+				}
+				im.track.Name = strings.TrimSpace(im.track.Name)
+				continue
+			case name == "metadata" || name == "extensions":
+				if err := im.dec.Skip(); err != nil {
+					return err
+				}
+				continue
+			case name == "rte" && parent == "gpx":
+				im.beginTrack(store.TrackKindRoute)
+			case name == "trk" && parent == "gpx":
+				im.beginTrack(store.TrackKindTrack)
+			case name == "trkseg" && parent == "trk":
+				im.beginSegment()
+			}
+			stack = append(stack, t.Name.Local)
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return nil
+			}
+			if name := t.Name.Local; name == "rte" || name == "trk" {
+				if err := im.endTrack(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// decodeGPXPoint decodes a point element and validates its coordinates
+func (im *importer) decodeGPXPoint(start *xml.StartElement) (*gpxPoint, *store.TrackPoint, error) {
+	var el gpxPoint
+	if err := im.dec.DecodeElement(&el, start); err != nil {
+		return nil, nil, err
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(el.Lat), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(el.Lon), 64)
+	if errLat != nil || errLon != nil {
+		return nil, nil, im.errorf("invalid coordinates %q,%q", el.Lat, el.Lon)
+	}
+	p, err := im.point(lat, lon, parseElevation(el.Ele), parseTime(el.Time))
+	return &el, p, err
+}
+
+// readGPXPoint stores a rtept or trkpt
+func (im *importer) readGPXPoint(start *xml.StartElement) error {
+	_, p, err := im.decodeGPXPoint(start)
+	if err != nil {
+		return err
+	}
+	return im.addPoint(p)
+}
+
+// readGPXWaypoint stores a wpt
+func (im *importer) readGPXWaypoint(start *xml.StartElement) error {
+	el, p, err := im.decodeGPXPoint(start)
+	if err != nil {
+		return err
+	}
+	desc := strings.TrimSpace(el.Desc)
+	if desc == "" {
+		desc = strings.TrimSpace(el.Cmt)
+	}
+	return im.addWaypoint(&store.Waypoint{
+		Name:        strings.TrimSpace(el.Name),
+		Description: desc,
+		Latitude:    p.Latitude,
+		Longitude:   p.Longitude,
+		Elevation:   p.Elevation,
+		Time:        p.Time,
+	})
+}
+
+// gpxWaypoint is a wpt, rtept or trkpt element written to GPX files, in the
+// element order of the GPX 1.1 schema
+type gpxWaypoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  string `xml:"ele,omitempty"`
+	Time string `xml:"time,omitempty"`
+	Name string `xml:"name,omitempty"`
+	Desc string `xml:"desc,omitempty"`
+	Type string `xml:"type,omitempty"`
+}
+
+// newGPXWaypoint returns the element of a point
+func newGPXWaypoint(p *Point) *gpxWaypoint {
+	w := &gpxWaypoint{
+		Lat:  formatFloat(p.Latitude),
+		Lon:  formatFloat(p.Longitude),
+		Name: p.Name,
+		Desc: p.Description,
+		Type: p.Type,
+	}
+	if p.Elevation != nil {
+		w.Ele = formatFloat(*p.Elevation)
+	}
+	if p.Time != nil {
+		w.Time = p.Time.UTC().Format(time.RFC3339)
+	}
+	return w
+}
+
+// WriteGPX writes the export as a GPX 1.1 document: points as waypoints,
+// flights and uploaded routes as routes, and uploaded tracks as tracks.
+// Track points are streamed from the store.
+func (e *Export) WriteGPX(ctx context.Context, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	x := &xmlWriter{enc: xml.NewEncoder(w)}
+	x.enc.Indent("", "  ")
+
+	x.start("gpx", "version", "1.1", "creator", "vibed-traveller", "xmlns", "http://www.topografix.com/GPX/1/1")
+	x.start("metadata")
+	x.text("name", e.Trip.Name)
+	x.text("desc", e.Trip.Description)
+	x.end("metadata")
+	for _, p := range e.Points {
+		x.element("wpt", newGPXWaypoint(p))
+	}
+
+	// The schema requires every route before the first track
+	for _, f := range e.Flights {
+		x.start("rte")
+		x.text("name", f.Name)
+		x.text("type", "flight")
+		x.element("rtept", newGPXWaypoint(f.Departure))
+		x.element("rtept", newGPXWaypoint(f.Arrival))
+		x.end("rte")
+	}
+	for _, t := range e.Tracks {
+		if t.Kind != store.TrackKindRoute || x.err != nil {
+			continue
+		}
+		x.start("rte")
+		x.text("name", t.Name)
+		x.setErr(e.store.TrackPoints(ctx, t.ID, -1, func(p *store.TrackPoint) error {
+			x.element("rtept", newGPXWaypoint(trackPoint(p)))
+			return x.err
+		}))
+		x.end("rte")
+	}
+	for _, t := range e.Tracks {
+		if t.Kind != store.TrackKindTrack || x.err != nil {
+			continue
+		}
+		x.start("trk")
+		x.text("name", t.Name)
+		segment := -1
+		x.setErr(e.store.TrackPoints(ctx, t.ID, -1, func(p *store.TrackPoint) error {
+			if p.Segment != segment {
+				if segment >= 0 {
+					x.end("trkseg")
+				}
+				x.start("trkseg")
+				segment = p.Segment
+			}
+			x.element("trkpt", newGPXWaypoint(trackPoint(p)))
+			return x.err
+		}))
+		if segment >= 0 {
+			x.end("trkseg")
+		}
+		x.end("trk")
+	}
+	x.end("gpx")
+	return x.flush()
+}
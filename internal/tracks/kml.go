@@ -0,0 +1,350 @@
+package tracks
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+// KMLContentType is the media type of KML files
+const KMLContentType = "application/vnd.google-earth.kml+xml"
+
+// readKML reads the placemarks of a KML document whose root element has
+// been read. Points become waypoints; LineStrings and gx:Tracks, including
+// those grouped in a MultiGeometry or gx:MultiTrack, become the segments of
+// a track. Other geometries are ignored.
+func (im *importer) readKML() error {
+	for {
+		tok, err := im.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "Placemark" {
+			if err := im.readPlacemark(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readPlacemark reads a Placemark up to its end element
+func (im *importer) readPlacemark() error {
+	var (
+		name, description string
+		at                *time.Time
+		points            []*store.TrackPoint
+
+		// whens are the timestamps of the gx:Track being read, matched to
+		// its gx:coord elements in order
+		inTrack bool
+		whens   []*time.Time
+		coords  int
+	)
+	for depth := 1; depth > 0; {
+		tok, err := im.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name", "description":
+				var s string
+				if err := im.dec.DecodeElement(&s, &t); err != nil {
+					return err
+				}
+				if t.Name.Local == "name" {
+					name = strings.TrimSpace(s)
+				} else {
+					description = strings.TrimSpace(s)
+				}
+				continue
+			case "when":
+				var s string
+				if err := im.dec.DecodeElement(&s, &t); err != nil {
+					return err
+				}
+				if inTrack {
+					whens = append(whens, parseTime(s))
+				} else {
+					at = parseTime(s)
+				}
+				continue
+			case "Point":
+				var el struct {
+					Coordinates string `xml:"coordinates"`
+				}
+				if err := im.dec.DecodeElement(&el, &t); err != nil {
+					return err
+				}
+				fields := strings.Fields(el.Coordinates)
+				if len(fields) == 0 {
+					return im.errorf("Point without coordinates")
+				}
+				p, err := im.kmlTuple(fields[0], ",")
+				if err != nil {
+					return err
+				}
+				points = append(points, p)
+				continue
+			case "Polygon", "Model", "ExtendedData":
+				if err := im.dec.Skip(); err != nil {
+					return err
+				}
+				continue
+			case "LineString", "Track":
+				if im.track == nil {
+					im.beginTrack(store.TrackKindTrack)
+				}
+				im.beginSegment()
+				inTrack, whens, coords = t.Name.Local == "Track", nil, 0
+			case "coordinates":
+				if err := im.readCoordinates(); err != nil {
+					return err
+				}
+				continue
+			case "coord":
+				var s string
+				if err := im.dec.DecodeElement(&s, &t); err != nil {
+					return err
+				}
+				p, err := im.kmlTuple(s, " ")
+				if err != nil {
+					return err
+				}
+				if coords < len(whens) {
+					p.Time = whens[coords]
+				}
+				coords++
+				if err := im.addPoint(p); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+
+		case xml.EndElement:
+			depth--
+			if t.Name.Local == "Track" {
+				inTrack = false
+			}
+		}
+	}
+
+	if im.track != nil {
+		im.track.Name = name
+		if err := im.endTrack(); err != nil {
+			return err
+		}
+	}
+	for _, p := range points {
+		err := im.addWaypoint(&store.Waypoint{
+			Name:        name,
+			Description: description,
+			Latitude:    p.Latitude,
+			Longitude:   p.Longitude,
+			Elevation:   p.Elevation,
+			Time:        at,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCoordinates reads the tuples of a LineString's coordinates element as
+// they are decoded, up to its end element
+func (im *importer) readCoordinates() error {
+	var carry string
+	for {
+		tok, err := im.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			// Text may arrive in several tokens; a tuple cut at the end of
+			// one is completed by the next
+			text := carry + string(t)
+			fields := strings.Fields(text)
+			carry = ""
+			if n := len(fields); n > 0 && strings.TrimRight(text, " \t\r\n") == text {
+				carry, fields = fields[n-1], fields[:n-1]
+			}
+			for _, field := range fields {
+				p, err := im.kmlTuple(field, ",")
+				if err != nil {
+					return err
+				}
+				if err := im.addPoint(p); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			if carry == "" {
+				return nil
+			}
+			p, err := im.kmlTuple(carry, ",")
+			if err != nil {
+				return err
+			}
+			return im.addPoint(p)
+		}
+	}
+}
+
+// kmlTuple parses a longitude, latitude and optional altitude separated by sep
+func (im *importer) kmlTuple(tuple, sep string) (*store.TrackPoint, error) {
+	parts := strings.Split(strings.TrimSpace(tuple), sep)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, im.errorf("invalid coordinates %q", tuple)
+	}
+	lon, errLon := strconv.ParseFloat(parts[0], 64)
+	lat, errLat := strconv.ParseFloat(parts[1], 64)
+	if errLon != nil || errLat != nil {
+		return nil, im.errorf("invalid coordinates %q", tuple)
+	}
+	var elevation *float64
+	if len(parts) == 3 {
+		elevation = parseElevation(parts[2])
+	}
+	return im.point(lat, lon, elevation, nil)
+}
+
+// kmlPlacemark writes a Point placemark
+func (x *xmlWriter) kmlPlacemark(p *Point) {
+	x.start("Placemark")
+	x.text("name", p.Name)
+	x.text("description", p.Description)
+	if p.Time != nil {
+		x.start("TimeStamp")
+		x.text("when", p.Time.UTC().Format(time.RFC3339))
+		x.end("TimeStamp")
+	}
+	x.start("Point")
+	x.text("coordinates", kmlCoordinates(p, ","))
+	x.end("Point")
+	x.end("Placemark")
+}
+
+// kmlCoordinates formats the longitude, latitude and altitude of a point separated by sep
+func kmlCoordinates(p *Point, sep string) string {
+	s := formatFloat(p.Longitude) + sep + formatFloat(p.Latitude)
+	if p.Elevation != nil {
+		s += sep + formatFloat(*p.Elevation)
+	}
+	return s
+}
+
+// WriteKML writes the export as a KML 2.2 document with a folder each for
+// points, flights and tracks. Tracks whose points all have timestamps are
+// written as gx:Tracks so that the times are kept, others as LineStrings.
+// Track points are streamed from the store.
+func (e *Export) WriteKML(ctx context.Context, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	x := &xmlWriter{enc: xml.NewEncoder(w)}
+	x.enc.Indent("", "  ")
+
+	x.start("kml", "xmlns", "http://www.opengis.net/kml/2.2", "xmlns:gx", "http://www.google.com/kml/ext/2.2")
+	x.start("Document")
+	x.text("name", e.Trip.Name)
+	x.text("description", e.Trip.Description)
+
+	x.start("Folder")
+	x.text("name", "Places")
+	for _, p := range e.Points {
+		x.kmlPlacemark(p)
+	}
+	x.end("Folder")
+
+	x.start("Folder")
+	x.text("name", "Flights")
+	for _, f := range e.Flights {
+		x.start("Placemark")
+		x.text("name", f.Name)
+		x.start("LineString")
+		// Tessellated lines follow the great circle
+		x.text("tessellate", "1")
+		x.text("coordinates", kmlCoordinates(f.Departure, ",")+" "+kmlCoordinates(f.Arrival, ","))
+		x.end("LineString")
+		x.end("Placemark")
+	}
+	x.end("Folder")
+
+	x.start("Folder")
+	x.text("name", "Tracks")
+	for _, t := range e.Tracks {
+		if x.err != nil {
+			break
+		}
+		x.start("Placemark")
+		x.text("name", t.Name)
+		if t.Timed {
+			e.writeKMLTrack(ctx, x, t)
+		} else {
+			e.writeKMLLines(ctx, x, t)
+		}
+		x.end("Placemark")
+	}
+	x.end("Folder")
+
+	x.end("Document")
+	x.end("kml")
+	return x.flush()
+}
+
+// writeKMLTrack writes each segment of a track as a gx:Track: every
+// timestamp, then every position
+func (e *Export) writeKMLTrack(ctx context.Context, x *xmlWriter, t *store.Track) {
+	x.start("gx:MultiTrack")
+	for segment := 0; segment < t.Segments && x.err == nil; segment++ {
+		x.start("gx:Track")
+		x.setErr(e.store.TrackPoints(ctx, t.ID, segment, func(p *store.TrackPoint) error {
+			x.text("when", p.Time.UTC().Format(time.RFC3339Nano))
+			return x.err
+		}))
+		x.setErr(e.store.TrackPoints(ctx, t.ID, segment, func(p *store.TrackPoint) error {
+			x.text("gx:coord", kmlCoordinates(trackPoint(p), " "))
+			return x.err
+		}))
+		x.end("gx:Track")
+	}
+	x.end("gx:MultiTrack")
+}
+
+// writeKMLLines writes each segment of a track as a LineString
+func (e *Export) writeKMLLines(ctx context.Context, x *xmlWriter, t *store.Track) {
+	x.start("MultiGeometry")
+	segment := -1
+	x.setErr(e.store.TrackPoints(ctx, t.ID, -1, func(p *store.TrackPoint) error {
+		if p.Segment != segment {
+			if segment >= 0 {
+				x.end("coordinates")
+				x.end("LineString")
+			}
+			x.start("LineString")
+			x.start("coordinates")
+			segment = p.Segment
+		}
+		x.chars(kmlCoordinates(trackPoint(p), ",") + " ")
+		return x.err
+	}))
+	if segment >= 0 {
+		x.end("coordinates")
+		x.end("LineString")
+	}
+	x.end("MultiGeometry")
+}
@@ -0,0 +1,330 @@
+package tracks
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"vibed-traveller/internal/geo"
+	"vibed-traveller/internal/store"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// elevationThresholdM is the hysteresis applied to elevation changes, so
+// that GPS noise on flat ground doesn't add up to a climb
+const elevationThresholdM = 3
+
+// ErrUnsupportedFormat is returned for XML documents that are neither GPX nor KML
+var ErrUnsupportedFormat = errors.New("not a GPX or KML file")
+
+// FormatError reports invalid content in an uploaded file
+type FormatError struct {
+	// Line is the line of the file the error was found on, or 0 for the whole file
+	Line int
+	Err  error
+}
+
+func (e *FormatError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// Result lists what an upload added to a trip
+type Result struct {
+	Format    string            `json:"format" doc:"gpx or kml"`
+	Tracks    []*store.Track    `json:"tracks"`
+	Waypoints []*store.Waypoint `json:"waypoints"`
+}
+
+// Import reads a GPX or KML file into a trip. The file is first received
+// into a temporary file, so that a slow upload doesn't hold the database's
+// write lock, then parsed with its points written one by one in a single
+// transaction. Files of any size can be imported, and nothing is kept if the
+// file is invalid.
+func Import(ctx context.Context, st *store.Store, tripID string, r io.Reader) (*Result, error) {
+	tmp, err := os.CreateTemp("", "track-*")
+	if err != nil {
+		return nil, fmt.Errorf("buffering track upload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("buffering track upload: %w", err)
+	}
+
+	dec := xml.NewDecoder(bufio.NewReader(tmp))
+	dec.CharsetReader = charsetReader
+
+	root, err := rootElement(dec)
+	if err != nil {
+		return nil, err
+	}
+	imp, err := st.BeginTrackImport(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+	im := &importer{imp: imp, dec: dec, result: &Result{Tracks: []*store.Track{}, Waypoints: []*store.Waypoint{}}}
+
+	switch root {
+	case "gpx":
+		im.result.Format = store.TrackSourceGPX
+		err = im.readGPX()
+	case "kml":
+		im.result.Format = store.TrackSourceKML
+		err = im.readKML()
+	}
+	if err == nil && len(im.result.Tracks) == 0 && len(im.result.Waypoints) == 0 {
+		err = &FormatError{Err: errors.New("the file has no tracks, routes or waypoints")}
+	}
+	if err != nil {
+		_ = imp.Rollback()
+		return nil, formatError(err)
+	}
+	if err := imp.Commit(); err != nil {
+		return nil, err
+	}
+	return im.result, nil
+}
+
+// rootElement returns the name of the document element
+func rootElement(dec *xml.Decoder) (string, error) {
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return "", ErrUnsupportedFormat
+		}
+		if err != nil {
+			return "", formatError(err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if name := start.Name.Local; name == "gpx" || name == "kml" {
+				return name, nil
+			}
+			return "", ErrUnsupportedFormat
+		}
+	}
+}
+
+// formatError converts XML syntax errors to FormatError
+func formatError(err error) error {
+	var syntax *xml.SyntaxError
+	if errors.As(err, &syntax) {
+		return &FormatError{Line: syntax.Line, Err: errors.New(syntax.Msg)}
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return &FormatError{Err: errors.New("the file is truncated")}
+	}
+	return err
+}
+
+// charsetReader decodes files declaring an encoding other than UTF-8
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	enc, err := ianaindex.IANA.Encoding(label)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", label)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// importer stores the tracks and waypoints of a file as they are parsed
+type importer struct {
+	imp    *store.TrackImport
+	dec    *xml.Decoder
+	result *Result
+
+	// track is the track being read, stored once it has a point
+	track      *store.Track
+	segment    int
+	newSegment bool
+	stats      stats
+}
+
+// beginTrack starts a track or route
+func (im *importer) beginTrack(kind string) {
+	im.track = &store.Track{Kind: kind, Source: im.result.Format}
+	im.segment, im.newSegment = -1, true
+	im.stats = stats{}
+}
+
+// beginSegment starts a new segment of the current track, unless the
+// current one is still empty
+func (im *importer) beginSegment() {
+	im.newSegment = true
+}
+
+// addPoint appends a point to the current track
+func (im *importer) addPoint(p *store.TrackPoint) error {
+	if im.track == nil {
+		return nil
+	}
+	if im.track.ID == "" {
+		if err := im.imp.AddTrack(im.track); err != nil {
+			return err
+		}
+	}
+	if im.newSegment {
+		im.segment++
+		im.newSegment = false
+		im.stats.breakSegment()
+	}
+	p.Segment = im.segment
+	if err := im.imp.AddTrackPoint(im.track, p); err != nil {
+		return err
+	}
+	im.stats.add(p)
+	return nil
+}
+
+// endTrack saves the current track, unless it has no points
+func (im *importer) endTrack() error {
+	t := im.track
+	im.track = nil
+	if t == nil || t.ID == "" {
+		return nil
+	}
+	im.stats.apply(t)
+	t.Segments = im.segment + 1
+	if err := im.imp.FinishTrack(t); err != nil {
+		return err
+	}
+	im.result.Tracks = append(im.result.Tracks, t)
+	return nil
+}
+
+// addWaypoint stores a waypoint
+func (im *importer) addWaypoint(w *store.Waypoint) error {
+	if err := im.imp.AddWaypoint(w); err != nil {
+		return err
+	}
+	im.result.Waypoints = append(im.result.Waypoints, w)
+	return nil
+}
+
+// errorf returns a FormatError at the decoder's current line
+func (im *importer) errorf(format string, args ...any) error {
+	line, _ := im.dec.InputPos()
+	return &FormatError{Line: line, Err: fmt.Errorf(format, args...)}
+}
+
+// point validates coordinates and returns them as a track point
+func (im *importer) point(lat, lon float64, elevation *float64, at *time.Time) (*store.TrackPoint, error) {
+	if !(geo.Point{Lat: lat, Lon: lon}).Valid() || math.IsNaN(lat) || math.IsNaN(lon) {
+		return nil, im.errorf("invalid coordinates %g,%g", lat, lon)
+	}
+	return &store.TrackPoint{Latitude: lat, Longitude: lon, Elevation: elevation, Time: at}, nil
+}
+
+// stats accumulates the statistics of a track
+type stats struct {
+	points, timed int
+	distanceKM    float64
+	previous      *store.TrackPoint
+
+	// reference is the elevation changes are measured from
+	reference  *float64
+	gain, loss float64
+	elevation  bool
+
+	first, last time.Time
+}
+
+// breakSegment stops distance and elevation from carrying over to the next point
+func (s *stats) breakSegment() {
+	s.previous, s.reference = nil, nil
+}
+
+// add accounts for the next point of the track
+func (s *stats) add(p *store.TrackPoint) {
+	s.points++
+	if s.previous != nil {
+		s.distanceKM += geo.DistanceKM(
+			geo.Point{Lat: s.previous.Latitude, Lon: s.previous.Longitude},
+			geo.Point{Lat: p.Latitude, Lon: p.Longitude},
+		)
+	}
+	s.previous = p
+
+	if p.Elevation != nil {
+		s.elevation = true
+		switch {
+		case s.reference == nil:
+			s.reference = p.Elevation
+		case *p.Elevation-*s.reference >= elevationThresholdM:
+			s.gain += *p.Elevation - *s.reference
+			s.reference = p.Elevation
+		case *s.reference-*p.Elevation >= elevationThresholdM:
+			s.loss += *s.reference - *p.Elevation
+			s.reference = p.Elevation
+		}
+	}
+
+	if p.Time != nil {
+		s.timed++
+		if s.first.IsZero() || p.Time.Before(s.first) {
+			s.first = *p.Time
+		}
+		if p.Time.After(s.last) {
+			s.last = *p.Time
+		}
+	}
+}
+
+// apply copies the statistics to a track
+func (s *stats) apply(t *store.Track) {
+	t.Points = s.points
+	t.Timed = s.timed == s.points
+	t.DistanceKM = math.Round(s.distanceKM*1000) / 1000
+	if s.elevation {
+		gain, loss := math.Round(s.gain*10)/10, math.Round(s.loss*10)/10
+		t.ElevationGainM, t.ElevationLossM = &gain, &loss
+	}
+	if s.timed > 0 {
+		first, last := s.first, s.last
+		duration := int64(last.Sub(first) / time.Second)
+		t.StartedAt, t.EndedAt, t.DurationSeconds = &first, &last, &duration
+	}
+}
+
+// parseTime parses an XML Schema dateTime, or a date; times without a zone are UTC
+func parseTime(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return nil
+}
+
+// parseElevation parses an elevation in meters, or returns nil if it is absent or invalid
+func parseElevation(s string) *float64 {
+	ele, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(ele) || math.IsInf(ele, 0) {
+		return nil
+	}
+	return &ele
+}
+
+// formatFloat formats a coordinate or elevation without trailing zeros
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
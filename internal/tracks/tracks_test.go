@@ -0,0 +1,116 @@
+package tracks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vibed-traveller/internal/store"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test">
+  <wpt lat="46.5" lon="7.9"><name>Hut</name></wpt>
+  <trk><name>Ridge</name><trkseg>
+    <trkpt lat="46.50" lon="7.90"><ele>2000</ele><time>2024-07-01T08:00:00Z</time></trkpt>
+    <trkpt lat="46.51" lon="7.91"><ele>2100</ele><time>2024-07-01T09:00:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+// newTestTrip opens a store over a fresh database with a trip to import into
+func newTestTrip(t *testing.T) (*store.Store, *store.Trip) {
+	t.Helper()
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	if _, err := st.UpsertUser(ctx, "alice", "alice@example.com", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	trip := &store.Trip{OwnerID: "alice", Name: "Alps"}
+	if err := st.CreateTrip(ctx, trip); err != nil {
+		t.Fatal(err)
+	}
+	return st, trip
+}
+
+func TestImport(t *testing.T) {
+	st, trip := newTestTrip(t)
+	result, err := Import(context.Background(), st, trip.ID, strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Format != store.TrackSourceGPX || len(result.Tracks) != 1 || len(result.Waypoints) != 1 {
+		t.Fatalf("Import = %+v", result)
+	}
+	if track := result.Tracks[0]; track.Name != "Ridge" || track.Points != 2 || !track.Timed {
+		t.Errorf("track = %+v", track)
+	}
+}
+
+func TestImportInvalidKeepsNothing(t *testing.T) {
+	st, trip := newTestTrip(t)
+	truncated := testGPX[:strings.Index(testGPX, "</trkseg>")]
+	_, err := Import(context.Background(), st, trip.ID, strings.NewReader(truncated))
+	var invalid *FormatError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Import = %v, want a FormatError", err)
+	}
+	tracks, err := st.ListTracks(context.Background(), trip.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 0 {
+		t.Errorf("%d tracks kept from an invalid file", len(tracks))
+	}
+}
+
+func TestImportDoesNotLockWhileReceiving(t *testing.T) {
+	st, trip := newTestTrip(t)
+	pr, w := io.Pipe()
+	r := &waitingReader{r: pr, waiting: make(chan struct{}, 8)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := Import(context.Background(), st, trip.ID, r)
+		done <- err
+	}()
+
+	// Half the file has arrived, has been handled, and the client is slow to
+	// send the rest
+	split := strings.Index(testGPX, "</trkseg>")
+	<-r.waiting
+	if _, err := io.WriteString(w, testGPX[:split]); err != nil {
+		t.Fatal(err)
+	}
+	<-r.waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := st.CreateTrip(ctx, &store.Trip{OwnerID: "alice", Name: "Other"}); err != nil {
+		t.Fatalf("writing during an upload: %v", err)
+	}
+
+	if _, err := io.WriteString(w, testGPX[split:]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitingReader signals each time its reader is asked for more data
+type waitingReader struct {
+	r       io.Reader
+	waiting chan struct{}
+}
+
+func (r *waitingReader) Read(p []byte) (int, error) {
+	r.waiting <- struct{}{}
+	return r.r.Read(p)
+}
@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of encryption keys in bytes
+const KeySize = 32
+
+// format is the first byte of sealed values, identifying their layout:
+// format, key ID, nonce, then the ciphertext with its authentication tag
+const format = 1
+
+// keyIDSize is the length of the key fingerprint stored with sealed values
+const keyIDSize = 4
+
+var (
+	// ErrNoKeys is returned by NewKeyring when no key is configured
+	ErrNoKeys = errors.New("no encryption key configured")
+
+	// ErrUnknownKey is returned when opening a value sealed with a key that is not in the keyring
+	ErrUnknownKey = errors.New("value was sealed with an unknown key")
+
+	// ErrCorrupt is returned when a sealed value is malformed or fails authentication
+	ErrCorrupt = errors.New("sealed value is corrupt")
+)
+
+// key is one key of a keyring
+type key struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// Keyring seals values with its first key and opens values sealed with any
+// of its keys, so that keys can be rotated without losing data
+type Keyring struct {
+	keys []key
+}
+
+// ParseKey decodes a base64-encoded 32-byte key
+func ParseKey(encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64: %w", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, want %d", len(raw), KeySize)
+	}
+	return raw, nil
+}
+
+// NewKeyring returns a keyring of base64-encoded keys; the first one seals
+func NewKeyring(encoded []string) (*Keyring, error) {
+	if len(encoded) == 0 {
+		return nil, ErrNoKeys
+	}
+	kr := &Keyring{}
+	for i, s := range encoded {
+		raw, err := ParseKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		kr.keys = append(kr.keys, key{id: sum[:keyIDSize], aead: aead})
+	}
+	return kr, nil
+}
+
+// Seal encrypts plaintext with the first key. additionalData is authenticated
+// but not stored; the same value must be given to Open, which binds the
+// sealed value to e.g. the record and field it belongs to.
+func (kr *Keyring) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	k := kr.keys[0]
+	nonceSize := k.aead.NonceSize()
+
+	out := make([]byte, 1+keyIDSize+nonceSize, 1+keyIDSize+nonceSize+len(plaintext)+k.aead.Overhead())
+	out[0] = format
+	copy(out[1:], k.id)
+	nonce := out[1+keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return k.aead.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a value returned by Seal
+func (kr *Keyring) Open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < 1+keyIDSize || sealed[0] != format {
+		return nil, ErrCorrupt
+	}
+	k, ok := kr.key(sealed[1 : 1+keyIDSize])
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	body := sealed[1+keyIDSize:]
+	if len(body) < k.aead.NonceSize()+k.aead.Overhead() {
+		return nil, ErrCorrupt
+	}
+	nonce, ciphertext := body[:k.aead.NonceSize()], body[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+// Current reports whether a sealed value uses the first key, i.e. does not
+// need to be sealed again after a rotation
+func (kr *Keyring) Current(sealed []byte) bool {
+	return len(sealed) >= 1+keyIDSize && sealed[0] == format && bytes.Equal(sealed[1:1+keyIDSize], kr.keys[0].id)
+}
+
+// key returns the key with the given ID
+func (kr *Keyring) key(id []byte) (key, bool) {
+	for _, k := range kr.keys {
+		if bytes.Equal(k.id, id) {
+			return k, true
+		}
+	}
+	return key{}, false
+}
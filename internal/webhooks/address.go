@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for webhook hosts on loopback, link-local,
+// private or unspecified addresses, which would let users reach the server's
+// own network and cloud metadata services
+var ErrForbiddenAddress = errors.New("address not allowed for webhooks")
+
+// forbiddenNetworks are ranges not covered by the net.IP predicates
+var forbiddenNetworks = []*net.IPNet{
+	// "This network", which reaches the host itself on Linux
+	mustParseCIDR("0.0.0.0/8"),
+	// Carrier-grade NAT, also used by some cloud metadata services
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+// mustParseCIDR parses a constant CIDR range
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// forbiddenIP reports whether webhooks may not be sent to ip
+func forbiddenIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range forbiddenNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL resolves the host of a webhook URL and returns an error wrapping
+// ErrForbiddenAddress if any of its addresses is forbidden. Deliveries check
+// the address again when connecting, since DNS answers can change.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if forbiddenIP(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if forbiddenIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to forbidden addresses unless allowed
+// returns true. It runs after DNS resolution, for every address tried.
+func dialControl(allowed func() bool) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		if allowed() {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || forbiddenIP(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/store"
+)
+
+// Headers sent with every delivery
+const (
+	// IDHeader carries the event ID, which is the same across redeliveries
+	IDHeader = "Webhook-Id"
+
+	// EventHeader carries the event type
+	EventHeader = "Webhook-Event"
+
+	// SignatureHeader carries t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	SignatureHeader = "Webhook-Signature"
+)
+
+const (
+	// pollInterval is how often the queue is checked for due deliveries
+	pollInterval = time.Second
+
+	// batchSize is how many deliveries are claimed per poll
+	batchSize = 20
+
+	// maxRetryDelay caps the backoff between attempts
+	maxRetryDelay = 6 * time.Hour
+
+	// maxErrorLength caps the error text kept per attempt
+	maxErrorLength = 500
+
+	// maxDrainLength caps the response body read so that connections can be reused
+	maxDrainLength = 64 << 10
+)
+
+// Sign returns the signature header value for a body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Worker sends due deliveries from the store's queue
+type Worker struct {
+	store   *store.Store
+	configs *config.Manager
+	client  *http.Client
+}
+
+// NewWorker creates a worker reading its settings from the current configuration
+func NewWorker(st *store.Store, configs *config.Manager) *Worker {
+	// Connections to the server's own networks are refused once the host is
+	// resolved, so a host can't be pointed at them after registration
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl(func() bool { return configs.Current().WebhookAllowPrivate }),
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		// Redirects are reported as failures rather than followed, since
+		// following turns the POST into a GET
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return &Worker{store: st, configs: configs, client: client}
+}
+
+// Run delivers due webhooks until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue claims and sends a batch of due deliveries
+func (w *Worker) deliverDue(ctx context.Context) {
+	cfg := w.configs.Current()
+	// A claim outlives the request so that slow endpoints aren't sent twice
+	due, err := w.store.ClaimDueDeliveries(ctx, 2*cfg.WebhookTimeout, batchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to claim webhook deliveries", slog.Any("error", err))
+		return
+	}
+	for _, d := range due {
+		w.deliver(ctx, cfg, d)
+	}
+}
+
+// deliver sends one delivery and records the outcome
+func (w *Worker) deliver(ctx context.Context, cfg *config.Config, d *store.DueDelivery) {
+	attempt := store.WebhookAttempt{Number: d.AttemptCount + 1, AttemptedAt: time.Now().UTC()}
+	attempt.StatusCode, attempt.Error = w.send(ctx, cfg.WebhookTimeout, d)
+	attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+
+	status, next := store.DeliverySucceeded, (*time.Time)(nil)
+	if attempt.StatusCode < 200 || attempt.StatusCode > 299 {
+		status = store.DeliveryFailed
+		if attempt.Number < max(cfg.WebhookMaxAttempts, 1) {
+			retryAt := attempt.AttemptedAt.Add(retryDelay(cfg.WebhookRetryDelay, attempt.Number))
+			status, next = store.DeliveryPending, &retryAt
+		}
+	}
+
+	if err := w.store.RecordDeliveryAttempt(ctx, &d.WebhookDelivery, attempt, status, next); err != nil {
+		slog.ErrorContext(ctx, "Failed to record webhook attempt", slog.String("delivery_id", d.ID), slog.Any("error", err))
+		return
+	}
+	slog.InfoContext(ctx, "Webhook delivery attempted",
+		slog.String("delivery_id", d.ID),
+		slog.String("event_type", d.EventType),
+		slog.Int("attempt", attempt.Number),
+		slog.Int("status_code", attempt.StatusCode),
+		slog.String("outcome", status))
+}
+
+// send posts the payload, returning the response status or an error
+// description. Response bodies are not kept, as the delivery log would
+// otherwise show users what the receiving server answers.
+func (w *Worker) send(ctx context.Context, timeout time.Duration, d *store.DueDelivery) (int, string) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vibed-traveller-webhooks/1")
+	req.Header.Set(IDHeader, d.EventID)
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, truncate(err.Error())
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainLength))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp.StatusCode, ""
+	}
+	return resp.StatusCode, truncate(resp.Status)
+}
+
+// retryDelay is the wait after the given failed attempt: base doubled per attempt, capped
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// truncate shortens s to maxErrorLength bytes
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
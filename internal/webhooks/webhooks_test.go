@@ -0,0 +1,398 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vibed-traveller/internal/config"
+	"vibed-traveller/internal/listquery"
+	"vibed-traveller/internal/store"
+)
+
+const testOwner = "auth0|owner"
+
+// received is a request seen by a receiver
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a local webhook endpoint answering with the status returned by
+// respond for each request, which it records
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []received
+	respond  func(n int) int
+}
+
+func newReceiver(t *testing.T, respond func(n int) int) *receiver {
+	t.Helper()
+	rc := &receiver{respond: respond}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		rc.requests = append(rc.requests, received{header: r.Header.Clone(), body: body})
+		status := rc.respond(len(rc.requests))
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, "internal detail of the receiver")
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) received() []received {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]received(nil), rc.requests...)
+}
+
+// fixture is a worker over a fresh database with one webhook
+type fixture struct {
+	store  *store.Store
+	worker *Worker
+	hook   *store.Webhook
+}
+
+func newFixture(t *testing.T, cfg *config.Config, url string) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	if _, err := st.UpsertUser(ctx, testOwner, "owner@example.com", "owner", nil); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	hook := &store.Webhook{OwnerID: testOwner, URL: url, Events: []string{"trip.created"}, Active: true}
+	if err := st.CreateWebhook(ctx, hook); err != nil {
+		t.Fatalf("creating webhook: %v", err)
+	}
+	return &fixture{store: st, worker: NewWorker(st, config.NewManager(cfg, nil)), hook: hook}
+}
+
+// testConfig returns webhook settings allowing the local receivers
+func testConfig(maxAttempts int, retryDelay time.Duration) *config.Config {
+	return &config.Config{
+		WebhookTimeout:      5 * time.Second,
+		WebhookMaxAttempts:  maxAttempts,
+		WebhookRetryDelay:   retryDelay,
+		WebhookAllowPrivate: true,
+	}
+}
+
+// enqueue queues a trip.created event
+func (f *fixture) enqueue(t *testing.T) {
+	t.Helper()
+	data := map[string]string{"id": "trip-1", "name": "Lisbon"}
+	if err := f.store.EnqueueWebhookEvent(context.Background(), testOwner, "trip.created", data); err != nil {
+		t.Fatalf("queueing event: %v", err)
+	}
+}
+
+// deliveries returns the webhook's deliveries, oldest first, with their attempts
+func (f *fixture) deliveries(t *testing.T) []*store.WebhookDelivery {
+	t.Helper()
+	ctx := context.Background()
+	q, err := listquery.Parse(url.Values{"sort": {"created_at"}}, store.DeliveryListSpec)
+	if err != nil {
+		t.Fatalf("parsing query: %v", err)
+	}
+	list, _, err := f.store.ListDeliveries(ctx, f.hook.ID, q)
+	if err != nil {
+		t.Fatalf("listing deliveries: %v", err)
+	}
+	out := make([]*store.WebhookDelivery, 0, len(list))
+	for _, d := range list {
+		full, err := f.store.GetDelivery(ctx, f.hook.ID, d.ID)
+		if err != nil {
+			t.Fatalf("loading delivery: %v", err)
+		}
+		out = append(out, full)
+	}
+	return out
+}
+
+// waitUntilDue sleeps until the next attempt of d is due
+func waitUntilDue(t *testing.T, d *store.WebhookDelivery) {
+	t.Helper()
+	if d.NextAttemptAt == nil {
+		t.Fatal("delivery has no next attempt")
+	}
+	time.Sleep(time.Until(*d.NextAttemptAt) + 5*time.Millisecond)
+}
+
+func TestDeliverySignature(t *testing.T) {
+	rc := newReceiver(t, func(int) int { return http.StatusNoContent })
+	f := newFixture(t, testConfig(3, time.Minute), rc.URL)
+	f.enqueue(t)
+
+	before := time.Now()
+	f.worker.deliverDue(context.Background())
+
+	requests := rc.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+
+	var event store.WebhookEvent
+	if err := json.Unmarshal(req.body, &event); err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+	if event.Type != "trip.created" || !strings.Contains(string(event.Data), "Lisbon") {
+		t.Errorf("unexpected event %s", req.body)
+	}
+	if got := req.header.Get(IDHeader); got != event.ID {
+		t.Errorf("%s = %q, want %q", IDHeader, got, event.ID)
+	}
+	if got := req.header.Get(EventHeader); got != "trip.created" {
+		t.Errorf("%s = %q, want trip.created", EventHeader, got)
+	}
+
+	// Verify the signature as a receiver would
+	ts, sig, ok := strings.Cut(req.header.Get(SignatureHeader), ",")
+	ts, okT := strings.CutPrefix(ts, "t=")
+	sig, okV := strings.CutPrefix(sig, "v1=")
+	if !ok || !okT || !okV {
+		t.Fatalf("malformed %s %q", SignatureHeader, req.header.Get(SignatureHeader))
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || unix < before.Unix() || unix > time.Now().Unix() {
+		t.Errorf("signature time %q is not the time of sending", ts)
+	}
+	mac := hmac.New(sha256.New, []byte(f.hook.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(req.body)
+	if want := hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(sig), []byte(want)) {
+		t.Errorf("signature %s, want %s", sig, want)
+	}
+
+	mac = hmac.New(sha256.New, []byte("wrong secret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(req.body)
+	if hmac.Equal([]byte(sig), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Error("signature verifies with another secret")
+	}
+
+	d := f.deliveries(t)[0]
+	if d.Status != store.DeliverySucceeded || d.LastStatusCode != http.StatusNoContent || d.NextAttemptAt != nil {
+		t.Errorf("delivery is %s with status %d, want succeeded with 204", d.Status, d.LastStatusCode)
+	}
+}
+
+func TestDeliveryRetriesUntilMaxAttempts(t *testing.T) {
+	const base = 40 * time.Millisecond
+	rc := newReceiver(t, func(int) int { return http.StatusServiceUnavailable })
+	f := newFixture(t, testConfig(3, base), rc.URL)
+	f.enqueue(t)
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		f.worker.deliverDue(ctx)
+		if got := len(rc.received()); got != attempt {
+			t.Fatalf("receiver got %d requests after attempt %d", got, attempt)
+		}
+		d := f.deliveries(t)[0]
+		if d.AttemptCount != attempt || len(d.Attempts) != attempt {
+			t.Fatalf("delivery has %d attempts, want %d", d.AttemptCount, attempt)
+		}
+		last := d.Attempts[attempt-1]
+		if last.StatusCode != http.StatusServiceUnavailable || last.Error != "503 Service Unavailable" {
+			t.Errorf("attempt %d recorded %d %q", attempt, last.StatusCode, last.Error)
+		}
+		if attempt == 3 {
+			break
+		}
+
+		// Failed attempts are retried after the base delay, doubled per attempt
+		if d.Status != store.DeliveryPending || d.NextAttemptAt == nil {
+			t.Fatalf("delivery is %s after attempt %d, want pending", d.Status, attempt)
+		}
+		wait := d.NextAttemptAt.Sub(last.AttemptedAt)
+		if want := base << (attempt - 1); wait < want-time.Millisecond || wait > want+time.Millisecond {
+			t.Errorf("retry after attempt %d in %s, want %s", attempt, wait, want)
+		}
+
+		// Nothing is sent before the retry is due
+		f.worker.deliverDue(ctx)
+		if got := len(rc.received()); got != attempt {
+			t.Fatalf("delivery retried early: receiver got %d requests", got)
+		}
+		waitUntilDue(t, d)
+	}
+
+	d := f.deliveries(t)[0]
+	if d.Status != store.DeliveryFailed || d.NextAttemptAt != nil {
+		t.Fatalf("delivery is %s, want failed without a next attempt", d.Status)
+	}
+	if d.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("last status code %d, want 503", d.LastStatusCode)
+	}
+	for _, a := range d.Attempts {
+		if strings.Contains(a.Error, "internal detail") {
+			t.Errorf("attempt %d kept the response body: %q", a.Number, a.Error)
+		}
+	}
+
+	// A failed delivery is not sent again
+	time.Sleep(4 * base)
+	f.worker.deliverDue(ctx)
+	if got := len(rc.received()); got != 3 {
+		t.Errorf("receiver got %d requests after the last attempt", got)
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	rc := newReceiver(t, func(n int) int {
+		if n == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	f := newFixture(t, testConfig(1, time.Minute), rc.URL)
+	f.enqueue(t)
+	ctx := context.Background()
+
+	f.worker.deliverDue(ctx)
+	failed := f.deliveries(t)[0]
+	if failed.Status != store.DeliveryFailed {
+		t.Fatalf("delivery is %s, want failed", failed.Status)
+	}
+
+	queued, err := f.store.Redeliver(ctx, failed)
+	if err != nil {
+		t.Fatalf("redelivering: %v", err)
+	}
+	if queued.ID == failed.ID || queued.EventID != failed.EventID {
+		t.Errorf("redelivery %s of event %s, want a new delivery of event %s", queued.ID, queued.EventID, failed.EventID)
+	}
+	f.worker.deliverDue(ctx)
+
+	requests := rc.received()
+	if len(requests) != 2 {
+		t.Fatalf("receiver got %d requests, want 2", len(requests))
+	}
+	if a, b := requests[0].header.Get(IDHeader), requests[1].header.Get(IDHeader); a != b {
+		t.Errorf("redelivery has event ID %q, want %q", b, a)
+	}
+	if string(requests[0].body) != string(requests[1].body) {
+		t.Errorf("redelivery sent %s, want %s", requests[1].body, requests[0].body)
+	}
+
+	deliveries := f.deliveries(t)
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries, want 2", len(deliveries))
+	}
+	if deliveries[0].Status != store.DeliveryFailed || deliveries[1].Status != store.DeliverySucceeded {
+		t.Errorf("deliveries are %s and %s, want failed and succeeded", deliveries[0].Status, deliveries[1].Status)
+	}
+}
+
+func TestDeliveryRefusesPrivateAddresses(t *testing.T) {
+	rc := newReceiver(t, func(int) int { return http.StatusOK })
+	cfg := testConfig(1, time.Minute)
+	cfg.WebhookAllowPrivate = false
+	f := newFixture(t, cfg, rc.URL)
+	f.enqueue(t)
+
+	f.worker.deliverDue(context.Background())
+	if got := len(rc.received()); got != 0 {
+		t.Fatalf("receiver on a loopback address got %d requests", got)
+	}
+	d := f.deliveries(t)[0]
+	if d.Status != store.DeliveryFailed || !strings.Contains(d.LastError, ErrForbiddenAddress.Error()) {
+		t.Errorf("delivery is %s with error %q, want failed as forbidden", d.Status, d.LastError)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		forbidden bool
+	}{
+		{"http://127.0.0.1:8080/hook", true},
+		{"http://127.10.0.1/", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://10.0.0.5/", true},
+		{"https://172.16.3.4/", true},
+		{"http://192.168.1.1/", true},
+		{"http://100.100.100.200/", true},
+		{"http://0.0.0.0/", true},
+		{"http://0.1.2.3/", true},
+		{"http://[::1]/", true},
+		{"http://[::]/", true},
+		{"http://[fe80::1]/", true},
+		{"http://[fd00::1]/", true},
+		{"http://[::ffff:127.0.0.1]/", true},
+		{"http://[::ffff:169.254.169.254]/", true},
+		{"http://localhost:3000/", true},
+		{"https://93.184.215.14/hook", false},
+		{"https://[2606:4700::1111]/hook", false},
+	}
+	for _, tt := range tests {
+		err := CheckURL(context.Background(), tt.url)
+		if got := errors.Is(err, ErrForbiddenAddress); got != tt.forbidden {
+			t.Errorf("CheckURL(%q) = %v, want forbidden %t", tt.url, err, tt.forbidden)
+		}
+		if !tt.forbidden && err != nil {
+			t.Errorf("CheckURL(%q) = %v", tt.url, err)
+		}
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	allowed := false
+	control := dialControl(func() bool { return allowed })
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "169.254.169.254:80", "10.1.2.3:8080"} {
+		if err := control("tcp", address, nil); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("dialing %s: %v, want forbidden", address, err)
+		}
+	}
+	if err := control("tcp", net.JoinHostPort("93.184.215.14", "443"), nil); err != nil {
+		t.Errorf("dialing a public address: %v", err)
+	}
+	allowed = true
+	if err := control("tcp", "127.0.0.1:80", nil); err != nil {
+		t.Errorf("dialing loopback with private addresses allowed: %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(30*time.Second, tt.attempt); got != tt.want {
+			t.Errorf("retryDelay(30s, %d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}